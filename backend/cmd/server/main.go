@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
 	"syscall"
@@ -13,6 +15,8 @@ import (
 	"wg-manager/backend/internal/handlers"
 	"wg-manager/backend/internal/middleware"
 	"wg-manager/backend/internal/wireguard"
+	"wg-manager/backend/internal/wireguard/fakedevice"
+	"wg-manager/backend/internal/wireguard/userspace"
 
 	"github.com/joho/godotenv"
 )
@@ -38,44 +42,33 @@ func main() {
 		os.Exit(1)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == purgeKeysCommand {
+		if err := purgeKeys(cfg.StoragePath); err != nil {
+			slog.Error("Failed to purge private keys", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Initialize application dependencies
-	var wgService wireguard.Service
-	wgService, err = wireguard.NewRealService(
-		cfg.InterfaceName,
-		cfg.StoragePath,
-		cfg.ServerEndpoint,
-		cfg.ServerPubKey,
-		cfg.VPNSubnet,
-	)
+	wgService, err := newWireGuardService(cfg)
 	if err != nil {
-		slog.Warn("Failed to initialize native WireGuard service, falling back to mock", "error", err)
-		wgService = wireguard.NewMockService()
+		slog.Error("Failed to initialize WireGuard backend", "backend", cfg.Backend, "error", err)
+		os.Exit(1)
 	}
+	slog.Info("WireGuard backend ready", "backend", cfg.Backend)
 
 	app := &Application{
 		Config:    cfg,
 		WireGuard: wgService,
 	}
 
-	peerHandler := handlers.NewPeerHandler(app.WireGuard)
-
-	// Create a new ServeMux and register routes using modern syntax
-	mux := http.NewServeMux()
-	mux.HandleFunc("GET /peers", peerHandler.List)
-	mux.HandleFunc("POST /peers", peerHandler.Add)
-	mux.HandleFunc("DELETE /peers/{id}", peerHandler.Remove)
-	mux.HandleFunc("PATCH /peers/{id}", peerHandler.Update)
-	mux.HandleFunc("POST /peers/regenerate-keys/{id}", peerHandler.Regenerate)
-	mux.HandleFunc("GET /peers/config/{id}", peerHandler.GetConfig)
-	mux.HandleFunc("GET /peers/qr/{id}", peerHandler.GetQR)
-	mux.HandleFunc("GET /stats", peerHandler.Stats)
-	mux.HandleFunc("GET /stats/history", peerHandler.GetHistory)
-	mux.HandleFunc("GET /settings", peerHandler.GetSettings)
-	mux.HandleFunc("POST /settings", peerHandler.UpdateSettings)
+	mux := newMux(app)
 
 	// Apply middleware to all routes
 	wrappedMux := middleware.LoggingMiddleware(mux)
 	wrappedMux = middleware.CORSMiddleware(wrappedMux)
+	wrappedMux = middleware.RequestIDMiddleware(wrappedMux)
 
 	srv := &http.Server{
 		Addr:    app.Config.ServerPort,
@@ -113,3 +106,147 @@ func main() {
 
 	slog.Info("Server exited")
 }
+
+// newMux registers every route. Routes must also be documented in the
+// OpenAPI route table in the handlers package.
+func newMux(app *Application) *http.ServeMux {
+	peerHandler := handlers.NewPeerHandler(app.WireGuard, app.Config.VPNSubnet)
+	peerHandler.ExportToken = app.Config.ExportToken
+	healthHandler := handlers.NewHealthHandler(app.WireGuard, app.Config.Backend)
+	inviteHandler := handlers.NewInviteHandler(app.WireGuard)
+	groupHandler := handlers.NewGroupHandler(app.WireGuard)
+	openAPIHandler := handlers.NewOpenAPIHandler()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /peers", peerHandler.List)
+	mux.HandleFunc("POST /peers", peerHandler.Add)
+	mux.HandleFunc("POST /peers/bulk", peerHandler.Bulk)
+	mux.HandleFunc("GET /peers/export", peerHandler.Export)
+	mux.HandleFunc("POST /peers/import", peerHandler.Import)
+	mux.HandleFunc("GET /peers/{id}", peerHandler.Get)
+	mux.HandleFunc("DELETE /peers/{id}", peerHandler.Remove)
+	mux.HandleFunc("PATCH /peers/{id}", peerHandler.Update)
+	mux.HandleFunc("POST /peers/{id}/regenerate-keys", peerHandler.Regenerate)
+	mux.HandleFunc("POST /peers/{id}/claim", peerHandler.Claim)
+	mux.HandleFunc("POST /peers/{id}/reissue", peerHandler.Reissue)
+	mux.HandleFunc("POST /peers/{id}/share", peerHandler.Share)
+	mux.HandleFunc("GET /share/{token}", peerHandler.OpenShare)
+	mux.HandleFunc("GET /shares/audit", peerHandler.ShareAudit)
+	mux.HandleFunc("GET /invites", inviteHandler.List)
+	mux.HandleFunc("POST /invites", inviteHandler.Create)
+	mux.HandleFunc("DELETE /invites/{id}", inviteHandler.Revoke)
+	mux.HandleFunc("POST /invites/redeem", inviteHandler.Redeem)
+	mux.HandleFunc("GET /groups", groupHandler.List)
+	mux.HandleFunc("POST /groups", groupHandler.Create)
+	mux.HandleFunc("GET /groups/{name}", groupHandler.Get)
+	mux.HandleFunc("PUT /groups/{name}", groupHandler.Update)
+	mux.HandleFunc("DELETE /groups/{name}", groupHandler.Delete)
+	mux.HandleFunc("GET /peers/config/{id}", peerHandler.GetConfig)
+	mux.HandleFunc("GET /peers/qr/{id}", peerHandler.GetQR)
+	mux.HandleFunc("GET /peers/{id}/config/diff", peerHandler.GetConfigDiff)
+	mux.HandleFunc("GET /stats", peerHandler.Stats)
+	mux.HandleFunc("GET /stats/history", peerHandler.GetHistory)
+	mux.HandleFunc("GET /settings", peerHandler.GetSettings)
+	mux.HandleFunc("PUT /settings", peerHandler.ReplaceSettings)
+	mux.HandleFunc("PATCH /settings", peerHandler.PatchSettings)
+	mux.HandleFunc("POST /settings", peerHandler.UpdateSettings)
+	mux.HandleFunc("GET /reconcile", peerHandler.Drift)
+	mux.HandleFunc("POST /reconcile", peerHandler.Reconcile)
+	mux.HandleFunc("GET /events", peerHandler.Events)
+	mux.HandleFunc("GET /health", healthHandler.Health)
+	mux.HandleFunc("GET /healthz", healthHandler.Healthz)
+	mux.HandleFunc("GET /readyz", healthHandler.Readyz)
+	mux.HandleFunc("GET /diagnostics", healthHandler.Diagnostics)
+	mux.HandleFunc("GET /openapi.json", openAPIHandler.Spec)
+
+	return mux
+}
+
+// Backends selectable via the "backend" config option.
+const (
+	backendKernel    = "kernel"
+	backendUserspace = "userspace"
+	backendMock      = "mock"
+)
+
+// newWireGuardService starts the WireGuard backend selected by cfg.Backend.
+// There is no fallback: a backend that cannot start is an error.
+func newWireGuardService(cfg *config.Config) (wireguard.Service, error) {
+	if cfg.Backend == "" {
+		cfg.Backend = backendKernel
+	}
+
+	switch cfg.Backend {
+	case backendKernel:
+		return wireguard.NewRealService(
+			cfg.InterfaceName,
+			cfg.StoragePath,
+			cfg.ServerEndpoint,
+			cfg.ServerPubKey,
+			cfg.VPNSubnet,
+		)
+	case backendUserspace:
+		return newUserspaceService(cfg)
+	case backendMock:
+		return newMockService(cfg)
+	default:
+		return nil, fmt.Errorf("unknown backend %q (expected %s, %s or %s)", cfg.Backend, backendKernel, backendUserspace, backendMock)
+	}
+}
+
+// newUserspaceService runs wireguard-go on an in-process netstack, using the
+// first host address of the VPN subnet as the server's interface address.
+func newUserspaceService(cfg *config.Config) (wireguard.Service, error) {
+	subnet, err := netip.ParsePrefix(cfg.VPNSubnet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VPN subnet: %w", err)
+	}
+
+	privateKey, err := serverPrivateKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := userspace.New(userspace.Options{
+		InterfaceName: cfg.InterfaceName,
+		PrivateKey:    privateKey.String(),
+		ListenPort:    cfg.ListenPort,
+		Addresses:     []netip.Addr{subnet.Masked().Addr().Next()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newServiceWithDeviceKey(cfg, client)
+}
+
+// newMockService runs the real service against an in-memory device, so demo
+// mode persists peers to storage exactly like production. The device starts
+// empty and is repopulated from storage on every start.
+func newMockService(cfg *config.Config) (wireguard.Service, error) {
+	privateKey, err := serverPrivateKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	client := fakedevice.New()
+	client.AddDevice(cfg.InterfaceName, privateKey, cfg.ListenPort)
+	return newServiceWithDeviceKey(cfg, client)
+}
+
+// newServiceWithDeviceKey starts the service using the device's own public
+// key. The device key is authoritative; a generated key would otherwise leave
+// rendered configs pointing at the wrong server.
+func newServiceWithDeviceKey(cfg *config.Config, client wireguard.DeviceClient) (wireguard.Service, error) {
+	device, err := client.Device(cfg.InterfaceName)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	serverPubKey := device.PublicKey.String()
+	if cfg.ServerPubKey != "" && cfg.ServerPubKey != serverPubKey {
+		slog.Warn("Configured server public key does not match the device", "backend", cfg.Backend, "configured", cfg.ServerPubKey, "device", serverPubKey)
+	}
+	slog.Info("WireGuard device started", "backend", cfg.Backend, "interface", cfg.InterfaceName, "publicKey", serverPubKey, "listenPort", device.ListenPort)
+
+	return wireguard.NewServiceWithClient(client, cfg.InterfaceName, cfg.StoragePath, cfg.ServerEndpoint, serverPubKey, cfg.VPNSubnet)
+}
@@ -2,11 +2,17 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"io/fs"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
 	"wg-manager/backend/internal/config"
 	"wg-manager/backend/internal/handlers"
 	"wg-manager/backend/internal/wireguard"
@@ -14,7 +20,7 @@ import (
 
 func TestPeersHandler(t *testing.T) {
 	mockWGService := wireguard.NewMockService()
-	h := handlers.NewPeerHandler(mockWGService)
+	h := handlers.NewPeerHandler(mockWGService, "10.0.0.0/24")
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /peers", h.List)
 
@@ -41,7 +47,7 @@ func TestPeersHandler(t *testing.T) {
 
 func TestRemovePeerHandler(t *testing.T) {
 	mockWGService := wireguard.NewMockService()
-	h := handlers.NewPeerHandler(mockWGService)
+	h := handlers.NewPeerHandler(mockWGService, "10.0.0.0/24")
 	mux := http.NewServeMux()
 	mux.HandleFunc("DELETE /peers/{id}", h.Remove)
 
@@ -62,16 +68,16 @@ func TestRemovePeerHandler(t *testing.T) {
 
 		mux.ServeHTTP(rr, req)
 
-		if status := rr.Code; status != http.StatusInternalServerError {
+		if status := rr.Code; status != http.StatusNotFound {
 			t.Errorf("handler returned wrong status code: got %v want %v",
-				status, http.StatusInternalServerError)
+				status, http.StatusNotFound)
 		}
 	})
 }
 
 func TestAddPeerHandler(t *testing.T) {
 	mockWGService := wireguard.NewMockService()
-	h := handlers.NewPeerHandler(mockWGService)
+	h := handlers.NewPeerHandler(mockWGService, "10.0.0.0/24")
 	mux := http.NewServeMux()
 	mux.HandleFunc("POST /peers", h.Add)
 
@@ -138,7 +144,7 @@ func TestAddPeerHandler(t *testing.T) {
 
 func TestRegeneratePeerHandler(t *testing.T) {
 	mockWGService := wireguard.NewMockService()
-	h := handlers.NewPeerHandler(mockWGService)
+	h := handlers.NewPeerHandler(mockWGService, "10.0.0.0/24")
 	mux := http.NewServeMux()
 	mux.HandleFunc("POST /peers/{id}/regenerate-keys", h.Regenerate)
 
@@ -167,16 +173,16 @@ func TestRegeneratePeerHandler(t *testing.T) {
 
 		mux.ServeHTTP(rr, req)
 
-		if status := rr.Code; status != http.StatusInternalServerError {
+		if status := rr.Code; status != http.StatusNotFound {
 			t.Errorf("handler returned wrong status code: got %v want %v",
-				status, http.StatusInternalServerError)
+				status, http.StatusNotFound)
 		}
 	})
 }
 
 func TestUpdatePeerHandler(t *testing.T) {
 	mockWGService := wireguard.NewMockService()
-	h := handlers.NewPeerHandler(mockWGService)
+	h := handlers.NewPeerHandler(mockWGService, "10.0.0.0/24")
 	mux := http.NewServeMux()
 	mux.HandleFunc("PATCH /peers/{id}", h.Update)
 
@@ -243,9 +249,9 @@ func TestUpdatePeerHandler(t *testing.T) {
 
 		mux.ServeHTTP(rr, req)
 
-		if status := rr.Code; status != http.StatusInternalServerError {
+		if status := rr.Code; status != http.StatusNotFound {
 			t.Errorf("handler returned wrong status code: got %v want %v",
-				status, http.StatusInternalServerError)
+				status, http.StatusNotFound)
 		}
 	})
 
@@ -264,7 +270,7 @@ func TestUpdatePeerHandler(t *testing.T) {
 
 func TestStatsHandler(t *testing.T) {
 	mockWGService := wireguard.NewMockService()
-	h := handlers.NewPeerHandler(mockWGService)
+	h := handlers.NewPeerHandler(mockWGService, "10.0.0.0/24")
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /stats", h.Stats)
 
@@ -304,7 +310,7 @@ func TestStatsHandler(t *testing.T) {
 		mockWGService := wireguard.NewMockService()
 		_, _ = mockWGService.AddPeer(wireguard.AddPeerOptions{Name: "force-stats-error", AllowedIPs: []string{"10.0.0.1/32"}})
 
-		h := handlers.NewPeerHandler(mockWGService)
+		h := handlers.NewPeerHandler(mockWGService, "10.0.0.0/24")
 		mux := http.NewServeMux()
 		mux.HandleFunc("GET /stats", h.Stats)
 
@@ -324,7 +330,7 @@ func TestListPeersHandlerError(t *testing.T) {
 	mockWGService := wireguard.NewMockService()
 	_, _ = mockWGService.AddPeer(wireguard.AddPeerOptions{Name: "force-list-error", AllowedIPs: []string{"10.0.0.1/32"}})
 
-	h := handlers.NewPeerHandler(mockWGService)
+	h := handlers.NewPeerHandler(mockWGService, "10.0.0.0/24")
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /peers", h.List)
 
@@ -341,7 +347,7 @@ func TestListPeersHandlerError(t *testing.T) {
 
 func TestAddPeerHandlerServiceError(t *testing.T) {
 	mockWGService := wireguard.NewMockService()
-	h := handlers.NewPeerHandler(mockWGService)
+	h := handlers.NewPeerHandler(mockWGService, "10.0.0.0/24")
 	mux := http.NewServeMux()
 	mux.HandleFunc("POST /peers", h.Add)
 
@@ -359,7 +365,7 @@ func TestAddPeerHandlerServiceError(t *testing.T) {
 
 func TestRemovePeerHandlerForceError(t *testing.T) {
 	mockWGService := wireguard.NewMockService()
-	h := handlers.NewPeerHandler(mockWGService)
+	h := handlers.NewPeerHandler(mockWGService, "10.0.0.0/24")
 	mux := http.NewServeMux()
 	mux.HandleFunc("DELETE /peers/{id}", h.Remove)
 
@@ -376,7 +382,7 @@ func TestRemovePeerHandlerForceError(t *testing.T) {
 
 func TestRegeneratePeerHandlerForceError(t *testing.T) {
 	mockWGService := wireguard.NewMockService()
-	h := handlers.NewPeerHandler(mockWGService)
+	h := handlers.NewPeerHandler(mockWGService, "10.0.0.0/24")
 	mux := http.NewServeMux()
 	mux.HandleFunc("POST /peers/{id}/regenerate-keys", h.Regenerate)
 
@@ -393,7 +399,7 @@ func TestRegeneratePeerHandlerForceError(t *testing.T) {
 
 func TestUpdatePeerHandlerForceError(t *testing.T) {
 	mockWGService := wireguard.NewMockService()
-	h := handlers.NewPeerHandler(mockWGService)
+	h := handlers.NewPeerHandler(mockWGService, "10.0.0.0/24")
 	mux := http.NewServeMux()
 	mux.HandleFunc("PATCH /peers/{id}", h.Update)
 
@@ -438,9 +444,89 @@ func TestLoadConfig(t *testing.T) {
 	}
 }
 
+func TestNewWireGuardService(t *testing.T) {
+	newConfig := func(t *testing.T, backend string) *config.Config {
+		return &config.Config{
+			InterfaceName: "wg-test-missing",
+			StoragePath:   filepath.Join(t.TempDir(), "peers.json"),
+			VPNSubnet:     "10.0.0.0/24",
+			Backend:       backend,
+		}
+	}
+
+	t.Run("UnknownBackend", func(t *testing.T) {
+		if _, err := newWireGuardService(newConfig(t, "bogus")); err == nil {
+			t.Error("expected an error for an unknown backend")
+		}
+	})
+
+	t.Run("KernelFailsWithoutFallback", func(t *testing.T) {
+		cfg := newConfig(t, "")
+		srv, err := newWireGuardService(cfg)
+		if err == nil {
+			srv.Close()
+			t.Fatal("expected kernel backend to fail for a missing interface")
+		}
+		if cfg.Backend != "kernel" {
+			t.Errorf("expected default backend to be kernel, got %q", cfg.Backend)
+		}
+	})
+
+	t.Run("MockPersistsToStorage", func(t *testing.T) {
+		cfg := newConfig(t, "mock")
+		srv, err := newWireGuardService(cfg)
+		if err != nil {
+			t.Fatalf("failed to start mock backend: %v", err)
+		}
+		if _, err := srv.AddPeer(wireguard.AddPeerOptions{Name: "demo", AllowedIPs: []string{"10.0.0.2/32"}}); err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		before, _ := srv.GetStats()
+		srv.Close()
+
+		srv, err = newWireGuardService(cfg)
+		if err != nil {
+			t.Fatalf("failed to restart mock backend: %v", err)
+		}
+		defer srv.Close()
+		peers, err := srv.ListPeers()
+		if err != nil {
+			t.Fatalf("ListPeers failed: %v", err)
+		}
+		if len(peers) != 1 || peers[0].Name != "demo" {
+			t.Errorf("expected peer to survive a restart, got %+v", peers)
+		}
+		// Issued configs name the server key, so a generated one is kept.
+		if after, _ := srv.GetStats(); after.PublicKey == "" || after.PublicKey != before.PublicKey {
+			t.Errorf("expected the server key to survive a restart, got %q then %q", before.PublicKey, after.PublicKey)
+		}
+		info, err := os.Stat(filepath.Join(filepath.Dir(cfg.StoragePath), serverKeyFile))
+		if err != nil || info.Mode().Perm() != 0o600 {
+			t.Errorf("expected the key to be saved with mode 0600, got %v, %v", info, err)
+		}
+	})
+
+	t.Run("ConfiguredKeyIsNotSaved", func(t *testing.T) {
+		cfg := newConfig(t, "mock")
+		key, _ := wgtypes.GeneratePrivateKey()
+		cfg.PrivateKey = key.String()
+		srv, err := newWireGuardService(cfg)
+		if err != nil {
+			t.Fatalf("failed to start mock backend: %v", err)
+		}
+		defer srv.Close()
+		if stats, _ := srv.GetStats(); stats.PublicKey != key.PublicKey().String() {
+			t.Errorf("expected the configured key, got %s", stats.PublicKey)
+		}
+		if _, err := os.Stat(filepath.Join(filepath.Dir(cfg.StoragePath), serverKeyFile)); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected no key file, got %v", err)
+		}
+	})
+}
+
 func TestHandlerMissingID(t *testing.T) {
 	mockWGService := wireguard.NewMockService()
-	h := handlers.NewPeerHandler(mockWGService)
+	h := handlers.NewPeerHandler(mockWGService, "10.0.0.0/24")
 	mux := http.NewServeMux()
 
 	// Register without {id} to test missing ID check in handler
@@ -479,7 +565,7 @@ func TestHandlerMissingID(t *testing.T) {
 
 func TestAddPeerHandlerEmptyAllowedIPs(t *testing.T) {
 	mockWGService := wireguard.NewMockService()
-	h := handlers.NewPeerHandler(mockWGService)
+	h := handlers.NewPeerHandler(mockWGService, "10.0.0.0/24")
 	mux := http.NewServeMux()
 	mux.HandleFunc("POST /peers", h.Add)
 
@@ -494,3 +580,51 @@ func TestAddPeerHandlerEmptyAllowedIPs(t *testing.T) {
 			status, http.StatusBadRequest)
 	}
 }
+
+func TestAddPeerHandlerConflict(t *testing.T) {
+	mockWGService := wireguard.NewMockService()
+	h := handlers.NewPeerHandler(mockWGService, "10.0.0.0/24")
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /peers", h.Add)
+
+	t.Run("Overlap", func(t *testing.T) {
+		reqBody := `{"name":"Intruder", "allowedIPs":["10.0.0.0/30"]}`
+		req := httptest.NewRequest("POST", "/peers", strings.NewReader(reqBody))
+		rr := httptest.NewRecorder()
+
+		mux.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusConflict {
+			t.Fatalf("handler returned wrong status code: got %v want %v",
+				status, http.StatusConflict)
+		}
+
+		var body struct {
+			Code    string                  `json:"code"`
+			Details wireguard.ConflictError `json:"details"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("could not unmarshal response: %v", err)
+		}
+		if body.Code != handlers.CodeConflict {
+			t.Errorf("expected code %q, got %q", handlers.CodeConflict, body.Code)
+		}
+		conflict := body.Details
+		if conflict.PeerID != "mock-peer-1" || conflict.ConflictingPrefix != "10.0.0.2/32" {
+			t.Errorf("expected conflict with mock-peer-1 on 10.0.0.2/32, got %+v", conflict)
+		}
+	})
+
+	t.Run("Force", func(t *testing.T) {
+		reqBody := `{"name":"Intruder", "allowedIPs":["10.0.0.2/32"], "force":true}`
+		req := httptest.NewRequest("POST", "/peers", strings.NewReader(reqBody))
+		rr := httptest.NewRecorder()
+
+		mux.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusCreated {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusCreated)
+		}
+	})
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"wg-manager/backend/internal/config"
+	"wg-manager/backend/internal/handlers"
+	"wg-manager/backend/internal/openapi"
+	"wg-manager/backend/internal/wireguard"
+)
+
+// registeredPatterns returns every pattern passed to HandleFunc in main.go.
+func registeredPatterns(t *testing.T) []string {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "main.go", nil, 0)
+	if err != nil {
+		t.Fatalf("failed to parse main.go: %v", err)
+	}
+
+	var patterns []string
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || sel.Sel.Name != "HandleFunc" {
+			return true
+		}
+		lit, ok := call.Args[0].(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			t.Errorf("HandleFunc pattern at offset %d is not a string literal", call.Pos())
+			return true
+		}
+		pattern, _ := strconv.Unquote(lit.Value)
+		patterns = append(patterns, pattern)
+		return true
+	})
+	return patterns
+}
+
+func specPatterns(doc openapi.Document) []string {
+	var patterns []string
+	for path, item := range doc.Paths {
+		for method := range item {
+			patterns = append(patterns, strings.ToUpper(method)+" "+path)
+		}
+	}
+	return patterns
+}
+
+// checkValue verifies that every field in a decoded JSON value is described
+// by schema, and that every required property is present.
+func checkValue(t *testing.T, doc openapi.Document, schema *openapi.Schema, value any, where string) {
+	t.Helper()
+	schema = doc.Resolve(schema)
+	if schema == nil {
+		t.Errorf("%s: unresolvable schema", where)
+		return
+	}
+	if len(schema.OneOf) > 0 {
+		checkValue(t, doc, oneOfFor(doc, schema.OneOf, value), value, where)
+		return
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return
+	case map[string]any:
+		if schema.Type != "object" && schema.Type != "" {
+			t.Errorf("%s: got object, spec says %s", where, schema.Type)
+			return
+		}
+		for key, field := range v {
+			fieldSchema, ok := schema.Properties[key]
+			if !ok {
+				fieldSchema = schema.AdditionalProperties
+			}
+			if fieldSchema == nil {
+				if schema.Properties != nil || schema.Type == "object" {
+					t.Errorf("%s: field %q is missing from the spec", where, key)
+				}
+				continue
+			}
+			checkValue(t, doc, fieldSchema, field, where+"."+key)
+		}
+		for _, key := range schema.Required {
+			if _, ok := v[key]; !ok {
+				t.Errorf("%s: required field %q is not in the response", where, key)
+			}
+		}
+	case []any:
+		if schema.Type != "array" && schema.Type != "" {
+			t.Errorf("%s: got array, spec says %s", where, schema.Type)
+			return
+		}
+		for i, item := range v {
+			checkValue(t, doc, schema.Items, item, where+"["+strconv.Itoa(i)+"]")
+		}
+	case string:
+		if schema.Type != "string" && schema.Type != "" {
+			t.Errorf("%s: got string, spec says %s", where, schema.Type)
+		}
+	case float64:
+		if schema.Type != "integer" && schema.Type != "number" && schema.Type != "" {
+			t.Errorf("%s: got number, spec says %s", where, schema.Type)
+		}
+	case bool:
+		if schema.Type != "boolean" && schema.Type != "" {
+			t.Errorf("%s: got boolean, spec says %s", where, schema.Type)
+		}
+	}
+}
+
+// oneOfFor picks the alternative whose type matches a decoded JSON value,
+// or the first one if none does.
+func oneOfFor(doc openapi.Document, alternatives []*openapi.Schema, value any) *openapi.Schema {
+	want := "object"
+	if _, ok := value.([]any); ok {
+		want = "array"
+	}
+	for _, s := range alternatives {
+		if r := doc.Resolve(s); r != nil && r.Type == want {
+			return s
+		}
+	}
+	return alternatives[0]
+}
+
+// contractRequests holds the request bodies used to exercise each route.
+var contractRequests = map[string]string{
+	"POST /peers":                      `{"name":"Contract","allowedIPs":["10.0.0.50/32"]}`,
+	"PATCH /peers/{id}":                `{"name":"Renamed"}`,
+	"POST /settings":                   `{"dns":"1.1.1.1","mtu":1420}`,
+	"PUT /settings":                    `{"dns":"1.1.1.1","mtu":1420}`,
+	"PATCH /settings":                  `{"dns":"9.9.9.9"}`,
+	"POST /reconcile":                  `{"mode":"adopt"}`,
+	"POST /peers/bulk":                 `{"operations":[{"op":"disable","id":"mock-peer-1"},{"op":"delete","selector":{"group":"staff"}}],"dryRun":true}`,
+	"POST /peers/import":               `{"rows":[{"name":"Contract Import","group":"staff"}]}`,
+	"POST /peers/{id}/regenerate-keys": `{"overlapSeconds":300}`,
+	"POST /invites":                    `{"nameTemplate":"laptop-{n}","maxUses":5}`,
+	"POST /invites/redeem":             `{"token":"mock-invite-token","name":"Dana"}`,
+	"POST /peers/{id}/share":           `{"expiresInSeconds":3600}`,
+	"POST /peers/{id}/claim":           `{"publicKey":"xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="}`,
+	"POST /groups":                     `{"name":"contractors","peerLifetime":2592000}`,
+	"PUT /groups/{name}":               `{"dns":"10.0.0.53","mtu":1380}`,
+}
+
+func TestOpenAPIContract(t *testing.T) {
+	doc := handlers.BuildOpenAPI()
+	registered := registeredPatterns(t)
+	documented := specPatterns(doc)
+
+	t.Run("Routes", func(t *testing.T) {
+		if len(registered) == 0 {
+			t.Fatal("expected to find routes in main.go")
+		}
+		for _, p := range registered {
+			if !slices.Contains(documented, p) {
+				t.Errorf("route %q is registered but missing from the OpenAPI spec", p)
+			}
+		}
+		for _, p := range documented {
+			if !slices.Contains(registered, p) {
+				t.Errorf("route %q is in the OpenAPI spec but not registered", p)
+			}
+		}
+	})
+
+	t.Run("Served", func(t *testing.T) {
+		app := &Application{Config: &config.Config{Backend: "mock"}, WireGuard: wireguard.NewMockService()}
+		req := httptest.NewRequest("GET", "/openapi.json", nil)
+		rr := httptest.NewRecorder()
+		newMux(app).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", rr.Code)
+		}
+		var served openapi.Document
+		if err := json.Unmarshal(rr.Body.Bytes(), &served); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		if served.OpenAPI != openapi.Version || len(served.Paths) != len(doc.Paths) {
+			t.Errorf("expected the generated document to be served, got version %q with %d paths", served.OpenAPI, len(served.Paths))
+		}
+	})
+
+	t.Run("ZeroKnowledgeNotes", func(t *testing.T) {
+		for _, route := range []struct{ path, method string }{{"/peers/config/{id}", "get"}, {"/settings", "patch"}} {
+			if op := doc.Paths[route.path][route.method]; op == nil || !strings.Contains(strings.ToLower(op.Description), "preshared key") {
+				t.Errorf("expected %s %s to say configs keep the preshared key, got %+v", route.method, route.path, op)
+			}
+		}
+	})
+
+	// Exercise each route against the mock service and check the response
+	// matches the documented status, content type and JSON fields.
+	for _, pattern := range registered {
+		t.Run(pattern, func(t *testing.T) {
+			method, path, _ := strings.Cut(pattern, " ")
+			op := doc.Paths[path][strings.ToLower(method)]
+			if op == nil {
+				t.Skip("not documented; reported by the Routes subtest")
+			}
+
+			app := &Application{Config: &config.Config{Backend: "mock"}, WireGuard: wireguard.NewMockService()}
+			path = strings.NewReplacer("{id}", "mock-peer-1", "{name}", "staff").Replace(path)
+			req := httptest.NewRequest(method, path, strings.NewReader(contractRequests[pattern]))
+			rr := httptest.NewRecorder()
+			newMux(app).ServeHTTP(rr, req)
+
+			resp, ok := op.Responses[strconv.Itoa(rr.Code)]
+			if !ok {
+				t.Fatalf("status %d is not documented (body %q)", rr.Code, rr.Body.String())
+			}
+			if rr.Code >= 300 {
+				t.Errorf("expected a success status from the mock, got %d: %s", rr.Code, rr.Body.String())
+			}
+			if rr.Body.Len() == 0 {
+				if len(resp.Content) != 0 {
+					t.Errorf("expected a response body for status %d", rr.Code)
+				}
+				return
+			}
+
+			contentType, _, _ := mime.ParseMediaType(rr.Header().Get("Content-Type"))
+			media, ok := resp.Content[contentType]
+			if !ok {
+				t.Fatalf("content type %q is not documented for status %d", contentType, rr.Code)
+			}
+			if contentType != "application/json" {
+				return
+			}
+
+			var body any
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatalf("unmarshal error: %v", err)
+			}
+			checkValue(t, doc, media.Schema, body, "response")
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"wg-manager/backend/internal/wireguard"
+)
+
+// purgeKeysCommand is the subcommand that removes stored private keys.
+const purgeKeysCommand = "purge-keys"
+
+// purgeKeys removes every client private key from the peer store. Run it
+// while the server is stopped: a running server keeps its own copy of the
+// store and would write the keys back on its next save.
+func purgeKeys(storagePath string) error {
+	storage, err := wireguard.NewStorage(storagePath)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	purged, err := storage.PurgePrivateKeys()
+	if err != nil {
+		return fmt.Errorf("failed to purge private keys: %w", err)
+	}
+	slog.Info("Purged stored private keys", "peers", purged, "storage", storagePath)
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"wg-manager/backend/internal/config"
+)
+
+// serverKeyFile is the file, next to the peer store, that keeps the interface
+// key generated for the userspace and mock backends.
+const serverKeyFile = "server.key"
+
+// serverPrivateKey returns the interface key of the userspace and mock
+// backends: the configured key, or else the one saved next to the peer store,
+// generated on first start. Every issued config names the server's public
+// key, so it must survive restarts.
+func serverPrivateKey(cfg *config.Config) (wgtypes.Key, error) {
+	if cfg.PrivateKey != "" {
+		key, err := wgtypes.ParseKey(cfg.PrivateKey)
+		if err != nil {
+			return wgtypes.Key{}, fmt.Errorf("invalid private key: %w", err)
+		}
+		return key, nil
+	}
+
+	path := filepath.Join(filepath.Dir(cfg.StoragePath), serverKeyFile)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := wgtypes.ParseKey(strings.TrimSpace(string(data)))
+		if err != nil {
+			return wgtypes.Key{}, fmt.Errorf("invalid private key in %s: %w", path, err)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return wgtypes.Key{}, fmt.Errorf("failed to read server key: %w", err)
+	}
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return wgtypes.Key{}, fmt.Errorf("failed to generate server key: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return wgtypes.Key{}, fmt.Errorf("failed to save server key: %w", err)
+	}
+	defer file.Close()
+	if _, err := fmt.Fprintln(file, key.String()); err != nil {
+		return wgtypes.Key{}, fmt.Errorf("failed to save server key: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return wgtypes.Key{}, fmt.Errorf("failed to save server key: %w", err)
+	}
+	slog.Info("Generated server key", "path", path)
+	return key, nil
+}
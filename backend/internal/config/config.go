@@ -3,6 +3,7 @@ package config
 import (
 	"encoding/json"
 	"os"
+	"strconv"
 )
 
 // Config holds the application configuration.
@@ -14,6 +15,18 @@ type Config struct {
 	ServerPubKey       string `json:"server_pubkey"`
 	VPNSubnet          string `json:"vpn_subnet"`
 	CORSAllowedOrigins string `json:"cors_allowed_origins"`
+	// Backend selects the WireGuard implementation: "kernel" (default),
+	// "userspace" (wireguard-go on an in-process netstack) or "mock" (an
+	// in-memory device backed by real storage, for demos).
+	Backend string `json:"backend"`
+	// PrivateKey and ListenPort configure the userspace and mock interfaces;
+	// the kernel backend reads both from the existing device. Without a
+	// PrivateKey, a generated key is saved next to the peer store.
+	PrivateKey string `json:"private_key"`
+	ListenPort int    `json:"listen_port"`
+	// ExportToken authorises exports that include private keys. It is sent
+	// as a bearer token; while it is empty, keys cannot be exported.
+	ExportToken string `json:"export_token"`
 }
 
 // LoadConfig loads configuration from the specified JSON file.
@@ -50,6 +63,22 @@ func LoadConfig(path string) (*Config, error) {
 	if envOrigins := os.Getenv("CORS_ALLOWED_ORIGINS"); envOrigins != "" {
 		cfg.CORSAllowedOrigins = envOrigins
 	}
+	if envBackend := os.Getenv("WG_BACKEND"); envBackend != "" {
+		cfg.Backend = envBackend
+	}
+	if envPrivateKey := os.Getenv("WG_PRIVATE_KEY"); envPrivateKey != "" {
+		cfg.PrivateKey = envPrivateKey
+	}
+	if envExportToken := os.Getenv("WG_EXPORT_TOKEN"); envExportToken != "" {
+		cfg.ExportToken = envExportToken
+	}
+	if envListenPort := os.Getenv("WG_LISTEN_PORT"); envListenPort != "" {
+		port, err := strconv.Atoi(envListenPort)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ListenPort = port
+	}
 
 	return &cfg, nil
 }
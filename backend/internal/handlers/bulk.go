@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"wg-manager/backend/internal/wireguard"
+)
+
+// BulkRequest is a batch of peer operations, run in order.
+type BulkRequest struct {
+	Operations []BulkOperationRequest `json:"operations"`
+	// Atomic applies nothing unless every operation succeeds.
+	Atomic bool `json:"atomic"`
+	// DryRun reports what the batch would do without applying it.
+	DryRun bool `json:"dryRun"`
+}
+
+// BulkOperationRequest is one operation of a batch. Every operation but
+// create names its peer by id, or picks peers with a selector.
+type BulkOperationRequest struct {
+	Op       string                  `json:"op"`
+	ID       string                  `json:"id,omitempty"`
+	Selector *wireguard.PeerSelector `json:"selector,omitempty"`
+	// Peer is the new peer of a create operation. Without AllowedIPs it gets
+	// the next free address of the VPN subnet.
+	Peer *AddPeerRequest `json:"peer,omitempty"`
+	// Changes are the fields to change in an update operation.
+	Changes *UpdatePeerRequest `json:"changes,omitempty"`
+	// OverlapSeconds is the key overlap of a regenerate operation.
+	OverlapSeconds int `json:"overlapSeconds,omitempty"`
+}
+
+// operation converts the request to the service's operation.
+func (req BulkOperationRequest) operation() wireguard.BulkOperation {
+	op := wireguard.BulkOperation{
+		Op:         req.Op,
+		ID:         req.ID,
+		Selector:   req.Selector,
+		Regenerate: wireguard.RegenerateOptions{Overlap: time.Duration(req.OverlapSeconds) * time.Second},
+	}
+	if req.Peer != nil {
+		op.Create = req.Peer.options()
+	}
+	if req.Changes != nil {
+		op.Update = req.Changes.updates()
+	}
+	return op
+}
+
+// Bulk runs a batch of peer operations. Failed operations are reported per
+// item with the same codes as the single-peer endpoints, so the response is
+// 200 even if some of them failed.
+func (h *PeerHandler) Bulk(w http.ResponseWriter, r *http.Request) {
+	var req BulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("Failed to decode bulk request", "error", err)
+		writeBadRequest(w, r, "Invalid request body")
+		return
+	}
+
+	for _, op := range req.Operations {
+		if op.Peer != nil {
+			op.Peer.Name = strings.TrimSpace(op.Peer.Name)
+		}
+		if op.Changes != nil && op.Changes.Name != nil {
+			name := strings.TrimSpace(*op.Changes.Name)
+			op.Changes.Name = &name
+		}
+	}
+	if errs := validateBulk(req, h.VPNSubnet); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	ops := make([]wireguard.BulkOperation, len(req.Operations))
+	for i, op := range req.Operations {
+		ops[i] = op.operation()
+	}
+	result, err := h.Service.Bulk(ops, wireguard.BulkOptions{Atomic: req.Atomic, DryRun: req.DryRun})
+	if err != nil {
+		slog.Error("Failed to run bulk operations", "error", err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	writeBulkResult(w, result)
+}
+
+// writeBulkResult encodes the result of a batch, giving each failed item the
+// code the single-peer endpoints would have used.
+func writeBulkResult(w http.ResponseWriter, result wireguard.BulkResult) {
+	for i := range result.Results {
+		item := &result.Results[i]
+		if item.Err != nil {
+			if _, code, ok := classifyServiceError(item.Err); ok {
+				item.Code = code
+			} else {
+				slog.Error("Bulk operation failed", "error", item.Err, "op", item.Op, "id", item.ID)
+				item.Code, item.Error = CodeInternal, http.StatusText(http.StatusInternalServerError)
+			}
+		}
+		if item.Peer != nil && item.Peer.Config != "" {
+			*item.Peer = withQRCode(*item.Peer)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		slog.Error("Failed to encode bulk response", "error", err)
+	}
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// ClaimPeerRequest carries the client-generated public key for a peer slot.
+type ClaimPeerRequest struct {
+	PublicKey string `json:"publicKey"`
+}
+
+// Claim assigns a client's public key to a peer created as claimable. The
+// client keeps its private key; the returned config has a placeholder for it.
+func (h *PeerHandler) Claim(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeBadRequest(w, r, "Missing peer ID in path")
+		return
+	}
+
+	var req ClaimPeerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("Failed to decode claim request", "error", err)
+		writeBadRequest(w, r, "Invalid request body")
+		return
+	}
+	if errs := validateClaim(req); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	ifRevision, ok := ifMatchRevision(w, r)
+	if !ok {
+		return
+	}
+
+	peer, err := h.Service.ClaimPeer(id, req.PublicKey, ifRevision)
+	if err != nil {
+		slog.Error("Failed to claim peer", "error", err, "id", id)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	writePeer(w, http.StatusOK, peer.Revision, peer)
+}
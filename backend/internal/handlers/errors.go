@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"wg-manager/backend/internal/middleware"
+	"wg-manager/backend/internal/validation"
+	"wg-manager/backend/internal/wireguard"
+)
+
+// Error codes returned in the "code" field of error responses.
+const (
+	CodeInvalidRequest     = "invalid_request"
+	CodeValidationFailed   = "validation_failed"
+	CodePeerNotFound       = "peer_not_found"
+	CodeInvalidKey         = "invalid_key"
+	CodeConflict           = "conflict"
+	CodeDeviceUnavailable  = "device_unavailable"
+	CodePreconditionFailed = "precondition_failed"
+	CodeConfigUnavailable  = "config_unavailable"
+	CodeShareNotFound      = "share_not_found"
+	CodeInviteNotFound     = "invite_not_found"
+	CodeGroupNotFound      = "group_not_found"
+	CodeForbidden          = "forbidden"
+	CodeInternal           = "internal_error"
+)
+
+// ErrorResponse is the JSON body of every error response.
+type ErrorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Details   any    `json:"details,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// writeError writes an ErrorResponse with the given status.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string, details any) {
+	body := ErrorResponse{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: middleware.RequestID(r.Context()),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Error("Failed to encode error response", "error", err)
+	}
+}
+
+// classifyServiceError maps the typed errors of the wireguard package to a
+// status and code. ok is false for untyped errors.
+func classifyServiceError(err error) (status int, code string, ok bool) {
+	switch {
+	case errors.Is(err, wireguard.ErrPeerNotFound):
+		return http.StatusNotFound, CodePeerNotFound, true
+	case errors.Is(err, wireguard.ErrInvalidKey):
+		return http.StatusBadRequest, CodeInvalidKey, true
+	case errors.Is(err, wireguard.ErrConflict):
+		return http.StatusConflict, CodeConflict, true
+	case errors.Is(err, wireguard.ErrDeviceUnavailable):
+		return http.StatusServiceUnavailable, CodeDeviceUnavailable, true
+	case errors.Is(err, wireguard.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed, CodePreconditionFailed, true
+	case errors.Is(err, wireguard.ErrShareNotFound):
+		return http.StatusNotFound, CodeShareNotFound, true
+	case errors.Is(err, wireguard.ErrInviteNotFound):
+		return http.StatusNotFound, CodeInviteNotFound, true
+	case errors.Is(err, wireguard.ErrGroupNotFound):
+		return http.StatusNotFound, CodeGroupNotFound, true
+	case errors.Is(err, wireguard.ErrInvalidQuery):
+		return http.StatusBadRequest, CodeInvalidRequest, true
+	case errors.Is(err, wireguard.ErrInvalidArgument):
+		return http.StatusBadRequest, CodeInvalidRequest, true
+	case errors.Is(err, wireguard.ErrConfigUnavailable):
+		return http.StatusNotFound, CodeConfigUnavailable, true
+	}
+	return 0, "", false
+}
+
+// writeServiceError reports an error returned by the service. Untyped errors
+// become a 500 without leaking internals; conflicts carry the
+// *wireguard.ConflictError as details.
+func writeServiceError(w http.ResponseWriter, r *http.Request, err error) {
+	status, code, ok := classifyServiceError(err)
+	if !ok {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, http.StatusText(http.StatusInternalServerError), nil)
+		return
+	}
+
+	var details any
+	var conflict *wireguard.ConflictError
+	if errors.As(err, &conflict) {
+		details = conflict
+	}
+	writeError(w, r, status, code, err.Error(), details)
+}
+
+// writeBadRequest reports a malformed or invalid request.
+func writeBadRequest(w http.ResponseWriter, r *http.Request, message string) {
+	writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, message, nil)
+}
+
+// writeValidationError reports field-level validation errors. The details are
+// the list of validation.FieldError.
+func writeValidationError(w http.ResponseWriter, r *http.Request, errs validation.Errors) {
+	writeError(w, r, http.StatusBadRequest, CodeValidationFailed, errs.Error(), []validation.FieldError(errs))
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// setETag sets a strong ETag holding a resource revision.
+func setETag(w http.ResponseWriter, revision int64) {
+	w.Header().Set("ETag", `"`+strconv.FormatInt(revision, 10)+`"`)
+}
+
+// ifMatchRevision reads the revision from an If-Match header. A missing
+// header or "*" yields 0, meaning no precondition. Anything other than a
+// single strong revision ETag can never match, so it is reported as 412 and
+// ok is false.
+func ifMatchRevision(w http.ResponseWriter, r *http.Request) (revision int64, ok bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, true
+	}
+
+	unquoted, found := strings.CutPrefix(header, `"`)
+	unquoted, closed := strings.CutSuffix(unquoted, `"`)
+	revision, err := strconv.ParseInt(unquoted, 10, 64)
+	if !found || !closed || err != nil || revision < 1 {
+		writeError(w, r, http.StatusPreconditionFailed, CodePreconditionFailed, "If-Match must be a single revision ETag such as \"3\"", nil)
+		return 0, false
+	}
+	return revision, true
+}
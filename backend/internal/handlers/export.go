@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"wg-manager/backend/internal/wireguard"
+)
+
+// Formats accepted by the export's format parameter.
+const (
+	formatCSV  = "csv"
+	formatJSON = "json"
+)
+
+// exportColumns are the CSV columns of an export. Key exports add
+// privateKey and presharedKey.
+var exportColumns = []string{
+	"id", "name", "group", "tags", "publicKey", "allowedIPs", "status",
+	"expires", "lastHandshake", "receiveBytes", "transmitBytes",
+}
+
+// Export downloads the peers selected by the q, status, group, tag, sort and
+// order parameters as CSV or JSON, without paging. Keys are only included
+// with secrets=true and the export token.
+func (h *PeerHandler) Export(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	query, errs := parsePeerQuery(values)
+	format := values.Get("format")
+	if format == "" {
+		format = formatJSON
+	}
+	if format != formatCSV && format != formatJSON {
+		errs.Add("format", "must be csv or json")
+	}
+	var secrets bool
+	if v := values.Get("secrets"); v != "" {
+		var err error
+		if secrets, err = strconv.ParseBool(v); err != nil {
+			errs.Add("secrets", "must be true or false")
+		}
+	}
+	if len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	if secrets {
+		if h.ExportToken == "" {
+			writeError(w, r, http.StatusForbidden, CodeForbidden, "Exporting keys is disabled", nil)
+			return
+		}
+		if !h.exportAuthorized(r) {
+			writeError(w, r, http.StatusForbidden, CodeForbidden, "Exporting keys requires the export token", nil)
+			return
+		}
+	}
+
+	peers, err := h.Service.ExportPeers(query, secrets)
+	if err != nil {
+		slog.Error("Failed to export peers", "error", err)
+		writeServiceError(w, r, err)
+		return
+	}
+	if secrets {
+		slog.Warn("Exported peer keys", "peers", len(peers), "remote", r.RemoteAddr)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Disposition", `attachment; filename="peers.`+format+`"`)
+	if format == formatCSV {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		if err := writeExportCSV(w, peers, secrets); err != nil {
+			slog.Error("Failed to encode peer export", "error", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(peers); err != nil {
+		slog.Error("Failed to encode peer export", "error", err)
+	}
+}
+
+// exportAuthorized reports whether the request carries the export token.
+func (h *PeerHandler) exportAuthorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(h.ExportToken)) == 1
+}
+
+// writeExportCSV writes peers as CSV with a header row. Lists are separated
+// by spaces and times are RFC 3339 in UTC, empty for never.
+func writeExportCSV(w http.ResponseWriter, peers []wireguard.PeerExport, secrets bool) error {
+	out := csv.NewWriter(w)
+	header := exportColumns
+	if secrets {
+		header = append(header[:len(header):len(header)], "privateKey", "presharedKey")
+	}
+	if err := out.Write(header); err != nil {
+		return err
+	}
+	for _, p := range peers {
+		record := []string{
+			p.ID,
+			csvCell(p.Name),
+			csvCell(p.Group),
+			csvCell(strings.Join(p.Tags, " ")),
+			p.PublicKey,
+			strings.Join(p.AllowedIPs, " "),
+			p.Status,
+			unixTime(p.Expires),
+			unixTime(p.LastHandshakeUnix),
+			strconv.FormatInt(p.ReceiveBytes, 10),
+			strconv.FormatInt(p.TransmitBytes, 10),
+		}
+		if secrets {
+			record = append(record, p.PrivateKey, p.PresharedKey)
+		}
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func unixTime(seconds int64) string {
+	if seconds == 0 {
+		return ""
+	}
+	return time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+}
+
+// csvCell keeps spreadsheets from running free text as a formula by quoting
+// the characters that start one. Keys are left alone, as base64 may start
+// with +.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
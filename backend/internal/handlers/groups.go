@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"wg-manager/backend/internal/wireguard"
+)
+
+// GroupHandler manages peer groups and their defaults.
+type GroupHandler struct {
+	Service wireguard.Service
+}
+
+func NewGroupHandler(service wireguard.Service) *GroupHandler {
+	return &GroupHandler{Service: service}
+}
+
+func (h *GroupHandler) List(w http.ResponseWriter, r *http.Request) {
+	groups, err := h.Service.ListGroups()
+	if err != nil {
+		slog.Error("Failed to list groups", "error", err)
+		writeServiceError(w, r, err)
+		return
+	}
+	writeGroup(w, http.StatusOK, groups)
+}
+
+func (h *GroupHandler) Get(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	group, err := h.Service.GetGroup(name)
+	if err != nil {
+		slog.Error("Failed to get group", "error", err, "name", name)
+		writeServiceError(w, r, err)
+		return
+	}
+	writeGroup(w, http.StatusOK, group)
+}
+
+func (h *GroupHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var group wireguard.Group
+	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+		slog.Error("Failed to decode group request", "error", err)
+		writeBadRequest(w, r, "Invalid request body")
+		return
+	}
+	group.Name = strings.TrimSpace(group.Name)
+	if errs := validateGroup(group); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	created, err := h.Service.CreateGroup(group)
+	if err != nil {
+		slog.Error("Failed to create group", "error", err)
+		writeServiceError(w, r, err)
+		return
+	}
+	writeGroup(w, http.StatusCreated, created)
+}
+
+// Update replaces a group's defaults. The name comes from the path; groups
+// cannot be renamed.
+func (h *GroupHandler) Update(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	var group wireguard.Group
+	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+		slog.Error("Failed to decode group request", "error", err)
+		writeBadRequest(w, r, "Invalid request body")
+		return
+	}
+	group.Name = name
+	if errs := validateGroup(group); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	change, err := h.Service.UpdateGroup(name, group)
+	if err != nil {
+		slog.Error("Failed to update group", "error", err, "name", name)
+		writeServiceError(w, r, err)
+		return
+	}
+	writeGroup(w, http.StatusOK, change)
+}
+
+func (h *GroupHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := h.Service.DeleteGroup(name); err != nil {
+		slog.Error("Failed to delete group", "error", err, "name", name)
+		writeServiceError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeGroup(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Error("Failed to encode group response", "error", err)
+	}
+}
@@ -2,11 +2,13 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
-	"net"
 	"net/http"
 	"strings"
+	"time"
 	"wg-manager/backend/internal/wireguard"
 
 	"github.com/skip2/go-qrcode"
@@ -14,26 +16,70 @@ import (
 
 type PeerHandler struct {
 	Service wireguard.Service
+	// VPNSubnet bounds the tunnel addresses accepted from clients. Empty
+	// disables the check.
+	VPNSubnet string
+	// ExportToken is the bearer token that authorises exporting keys. Empty
+	// disables key exports.
+	ExportToken string
 }
 
-func NewPeerHandler(service wireguard.Service) *PeerHandler {
-	return &PeerHandler{Service: service}
+func NewPeerHandler(service wireguard.Service, vpnSubnet string) *PeerHandler {
+	return &PeerHandler{Service: service, VPNSubnet: vpnSubnet}
 }
 
+// List returns the peers selected by the q, status, group, tag, sort, order,
+// cursor and limit query parameters. Without a limit or cursor it answers
+// with a bare array, as before paging existed; with either, with a PeerPage.
 func (h *PeerHandler) List(w http.ResponseWriter, r *http.Request) {
-	peers, err := h.Service.ListPeers()
+	query, errs := parsePeerQuery(r.URL.Query())
+	if len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+	page, err := h.Service.QueryPeers(query)
 	if err != nil {
 		slog.Error("Failed to list peers", "error", err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeServiceError(w, r, err)
 		return
 	}
 
+	var body any = page.Peers
+	if query.Limit > 0 || query.Cursor != "" {
+		body = page
+	}
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(peers); err != nil {
+	if err := json.NewEncoder(w).Encode(body); err != nil {
 		slog.Error("Failed to encode peers response", "error", err)
 	}
 }
 
+// Get returns a single peer, with its revision as the ETag.
+func (h *PeerHandler) Get(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	peer, err := h.Service.GetPeer(id)
+	if err != nil {
+		slog.Error("Failed to get peer", "error", err, "id", id)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	writePeer(w, http.StatusOK, peer.Revision, peer)
+}
+
+// writePeer encodes a peer or peer response, setting its ETag when the peer
+// has a stored revision.
+func writePeer(w http.ResponseWriter, status int, revision int64, body any) {
+	if revision != 0 {
+		setETag(w, revision)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Error("Failed to encode peer response", "error", err)
+	}
+}
+
 type AddPeerRequest struct {
 	Name                string   `json:"name"`
 	PublicKey           string   `json:"publicKey"`
@@ -43,95 +89,130 @@ type AddPeerRequest struct {
 	PersistentKeepalive int      `json:"persistentKeepalive"`
 	PreSharedKey        bool     `json:"preSharedKey"`
 	InterfaceAddress    string   `json:"interfaceAddress"`
+	RouteProfile        string   `json:"routeProfile"`
+	ClientAllowedIPs    []string `json:"clientAllowedIPs"`
+	Type                string   `json:"type"`
+	Networks            []string `json:"networks"`
+	SiteForwarding      bool     `json:"siteForwarding"`
+	Endpoint            string   `json:"endpoint"`
+	ServerKeepalive     int      `json:"serverKeepalive"`
+	Group               string   `json:"group"`
+	Tags                []string `json:"tags"`
+	Expires             int64    `json:"expires"`
+	Force               bool     `json:"force"`
+	Claimable           bool     `json:"claimable"`
+}
+
+// options converts the request to the service's options.
+func (req AddPeerRequest) options() wireguard.AddPeerOptions {
+	return wireguard.AddPeerOptions{
+		Name:                req.Name,
+		PublicKey:           req.PublicKey,
+		AllowedIPs:          req.AllowedIPs,
+		DNS:                 req.DNS,
+		MTU:                 req.MTU,
+		PersistentKeepalive: req.PersistentKeepalive,
+		PreSharedKey:        req.PreSharedKey,
+		InterfaceAddress:    req.InterfaceAddress,
+		RouteProfile:        req.RouteProfile,
+		ClientAllowedIPs:    req.ClientAllowedIPs,
+		Type:                req.Type,
+		Networks:            req.Networks,
+		SiteForwarding:      req.SiteForwarding,
+		Endpoint:            req.Endpoint,
+		ServerKeepalive:     req.ServerKeepalive,
+		Group:               req.Group,
+		Tags:                req.Tags,
+		Expires:             req.Expires,
+		Force:               req.Force,
+		Claimable:           req.Claimable,
+	}
 }
 
 func (h *PeerHandler) Add(w http.ResponseWriter, r *http.Request) {
 	var req AddPeerRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		slog.Error("Failed to decode add peer request", "error", err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeBadRequest(w, r, "Invalid request body")
 		return
 	}
 
-	// Input Validation
 	req.Name = strings.TrimSpace(req.Name)
-	if req.Name == "" {
-		http.Error(w, "Name is required", http.StatusBadRequest)
-		return
-	}
-
-	if len(req.AllowedIPs) == 0 {
-		http.Error(w, "At least one AllowedIP is required", http.StatusBadRequest)
+	if errs := validateAddPeer(req, h.VPNSubnet); len(errs) > 0 {
+		writeValidationError(w, r, errs)
 		return
 	}
 
-	for _, ip := range req.AllowedIPs {
-		if _, _, err := net.ParseCIDR(ip); err != nil {
-			http.Error(w, fmt.Sprintf("Invalid AllowedIP CIDR: %s", ip), http.StatusBadRequest)
-			return
-		}
-	}
-
-	opts := wireguard.AddPeerOptions{
-		Name:                req.Name,
-		PublicKey:           req.PublicKey,
-		AllowedIPs:          req.AllowedIPs,
-		DNS:                 req.DNS,
-		MTU:                 req.MTU,
-		PersistentKeepalive: req.PersistentKeepalive,
-		PreSharedKey:        req.PreSharedKey,
-		InterfaceAddress:    req.InterfaceAddress,
-	}
-
-	peer, err := h.Service.AddPeer(opts)
+	peer, err := h.Service.AddPeer(req.options())
 	if err != nil {
 		slog.Error("Failed to add peer", "error", err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeServiceError(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(peer); err != nil {
-		slog.Error("Failed to encode peer response", "error", err)
-	}
+	writePeer(w, http.StatusCreated, peer.Revision, withQRCode(peer))
 }
 
 func (h *PeerHandler) Remove(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	// id here is the public key of the peer
 	if id == "" {
-		http.Error(w, "Missing peer ID in path", http.StatusBadRequest)
+		writeBadRequest(w, r, "Missing peer ID in path")
+		return
+	}
+	ifRevision, ok := ifMatchRevision(w, r)
+	if !ok {
 		return
 	}
 
-	if err := h.Service.RemovePeer(id); err != nil {
+	if err := h.Service.RemovePeer(id, ifRevision); err != nil {
 		slog.Error("Failed to remove peer", "error", err, "id", id)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeServiceError(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// RegenerateRequest is the optional body of a key regeneration.
+type RegenerateRequest struct {
+	// OverlapSeconds keeps the old key valid until the new key completes a
+	// handshake or this many seconds pass. Zero switches immediately.
+	OverlapSeconds int `json:"overlapSeconds"`
+}
+
 func (h *PeerHandler) Regenerate(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	if id == "" {
-		http.Error(w, "Missing peer ID in path", http.StatusBadRequest)
+		writeBadRequest(w, r, "Missing peer ID in path")
+		return
+	}
+
+	var req RegenerateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		slog.Error("Failed to decode regenerate request", "error", err)
+		writeBadRequest(w, r, "Invalid request body")
+		return
+	}
+	if errs := validateRegenerate(req); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	ifRevision, ok := ifMatchRevision(w, r)
+	if !ok {
 		return
 	}
 
-	peer, err := h.Service.RegeneratePeer(id)
+	opts := wireguard.RegenerateOptions{Overlap: time.Duration(req.OverlapSeconds) * time.Second}
+	peer, err := h.Service.RegeneratePeer(id, opts, ifRevision)
 	if err != nil {
 		slog.Error("Failed to regenerate peer keys", "error", err, "id", id)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeServiceError(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(peer); err != nil {
-		slog.Error("Failed to encode peer response", "error", err)
-	}
+	writePeer(w, http.StatusOK, peer.Revision, withQRCode(peer))
 }
 
 type UpdatePeerRequest struct {
@@ -141,59 +222,85 @@ type UpdatePeerRequest struct {
 	MTU                 *int      `json:"mtu"`
 	PersistentKeepalive *int      `json:"persistentKeepalive"`
 	InterfaceAddress    *string   `json:"interfaceAddress"`
+	RouteProfile        *string   `json:"routeProfile"`
+	ClientAllowedIPs    *[]string `json:"clientAllowedIPs"`
+	Networks            *[]string `json:"networks"`
+	SiteForwarding      *bool     `json:"siteForwarding"`
+	Endpoint            *string   `json:"endpoint"`
+	ServerKeepalive     *int      `json:"serverKeepalive"`
+	Group               *string   `json:"group"`
+	Tags                *[]string `json:"tags"`
+	Expires             *int64    `json:"expires"`
+	Disabled            *bool     `json:"disabled"`
+	Force               bool      `json:"force"`
+}
+
+// updates converts the request to the service's changes.
+func (req UpdatePeerRequest) updates() wireguard.PeerUpdate {
+	return wireguard.PeerUpdate{
+		Name:                req.Name,
+		AllowedIPs:          req.AllowedIPs,
+		DNS:                 req.DNS,
+		MTU:                 req.MTU,
+		PersistentKeepalive: req.PersistentKeepalive,
+		InterfaceAddress:    req.InterfaceAddress,
+		RouteProfile:        req.RouteProfile,
+		ClientAllowedIPs:    req.ClientAllowedIPs,
+		Networks:            req.Networks,
+		SiteForwarding:      req.SiteForwarding,
+		Endpoint:            req.Endpoint,
+		ServerKeepalive:     req.ServerKeepalive,
+		Group:               req.Group,
+		Tags:                req.Tags,
+		Expires:             req.Expires,
+		Disabled:            req.Disabled,
+		Force:               req.Force,
+	}
 }
 
 func (h *PeerHandler) Update(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	if id == "" {
-		http.Error(w, "Missing peer ID in path", http.StatusBadRequest)
+		writeBadRequest(w, r, "Missing peer ID in path")
+		return
+	}
+
+	ifRevision, ok := ifMatchRevision(w, r)
+	if !ok {
 		return
 	}
 
 	var req UpdatePeerRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		slog.Error("Failed to decode update peer request", "error", err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeBadRequest(w, r, "Invalid request body")
 		return
 	}
 
-	// Validation
-	if req.AllowedIPs != nil {
-		for _, ip := range *req.AllowedIPs {
-			if _, _, err := net.ParseCIDR(ip); err != nil {
-				http.Error(w, fmt.Sprintf("Invalid AllowedIP CIDR: %s", ip), http.StatusBadRequest)
-				return
-			}
-		}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		req.Name = &name
 	}
-
-	updates := wireguard.PeerUpdate{
-		Name:                req.Name,
-		AllowedIPs:          req.AllowedIPs,
-		DNS:                 req.DNS,
-		MTU:                 req.MTU,
-		PersistentKeepalive: req.PersistentKeepalive,
-		InterfaceAddress:    req.InterfaceAddress,
+	if errs := validateUpdatePeer(req, h.VPNSubnet); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
 	}
 
-	peer, err := h.Service.UpdatePeer(id, updates)
+	peer, err := h.Service.UpdatePeer(id, req.updates(), ifRevision)
 	if err != nil {
 		slog.Error("Failed to update peer", "error", err, "id", id)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeServiceError(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(peer); err != nil {
-		slog.Error("Failed to encode peer response", "error", err)
-	}
+	writePeer(w, http.StatusOK, peer.Revision, peer)
 }
 
 func (h *PeerHandler) Stats(w http.ResponseWriter, r *http.Request) {
 	stats, err := h.Service.GetStats()
 	if err != nil {
 		slog.Error("Failed to get stats", "error", err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeServiceError(w, r, err)
 		return
 	}
 
@@ -206,14 +313,14 @@ func (h *PeerHandler) Stats(w http.ResponseWriter, r *http.Request) {
 func (h *PeerHandler) GetConfig(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	if id == "" {
-		http.Error(w, "Missing peer ID in path", http.StatusBadRequest)
+		writeBadRequest(w, r, "Missing peer ID in path")
 		return
 	}
 
 	config, err := h.Service.GetPeerConfig(id)
 	if err != nil {
 		slog.Error("Failed to get peer config", "error", err, "id", id)
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeServiceError(w, r, err)
 		return
 	}
 
@@ -222,24 +329,44 @@ func (h *PeerHandler) GetConfig(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(config))
 }
 
+func (h *PeerHandler) GetConfigDiff(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeBadRequest(w, r, "Missing peer ID in path")
+		return
+	}
+
+	diff, err := h.Service.GetPeerConfigDiff(id)
+	if err != nil {
+		slog.Error("Failed to diff peer config", "error", err, "id", id)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(diff); err != nil {
+		slog.Error("Failed to encode config diff response", "error", err)
+	}
+}
+
 func (h *PeerHandler) GetQR(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	if id == "" {
-		http.Error(w, "Missing peer ID in path", http.StatusBadRequest)
+		writeBadRequest(w, r, "Missing peer ID in path")
 		return
 	}
 
 	config, err := h.Service.GetPeerConfig(id)
 	if err != nil {
 		slog.Error("Failed to get peer config for QR", "error", err, "id", id)
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeServiceError(w, r, err)
 		return
 	}
 
 	png, err := qrcode.Encode(config, qrcode.High, 256)
 	if err != nil {
 		slog.Error("Failed to generate QR code", "error", err)
-		http.Error(w, "Failed to generate QR code", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to generate QR code", nil)
 		return
 	}
 
@@ -251,7 +378,7 @@ func (h *PeerHandler) GetHistory(w http.ResponseWriter, r *http.Request) {
 	history, err := h.Service.GetStatsHistory()
 	if err != nil {
 		slog.Error("Failed to get stats history", "error", err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeServiceError(w, r, err)
 		return
 	}
 
@@ -265,29 +392,95 @@ func (h *PeerHandler) GetSettings(w http.ResponseWriter, r *http.Request) {
 	settings, err := h.Service.GetSettings()
 	if err != nil {
 		slog.Error("Failed to get settings", "error", err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeServiceError(w, r, err)
 		return
 	}
 
+	setETag(w, settings.Revision)
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(settings); err != nil {
 		slog.Error("Failed to encode settings response", "error", err)
 	}
 }
 
+// ReplaceSettings replaces the global settings. Fields left out of the body
+// are reset to their zero values; use PatchSettings to change only some.
+func (h *PeerHandler) ReplaceSettings(w http.ResponseWriter, r *http.Request) {
+	change, ok := h.replaceSettings(w, r)
+	if !ok {
+		return
+	}
+	writeSettingsChange(w, change)
+}
+
+// UpdateSettings is the original POST /settings. It behaves like
+// ReplaceSettings but responds with 204 and no body.
 func (h *PeerHandler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
+	change, ok := h.replaceSettings(w, r)
+	if !ok {
+		return
+	}
+	setETag(w, change.Settings.Revision)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *PeerHandler) replaceSettings(w http.ResponseWriter, r *http.Request) (wireguard.SettingsChange, bool) {
+	ifRevision, ok := ifMatchRevision(w, r)
+	if !ok {
+		return wireguard.SettingsChange{}, false
+	}
+
 	var settings wireguard.GlobalSettings
 	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
 		slog.Error("Failed to decode update settings request", "error", err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
+		writeBadRequest(w, r, "Invalid request body")
+		return wireguard.SettingsChange{}, false
+	}
+	if errs := validateSettings(settings, h.VPNSubnet); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return wireguard.SettingsChange{}, false
 	}
 
-	if err := h.Service.UpdateSettings(settings); err != nil {
+	change, err := h.Service.UpdateSettings(settings, ifRevision)
+	if err != nil {
 		slog.Error("Failed to update settings", "error", err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeServiceError(w, r, err)
+		return wireguard.SettingsChange{}, false
+	}
+	return change, true
+}
+
+// PatchSettings changes only the settings present in the body.
+func (h *PeerHandler) PatchSettings(w http.ResponseWriter, r *http.Request) {
+	ifRevision, ok := ifMatchRevision(w, r)
+	if !ok {
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	var update wireguard.SettingsUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		slog.Error("Failed to decode patch settings request", "error", err)
+		writeBadRequest(w, r, "Invalid request body")
+		return
+	}
+	if errs := validateSettingsUpdate(update, h.VPNSubnet); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	change, err := h.Service.PatchSettings(update, ifRevision)
+	if err != nil {
+		slog.Error("Failed to patch settings", "error", err)
+		writeServiceError(w, r, err)
+		return
+	}
+	writeSettingsChange(w, change)
+}
+
+func writeSettingsChange(w http.ResponseWriter, change wireguard.SettingsChange) {
+	setETag(w, change.Settings.Revision)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(change); err != nil {
+		slog.Error("Failed to encode settings response", "error", err)
+	}
 }
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"wg-manager/backend/internal/wireguard"
+)
+
+func TestBulk(t *testing.T) {
+	service := wireguard.NewMockService()
+	h := NewPeerHandler(service, "10.0.0.0/24")
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /peers/bulk", h.Bulk)
+
+	bulk := func(t *testing.T, body string) wireguard.BulkResult {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("POST", "/peers/bulk", strings.NewReader(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var result wireguard.BulkResult
+		if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		return result
+	}
+
+	t.Run("ItemCodes", func(t *testing.T) {
+		result := bulk(t, `{"operations":[
+			{"op":"create","peer":{"name":" New ","allowedIPs":["10.0.0.20/32"]}},
+			{"op":"delete","id":"missing"},
+			{"op":"delete","id":"force-error"}
+		]}`)
+		if !result.Applied || result.Succeeded != 1 || result.Failed != 2 {
+			t.Fatalf("expected one success and two failures, got %+v", result)
+		}
+		if peer := result.Results[0].Peer; peer == nil || peer.Name != "New" {
+			t.Errorf("expected the created peer with a trimmed name, got %+v", peer)
+		}
+		if item := result.Results[1]; item.Code != CodePeerNotFound {
+			t.Errorf("expected %s, got %+v", CodePeerNotFound, item)
+		}
+		if item := result.Results[2]; item.Code != CodeInternal || strings.Contains(item.Error, "forced") {
+			t.Errorf("expected an internal error without details, got %+v", item)
+		}
+	})
+
+	t.Run("AtomicDryRun", func(t *testing.T) {
+		for _, body := range []string{
+			`{"operations":[{"op":"disable","id":"mock-peer-1"}],"dryRun":true}`,
+			`{"operations":[{"op":"disable","id":"mock-peer-1"},{"op":"delete","id":"missing"}],"atomic":true}`,
+		} {
+			if result := bulk(t, body); result.Applied {
+				t.Errorf("expected %s not to be applied, got %+v", body, result)
+			}
+		}
+		peer, _ := service.GetPeer("mock-peer-1")
+		if peer.Status == wireguard.PeerStatusDisabled {
+			t.Error("expected the peer to stay enabled")
+		}
+	})
+	t.Run("CreateAllocatesAddresses", func(t *testing.T) {
+		h := NewPeerHandler(newFakeDeviceService(t), "10.0.0.0/24")
+		body := `{"operations":[{"op":"create","peer":{"name":"a"}},{"op":"create","peer":{"name":"b"}}],"atomic":true}`
+		rr := httptest.NewRecorder()
+		h.Bulk(rr, httptest.NewRequest("POST", "/peers/bulk", strings.NewReader(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var result wireguard.BulkResult
+		if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		if !result.Applied || result.Succeeded != 2 {
+			t.Fatalf("expected both peers to be created, got %+v", result)
+		}
+		a, b := result.Results[0].Peer.AllowedIPs, result.Results[1].Peer.AllowedIPs
+		if len(a) != 1 || len(b) != 1 || a[0] == b[0] || !strings.HasPrefix(a[0], "10.0.0.") || !strings.HasSuffix(a[0], "/32") {
+			t.Errorf("expected each peer to get its own address, got %v and %v", a, b)
+		}
+	})
+}
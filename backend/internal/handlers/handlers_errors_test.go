@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"wg-manager/backend/internal/middleware"
+	"wg-manager/backend/internal/wireguard"
+	"wg-manager/backend/internal/wireguard/fakedevice"
+)
+
+func TestWriteServiceError(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		status  int
+		code    string
+		message string
+	}{
+		{"NotFound", fmt.Errorf("wrapped: %w", wireguard.ErrPeerNotFound), http.StatusNotFound, CodePeerNotFound, "wrapped: peer not found"},
+		{"InvalidKey", fmt.Errorf("%w: bad base64", wireguard.ErrInvalidKey), http.StatusBadRequest, CodeInvalidKey, "invalid key: bad base64"},
+		{"Conflict", &wireguard.ConflictError{Prefix: "10.0.0.2/32", ConflictingPrefix: "10.0.0.0/24", PeerID: "abc"}, http.StatusConflict, CodeConflict, "allowed IP 10.0.0.2/32 overlaps 10.0.0.0/24 owned by peer abc"},
+		{"DeviceUnavailable", fmt.Errorf("%w: netlink", wireguard.ErrDeviceUnavailable), http.StatusServiceUnavailable, CodeDeviceUnavailable, "device unavailable: netlink"},
+		{"InvalidArgument", fmt.Errorf("%w: unknown route profile: lan", wireguard.ErrInvalidArgument), http.StatusBadRequest, CodeInvalidRequest, "invalid argument: unknown route profile: lan"},
+		{"ConfigUnavailable", fmt.Errorf("%w: peer abc: unknown route profile: lan", wireguard.ErrConfigUnavailable), http.StatusNotFound, CodeConfigUnavailable, "config unavailable: peer abc: unknown route profile: lan"},
+		{"Untyped", errors.New("disk exploded"), http.StatusInternalServerError, CodeInternal, "Internal Server Error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := middleware.RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				writeServiceError(w, r, tt.err)
+			}))
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set(middleware.RequestIDHeader, "req-123")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Errorf("expected %d, got %d", tt.status, rr.Code)
+			}
+			var body ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatalf("unmarshal error: %v", err)
+			}
+			if body.Code != tt.code || body.Message != tt.message || body.RequestID != "req-123" {
+				t.Errorf("expected %s/%q/req-123, got %+v", tt.code, tt.message, body)
+			}
+			if (tt.code == CodeConflict) != (body.Details != nil) {
+				t.Errorf("expected details only for conflicts, got %v", body.Details)
+			}
+		})
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	h := NewPeerHandler(wireguard.NewMockService(), "10.0.0.0/24")
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /peers/{id}", h.Remove)
+	mux.HandleFunc("POST /reconcile", h.Reconcile)
+	handler := middleware.RequestIDMiddleware(mux)
+
+	t.Run("PeerNotFound", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", "/peers/nonexistent", nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusNotFound {
+			t.Fatalf("expected 404, got %d", rr.Code)
+		}
+		var body ErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		if body.Code != CodePeerNotFound || body.RequestID == "" || body.RequestID != rr.Header().Get(middleware.RequestIDHeader) {
+			t.Errorf("expected peer_not_found with the response request ID, got %+v", body)
+		}
+	})
+
+	t.Run("BadRequest", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/reconcile", nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d", rr.Code)
+		}
+		var body ErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		if body.Code != CodeInvalidRequest || body.Message != "Invalid request body" {
+			t.Errorf("expected invalid_request, got %+v", body)
+		}
+	})
+}
+
+// newFakeDeviceService starts the real service on an in-memory device, for
+// behaviour the mock service does not have.
+func newFakeDeviceService(t *testing.T) wireguard.Service {
+	t.Helper()
+	return newFakeDeviceServiceAt(t, filepath.Join(t.TempDir(), "peers.json"))
+}
+
+// newFakeDeviceServiceAt is newFakeDeviceService with the store at path.
+func newFakeDeviceServiceAt(t *testing.T, path string) wireguard.Service {
+	t.Helper()
+	key, err := wgtypes.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := fakedevice.New()
+	client.AddDevice("wg0", key, 51820)
+	srv, err := wireguard.NewServiceWithClient(client, "wg0", path, "vpn.example.com:51820", "SERVER_PUB", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("NewServiceWithClient failed: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+// TestConfigStorageFailure checks that a config download whose record cannot
+// be saved is an internal error, not a missing config, and hides the cause.
+func TestConfigStorageFailure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "store")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	srv := newFakeDeviceServiceAt(t, filepath.Join(dir, "peers.json"))
+	peer, err := srv.AddPeer(wireguard.AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+	if err != nil {
+		t.Fatalf("AddPeer failed: %v", err)
+	}
+	// Change the rendered config, so the download has to be recorded.
+	dns := "9.9.9.9"
+	if _, err := srv.PatchSettings(wireguard.SettingsUpdate{DNS: &dns}, 0); err != nil {
+		t.Fatalf("PatchSettings failed: %v", err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewPeerHandler(srv, "10.0.0.0/24")
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /peers/config/{id}", h.GetConfig)
+	mux.HandleFunc("GET /peers/qr/{id}", h.GetQR)
+	for _, path := range []string{"/peers/config/" + peer.ID, "/peers/qr/" + peer.ID} {
+		t.Run(path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+			if rr.Code != http.StatusInternalServerError {
+				t.Fatalf("expected 500, got %d: %s", rr.Code, rr.Body.String())
+			}
+			var body ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatalf("unmarshal error: %v", err)
+			}
+			if body.Code != CodeInternal || strings.Contains(body.Message, dir) {
+				t.Errorf("expected a generic internal_error, got %+v", body)
+			}
+		})
+	}
+}
+
+// TestInvalidArgumentErrors checks that requests the service refuses as bad
+// input are reported as 400s rather than internal errors.
+func TestInvalidArgumentErrors(t *testing.T) {
+	srv := newFakeDeviceService(t)
+	key, err := wgtypes.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := srv.AddPeer(wireguard.AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+	if err != nil {
+		t.Fatalf("AddPeer failed: %v", err)
+	}
+	unknownProfile := "lan"
+	networks := []string{"192.168.1.0/24"}
+	endpoint := "203.0.113.1:51820"
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"ClaimableWithKey", func() error {
+			_, err := srv.AddPeer(wireguard.AddPeerOptions{Name: "a", Claimable: true, PublicKey: key.PublicKey().String(), AllowedIPs: []string{"10.0.0.3/32"}})
+			return err
+		}},
+		{"ClientWithNetworks", func() error {
+			_, err := srv.AddPeer(wireguard.AddPeerOptions{Name: "a", AllowedIPs: []string{"10.0.0.3/32"}, Networks: networks})
+			return err
+		}},
+		{"SiteWithoutNetworks", func() error {
+			_, err := srv.AddPeer(wireguard.AddPeerOptions{Name: "a", Type: wireguard.PeerTypeSite, AllowedIPs: []string{"10.0.0.3/32"}})
+			return err
+		}},
+		{"SiteNetworkInVPNSubnet", func() error {
+			_, err := srv.AddPeer(wireguard.AddPeerOptions{Name: "a", Type: wireguard.PeerTypeSite, AllowedIPs: []string{"10.0.0.3/32"}, Networks: []string{"10.0.0.0/16"}})
+			return err
+		}},
+		{"UnknownPeerType", func() error {
+			_, err := srv.AddPeer(wireguard.AddPeerOptions{Name: "a", Type: "router", AllowedIPs: []string{"10.0.0.3/32"}})
+			return err
+		}},
+		{"ClientWithEndpoint", func() error {
+			_, err := srv.AddPeer(wireguard.AddPeerOptions{Name: "a", AllowedIPs: []string{"10.0.0.3/32"}, Endpoint: endpoint})
+			return err
+		}},
+		{"UpdateClientNetworks", func() error {
+			_, err := srv.UpdatePeer(peer.ID, wireguard.PeerUpdate{Networks: &networks}, 0)
+			return err
+		}},
+		{"UpdateClientEndpoint", func() error {
+			_, err := srv.UpdatePeer(peer.ID, wireguard.PeerUpdate{Endpoint: &endpoint}, 0)
+			return err
+		}},
+		{"UpdateUnknownRouteProfile", func() error {
+			_, err := srv.UpdatePeer(peer.ID, wireguard.PeerUpdate{RouteProfile: &unknownProfile}, 0)
+			return err
+		}},
+		{"GroupUnknownRouteProfile", func() error {
+			_, err := srv.CreateGroup(wireguard.Group{Name: "staff", RouteProfile: unknownProfile})
+			return err
+		}},
+		{"SettingsUnknownRouteProfile", func() error {
+			_, err := srv.PatchSettings(wireguard.SettingsUpdate{ClientRouteProfile: &unknownProfile}, 0)
+			return err
+		}},
+		{"SettingsMalformedCIDR", func() error {
+			profiles := []wireguard.RouteProfile{{Name: "lan", AllowedIPs: []string{"10.0.0.0/8"}, Exclude: []string{"not-a-cidr"}}}
+			_, err := srv.PatchSettings(wireguard.SettingsUpdate{RouteProfiles: &profiles}, 0)
+			return err
+		}},
+		{"InviteTTL", func() error {
+			_, err := srv.CreateInvite(wireguard.InviteOptions{NameTemplate: "guest-{n}", TTL: -time.Hour})
+			return err
+		}},
+		{"InviteMaxUses", func() error {
+			_, err := srv.CreateInvite(wireguard.InviteOptions{NameTemplate: "guest-{n}", MaxUses: -1})
+			return err
+		}},
+		{"InviteNameTemplate", func() error {
+			_, err := srv.CreateInvite(wireguard.InviteOptions{NameTemplate: " "})
+			return err
+		}},
+		{"ShareTTL", func() error {
+			_, err := srv.SharePeer(peer.ID, -time.Hour)
+			return err
+		}},
+		{"RegenerateOverlap", func() error {
+			_, err := srv.RegeneratePeer(peer.ID, wireguard.RegenerateOptions{Overlap: -time.Second}, 0)
+			return err
+		}},
+		{"ReconcileMode", func() error {
+			_, err := srv.Reconcile("sideways")
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			if !errors.Is(err, wireguard.ErrInvalidArgument) {
+				t.Fatalf("expected ErrInvalidArgument, got %v", err)
+			}
+			rr := httptest.NewRecorder()
+			writeServiceError(rr, httptest.NewRequest("GET", "/", nil), err)
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("expected 400, got %d: %s", rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"wg-manager/backend/internal/wireguard"
+)
+
+func TestExport(t *testing.T) {
+	h := NewPeerHandler(wireguard.NewMockService(), "10.0.0.0/24")
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /peers/export", h.Export)
+
+	export := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("CSV", func(t *testing.T) {
+		rr := export("/peers/export?format=csv", "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+			t.Errorf("expected text/csv, got %s", ct)
+		}
+		records, err := csv.NewReader(rr.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("failed to read CSV: %v", err)
+		}
+		if len(records) != 3 || !slices.Equal(records[0], exportColumns) {
+			t.Fatalf("expected a header and 2 peers, got %v", records)
+		}
+		if records[1][1] != "Mobile Client" {
+			t.Errorf("expected peers sorted by name, got %v", records[1])
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		rr := export("/peers/export?q=primary", "")
+		var peers []wireguard.PeerExport
+		if err := json.Unmarshal(rr.Body.Bytes(), &peers); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		if len(peers) != 1 || peers[0].ID != "mock-peer-1" {
+			t.Errorf("expected the searched peer, got %+v", peers)
+		}
+	})
+
+	t.Run("Secrets", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			configured string
+			token      string
+			want       int
+		}{
+			{"Disabled", "", "anything", http.StatusForbidden},
+			{"MissingToken", "s3cret", "", http.StatusForbidden},
+			{"WrongToken", "s3cret", "guess", http.StatusForbidden},
+			{"Authorised", "s3cret", "s3cret", http.StatusOK},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				h.ExportToken = tt.configured
+				rr := export("/peers/export?format=csv&secrets=true", tt.token)
+				if rr.Code != tt.want {
+					t.Fatalf("expected %d, got %d: %s", tt.want, rr.Code, rr.Body.String())
+				}
+				if tt.want == http.StatusForbidden && !strings.Contains(rr.Body.String(), CodeForbidden) {
+					t.Errorf("expected %s, got %s", CodeForbidden, rr.Body.String())
+				}
+				if tt.want == http.StatusOK && !strings.Contains(rr.Body.String(), "privateKey,presharedKey") {
+					t.Errorf("expected the key columns, got %s", rr.Body.String())
+				}
+			})
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		rr := export("/peers/export?format=xml&secrets=maybe", "")
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeValidationFailed) {
+			t.Errorf("expected 400 %s, got %d: %s", CodeValidationFailed, rr.Code, rr.Body.String())
+		}
+	})
+}
+
+func TestImport(t *testing.T) {
+	service := wireguard.NewMockService()
+	h := NewPeerHandler(service, "10.0.0.0/24")
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /peers/import", h.Import)
+
+	post := func(path, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	decode := func(t *testing.T, rr *httptest.ResponseRecorder) wireguard.BulkResult {
+		t.Helper()
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var result wireguard.BulkResult
+		if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		return result
+	}
+	count := func() int {
+		peers, _ := service.ListPeers()
+		return len(peers)
+	}
+
+	t.Run("PreviewCSV", func(t *testing.T) {
+		body := "\ufeffName,Email,IP\nAda Lovelace,ada@example.com,\nAlan Turing,alan@example.com,10.0.0.9\n"
+		result := decode(t, post("/peers/import?preview=true", "text/csv; charset=utf-8", body))
+		if result.Applied || !result.DryRun || result.Succeeded != 2 {
+			t.Fatalf("expected a preview of 2 peers, got %+v", result)
+		}
+		for i, want := range []string{"Ada Lovelace", "Alan Turing"} {
+			if item := result.Results[i]; item.Index != i || item.Peer == nil || item.Peer.Name != want {
+				t.Errorf("expected row %d to be %s, got %+v", i, want, item)
+			}
+		}
+		if got := result.Results[1].Peer.AllowedIPs; !slices.Equal(got, []string{"10.0.0.9/32"}) {
+			t.Errorf("expected the given address as a host prefix, got %v", got)
+		}
+		if count() != 2 {
+			t.Error("expected the preview not to create peers")
+		}
+	})
+
+	t.Run("AllOrNothing", func(t *testing.T) {
+		result := decode(t, post("/peers/import", "application/json", `{"rows":[{"name":"New"},{"name":"Clash","ip":"10.0.0.2"}]}`))
+		if result.Applied || result.Failed != 1 {
+			t.Fatalf("expected the import not to be applied, got %+v", result)
+		}
+		if item := result.Results[1]; item.Index != 1 || item.Code != CodeConflict {
+			t.Errorf("expected row 1 to fail with %s, got %+v", CodeConflict, item)
+		}
+		if count() != 2 {
+			t.Error("expected no peers to be created")
+		}
+
+		result = decode(t, post("/peers/import", "application/json", `{"rows":[{"name":"New","group":"staff"}]}`))
+		if !result.Applied || count() != 3 {
+			t.Errorf("expected the peer to be created, got %+v", result)
+		}
+	})
+
+	t.Run("RowErrors", func(t *testing.T) {
+		body := "name,group,publicKey,ip\n,staff,,\nBob,,not-a-key,10.0.0.0/24\nCarol,,,192.168.1.5\n"
+		rr := post("/peers/import", "text/csv", body)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var resp struct {
+			Details []struct {
+				Field string `json:"field"`
+			} `json:"details"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		var got []string
+		for _, d := range resp.Details {
+			got = append(got, d.Field)
+		}
+		if want := []string{"rows[0].name", "rows[1].publicKey", "rows[1].ip", "rows[2].ip"}; !slices.Equal(got, want) {
+			t.Errorf("expected fields %v, got %v", want, got)
+		}
+	})
+
+	t.Run("MalformedBody", func(t *testing.T) {
+		for _, tt := range []struct{ contentType, body string }{
+			{"text/csv", "group,ip\nstaff,\n"},
+			{"text/csv", "name,ip\nAda\n"},
+			{"application/json", "{"},
+			{"application/xml", "<rows/>"},
+		} {
+			rr := post("/peers/import", tt.contentType, tt.body)
+			if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeInvalidRequest) {
+				t.Errorf("%s %q: expected 400 %s, got %d: %s", tt.contentType, tt.body, CodeInvalidRequest, rr.Code, rr.Body.String())
+			}
+		}
+	})
+}
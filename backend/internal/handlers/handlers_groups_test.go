@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"wg-manager/backend/internal/wireguard"
+)
+
+func TestGroups(t *testing.T) {
+	service := wireguard.NewMockService()
+	groups := NewGroupHandler(service)
+	peers := NewPeerHandler(service, "10.0.0.0/24")
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /groups", groups.List)
+	mux.HandleFunc("POST /groups", groups.Create)
+	mux.HandleFunc("GET /groups/{name}", groups.Get)
+	mux.HandleFunc("PUT /groups/{name}", groups.Update)
+	mux.HandleFunc("DELETE /groups/{name}", groups.Delete)
+	mux.HandleFunc("GET /peers", peers.List)
+	mux.HandleFunc("POST /peers", peers.Add)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rr
+	}
+
+	t.Run("Lifecycle", func(t *testing.T) {
+		if rr := do("POST", "/groups", `{"name":"contractors","mtu":1380,"peerLifetime":86400}`); rr.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if rr := do("POST", "/groups", `{"name":"contractors"}`); rr.Code != http.StatusConflict {
+			t.Errorf("expected 409 for a duplicate name, got %d", rr.Code)
+		}
+
+		rr := do("PUT", "/groups/contractors", `{"name":"ignored","dns":"10.0.0.53"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var change wireguard.GroupChange
+		if err := json.Unmarshal(rr.Body.Bytes(), &change); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		if change.Group.Name != "contractors" || change.Group.DNS != "10.0.0.53" {
+			t.Errorf("expected the group to keep its name and take the new DNS, got %+v", change.Group)
+		}
+
+		if rr := do("DELETE", "/groups/contractors", ""); rr.Code != http.StatusNoContent {
+			t.Errorf("expected 204, got %d", rr.Code)
+		}
+		rr = do("GET", "/groups/contractors", "")
+		if rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), CodeGroupNotFound) {
+			t.Errorf("expected 404 %s, got %d: %s", CodeGroupNotFound, rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("FilterPeers", func(t *testing.T) {
+		rr := do("POST", "/peers", `{"name":"tagged","allowedIPs":["10.0.0.20/32"],"group":"staff","tags":["oncall"]}`)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		tests := []struct {
+			query string
+			want  int
+		}{
+			{"", 3},
+			{"?group=staff", 1},
+			{"?tag=oncall", 1},
+			{"?group=staff&tag=other", 0},
+		}
+		for _, tt := range tests {
+			rr := do("GET", "/peers"+tt.query, "")
+			var listed []wireguard.Peer
+			if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil {
+				t.Fatalf("unmarshal error: %v", err)
+			}
+			if len(listed) != tt.want {
+				t.Errorf("%q: expected %d peers, got %d", tt.query, tt.want, len(listed))
+			}
+		}
+
+		// Paging parameters switch to the PeerPage shape.
+		var page wireguard.PeerPage
+		if err := json.Unmarshal(do("GET", "/peers?limit=2", "").Body.Bytes(), &page); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		if len(page.Peers) != 2 || page.Total != 3 || page.NextCursor == "" {
+			t.Fatalf("expected a first page of 2 of 3 peers, got %+v", page)
+		}
+		var last wireguard.PeerPage
+		if err := json.Unmarshal(do("GET", "/peers?cursor="+page.NextCursor, "").Body.Bytes(), &last); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		if len(last.Peers) != 1 || last.NextCursor != "" {
+			t.Errorf("expected the last peer on the next page, got %+v", last)
+		}
+
+		if rr := do("GET", "/peers?cursor=bogus", ""); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeInvalidRequest) {
+			t.Errorf("expected 400 %s for a bad cursor, got %d: %s", CodeInvalidRequest, rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		tests := []struct {
+			name, method, path, body, field string
+		}{
+			{"GroupName", "POST", "/groups", `{"name":""}`, "name"},
+			{"GroupMTU", "POST", "/groups", `{"name":"small","mtu":500}`, "mtu"},
+			{"Lifetime", "PUT", "/groups/staff", `{"peerLifetime":-1}`, "peerLifetime"},
+			{"PeerTag", "POST", "/peers", `{"name":"x","allowedIPs":["10.0.0.21/32"],"tags":["on call"]}`, "tags[0]"},
+			{"PeerExpires", "POST", "/peers", `{"name":"x","allowedIPs":["10.0.0.21/32"],"expires":-5}`, "expires"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				rr := do(tt.method, tt.path, tt.body)
+				if rr.Code != http.StatusBadRequest {
+					t.Fatalf("expected 400, got %d", rr.Code)
+				}
+				if !strings.Contains(rr.Body.String(), `"field":"`+tt.field+`"`) {
+					t.Errorf("expected an error for %s, got %s", tt.field, rr.Body.String())
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"wg-manager/backend/internal/wireguard"
+)
+
+// notReadyService fails readiness so Readyz can be tested against the mock.
+type notReadyService struct {
+	wireguard.Service
+}
+
+func (notReadyService) Readiness() wireguard.Readiness {
+	return wireguard.Readiness{Checks: []wireguard.HealthCheck{{Name: "storage", Message: "read-only file system"}}}
+}
+
+func TestHealthHandlers(t *testing.T) {
+	h := NewHealthHandler(wireguard.NewMockService(), "userspace")
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", h.Health)
+	mux.HandleFunc("GET /healthz", h.Healthz)
+	mux.HandleFunc("GET /readyz", h.Readyz)
+	mux.HandleFunc("GET /diagnostics", h.Diagnostics)
+
+	for _, path := range []string{"/health", "/healthz"} {
+		t.Run(path, func(t *testing.T) {
+			req := httptest.NewRequest("GET", path, nil)
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", rr.Code)
+			}
+			var resp HealthResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("unmarshal error: %v", err)
+			}
+			if resp.Status != "ok" || resp.Backend != "userspace" {
+				t.Errorf("expected ok/userspace, got %+v", resp)
+			}
+		})
+	}
+
+	t.Run("Ready", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/readyz", nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", rr.Code)
+		}
+	})
+
+	t.Run("NotReady", func(t *testing.T) {
+		h := NewHealthHandler(notReadyService{wireguard.NewMockService()}, "kernel")
+		req := httptest.NewRequest("GET", "/readyz", nil)
+		rr := httptest.NewRecorder()
+		h.Readyz(rr, req)
+
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Fatalf("expected 503, got %d", rr.Code)
+		}
+		var readiness wireguard.Readiness
+		if err := json.Unmarshal(rr.Body.Bytes(), &readiness); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		if readiness.Ready || len(readiness.Checks) != 1 || readiness.Checks[0].Name != "storage" {
+			t.Errorf("expected failing storage check, got %+v", readiness)
+		}
+	})
+
+	t.Run("Diagnostics", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/diagnostics", nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", rr.Code)
+		}
+		var diag wireguard.Diagnostics
+		if err := json.Unmarshal(rr.Body.Bytes(), &diag); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		if diag.Interface != "wg0" {
+			t.Errorf("expected interface wg0, got %q", diag.Interface)
+		}
+	})
+}
@@ -11,7 +11,7 @@ import (
 
 func TestHistoryAndSettingsHandlers(t *testing.T) {
 	mockWGService := wireguard.NewMockService()
-	h := NewPeerHandler(mockWGService)
+	h := NewPeerHandler(mockWGService, "10.0.0.0/24")
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /stats/history", h.GetHistory)
 	mux.HandleFunc("GET /settings", h.GetSettings)
@@ -54,7 +54,7 @@ func TestHistoryAndSettingsHandlers(t *testing.T) {
 	})
 
 	t.Run("UpdateSettings", func(t *testing.T) {
-		reqBody := `{"serverAddress":"10.0.0.1/24", "dns":"1.1.1.1", "mtu":1420, "keepalive":25, "endpoint":"vpn.example.com"}`
+		reqBody := `{"serverAddress":"10.0.0.1/24", "dns":"1.1.1.1", "mtu":1420, "keepalive":25, "endpoint":"vpn.example.com:51820"}`
 		req := httptest.NewRequest("POST", "/settings", strings.NewReader(reqBody))
 		rr := httptest.NewRecorder()
 		mux.ServeHTTP(rr, req)
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"wg-manager/backend/internal/wireguard"
+)
+
+func TestInvites(t *testing.T) {
+	h := NewInviteHandler(wireguard.NewMockService())
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /invites", h.List)
+	mux.HandleFunc("POST /invites", h.Create)
+	mux.HandleFunc("DELETE /invites/{id}", h.Revoke)
+	mux.HandleFunc("POST /invites/redeem", h.Redeem)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rr
+	}
+
+	t.Run("Lifecycle", func(t *testing.T) {
+		rr := do("POST", "/invites", `{"nameTemplate":"laptop-{n}","maxUses":3}`)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var invite wireguard.InviteResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &invite); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		if invite.Token == "" || invite.MaxUses != 3 {
+			t.Errorf("expected a token for 3 uses, got %+v", invite)
+		}
+
+		rr = do("POST", "/invites/redeem", `{"token":"`+invite.Token+`","name":"Dana"}`)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		if rr = do("DELETE", "/invites/"+invite.ID, ""); rr.Code != http.StatusNoContent {
+			t.Errorf("expected 204, got %d", rr.Code)
+		}
+		rr = do("GET", "/invites", "")
+		var invites []wireguard.Invite
+		if err := json.Unmarshal(rr.Body.Bytes(), &invites); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		if len(invites) != 1 || !invites[0].Revoked {
+			t.Errorf("expected the invite revoked, got %+v", invites)
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		tests := []struct {
+			name, path, body, field string
+		}{
+			{"Template", "/invites", `{"nameTemplate":"bad\t{n}"}`, "nameTemplate"},
+			{"MaxUses", "/invites", `{"nameTemplate":"guest-{n}","maxUses":-1}`, "maxUses"},
+			{"Token", "/invites/redeem", `{"name":"Dana"}`, "token"},
+			{"PublicKey", "/invites/redeem", `{"token":"abc","publicKey":"nope"}`, "publicKey"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				rr := do("POST", tt.path, tt.body)
+				if rr.Code != http.StatusBadRequest {
+					t.Fatalf("expected 400, got %d", rr.Code)
+				}
+				if !strings.Contains(rr.Body.String(), `"field":"`+tt.field+`"`) {
+					t.Errorf("expected an error for %s, got %s", tt.field, rr.Body.String())
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"wg-manager/backend/internal/wireguard"
+)
+
+func TestReconcileHandlers(t *testing.T) {
+	mockWGService := wireguard.NewMockService()
+	h := NewPeerHandler(mockWGService, "10.0.0.0/24")
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /reconcile", h.Drift)
+	mux.HandleFunc("POST /reconcile", h.Reconcile)
+	mux.HandleFunc("GET /events", h.Events)
+
+	t.Run("DryRun", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/reconcile", nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", rr.Code)
+		}
+
+		var report wireguard.DriftReport
+		if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		if !report.InSync {
+			t.Error("expected mock device to be in sync")
+		}
+	})
+
+	t.Run("Apply", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/reconcile", strings.NewReader(`{"mode":"storage-wins"}`))
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", rr.Code)
+		}
+
+		var result wireguard.ReconcileResult
+		if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		if result.Mode != wireguard.ReconcileStorageWins {
+			t.Errorf("expected mode %q, got %q", wireguard.ReconcileStorageWins, result.Mode)
+		}
+	})
+
+	t.Run("InvalidMode", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/reconcile", strings.NewReader(`{"mode":"yolo"}`))
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("Events", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/events", nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("expected 200, got %d", rr.Code)
+		}
+	})
+}
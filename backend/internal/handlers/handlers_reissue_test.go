@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"wg-manager/backend/internal/wireguard"
+)
+
+func TestReissue(t *testing.T) {
+	t.Run("Route", func(t *testing.T) {
+		h := NewPeerHandler(wireguard.NewMockService(), "10.0.0.0/24")
+		mux := http.NewServeMux()
+		mux.HandleFunc("POST /peers/{id}/reissue", h.Reissue)
+
+		req := httptest.NewRequest("POST", "/peers/mock-peer-1/reissue", nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if rr.Header().Get("ETag") == "" {
+			t.Error("expected an ETag")
+		}
+	})
+
+	t.Run("QRCodeOnlyWhenDiscarded", func(t *testing.T) {
+		peer := wireguard.PeerResponse{Config: "[Interface]\nPrivateKey = abc\n", PrivateKey: "abc"}
+		if got := withQRCode(peer); got.QRCode != "" {
+			t.Error("expected no QR code for a stored private key")
+		}
+		peer.PrivateKeyDiscarded = true
+		if got := withQRCode(peer); !strings.HasPrefix(got.QRCode, "data:image/png;base64,") {
+			t.Errorf("expected a PNG data URL, got %.40q", got.QRCode)
+		}
+	})
+}
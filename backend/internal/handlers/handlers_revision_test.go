@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"wg-manager/backend/internal/wireguard"
+)
+
+func TestPeerRevisions(t *testing.T) {
+	h := NewPeerHandler(wireguard.NewMockService(), "10.0.0.0/24")
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /peers/{id}", h.Get)
+	mux.HandleFunc("PATCH /peers/{id}", h.Update)
+	mux.HandleFunc("DELETE /peers/{id}", h.Remove)
+	mux.HandleFunc("POST /peers/{id}/regenerate-keys", h.Regenerate)
+
+	serve := func(method, path, body, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("GetSetsETag", func(t *testing.T) {
+		rr := serve("GET", "/peers/mock-peer-1", "", "")
+		if rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"1"` {
+			t.Errorf("expected 200 with ETag \"1\", got %d %q", rr.Code, rr.Header().Get("ETag"))
+		}
+	})
+
+	t.Run("PatchWithCurrentETag", func(t *testing.T) {
+		rr := serve("PATCH", "/peers/mock-peer-1", `{"name":"Renamed"}`, `"1"`)
+		if rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"2"` {
+			t.Errorf("expected 200 with ETag \"2\", got %d %q", rr.Code, rr.Header().Get("ETag"))
+		}
+	})
+
+	for _, tt := range []struct{ method, path, body string }{
+		{"PATCH", "/peers/mock-peer-1", `{"name":"Clobbered"}`},
+		{"DELETE", "/peers/mock-peer-1", ""},
+		{"POST", "/peers/mock-peer-1/regenerate-keys", ""},
+	} {
+		t.Run("Stale/"+tt.method, func(t *testing.T) {
+			rr := serve(tt.method, tt.path, tt.body, `"1"`)
+			if rr.Code != http.StatusPreconditionFailed {
+				t.Errorf("expected 412, got %d", rr.Code)
+			}
+		})
+	}
+
+	t.Run("DeleteWithoutIfMatch", func(t *testing.T) {
+		rr := serve("DELETE", "/peers/mock-peer-2", "", "")
+		if rr.Code != http.StatusNoContent {
+			t.Errorf("expected 204, got %d", rr.Code)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"wg-manager/backend/internal/wireguard"
+)
+
+func TestSettingsRevisions(t *testing.T) {
+	h := NewPeerHandler(wireguard.NewMockService(), "10.0.0.0/24")
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /settings", h.GetSettings)
+	mux.HandleFunc("PUT /settings", h.ReplaceSettings)
+	mux.HandleFunc("PATCH /settings", h.PatchSettings)
+
+	serve := func(method, body, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/settings", strings.NewReader(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("GetSetsETag", func(t *testing.T) {
+		rr := serve("GET", "", "")
+		if etag := rr.Header().Get("ETag"); etag != `"1"` {
+			t.Errorf("expected ETag \"1\", got %q", etag)
+		}
+	})
+
+	t.Run("PatchKeepsOtherFields", func(t *testing.T) {
+		rr := serve("PATCH", `{"dns":"9.9.9.9"}`, `"1"`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var change wireguard.SettingsChange
+		if err := json.Unmarshal(rr.Body.Bytes(), &change); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		if change.Settings.DNS != "9.9.9.9" || change.Settings.MTU != 1420 || change.Settings.Endpoint == "" {
+			t.Errorf("expected only DNS to change, got %+v", change.Settings)
+		}
+		if etag := rr.Header().Get("ETag"); etag != `"2"` {
+			t.Errorf("expected ETag \"2\", got %q", etag)
+		}
+	})
+
+	t.Run("PutValidatesFullBody", func(t *testing.T) {
+		rr := serve("PUT", `{"dns":"9.9.9.9"}`, "")
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for missing MTU, got %d", rr.Code)
+		}
+	})
+
+	for _, ifMatch := range []string{`"7"`, `W/"1"`, "garbage"} {
+		t.Run("PreconditionFailed/"+ifMatch, func(t *testing.T) {
+			rr := serve("PATCH", `{"mtu":1400}`, ifMatch)
+			if rr.Code != http.StatusPreconditionFailed {
+				t.Fatalf("expected 412, got %d", rr.Code)
+			}
+			var body ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatalf("unmarshal error: %v", err)
+			}
+			if body.Code != CodePreconditionFailed {
+				t.Errorf("expected %s, got %s", CodePreconditionFailed, body.Code)
+			}
+		})
+	}
+}
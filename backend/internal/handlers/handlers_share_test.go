@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"wg-manager/backend/internal/wireguard"
+)
+
+func TestShare(t *testing.T) {
+	h := NewPeerHandler(wireguard.NewMockService(), "10.0.0.0/24")
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /peers/{id}/share", h.Share)
+	mux.HandleFunc("GET /share/{token}", h.OpenShare)
+
+	t.Run("Create", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/peers/mock-peer-1/share", nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var link wireguard.ShareLink
+		if err := json.Unmarshal(rr.Body.Bytes(), &link); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		if link.Token == "" || link.PeerID != "mock-peer-1" {
+			t.Errorf("expected a link to mock-peer-1, got %+v", link)
+		}
+	})
+
+	t.Run("Open", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/share/mock-share-token", nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if got := rr.Header().Get("Cache-Control"); got != "no-store" {
+			t.Errorf("expected Cache-Control no-store, got %q", got)
+		}
+		body := rr.Body.String()
+		for _, want := range []string{"[Interface]", `src="data:image/png;base64,`, `href="data:text/plain;base64,`} {
+			if !strings.Contains(body, want) {
+				t.Errorf("expected the page to contain %q", want)
+			}
+		}
+	})
+
+	t.Run("Audit", func(t *testing.T) {
+		srv := newFakeDeviceService(t)
+		h := NewPeerHandler(srv, "10.0.0.0/24")
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /share/{token}", h.OpenShare)
+		mux.HandleFunc("GET /shares/audit", h.ShareAudit)
+
+		resp, err := srv.AddPeer(wireguard.AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		link, err := srv.SharePeer(resp.ID, time.Hour)
+		if err != nil {
+			t.Fatalf("SharePeer failed: %v", err)
+		}
+		for _, want := range []int{http.StatusOK, http.StatusNotFound} {
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, httptest.NewRequest("GET", link.Path, nil))
+			if rr.Code != want {
+				t.Fatalf("expected %d, got %d: %s", want, rr.Code, rr.Body.String())
+			}
+		}
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("GET", "/shares/audit", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if strings.Contains(rr.Body.String(), link.Token) {
+			t.Error("expected the audit log not to contain the token")
+		}
+		var audit []wireguard.ShareAuditEntry
+		if err := json.Unmarshal(rr.Body.Bytes(), &audit); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		var types []string
+		for _, e := range audit {
+			types = append(types, e.Type)
+		}
+		want := []string{wireguard.EventShareCreated, wireguard.EventShareOpened, wireguard.EventShareRejected}
+		if !slices.Equal(types, want) {
+			t.Errorf("expected %v, got %v", want, types)
+		}
+	})
+
+	t.Run("InvalidLifetime", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/peers/mock-peer-1/share", strings.NewReader(`{"expiresInSeconds":-1}`))
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected 400, got %d", rr.Code)
+		}
+	})
+}
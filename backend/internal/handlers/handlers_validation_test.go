@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"wg-manager/backend/internal/wireguard"
+)
+
+func TestValidationErrors(t *testing.T) {
+	h := NewPeerHandler(wireguard.NewMockService(), "10.0.0.0/24")
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /peers", h.List)
+	mux.HandleFunc("POST /peers", h.Add)
+	mux.HandleFunc("PATCH /peers/{id}", h.Update)
+	mux.HandleFunc("POST /settings", h.UpdateSettings)
+	mux.HandleFunc("POST /peers/{id}/regenerate-keys", h.Regenerate)
+	mux.HandleFunc("POST /peers/{id}/claim", h.Claim)
+	mux.HandleFunc("POST /peers/bulk", h.Bulk)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		fields []string
+	}{
+		{
+			name:   "AddPeer",
+			method: "POST", path: "/peers",
+			body:   `{"name":"bad\tname","allowedIPs":["10.0.0.9/32","nope"],"dns":"1.1.1.1, ???","mtu":100,"persistentKeepalive":-5,"interfaceAddress":"192.168.0.1/24"}`,
+			fields: []string{"name", "allowedIPs[1]", "dns", "mtu", "persistentKeepalive", "interfaceAddress"},
+		},
+		{
+			name:   "AddClientWithEndpoint",
+			method: "POST", path: "/peers",
+			body:   `{"name":"Laptop","allowedIPs":["10.0.0.9/32"],"endpoint":"203.0.113.1:51820"}`,
+			fields: []string{"endpoint"},
+		},
+		{
+			name:   "UpdatePeer",
+			method: "PATCH", path: "/peers/mock-peer-1",
+			body:   `{"mtu":0,"endpoint":"no-port","interfaceAddress":"10.1.0.1"}`,
+			fields: []string{"endpoint", "interfaceAddress"},
+		},
+		{
+			name:   "Settings",
+			method: "POST", path: "/settings",
+			body:   `{"serverAddress":"10.9.0.1/24","dns":"dns server","mtu":0,"keepalive":-1,"endpoint":"vpn.example.com"}`,
+			fields: []string{"serverAddress", "dns", "mtu", "keepalive", "endpoint"},
+		},
+		{
+			name:   "RegenerateOverlap",
+			method: "POST", path: "/peers/mock-peer-1/regenerate-keys",
+			body:   `{"overlapSeconds":-1}`,
+			fields: []string{"overlapSeconds"},
+		},
+		{
+			name:   "ClaimableWithKey",
+			method: "POST", path: "/peers",
+			body:   `{"name":"Laptop","allowedIPs":["10.0.0.9/32"],"claimable":true,"publicKey":"xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="}`,
+			fields: []string{"publicKey"},
+		},
+		{
+			name:   "ListQuery",
+			method: "GET", path: "/peers?status=sleeping&sort=age&order=up&limit=0",
+			fields: []string{"status", "sort", "order", "limit"},
+		},
+		{
+			name:   "Bulk",
+			method: "POST", path: "/peers/bulk",
+			body:   `{"operations":[{"op":"rename","id":"mock-peer-1"},{"op":"create","id":"mock-peer-1","peer":{"name":"","allowedIPs":["10.0.0.9/32"]}},{"op":"delete"},{"op":"disable","selector":{}},{"op":"update","id":"mock-peer-1","changes":{"mtu":100}},{"op":"regenerate","selector":{"tag":"old"},"overlapSeconds":-1}]}`,
+			fields: []string{"operations[0].op", "operations[1]", "operations[1].peer.name", "operations[2]", "operations[3].selector", "operations[4].changes.mtu", "operations[5].overlapSeconds"},
+		},
+		{
+			name:   "ClaimKey",
+			method: "POST", path: "/peers/mock-peer-1/claim",
+			body:   `{"publicKey":"not-a-key"}`,
+			fields: []string{"publicKey"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400, got %d", rr.Code)
+			}
+			var body struct {
+				Code    string `json:"code"`
+				Details []struct {
+					Field   string `json:"field"`
+					Message string `json:"message"`
+				} `json:"details"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatalf("unmarshal error: %v", err)
+			}
+			if body.Code != CodeValidationFailed {
+				t.Errorf("expected %s, got %s", CodeValidationFailed, body.Code)
+			}
+
+			var got []string
+			for _, d := range body.Details {
+				got = append(got, d.Field)
+			}
+			slices.Sort(got)
+			want := slices.Clone(tt.fields)
+			slices.Sort(want)
+			if !slices.Equal(got, want) {
+				t.Errorf("expected fields %v, got %v", want, got)
+			}
+		})
+	}
+}
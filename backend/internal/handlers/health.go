@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"wg-manager/backend/internal/wireguard"
+)
+
+type HealthHandler struct {
+	Service wireguard.Service
+	Backend string
+}
+
+func NewHealthHandler(service wireguard.Service, backend string) *HealthHandler {
+	return &HealthHandler{Service: service, Backend: backend}
+}
+
+type HealthResponse struct {
+	Status  string `json:"status"`
+	Backend string `json:"backend"`
+}
+
+// Health reports that the server is up and which WireGuard backend is active.
+func (h *HealthHandler) Health(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(HealthResponse{Status: "ok", Backend: h.Backend}); err != nil {
+		slog.Error("Failed to encode health response", "error", err)
+	}
+}
+
+// Healthz is a liveness probe: it succeeds whenever the process can serve HTTP.
+func (h *HealthHandler) Healthz(w http.ResponseWriter, r *http.Request) {
+	h.Health(w, r)
+}
+
+// Readyz returns 503 unless the device, storage and background collectors are healthy.
+func (h *HealthHandler) Readyz(w http.ResponseWriter, r *http.Request) {
+	readiness := h.Service.Readiness()
+	if !readiness.Ready {
+		slog.Warn("Readiness check failed", "checks", readiness.Checks)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !readiness.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(readiness); err != nil {
+		slog.Error("Failed to encode readiness response", "error", err)
+	}
+}
+
+// Diagnostics reports host state that commonly breaks tunnels.
+func (h *HealthHandler) Diagnostics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.Service.Diagnostics()); err != nil {
+		slog.Error("Failed to encode diagnostics response", "error", err)
+	}
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"wg-manager/backend/internal/validation"
+	"wg-manager/backend/internal/wireguard"
+)
+
+// ImportRow is one peer to import.
+type ImportRow struct {
+	Name  string `json:"name"`
+	Group string `json:"group,omitempty"`
+	// PublicKey is the client's own key; without one, keys are generated.
+	PublicKey string `json:"publicKey,omitempty"`
+	// IP is the peer's tunnel address. Without one, the next free address
+	// of the VPN subnet is assigned.
+	IP string `json:"ip,omitempty"`
+}
+
+// ImportRequest is the JSON body of an import. A CSV body has the same
+// fields as columns.
+type ImportRequest struct {
+	Rows []ImportRow `json:"rows"`
+}
+
+// Import creates a peer per row of a CSV or JSON body, all or nothing. With
+// preview=true it reports what the import would do without applying it.
+func (h *PeerHandler) Import(w http.ResponseWriter, r *http.Request) {
+	var preview bool
+	if v := r.URL.Query().Get("preview"); v != "" {
+		var err error
+		if preview, err = strconv.ParseBool(v); err != nil {
+			var errs validation.Errors
+			errs.Add("preview", "must be true or false")
+			writeValidationError(w, r, errs)
+			return
+		}
+	}
+
+	rows, err := readImport(r)
+	if err != nil {
+		slog.Error("Failed to read import", "error", err)
+		writeBadRequest(w, r, "Invalid import: "+err.Error())
+		return
+	}
+	for i := range rows {
+		rows[i].Name = strings.TrimSpace(rows[i].Name)
+		rows[i].Group = strings.TrimSpace(rows[i].Group)
+		rows[i].PublicKey = strings.TrimSpace(rows[i].PublicKey)
+		rows[i].IP = strings.TrimSpace(rows[i].IP)
+	}
+	if errs := validateImport(rows, h.VPNSubnet); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	// Rows with an address go first, so no address is handed out to a row
+	// without one before the row that asked for it.
+	order := make([]int, 0, len(rows))
+	for i, row := range rows {
+		if row.IP != "" {
+			order = append(order, i)
+		}
+	}
+	for i, row := range rows {
+		if row.IP == "" {
+			order = append(order, i)
+		}
+	}
+	ops := make([]wireguard.BulkOperation, len(rows))
+	for i, n := range order {
+		row := rows[n]
+		opts := wireguard.AddPeerOptions{Name: row.Name, Group: row.Group, PublicKey: row.PublicKey}
+		if row.IP != "" {
+			prefix, _ := hostPrefix(row.IP)
+			opts.AllowedIPs = []string{prefix.String()}
+		}
+		ops[i] = wireguard.BulkOperation{Op: wireguard.BulkCreate, Create: opts}
+	}
+	result, err := h.Service.Bulk(ops, wireguard.BulkOptions{Atomic: true, DryRun: preview})
+	if err != nil {
+		slog.Error("Failed to import peers", "error", err)
+		writeServiceError(w, r, err)
+		return
+	}
+	for i := range result.Results {
+		result.Results[i].Index = order[result.Results[i].Index]
+	}
+	slices.SortFunc(result.Results, func(a, b wireguard.BulkItemResult) int { return a.Index - b.Index })
+
+	writeBulkResult(w, result)
+}
+
+// readImport decodes the rows of a CSV or JSON body, picked by its content
+// type. Its errors are safe to show the client.
+func readImport(r *http.Request) ([]ImportRow, error) {
+	mediaType := "application/json"
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(ct); err != nil {
+			return nil, errors.New("malformed Content-Type")
+		}
+	}
+
+	switch mediaType {
+	case "application/json":
+		var req ImportRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, errors.New("malformed JSON")
+		}
+		return req.Rows, nil
+	case "text/csv":
+		return readImportCSV(r.Body)
+	}
+	return nil, fmt.Errorf("unsupported Content-Type %s; use application/json or text/csv", mediaType)
+}
+
+// readImportCSV reads rows from CSV with a header row naming the columns
+// name, group, publicKey and ip, in any order and case. Only name is
+// required; other columns are ignored.
+func readImportCSV(body io.Reader) ([]ImportRow, error) {
+	in := csv.NewReader(body)
+	in.TrimLeadingSpace = true
+	header, err := in.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("CSV is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed CSV: %v", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		if i == 0 {
+			// Spreadsheets often save UTF-8 with a byte order mark.
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, errors.New("CSV header must have a name column")
+	}
+	field := func(record []string, column string) string {
+		if i, ok := columns[column]; ok {
+			return record[i]
+		}
+		return ""
+	}
+
+	rows := []ImportRow{}
+	for {
+		record, err := in.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("malformed CSV: %v", err)
+		}
+		rows = append(rows, ImportRow{
+			Name:      field(record, "name"),
+			Group:     field(record, "group"),
+			PublicKey: field(record, "publickey"),
+			IP:        field(record, "ip"),
+		})
+	}
+}
+
+// hostPrefix parses a single address, bare or as a host prefix such as
+// 10.0.0.7/32, into a host prefix.
+func hostPrefix(ip string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(ip)
+	if err != nil || !prefix.IsSingleIP() {
+		return netip.Prefix{}, fmt.Errorf("%q is not a single IP address", ip)
+	}
+	return prefix, nil
+}
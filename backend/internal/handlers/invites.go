@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"wg-manager/backend/internal/wireguard"
+)
+
+// InviteHandler manages invites that let recipients create their own peers.
+type InviteHandler struct {
+	Service wireguard.Service
+}
+
+func NewInviteHandler(service wireguard.Service) *InviteHandler {
+	return &InviteHandler{Service: service}
+}
+
+// CreateInviteRequest is the body of POST /invites.
+type CreateInviteRequest struct {
+	// NameTemplate names the created peers; see wireguard.InviteOptions.
+	NameTemplate string `json:"nameTemplate"`
+	RouteProfile string `json:"routeProfile"`
+	// MaxUses is how many peers the invite can create. Zero means one.
+	MaxUses int `json:"maxUses"`
+	// ExpiresInSeconds is how long the invite stays valid. Zero uses
+	// wireguard.DefaultInviteTTL.
+	ExpiresInSeconds int `json:"expiresInSeconds"`
+}
+
+// RedeemInviteRequest is the body of POST /invites/redeem.
+type RedeemInviteRequest struct {
+	Token     string `json:"token"`
+	Name      string `json:"name"`
+	PublicKey string `json:"publicKey"`
+}
+
+func (h *InviteHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req CreateInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("Failed to decode invite request", "error", err)
+		writeBadRequest(w, r, "Invalid request body")
+		return
+	}
+	if errs := validateCreateInvite(req); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	invite, err := h.Service.CreateInvite(wireguard.InviteOptions{
+		NameTemplate: req.NameTemplate,
+		RouteProfile: req.RouteProfile,
+		MaxUses:      req.MaxUses,
+		TTL:          time.Duration(req.ExpiresInSeconds) * time.Second,
+	})
+	if err != nil {
+		slog.Error("Failed to create invite", "error", err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(invite); err != nil {
+		slog.Error("Failed to encode invite", "error", err)
+	}
+}
+
+func (h *InviteHandler) List(w http.ResponseWriter, r *http.Request) {
+	invites, err := h.Service.ListInvites()
+	if err != nil {
+		slog.Error("Failed to list invites", "error", err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(invites); err != nil {
+		slog.Error("Failed to encode invites", "error", err)
+	}
+}
+
+func (h *InviteHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeBadRequest(w, r, "Missing invite ID in path")
+		return
+	}
+
+	if err := h.Service.RevokeInvite(id); err != nil {
+		slog.Error("Failed to revoke invite", "error", err, "id", id)
+		writeServiceError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Redeem creates a peer for the recipient of an invite. The invite token is
+// the credential.
+func (h *InviteHandler) Redeem(w http.ResponseWriter, r *http.Request) {
+	var req RedeemInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("Failed to decode redeem request", "error", err)
+		writeBadRequest(w, r, "Invalid request body")
+		return
+	}
+	if errs := validateRedeemInvite(req); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	peer, err := h.Service.RedeemInvite(req.Token, wireguard.RedeemOptions{Name: req.Name, PublicKey: req.PublicKey})
+	if err != nil {
+		slog.Error("Failed to redeem invite", "error", err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	writePeer(w, http.StatusCreated, peer.Revision, withQRCode(peer))
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"wg-manager/backend/internal/openapi"
+	"wg-manager/backend/internal/wireguard"
+)
+
+// apiRoute documents one route registered in main for the OpenAPI document.
+type apiRoute struct {
+	Pattern string // "METHOD /path", exactly as passed to ServeMux
+	Summary string
+	Tag     string
+	Request any // zero value of the JSON request body, nil for none
+	Query   []openapi.Parameter
+	// Description holds notes that do not fit the summary.
+	Description string
+	// OptionalBody marks a request body that may be omitted.
+	OptionalBody bool
+	Status       int
+	Response     any // zero value of the success body, nil for none
+	// PagedResponse is the success body when a limit or cursor is given;
+	// the documented body is then one of the two.
+	PagedResponse any
+	ContentType   string // success content type; defaults to application/json
+	Errors        []int  // statuses answered with an ErrorResponse
+	// Responses documents additional non-error bodies, e.g. a 503 readiness report.
+	Responses map[int]any
+	// Revisioned routes return an ETag; writes other than creation also
+	// accept If-Match.
+	Revisioned bool
+}
+
+// peerListNote explains the two shapes of the peer list.
+const peerListNote = "Without limit or cursor the matching peers are returned as an array. " +
+	"With either, the response is a page carrying the peers, totals and the next cursor."
+
+// Zero-knowledge mode keeps preshared keys, which configs still carry.
+const (
+	zeroKnowledgeConfigNote   = "In zero-knowledge mode the config has PrivateKey = <insert>. It still carries the peer's preshared key, which the server keeps because the device needs it."
+	zeroKnowledgeSettingsNote = "zeroKnowledge stops generated private keys from being stored, and turning it on removes those already stored. Preshared keys are still stored and included in downloaded configs, because the device needs them."
+)
+
+var peerQueryParameters = []openapi.Parameter{
+	queryParameter("q", "Search in names, public keys and addresses; an IP also matches the peers routing it"),
+	queryParameter("status", "online, offline, disabled or expired"),
+	queryParameter("group", "Only peers in this group"),
+	queryParameter("tag", "Only peers with this tag"),
+	queryParameter("sort", "name (default), lastHandshake or traffic"),
+	queryParameter("order", "asc or desc; defaults to asc by name and desc otherwise"),
+	queryParameter("cursor", "nextCursor of the previous page"),
+	{Name: "limit", In: "query", Description: "Page size; all peers if omitted", Schema: &openapi.Schema{Type: "integer"}},
+}
+
+// exportParameters are the filters and sort of the peer list, without
+// paging, plus the export format.
+var exportParameters = append(peerQueryParameters[:6:6],
+	queryParameter("format", "json (default) or csv"),
+	queryParameter("secrets", "true to include stored keys; needs the export token as a bearer token"),
+)
+
+func queryParameter(name, description string) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "query", Description: description, Schema: &openapi.Schema{Type: "string"}}
+}
+
+// apiRoutes lists every route served by the backend. The contract test in
+// cmd/server fails if this drifts from the routes registered in main.
+var apiRoutes = []apiRoute{
+	{Pattern: "GET /peers", Summary: "Search, filter, sort and page peers", Description: peerListNote, Tag: "peers", Query: peerQueryParameters, Status: http.StatusOK, Response: []wireguard.Peer{}, PagedResponse: wireguard.PeerPage{}, Errors: []int{400, 503}},
+	{Pattern: "POST /peers", Summary: "Add a peer", Tag: "peers", Request: AddPeerRequest{}, Status: http.StatusCreated, Response: wireguard.PeerResponse{}, Errors: []int{400, 409, 503}, Revisioned: true},
+	{Pattern: "POST /peers/bulk", Summary: "Run a batch of peer operations", Tag: "peers", Request: BulkRequest{}, Status: http.StatusOK, Response: wireguard.BulkResult{}, Errors: []int{400, 503}},
+	{Pattern: "GET /peers/export", Summary: "Export peers as CSV or JSON", Tag: "peers", Query: exportParameters, Status: http.StatusOK, Response: []wireguard.PeerExport{}, Errors: []int{400, 403, 503}},
+	{Pattern: "POST /peers/import", Summary: "Import peers from CSV or JSON", Tag: "peers", Request: ImportRequest{}, Query: []openapi.Parameter{queryParameter("preview", "true to report the results without creating the peers")}, Status: http.StatusOK, Response: wireguard.BulkResult{}, Errors: []int{400, 503}},
+	{Pattern: "GET /peers/{id}", Summary: "Get a peer", Tag: "peers", Status: http.StatusOK, Response: wireguard.Peer{}, Errors: []int{404, 503}, Revisioned: true},
+	{Pattern: "DELETE /peers/{id}", Summary: "Remove a peer", Tag: "peers", Status: http.StatusNoContent, Errors: []int{400, 404, 412, 503}, Revisioned: true},
+	{Pattern: "PATCH /peers/{id}", Summary: "Update a peer", Tag: "peers", Request: UpdatePeerRequest{}, Status: http.StatusOK, Response: wireguard.Peer{}, Errors: []int{400, 404, 409, 412, 503}, Revisioned: true},
+	{Pattern: "POST /peers/{id}/regenerate-keys", Summary: "Regenerate a peer's keys", Tag: "peers", Request: RegenerateRequest{}, OptionalBody: true, Status: http.StatusOK, Response: wireguard.PeerResponse{}, Errors: []int{400, 404, 409, 412, 503}, Revisioned: true},
+	{Pattern: "POST /peers/{id}/claim", Summary: "Claim a peer with a client-generated public key", Tag: "peers", Request: ClaimPeerRequest{}, Status: http.StatusOK, Response: wireguard.PeerResponse{}, Errors: []int{400, 404, 409, 412, 503}, Revisioned: true},
+	{Pattern: "POST /peers/{id}/reissue", Summary: "Reissue a peer's config with new keys", Tag: "peers", Status: http.StatusOK, Response: wireguard.PeerResponse{}, Errors: []int{404, 409, 412, 503}, Revisioned: true},
+	{Pattern: "POST /peers/{id}/share", Summary: "Create a single-use link to a peer's config", Tag: "configs", Request: ShareRequest{}, OptionalBody: true, Status: http.StatusCreated, Response: wireguard.ShareLink{}, Errors: []int{400, 404}},
+	{Pattern: "GET /share/{token}", Summary: "Open a share link", Description: zeroKnowledgeConfigNote, Tag: "configs", Status: http.StatusOK, Response: "", ContentType: "text/html", Errors: []int{404}},
+	{Pattern: "GET /shares/audit", Summary: "Stored log of share links created, opened and rejected", Tag: "configs", Status: http.StatusOK, Response: []wireguard.ShareAuditEntry{}},
+	{Pattern: "GET /invites", Summary: "List invites", Tag: "invites", Status: http.StatusOK, Response: []wireguard.Invite{}},
+	{Pattern: "POST /invites", Summary: "Create an invite", Tag: "invites", Request: CreateInviteRequest{}, Status: http.StatusCreated, Response: wireguard.InviteResponse{}, Errors: []int{400}},
+	{Pattern: "DELETE /invites/{id}", Summary: "Revoke an invite", Tag: "invites", Status: http.StatusNoContent, Errors: []int{404}},
+	{Pattern: "GET /groups", Summary: "List groups", Tag: "groups", Status: http.StatusOK, Response: []wireguard.Group{}},
+	{Pattern: "POST /groups", Summary: "Create a group", Tag: "groups", Request: wireguard.Group{}, Status: http.StatusCreated, Response: wireguard.Group{}, Errors: []int{400, 409}},
+	{Pattern: "GET /groups/{name}", Summary: "Get a group", Tag: "groups", Status: http.StatusOK, Response: wireguard.Group{}, Errors: []int{404}},
+	{Pattern: "PUT /groups/{name}", Summary: "Replace a group's defaults", Tag: "groups", Request: wireguard.Group{}, Status: http.StatusOK, Response: wireguard.GroupChange{}, Errors: []int{400, 404}},
+	{Pattern: "DELETE /groups/{name}", Summary: "Delete an empty group", Tag: "groups", Status: http.StatusNoContent, Errors: []int{404, 409}},
+	{Pattern: "POST /invites/redeem", Summary: "Redeem an invite to create a peer", Tag: "invites", Request: RedeemInviteRequest{}, Status: http.StatusCreated, Response: wireguard.PeerResponse{}, Errors: []int{400, 404, 409, 503}, Revisioned: true},
+	{Pattern: "GET /peers/config/{id}", Summary: "Download a peer's wg-quick config", Description: zeroKnowledgeConfigNote, Tag: "configs", Status: http.StatusOK, Response: "", ContentType: "text/plain", Errors: []int{404}},
+	{Pattern: "GET /peers/qr/{id}", Summary: "Download a peer's config as a QR code", Description: zeroKnowledgeConfigNote, Tag: "configs", Status: http.StatusOK, Response: []byte{}, ContentType: "image/png", Errors: []int{404}},
+	{Pattern: "GET /peers/{id}/config/diff", Summary: "Diff the current config against the last download", Tag: "configs", Status: http.StatusOK, Response: wireguard.ConfigDiff{}, Errors: []int{404}},
+	{Pattern: "GET /stats", Summary: "Interface statistics", Tag: "stats", Status: http.StatusOK, Response: wireguard.Stats{}, Errors: []int{503}},
+	{Pattern: "GET /stats/history", Summary: "Traffic history", Tag: "stats", Status: http.StatusOK, Response: []wireguard.StatsHistoryItem{}},
+	{Pattern: "GET /settings", Summary: "Get global settings", Tag: "settings", Status: http.StatusOK, Response: wireguard.GlobalSettings{}, Revisioned: true},
+	{Pattern: "PUT /settings", Summary: "Replace global settings", Description: zeroKnowledgeSettingsNote, Tag: "settings", Request: wireguard.GlobalSettings{}, Status: http.StatusOK, Response: wireguard.SettingsChange{}, Errors: []int{400, 409, 412}, Revisioned: true},
+	{Pattern: "PATCH /settings", Summary: "Update some global settings", Description: zeroKnowledgeSettingsNote, Tag: "settings", Request: wireguard.SettingsUpdate{}, Status: http.StatusOK, Response: wireguard.SettingsChange{}, Errors: []int{400, 409, 412}, Revisioned: true},
+	{Pattern: "POST /settings", Summary: "Replace global settings (deprecated, use PUT)", Description: zeroKnowledgeSettingsNote, Tag: "settings", Request: wireguard.GlobalSettings{}, Status: http.StatusNoContent, Errors: []int{400, 409, 412}, Revisioned: true},
+	{Pattern: "GET /reconcile", Summary: "Report drift between storage and the device", Tag: "reconcile", Status: http.StatusOK, Response: wireguard.DriftReport{}, Errors: []int{503}},
+	{Pattern: "POST /reconcile", Summary: "Resolve drift between storage and the device", Tag: "reconcile", Request: ReconcileRequest{}, Status: http.StatusOK, Response: wireguard.ReconcileResult{}, Errors: []int{400, 503}},
+	{Pattern: "GET /events", Summary: "Recent service events", Tag: "events", Status: http.StatusOK, Response: []wireguard.Event{}},
+	{Pattern: "GET /health", Summary: "Liveness and active backend", Tag: "health", Status: http.StatusOK, Response: HealthResponse{}},
+	{Pattern: "GET /healthz", Summary: "Liveness probe", Tag: "health", Status: http.StatusOK, Response: HealthResponse{}},
+	{Pattern: "GET /readyz", Summary: "Readiness probe", Tag: "health", Status: http.StatusOK, Response: wireguard.Readiness{}, Responses: map[int]any{http.StatusServiceUnavailable: wireguard.Readiness{}}},
+	{Pattern: "GET /diagnostics", Summary: "Host diagnostics", Tag: "health", Status: http.StatusOK, Response: wireguard.Diagnostics{}},
+	{Pattern: "GET /openapi.json", Summary: "This OpenAPI document", Tag: "meta", Status: http.StatusOK, Response: map[string]any{}},
+}
+
+type OpenAPIHandler struct {
+	Document openapi.Document
+}
+
+func NewOpenAPIHandler() *OpenAPIHandler {
+	return &OpenAPIHandler{Document: BuildOpenAPI()}
+}
+
+// Spec serves the OpenAPI document.
+func (h *OpenAPIHandler) Spec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.Document); err != nil {
+		slog.Error("Failed to encode OpenAPI document", "error", err)
+	}
+}
+
+// BuildOpenAPI generates the OpenAPI document from apiRoutes.
+func BuildOpenAPI() openapi.Document {
+	gen := openapi.NewGenerator()
+	errorSchema := gen.SchemaOf(ErrorResponse{})
+	jsonContent := func(s *openapi.Schema) map[string]openapi.MediaType {
+		return map[string]openapi.MediaType{"application/json": {Schema: s}}
+	}
+
+	doc := openapi.Document{
+		OpenAPI: openapi.Version,
+		Info: openapi.Info{
+			Title:       "wg-manager API",
+			Version:     "1.0.0",
+			Description: "Manage WireGuard peers, configs and settings.",
+		},
+		Paths: map[string]openapi.PathItem{},
+	}
+
+	for _, route := range apiRoutes {
+		method, path, _ := strings.Cut(route.Pattern, " ")
+		op := &openapi.Operation{
+			OperationID: operationID(method, path),
+			Summary:     route.Summary,
+			Description: route.Description,
+			Tags:        []string{route.Tag},
+			Parameters:  append(openapi.PathParameters(path), route.Query...),
+			Responses:   map[string]openapi.Response{},
+		}
+
+		if route.Request != nil {
+			op.RequestBody = &openapi.RequestBody{Required: !route.OptionalBody, Content: jsonContent(gen.SchemaOf(route.Request))}
+		}
+
+		success := openapi.Response{Description: http.StatusText(route.Status)}
+		if route.Revisioned {
+			if route.Response != nil {
+				success.Headers = map[string]openapi.Header{"ETag": {Description: "Quoted revision of the resource", Schema: &openapi.Schema{Type: "string"}}}
+			}
+			// Creating a resource has no earlier revision to match.
+			if method != http.MethodGet && route.Status != http.StatusCreated {
+				op.Parameters = append(op.Parameters, openapi.Parameter{
+					Name:        "If-Match",
+					In:          "header",
+					Description: "ETag of the revision the change is based on; 412 if it is stale",
+					Schema:      &openapi.Schema{Type: "string"},
+				})
+			}
+		}
+		if route.Response != nil {
+			contentType := route.ContentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			schema := gen.SchemaOf(route.Response)
+			if route.PagedResponse != nil {
+				schema = &openapi.Schema{OneOf: []*openapi.Schema{schema, gen.SchemaOf(route.PagedResponse)}}
+			}
+			success.Content = map[string]openapi.MediaType{contentType: {Schema: schema}}
+		}
+		op.Responses[strconv.Itoa(route.Status)] = success
+
+		for status, body := range route.Responses {
+			op.Responses[strconv.Itoa(status)] = openapi.Response{Description: http.StatusText(status), Content: jsonContent(gen.SchemaOf(body))}
+		}
+		for _, status := range route.Errors {
+			op.Responses[strconv.Itoa(status)] = openapi.Response{Description: http.StatusText(status), Content: jsonContent(errorSchema)}
+		}
+		op.Responses["default"] = openapi.Response{Description: "Unexpected error", Content: jsonContent(errorSchema)}
+
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = openapi.PathItem{}
+		}
+		doc.Paths[path][strings.ToLower(method)] = op
+	}
+
+	doc.Components.Schemas = gen.Schemas()
+	return doc
+}
+
+// operationID derives a stable camelCase ID, e.g. "GET /peers/{id}/config/diff"
+// becomes "getPeersIdConfigDiff".
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == '{' || r == '}' || r == '-' || r == '.'
+	}) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"wg-manager/backend/internal/wireguard"
+)
+
+// Drift reports differences between storage and the device without changing anything.
+func (h *PeerHandler) Drift(w http.ResponseWriter, r *http.Request) {
+	report, err := h.Service.CheckDrift()
+	if err != nil {
+		slog.Error("Failed to check drift", "error", err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		slog.Error("Failed to encode drift response", "error", err)
+	}
+}
+
+type ReconcileRequest struct {
+	Mode string `json:"mode"`
+}
+
+// Reconcile resolves drift between storage and the device.
+func (h *PeerHandler) Reconcile(w http.ResponseWriter, r *http.Request) {
+	var req ReconcileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Error("Failed to decode reconcile request", "error", err)
+		writeBadRequest(w, r, "Invalid request body")
+		return
+	}
+
+	switch req.Mode {
+	case wireguard.ReconcileAdopt, wireguard.ReconcilePrune, wireguard.ReconcileStorageWins:
+	default:
+		writeBadRequest(w, r, fmt.Sprintf("Invalid reconcile mode: %q", req.Mode))
+		return
+	}
+
+	result, err := h.Service.Reconcile(req.Mode)
+	if err != nil {
+		slog.Error("Failed to reconcile", "error", err, "mode", req.Mode)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		slog.Error("Failed to encode reconcile response", "error", err)
+	}
+}
+
+// Events returns recently emitted service events.
+func (h *PeerHandler) Events(w http.ResponseWriter, r *http.Request) {
+	events, err := h.Service.GetEvents()
+	if err != nil {
+		slog.Error("Failed to get events", "error", err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(events); err != nil {
+		slog.Error("Failed to encode events response", "error", err)
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+
+	"wg-manager/backend/internal/wireguard"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// Reissue hands a peer a fresh config by regenerating its keys straight away.
+// In zero-knowledge mode this is the only way to get a config with a private
+// key again once the one-time response has been used.
+func (h *PeerHandler) Reissue(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeBadRequest(w, r, "Missing peer ID in path")
+		return
+	}
+	ifRevision, ok := ifMatchRevision(w, r)
+	if !ok {
+		return
+	}
+
+	peer, err := h.Service.RegeneratePeer(id, wireguard.RegenerateOptions{}, ifRevision)
+	if err != nil {
+		slog.Error("Failed to reissue peer config", "error", err, "id", id)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	writePeer(w, http.StatusOK, peer.Revision, withQRCode(peer))
+}
+
+// withQRCode adds a QR code of the config to a response whose private key is
+// not stored, since the QR endpoint can no longer render it. A failure only
+// drops the QR code; the config itself is still returned.
+func withQRCode(peer wireguard.PeerResponse) wireguard.PeerResponse {
+	if !peer.PrivateKeyDiscarded {
+		return peer
+	}
+	png, err := qrcode.Encode(peer.Config, qrcode.High, 256)
+	if err != nil {
+		slog.Error("Failed to generate QR code", "error", err, "id", peer.ID)
+		return peer
+	}
+	peer.QRCode = "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+	return peer
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// ShareRequest is the optional body of a share link request.
+type ShareRequest struct {
+	// ExpiresInSeconds is how long the link stays valid. Zero uses
+	// wireguard.DefaultShareTTL.
+	ExpiresInSeconds int `json:"expiresInSeconds"`
+}
+
+// Share creates a single-use, expiring link to a peer's config.
+func (h *PeerHandler) Share(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeBadRequest(w, r, "Missing peer ID in path")
+		return
+	}
+
+	var req ShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		slog.Error("Failed to decode share request", "error", err)
+		writeBadRequest(w, r, "Invalid request body")
+		return
+	}
+	if errs := validateShare(req); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	link, err := h.Service.SharePeer(id, time.Duration(req.ExpiresInSeconds)*time.Second)
+	if err != nil {
+		slog.Error("Failed to share peer config", "error", err, "id", id)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(link); err != nil {
+		slog.Error("Failed to encode share link", "error", err)
+	}
+}
+
+// ShareAudit returns the stored log of share links created, opened and
+// rejected.
+func (h *PeerHandler) ShareAudit(w http.ResponseWriter, r *http.Request) {
+	audit, err := h.Service.GetShareAudit()
+	if err != nil {
+		slog.Error("Failed to get share audit log", "error", err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(audit); err != nil {
+		slog.Error("Failed to encode share audit log", "error", err)
+	}
+}
+
+// sharePage is the page served for a share link. The link works once, so the
+// config, a download link and the QR code are all embedded in it.
+var sharePage = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>WireGuard config: {{.Name}}</title>
+</head>
+<body>
+<h1>WireGuard config: {{.Name}}</h1>
+<p>This link works only once. Scan the QR code or download the config now.</p>
+<img src="{{.QRCode}}" alt="QR code of the config" width="256" height="256">
+<p><a href="{{.Download}}" download="{{.Filename}}">Download {{.Filename}}</a></p>
+<pre>{{.Config}}</pre>
+</body>
+</html>
+`))
+
+// OpenShare serves the config behind a share link. It needs no
+// authentication: the token itself is the credential, and it is used up by
+// this request.
+func (h *PeerHandler) OpenShare(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	if token == "" {
+		writeBadRequest(w, r, "Missing share token in path")
+		return
+	}
+
+	shared, err := h.Service.OpenShare(token)
+	if err != nil {
+		slog.Error("Failed to open share link", "error", err)
+		writeServiceError(w, r, err)
+		return
+	}
+
+	png, err := qrcode.Encode(shared.Config, qrcode.High, 256)
+	if err != nil {
+		slog.Error("Failed to generate QR code", "error", err)
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to generate QR code", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	err = sharePage.Execute(w, struct {
+		Name     string
+		Config   string
+		Filename string
+		QRCode   template.URL
+		Download template.URL
+	}{
+		Name:     shared.Name,
+		Config:   shared.Config,
+		Filename: shared.PeerID + ".conf",
+		QRCode:   template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)),
+		Download: template.URL("data:text/plain;base64," + base64.StdEncoding.EncodeToString([]byte(shared.Config))),
+	})
+	if err != nil {
+		slog.Error("Failed to render share page", "error", err)
+	}
+}
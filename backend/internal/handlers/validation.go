@@ -0,0 +1,399 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"wg-manager/backend/internal/validation"
+	"wg-manager/backend/internal/wireguard"
+)
+
+// validateAddPeer checks the body of an add peer request.
+func validateAddPeer(req AddPeerRequest, vpnSubnet string) validation.Errors {
+	return checkNewPeer(req, vpnSubnet, false)
+}
+
+// checkNewPeer checks a new peer. Zero values mean "use the default" and are
+// not range-checked. With allocate, AllowedIPs may be left out for the
+// service to assign the next free address.
+func checkNewPeer(req AddPeerRequest, vpnSubnet string, allocate bool) validation.Errors {
+	var errs validation.Errors
+	errs.Check("name", validation.Name(req.Name))
+	if req.Claimable && req.PublicKey != "" {
+		errs.Add("publicKey", "must be empty for a claimable peer")
+	}
+
+	if len(req.AllowedIPs) == 0 && !allocate {
+		errs.Add("allowedIPs", "at least one AllowedIP is required")
+	}
+	checkCIDRs(&errs, "allowedIPs", req.AllowedIPs)
+	checkCIDRs(&errs, "clientAllowedIPs", req.ClientAllowedIPs)
+	checkPeerDefaults(&errs, req.DNS, req.MTU, req.PersistentKeepalive)
+	if req.InterfaceAddress != "" {
+		errs.Check("interfaceAddress", validation.InterfaceAddress(req.InterfaceAddress, vpnSubnet))
+	}
+
+	switch req.Type {
+	case "", wireguard.PeerTypeClient:
+		if len(req.Networks) > 0 {
+			errs.Add("networks", "only site peers can advertise networks")
+		}
+		if req.Endpoint != "" {
+			errs.Add("endpoint", "only site peers can have an endpoint")
+		}
+	case wireguard.PeerTypeSite:
+		if len(req.Networks) == 0 {
+			errs.Add("networks", "site peers must advertise at least one network")
+		}
+		if req.Endpoint != "" {
+			errs.Check("endpoint", validation.Endpoint(req.Endpoint))
+		}
+	default:
+		errs.Add("type", fmt.Sprintf("must be %q or %q", wireguard.PeerTypeClient, wireguard.PeerTypeSite))
+	}
+	checkCIDRs(&errs, "networks", req.Networks)
+	errs.Check("serverKeepalive", validation.Keepalive(req.ServerKeepalive))
+	checkMembership(&errs, req.Group, req.Tags, req.Expires)
+	return errs
+}
+
+// validateUpdatePeer checks the fields present in a peer update. Whether an
+// endpoint or networks suit the peer's type is left to the service, which
+// knows the type.
+func validateUpdatePeer(req UpdatePeerRequest, vpnSubnet string) validation.Errors {
+	var errs validation.Errors
+	if req.Name != nil {
+		errs.Check("name", validation.Name(*req.Name))
+	}
+	if req.AllowedIPs != nil {
+		if len(*req.AllowedIPs) == 0 {
+			errs.Add("allowedIPs", "at least one AllowedIP is required")
+		}
+		checkCIDRs(&errs, "allowedIPs", *req.AllowedIPs)
+	}
+	if req.ClientAllowedIPs != nil {
+		checkCIDRs(&errs, "clientAllowedIPs", *req.ClientAllowedIPs)
+	}
+	if req.Networks != nil {
+		checkCIDRs(&errs, "networks", *req.Networks)
+	}
+
+	var dns string
+	var mtu, keepalive int
+	if req.DNS != nil {
+		dns = *req.DNS
+	}
+	if req.MTU != nil {
+		mtu = *req.MTU
+	}
+	if req.PersistentKeepalive != nil {
+		keepalive = *req.PersistentKeepalive
+	}
+	checkPeerDefaults(&errs, dns, mtu, keepalive)
+
+	if req.InterfaceAddress != nil && *req.InterfaceAddress != "" {
+		errs.Check("interfaceAddress", validation.InterfaceAddress(*req.InterfaceAddress, vpnSubnet))
+	}
+	if req.Endpoint != nil && *req.Endpoint != "" {
+		errs.Check("endpoint", validation.Endpoint(*req.Endpoint))
+	}
+	if req.ServerKeepalive != nil {
+		errs.Check("serverKeepalive", validation.Keepalive(*req.ServerKeepalive))
+	}
+
+	var group string
+	var tags []string
+	var expires int64
+	if req.Group != nil {
+		group = *req.Group
+	}
+	if req.Tags != nil {
+		tags = *req.Tags
+	}
+	if req.Expires != nil {
+		expires = *req.Expires
+	}
+	checkMembership(&errs, group, tags, expires)
+	return errs
+}
+
+// validateGroup checks a group and its defaults. Zero values inherit the
+// global settings.
+func validateGroup(group wireguard.Group) validation.Errors {
+	var errs validation.Errors
+	errs.Check("name", validation.Name(group.Name))
+	checkPeerDefaults(&errs, group.DNS, group.MTU, group.PersistentKeepalive)
+	if group.PeerLifetime < 0 {
+		errs.Add("peerLifetime", "must not be negative")
+	}
+	return errs
+}
+
+// parsePeerQuery reads the peer list query parameters. The cursor is checked
+// by the service, which knows the sort it was made for.
+func parsePeerQuery(values url.Values) (wireguard.PeerQuery, validation.Errors) {
+	var errs validation.Errors
+	query := wireguard.PeerQuery{
+		Search: values.Get("q"),
+		Status: values.Get("status"),
+		Group:  values.Get("group"),
+		Tag:    values.Get("tag"),
+		Sort:   values.Get("sort"),
+		Order:  values.Get("order"),
+		Cursor: values.Get("cursor"),
+	}
+	if query.Status != "" && !slices.Contains(wireguard.PeerStatuses, query.Status) {
+		errs.Add("status", "must be one of "+strings.Join(wireguard.PeerStatuses, ", "))
+	}
+	sorts := []string{wireguard.PeerSortName, wireguard.PeerSortHandshake, wireguard.PeerSortTraffic}
+	if query.Sort != "" && !slices.Contains(sorts, query.Sort) {
+		errs.Add("sort", "must be one of "+strings.Join(sorts, ", "))
+	}
+	if query.Order != "" && query.Order != wireguard.OrderAsc && query.Order != wireguard.OrderDesc {
+		errs.Add("order", "must be asc or desc")
+	}
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > wireguard.MaxPeerPageSize {
+			errs.Add("limit", fmt.Sprintf("must be between 1 and %d", wireguard.MaxPeerPageSize))
+		}
+		query.Limit = n
+	}
+	return query, errs
+}
+
+// validateRegenerate checks the overlap window of a key regeneration.
+func validateRegenerate(req RegenerateRequest) validation.Errors {
+	var errs validation.Errors
+	maxOverlap := int(wireguard.MaxKeyOverlap / time.Second)
+	if req.OverlapSeconds < 0 || req.OverlapSeconds > maxOverlap {
+		errs.Add("overlapSeconds", fmt.Sprintf("must be between 0 and %d seconds", maxOverlap))
+	}
+	return errs
+}
+
+// validateBulk checks a batch and each of its operations. Errors in an
+// operation are reported under its index, e.g. "operations[2].peer.name".
+func validateBulk(req BulkRequest, vpnSubnet string) validation.Errors {
+	var errs validation.Errors
+	if len(req.Operations) == 0 {
+		errs.Add("operations", "at least one operation is required")
+	}
+	if len(req.Operations) > wireguard.MaxBulkOperations {
+		errs.Add("operations", fmt.Sprintf("must not have more than %d operations", wireguard.MaxBulkOperations))
+	}
+	for i, op := range req.Operations {
+		field := validation.Index("operations", i)
+		if !slices.Contains(wireguard.BulkOps, op.Op) {
+			errs.Add(field+".op", "must be one of "+strings.Join(wireguard.BulkOps, ", "))
+			continue
+		}
+
+		if op.Op == wireguard.BulkCreate {
+			if op.ID != "" || op.Selector != nil {
+				errs.Add(field, "create takes a peer, not an id or selector")
+			}
+			if op.Peer == nil {
+				errs.Add(field+".peer", "is required")
+			} else {
+				nestErrors(&errs, field+".peer", checkNewPeer(*op.Peer, vpnSubnet, true))
+			}
+			continue
+		}
+
+		switch {
+		case op.ID == "" && op.Selector == nil:
+			errs.Add(field, "an id or a selector is required")
+		case op.ID != "" && op.Selector != nil:
+			errs.Add(field, "give an id or a selector, not both")
+		case op.Selector != nil && op.Selector.Group == "" && op.Selector.Tag == "":
+			errs.Add(field+".selector", "must name a group or a tag")
+		}
+		switch op.Op {
+		case wireguard.BulkUpdate:
+			if op.Changes == nil {
+				errs.Add(field+".changes", "is required")
+			} else {
+				nestErrors(&errs, field+".changes", validateUpdatePeer(*op.Changes, vpnSubnet))
+			}
+		case wireguard.BulkRegenerate:
+			nestErrors(&errs, field, validateRegenerate(RegenerateRequest{OverlapSeconds: op.OverlapSeconds}))
+		}
+	}
+	return errs
+}
+
+// validateImport checks the rows of an import. Errors are reported per row,
+// e.g. "rows[3].ip". Whether groups exist and addresses are free is left to
+// the service, which reports it per row.
+func validateImport(rows []ImportRow, vpnSubnet string) validation.Errors {
+	var errs validation.Errors
+	if len(rows) == 0 {
+		errs.Add("rows", "at least one row is required")
+	}
+	if len(rows) > wireguard.MaxBulkOperations {
+		errs.Add("rows", fmt.Sprintf("must not have more than %d rows", wireguard.MaxBulkOperations))
+	}
+	for i, row := range rows {
+		field := validation.Index("rows", i)
+		errs.Check(field+".name", validation.Name(row.Name))
+		if row.Group != "" {
+			errs.Check(field+".group", validation.Name(row.Group))
+		}
+		if row.PublicKey != "" {
+			errs.Check(field+".publicKey", validation.Key(row.PublicKey))
+		}
+		if row.IP != "" {
+			if _, err := hostPrefix(row.IP); err != nil {
+				errs.Add(field+".ip", err.Error())
+			} else {
+				errs.Check(field+".ip", validation.InterfaceAddress(row.IP, vpnSubnet))
+			}
+		}
+	}
+	return errs
+}
+
+// validateShare checks the lifetime requested for a share link.
+func validateShare(req ShareRequest) validation.Errors {
+	var errs validation.Errors
+	maxTTL := int(wireguard.MaxShareTTL / time.Second)
+	if req.ExpiresInSeconds < 0 || req.ExpiresInSeconds > maxTTL {
+		errs.Add("expiresInSeconds", fmt.Sprintf("must be between 0 and %d seconds", maxTTL))
+	}
+	return errs
+}
+
+// validateCreateInvite checks a new invite. The name template is checked by
+// filling it in, as it would be for a recipient.
+func validateCreateInvite(req CreateInviteRequest) validation.Errors {
+	var errs validation.Errors
+	example := strings.NewReplacer("{n}", "1", "{name}", "peer").Replace(req.NameTemplate)
+	errs.Check("nameTemplate", validation.Name(example))
+	if req.MaxUses < 0 {
+		errs.Add("maxUses", "must not be negative")
+	}
+	maxTTL := int(wireguard.MaxInviteTTL / time.Second)
+	if req.ExpiresInSeconds < 0 || req.ExpiresInSeconds > maxTTL {
+		errs.Add("expiresInSeconds", fmt.Sprintf("must be between 0 and %d seconds", maxTTL))
+	}
+	return errs
+}
+
+// validateRedeemInvite checks what an invite recipient sends. The name and
+// key are optional.
+func validateRedeemInvite(req RedeemInviteRequest) validation.Errors {
+	var errs validation.Errors
+	if req.Token == "" {
+		errs.Add("token", "is required")
+	}
+	if req.Name != "" {
+		errs.Check("name", validation.Name(req.Name))
+	}
+	if req.PublicKey != "" {
+		errs.Check("publicKey", validation.Key(req.PublicKey))
+	}
+	return errs
+}
+
+// validateClaim checks the public key submitted to claim a peer.
+func validateClaim(req ClaimPeerRequest) validation.Errors {
+	var errs validation.Errors
+	if req.PublicKey == "" {
+		errs.Add("publicKey", "is required")
+	} else {
+		errs.Check("publicKey", validation.Key(req.PublicKey))
+	}
+	return errs
+}
+
+// validateSettings checks a full replacement of the global settings. Unlike
+// peer overrides, MTU has no fallback and is always required.
+func validateSettings(settings wireguard.GlobalSettings, vpnSubnet string) validation.Errors {
+	return validateSettingsUpdate(wireguard.SettingsUpdate{
+		ServerAddress:      &settings.ServerAddress,
+		DNS:                &settings.DNS,
+		MTU:                &settings.MTU,
+		Keepalive:          &settings.Keepalive,
+		Endpoint:           &settings.Endpoint,
+		ClientRouteProfile: &settings.ClientRouteProfile,
+		RouteProfiles:      &settings.RouteProfiles,
+		LocalNetworks:      &settings.LocalNetworks,
+		ZeroKnowledge:      &settings.ZeroKnowledge,
+	}, vpnSubnet)
+}
+
+// validateSettingsUpdate checks the fields present in a settings update.
+// Empty strings clear optional settings.
+func validateSettingsUpdate(update wireguard.SettingsUpdate, vpnSubnet string) validation.Errors {
+	var errs validation.Errors
+	if update.ServerAddress != nil && *update.ServerAddress != "" {
+		errs.Check("serverAddress", validation.InterfaceAddress(*update.ServerAddress, vpnSubnet))
+	}
+	if update.DNS != nil && *update.DNS != "" {
+		errs.Check("dns", validation.DNS(*update.DNS))
+	}
+	if update.MTU != nil {
+		errs.Check("mtu", validation.MTU(*update.MTU))
+	}
+	if update.Keepalive != nil {
+		errs.Check("keepalive", validation.Keepalive(*update.Keepalive))
+	}
+	if update.Endpoint != nil && *update.Endpoint != "" {
+		errs.Check("endpoint", validation.Endpoint(*update.Endpoint))
+	}
+	if update.RouteProfiles != nil {
+		for i, p := range *update.RouteProfiles {
+			field := validation.Index("routeProfiles", i)
+			errs.Check(field+".name", validation.Name(p.Name))
+			checkCIDRs(&errs, field+".allowedIPs", p.AllowedIPs)
+			checkCIDRs(&errs, field+".exclude", p.Exclude)
+		}
+	}
+	if update.LocalNetworks != nil {
+		checkCIDRs(&errs, "localNetworks", *update.LocalNetworks)
+	}
+	return errs
+}
+
+// checkPeerDefaults checks the per-peer overrides of global settings. Zero
+// values inherit the global setting.
+func checkPeerDefaults(errs *validation.Errors, dns string, mtu, keepalive int) {
+	if dns != "" {
+		errs.Check("dns", validation.DNS(dns))
+	}
+	if mtu != 0 {
+		errs.Check("mtu", validation.MTU(mtu))
+	}
+	errs.Check("persistentKeepalive", validation.Keepalive(keepalive))
+}
+
+// checkMembership checks a peer's group, tags and expiry. An empty group and
+// a zero expiry mean none.
+func checkMembership(errs *validation.Errors, group string, tags []string, expires int64) {
+	if group != "" {
+		errs.Check("group", validation.Name(group))
+	}
+	for i, tag := range tags {
+		errs.Check(validation.Index("tags", i), validation.Tag(tag))
+	}
+	if expires < 0 {
+		errs.Add("expires", "must not be negative")
+	}
+}
+
+// nestErrors records the errors of a nested object under prefix.
+func nestErrors(errs *validation.Errors, prefix string, nested validation.Errors) {
+	for _, e := range nested {
+		errs.Add(prefix+"."+e.Field, e.Message)
+	}
+}
+
+func checkCIDRs(errs *validation.Errors, field string, cidrs []string) {
+	for i, c := range cidrs {
+		errs.Check(validation.Index(field, i), validation.CIDR(c))
+	}
+}
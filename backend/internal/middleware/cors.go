@@ -39,8 +39,9 @@ func CORSMiddleware(next http.Handler) http.Handler {
 			// Inform caches that the response varies based on Origin.
 			w.Header().Add("Vary", "Origin")
 		}
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, "+RequestIDHeader)
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, "+RequestIDHeader)
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
@@ -15,6 +15,7 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 			"method", r.Method,
 			"path", r.URL.Path,
 			"duration", time.Since(start),
+			"requestId", RequestID(r.Context()),
 		)
 	})
 }
@@ -0,0 +1,83 @@
+package openapi
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+type inner struct {
+	When time.Time `json:"when"`
+}
+
+type base struct {
+	ID string `json:"id"`
+}
+
+type sample struct {
+	base
+	Name     string            `json:"name"`
+	Note     string            `json:"note,omitempty"`
+	Inner    *inner            `json:"inner"`
+	Count    *int              `json:"count"`
+	Labels   map[string]string `json:"labels"`
+	Tags     []string          `json:"tags"`
+	Secret   string            `json:"-"`
+	internal string
+}
+
+func TestGenerator(t *testing.T) {
+	g := NewGenerator()
+	ref := g.SchemaOf(sample{})
+	if ref.Ref != RefPrefix+"sample" {
+		t.Fatalf("expected a reference to sample, got %q", ref.Ref)
+	}
+
+	doc := Document{Components: Components{Schemas: g.Schemas()}}
+	s := doc.Resolve(ref)
+
+	t.Run("Fields", func(t *testing.T) {
+		for _, name := range []string{"id", "name", "note", "inner", "count", "labels", "tags"} {
+			if _, ok := s.Properties[name]; !ok {
+				t.Errorf("expected property %q", name)
+			}
+		}
+		for _, name := range []string{"Secret", "internal", "base"} {
+			if _, ok := s.Properties[name]; ok {
+				t.Errorf("expected property %q to be omitted", name)
+			}
+		}
+	})
+
+	t.Run("Required", func(t *testing.T) {
+		if slices.Contains(s.Required, "note") {
+			t.Error("expected omitempty field to be optional")
+		}
+		if !slices.Contains(s.Required, "id") || !slices.Contains(s.Required, "name") {
+			t.Errorf("expected id and name to be required, got %v", s.Required)
+		}
+	})
+
+	t.Run("Types", func(t *testing.T) {
+		if !s.Properties["count"].Nullable || s.Properties["count"].Type != "integer" {
+			t.Errorf("expected nullable integer, got %+v", s.Properties["count"])
+		}
+		if s.Properties["labels"].AdditionalProperties == nil {
+			t.Error("expected map to use additionalProperties")
+		}
+		when := doc.Resolve(s.Properties["inner"]).Properties["when"]
+		if when.Type != "string" || when.Format != "date-time" {
+			t.Errorf("expected date-time string, got %+v", when)
+		}
+	})
+}
+
+func TestPathParameters(t *testing.T) {
+	params := PathParameters("/peers/{id}/config/{rest...}")
+	if len(params) != 2 || params[0].Name != "id" || params[1].Name != "rest" {
+		t.Fatalf("expected id and rest parameters, got %+v", params)
+	}
+	if !params[0].Required || params[0].In != "path" {
+		t.Errorf("expected required path parameter, got %+v", params[0])
+	}
+}
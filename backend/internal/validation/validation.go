@@ -0,0 +1,214 @@
+// Package validation checks user-supplied WireGuard settings and reports every
+// problem at once as a list of field errors.
+package validation
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits enforced by the validators.
+const (
+	// MinMTU is the smallest MTU that can still carry IPv6 through the tunnel.
+	MinMTU = 1280
+	// MaxMTU allows jumbo frames on the underlying link.
+	MaxMTU = 9000
+	// MaxKeepalive is the largest interval WireGuard can encode, in seconds.
+	MaxKeepalive = 65535
+	// MaxNameLength is the longest peer or group name, in characters.
+	MaxNameLength = 64
+	// MaxTagLength is the longest peer tag, in characters.
+	MaxTagLength = 32
+)
+
+// FieldError is a problem with a single request field. Field uses the JSON
+// name of the field, with an index for list elements, e.g. "allowedIPs[1]".
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors collects field errors. The zero value is empty and ready to use.
+type Errors []FieldError
+
+// Error joins the field errors into one message.
+func (e Errors) Error() string {
+	parts := make([]string, len(e))
+	for i, f := range e {
+		parts[i] = f.Field + ": " + f.Message
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Add records a problem with field.
+func (e *Errors) Add(field, message string) {
+	*e = append(*e, FieldError{Field: field, Message: message})
+}
+
+// Check records err against field if err is non-nil.
+func (e *Errors) Check(field string, err error) {
+	if err != nil {
+		e.Add(field, err.Error())
+	}
+}
+
+// Err returns e as an error, or nil if there are no field errors.
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Index formats the field name of a list element.
+func Index(field string, i int) string {
+	return field + "[" + strconv.Itoa(i) + "]"
+}
+
+// MTU checks an interface MTU.
+func MTU(mtu int) error {
+	if mtu < MinMTU || mtu > MaxMTU {
+		return fmt.Errorf("must be between %d and %d", MinMTU, MaxMTU)
+	}
+	return nil
+}
+
+// Keepalive checks a persistent keepalive interval in seconds. Zero disables
+// keepalives.
+func Keepalive(seconds int) error {
+	if seconds < 0 || seconds > MaxKeepalive {
+		return fmt.Errorf("must be between 0 and %d seconds", MaxKeepalive)
+	}
+	return nil
+}
+
+// DNS checks a comma-separated list of DNS servers and search domains, as
+// written to the DNS line of a config.
+func DNS(list string) error {
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			return fmt.Errorf("contains an empty entry")
+		}
+		if _, err := netip.ParseAddr(entry); err != nil && !IsHostname(entry) {
+			return fmt.Errorf("%q is not an IP address or hostname", entry)
+		}
+	}
+	return nil
+}
+
+// Endpoint checks a host:port endpoint. The host is an IP address or a
+// hostname; IPv6 addresses must be bracketed.
+func Endpoint(endpoint string) error {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return fmt.Errorf("must be host:port")
+	}
+	if _, err := netip.ParseAddr(host); err != nil && !IsHostname(host) {
+		return fmt.Errorf("%q is not an IP address or hostname", host)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535")
+	}
+	return nil
+}
+
+// IsHostname reports whether s is a valid DNS hostname (RFC 1123).
+func IsHostname(s string) bool {
+	s = strings.TrimSuffix(s, ".")
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Key checks a base64-encoded WireGuard key.
+func Key(key string) error {
+	if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) != 32 {
+		return fmt.Errorf("must be a base64-encoded 32-byte key")
+	}
+	return nil
+}
+
+// CIDR checks an IP prefix such as 10.0.0.2/32.
+func CIDR(cidr string) error {
+	if _, err := netip.ParsePrefix(cidr); err != nil {
+		return fmt.Errorf("%q is not a valid CIDR", cidr)
+	}
+	return nil
+}
+
+// InterfaceAddress checks a tunnel address, with or without a prefix length,
+// and that it lies within subnet. An empty or unparsable subnet skips the
+// containment check.
+func InterfaceAddress(address, subnet string) error {
+	addr, err := netip.ParseAddr(address)
+	if prefix, perr := netip.ParsePrefix(address); perr == nil {
+		addr, err = prefix.Addr(), nil
+	}
+	if err != nil {
+		return fmt.Errorf("%q is not an IP address or CIDR", address)
+	}
+
+	vpn, err := netip.ParsePrefix(subnet)
+	if err != nil {
+		return nil
+	}
+	if !vpn.Contains(addr) {
+		return fmt.Errorf("%s is outside the VPN subnet %s", addr, vpn)
+	}
+	return nil
+}
+
+// Name checks a display name: 1 to MaxNameLength characters of letters,
+// digits, spaces and - _ . @ ' ( ). Surrounding spaces are not allowed.
+func Name(name string) error {
+	if name == "" {
+		return fmt.Errorf("is required")
+	}
+	if utf8.RuneCountInString(name) > MaxNameLength {
+		return fmt.Errorf("must be at most %d characters", MaxNameLength)
+	}
+	if strings.TrimSpace(name) != name {
+		return fmt.Errorf("must not start or end with a space")
+	}
+	for _, c := range name {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && !strings.ContainsRune(" -_.@'()", c) {
+			return fmt.Errorf("must not contain %q", c)
+		}
+	}
+	return nil
+}
+
+// Tag checks a peer tag: 1 to MaxTagLength letters, digits and - _ . : with
+// no spaces, so tags can be passed in query strings as they are.
+func Tag(tag string) error {
+	if tag == "" {
+		return fmt.Errorf("is required")
+	}
+	if utf8.RuneCountInString(tag) > MaxTagLength {
+		return fmt.Errorf("must be at most %d characters", MaxTagLength)
+	}
+	for _, c := range tag {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && !strings.ContainsRune("-_.:", c) {
+			return fmt.Errorf("must not contain %q", c)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidators(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		valid bool
+	}{
+		{"MTU/Default", MTU(1420), true},
+		{"MTU/Zero", MTU(0), false},
+		{"MTU/TooLarge", MTU(MaxMTU + 1), false},
+		{"Keepalive/Disabled", Keepalive(0), true},
+		{"Keepalive/Negative", Keepalive(-1), false},
+		{"Keepalive/TooLarge", Keepalive(MaxKeepalive + 1), false},
+		{"DNS/List", DNS("1.1.1.1, 2606:4700::1111, corp.example.com"), true},
+		{"DNS/Garbage", DNS("1.1.1.1, not a server"), false},
+		{"DNS/EmptyEntry", DNS("1.1.1.1,,8.8.8.8"), false},
+		{"Endpoint/Hostname", Endpoint("vpn.example.com:51820"), true},
+		{"Endpoint/IPv6", Endpoint("[2001:db8::1]:51820"), true},
+		{"Endpoint/NoPort", Endpoint("vpn.example.com"), false},
+		{"Endpoint/BadPort", Endpoint("vpn.example.com:0"), false},
+		{"Endpoint/BadHost", Endpoint("vpn_example!:51820"), false},
+		{"CIDR/Valid", CIDR("10.0.0.2/32"), true},
+		{"CIDR/Invalid", CIDR("10.0.0.2"), false},
+		{"InterfaceAddress/Inside", InterfaceAddress("10.0.0.5/24", "10.0.0.0/24"), true},
+		{"InterfaceAddress/Bare", InterfaceAddress("10.0.0.5", "10.0.0.0/24"), true},
+		{"InterfaceAddress/Outside", InterfaceAddress("10.0.1.5/32", "10.0.0.0/24"), false},
+		{"InterfaceAddress/NoSubnet", InterfaceAddress("192.168.1.1/32", ""), true},
+		{"InterfaceAddress/Garbage", InterfaceAddress("nope", "10.0.0.0/24"), false},
+		{"Name/Valid", Name("Alice's Laptop (work)"), true},
+		{"Name/Unicode", Name("Zoë-Phone_2"), true},
+		{"Name/Empty", Name(""), false},
+		{"Name/TooLong", Name(strings.Repeat("a", MaxNameLength+1)), false},
+		{"Name/Padded", Name(" padded"), false},
+		{"Name/Newline", Name("evil\nPostUp = rm -rf /"), false},
+		{"Tag/Valid", Tag("team:ops"), true},
+		{"Tag/Empty", Tag(""), false},
+		{"Tag/Space", Tag("on call"), false},
+		{"Tag/TooLong", Tag(strings.Repeat("a", MaxTagLength+1)), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if (tt.err == nil) != tt.valid {
+				t.Errorf("expected valid=%v, got error %v", tt.valid, tt.err)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var errs Errors
+	if errs.Err() != nil {
+		t.Error("expected no error from empty Errors")
+	}
+
+	errs.Check("mtu", MTU(0))
+	errs.Check("dns", nil)
+	errs.Add(Index("allowedIPs", 1), "bad")
+
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 field errors, got %d", len(errs))
+	}
+	if errs[1].Field != "allowedIPs[1]" {
+		t.Errorf("expected indexed field, got %q", errs[1].Field)
+	}
+	if got := errs.Err().Error(); got != "mtu: must be between 1280 and 9000; allowedIPs[1]: bad" {
+		t.Errorf("unexpected message %q", got)
+	}
+}
@@ -0,0 +1,55 @@
+package wireguard
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+)
+
+// allocateAddress returns the first free host address of the VPN subnet as a
+// single-address prefix. Addresses inside the AllowedIPs of a stored or
+// device peer are taken, as are the server's own address (the subnet's first
+// host unless the settings say otherwise) and the IPv4 broadcast address.
+// The caller must hold s.mu.
+func (s *realService) allocateAddress() (string, error) {
+	subnet, err := netip.ParsePrefix(s.vpnSubnet)
+	if err != nil {
+		return "", fmt.Errorf("cannot allocate an address: invalid VPN subnet %q", s.vpnSubnet)
+	}
+	subnet = subnet.Masked()
+
+	var taken []netip.Prefix
+	for _, meta := range s.storage.ListMetadata() {
+		for _, c := range meta.DeviceAllowedIPs() {
+			if p, err := netip.ParsePrefix(c); err == nil {
+				taken = append(taken, p.Masked())
+			}
+		}
+	}
+	device, err := s.client.Device(s.interfaceName)
+	if err != nil {
+		return "", deviceError("failed to get device "+s.interfaceName, err)
+	}
+	for _, peer := range device.Peers {
+		for _, ip := range peer.AllowedIPs {
+			if p, err := netip.ParsePrefix(ip.String()); err == nil {
+				taken = append(taken, p.Masked())
+			}
+		}
+	}
+
+	server := subnet.Addr().Next()
+	if p, err := netip.ParsePrefix(s.storage.GetSettings().ServerAddress); err == nil {
+		server = p.Addr()
+	}
+
+	for addr := subnet.Addr().Next(); subnet.Contains(addr); addr = addr.Next() {
+		if addr == server || addr.Is4() && !subnet.Contains(addr.Next()) {
+			continue
+		}
+		if !slices.ContainsFunc(taken, func(p netip.Prefix) bool { return p.Contains(addr) }) {
+			return netip.PrefixFrom(addr, addr.BitLen()).String(), nil
+		}
+	}
+	return "", fmt.Errorf("%w: no free address left in %s", ErrConflict, subnet)
+}
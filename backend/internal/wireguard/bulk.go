@@ -0,0 +1,422 @@
+package wireguard
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Bulk operations accepted by BulkOperation.Op.
+const (
+	BulkCreate     = "create"
+	BulkUpdate     = "update"
+	BulkDisable    = "disable"
+	BulkEnable     = "enable"
+	BulkDelete     = "delete"
+	BulkRegenerate = "regenerate"
+)
+
+// BulkOps lists every bulk operation.
+var BulkOps = []string{BulkCreate, BulkUpdate, BulkDisable, BulkEnable, BulkDelete, BulkRegenerate}
+
+// Statuses of a BulkItemResult.
+const (
+	BulkStatusOK     = "ok"
+	BulkStatusFailed = "failed"
+)
+
+// MaxBulkOperations bounds the operations in one batch.
+const MaxBulkOperations = 1000
+
+// PeerSelector picks the stored peers in a group, with a tag, or both.
+type PeerSelector struct {
+	Group string `json:"group,omitempty"`
+	Tag   string `json:"tag,omitempty"`
+}
+
+func (sel PeerSelector) matches(meta PeerMetadata) bool {
+	if sel.Group != "" && meta.Group != sel.Group {
+		return false
+	}
+	return sel.Tag == "" || slices.Contains(meta.Tags, sel.Tag)
+}
+
+// BulkOperation is one step of a batch. Every operation but create names its
+// peer by ID, or picks any number of peers with Selector.
+type BulkOperation struct {
+	Op       string
+	ID       string
+	Selector *PeerSelector
+	// Create is the new peer of a create operation. Without AllowedIPs it
+	// gets the next free address of the VPN subnet.
+	Create AddPeerOptions
+	// Update holds the changes of an update operation.
+	Update PeerUpdate
+	// Regenerate holds the options of a regenerate operation.
+	Regenerate RegenerateOptions
+}
+
+// BulkOptions controls how a batch is applied.
+type BulkOptions struct {
+	// Atomic applies nothing unless every operation succeeds.
+	Atomic bool
+	// DryRun runs the batch and reports the results without applying it.
+	DryRun bool
+}
+
+// BulkItemResult is the outcome of an operation on one peer.
+type BulkItemResult struct {
+	// Index is the position of the operation in the batch. An operation
+	// with a selector has one result per selected peer, and none if the
+	// selector matches nothing.
+	Index  int    `json:"index"`
+	Op     string `json:"op"`
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	// Peer is the peer after the operation; empty for deletes and failures.
+	// Created and regenerated peers carry their keys and config only if the
+	// batch was applied.
+	Peer *PeerResponse `json:"peer,omitempty"`
+	// Code and Error describe a failure. Err is the underlying error, for
+	// callers that map it to their own codes.
+	Code  string `json:"code,omitempty"`
+	Error string `json:"error,omitempty"`
+	Err   error  `json:"-"`
+}
+
+// BulkResult is the outcome of a batch.
+type BulkResult struct {
+	Results   []BulkItemResult `json:"results"`
+	Succeeded int              `json:"succeeded"`
+	Failed    int              `json:"failed"`
+	// Applied reports whether the batch was applied. It is false for dry
+	// runs and for atomic batches with a failed operation.
+	Applied bool `json:"applied"`
+	DryRun  bool `json:"dryRun"`
+}
+
+// Bulk runs a batch of peer operations in order. They run against a detached
+// copy of storage and a snapshot of the device, so each operation sees
+// the effects of the ones before it. The device is then changed with a single
+// ConfigureDevice call and storage with a single save. A failed operation is
+// reported in its result; the returned error is for failures of the batch as
+// a whole, which leave everything as it was.
+func (s *realService) Bulk(ops []BulkOperation, opts BulkOptions) (BulkResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, err := s.client.Device(s.interfaceName)
+	if err != nil {
+		return BulkResult{}, deviceError("failed to get device "+s.interfaceName, err)
+	}
+	stage := s.stage(device)
+
+	result := BulkResult{Results: []BulkItemResult{}, DryRun: opts.DryRun}
+	for i, op := range ops {
+		// The service's operations check everything before they change
+		// anything, so a failed one leaves the stage as it was.
+		for _, id := range stage.bulkTargets(op) {
+			item := stage.runBulk(op, id)
+			item.Index = i
+			if item.Err != nil {
+				result.Failed++
+			} else {
+				result.Succeeded++
+			}
+			result.Results = append(result.Results, item)
+		}
+	}
+
+	if opts.DryRun || (opts.Atomic && result.Failed > 0) {
+		// Keys made in the stage are thrown away; do not hand them out.
+		for _, item := range result.Results {
+			if item.Peer != nil {
+				*item.Peer = PeerResponse{Peer: item.Peer.Peer}
+			}
+		}
+		return result, nil
+	}
+
+	if err := s.commitStage(stage, device); err != nil {
+		return BulkResult{}, err
+	}
+	result.Applied = true
+	s.emit(EventBulkApplied, fmt.Sprintf("Bulk change applied: %d succeeded, %d failed", result.Succeeded, result.Failed), nil)
+	return result, nil
+}
+
+// bulkTargets returns the peer IDs an operation applies to: none for create,
+// its ID, or the stored peers its selector matches, by name.
+func (s *realService) bulkTargets(op BulkOperation) []string {
+	if op.Op == BulkCreate || op.Selector == nil {
+		return []string{op.ID}
+	}
+	var matched []PeerMetadata
+	for _, meta := range s.storage.ListMetadata() {
+		if op.Selector.matches(meta) {
+			matched = append(matched, meta)
+		}
+	}
+	slices.SortFunc(matched, func(a, b PeerMetadata) int {
+		return strings.Compare(a.Name+a.ID, b.Name+b.ID)
+	})
+	ids := make([]string, len(matched))
+	for i, meta := range matched {
+		ids[i] = meta.ID
+	}
+	return ids
+}
+
+// runBulk applies one operation to the peer with the given ID.
+func (s *realService) runBulk(op BulkOperation, id string) BulkItemResult {
+	item := BulkItemResult{Op: op.Op, ID: id, Status: BulkStatusOK}
+	var peer PeerResponse
+	var err error
+	switch {
+	case op.Op != BulkCreate && id == "":
+		err = invalidArgument("%s needs a peer ID or a selector", op.Op)
+	case op.Op == BulkCreate:
+		peer, err = s.bulkCreate(op.Create)
+	case op.Op == BulkUpdate:
+		peer.Peer, err = s.UpdatePeer(id, op.Update, 0)
+	case op.Op == BulkDisable || op.Op == BulkEnable:
+		disabled := op.Op == BulkDisable
+		peer.Peer, err = s.UpdatePeer(id, PeerUpdate{Disabled: &disabled}, 0)
+	case op.Op == BulkDelete:
+		err = s.RemovePeer(id, 0)
+	case op.Op == BulkRegenerate:
+		peer, err = s.RegeneratePeer(id, op.Regenerate, 0)
+	default:
+		err = invalidArgument("unknown bulk operation: %s", op.Op)
+	}
+
+	if err != nil {
+		item.Status, item.Error, item.Err = BulkStatusFailed, err.Error(), err
+		return item
+	}
+	if op.Op != BulkDelete {
+		item.ID = peer.ID
+		item.Peer = &peer
+	}
+	return item
+}
+
+// bulkCreate adds a peer, giving it the next free address of the VPN subnet
+// if it has no AllowedIPs.
+func (s *realService) bulkCreate(opts AddPeerOptions) (PeerResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(opts.AllowedIPs) == 0 {
+		address, err := s.allocateAddress()
+		if err != nil {
+			return PeerResponse{}, err
+		}
+		opts.AllowedIPs = []string{address}
+	}
+	return s.addPeer(opts)
+}
+
+// stage returns a copy of the service for trying out changes, backed by a
+// detached copy of storage and a snapshotDevice copy of device. The caller
+// must hold s.mu.
+func (s *realService) stage(device *wgtypes.Device) *realService {
+	return &realService{
+		client:         newSnapshotDevice(s.interfaceName, device),
+		interfaceName:  s.interfaceName,
+		storage:        s.storage.detached(),
+		serverPubKey:   s.serverPubKey,
+		serverEndpoint: s.serverEndpoint,
+		vpnSubnet:      s.vpnSubnet,
+	}
+}
+
+// snapshotDevice is a DeviceClient over a copy of a device read from the
+// real one. ConfigureDevice applies peer changes to the copy the way
+// WireGuard would, which is all a staged batch needs; statistics are kept
+// so staged peers are listed with their status.
+type snapshotDevice struct {
+	name   string
+	device wgtypes.Device
+}
+
+func newSnapshotDevice(name string, device *wgtypes.Device) *snapshotDevice {
+	return &snapshotDevice{name: name, device: copyDevice(device)}
+}
+
+// Device returns a copy of the snapshot.
+func (d *snapshotDevice) Device(name string) (*wgtypes.Device, error) {
+	if name != d.name {
+		return nil, os.ErrNotExist
+	}
+	device := copyDevice(&d.device)
+	return &device, nil
+}
+
+// ConfigureDevice applies the peers of cfg to the snapshot. Other device
+// settings are ignored, as the service never changes them.
+func (d *snapshotDevice) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	if name != d.name {
+		return os.ErrNotExist
+	}
+	if cfg.ReplacePeers {
+		d.device.Peers = nil
+	}
+	for _, pc := range cfg.Peers {
+		i := slices.IndexFunc(d.device.Peers, func(p wgtypes.Peer) bool { return p.PublicKey == pc.PublicKey })
+		if pc.Remove {
+			if i >= 0 {
+				d.device.Peers = slices.Delete(d.device.Peers, i, i+1)
+			}
+			continue
+		}
+		if i < 0 {
+			if pc.UpdateOnly {
+				continue
+			}
+			d.device.Peers = append(d.device.Peers, wgtypes.Peer{PublicKey: pc.PublicKey, ProtocolVersion: 1})
+			i = len(d.device.Peers) - 1
+		}
+
+		p := &d.device.Peers[i]
+		if pc.PresharedKey != nil {
+			p.PresharedKey = *pc.PresharedKey
+		}
+		if pc.Endpoint != nil {
+			endpoint := *pc.Endpoint
+			p.Endpoint = &endpoint
+		}
+		if pc.PersistentKeepaliveInterval != nil {
+			p.PersistentKeepaliveInterval = *pc.PersistentKeepaliveInterval
+		}
+		if pc.ReplaceAllowedIPs {
+			p.AllowedIPs = nil
+		}
+		for _, ip := range pc.AllowedIPs {
+			// An AllowedIP belongs to one peer; assigning it moves it.
+			ip = net.IPNet{IP: ip.IP.Mask(ip.Mask), Mask: ip.Mask}
+			for j := range d.device.Peers {
+				d.device.Peers[j].AllowedIPs = slices.DeleteFunc(d.device.Peers[j].AllowedIPs, func(n net.IPNet) bool {
+					return n.String() == ip.String()
+				})
+			}
+			d.device.Peers[i].AllowedIPs = append(d.device.Peers[i].AllowedIPs, ip)
+		}
+	}
+	return nil
+}
+
+func (d *snapshotDevice) Close() error { return nil }
+
+// copyDevice returns a copy of device that shares no peer slices with it.
+func copyDevice(device *wgtypes.Device) wgtypes.Device {
+	cp := *device
+	cp.Peers = make([]wgtypes.Peer, len(device.Peers))
+	for i, p := range device.Peers {
+		p.AllowedIPs = slices.Clone(p.AllowedIPs)
+		cp.Peers[i] = p
+	}
+	return cp
+}
+
+// commitStage applies the changes made in stage since device was read: the
+// device in one call, then storage in one save. If the save fails, the device
+// is put back. The caller must hold s.mu.
+func (s *realService) commitStage(stage *realService, device *wgtypes.Device) error {
+	staged, err := stage.client.Device(s.interfaceName)
+	if err != nil {
+		return fmt.Errorf("failed to read staged device: %w", err)
+	}
+
+	if changes := devicePeerChanges(device.Peers, staged.Peers); len(changes) > 0 {
+		if err := s.client.ConfigureDevice(s.interfaceName, wgtypes.Config{Peers: changes}); err != nil {
+			return deviceError("failed to apply bulk changes", err)
+		}
+	}
+	set, deleted := metadataChanges(s.storage.ListMetadata(), stage.storage.ListMetadata())
+	if err := s.storage.ApplyMetadata(set, deleted); err != nil {
+		if undo := devicePeerChanges(staged.Peers, device.Peers); len(undo) > 0 {
+			if err := s.client.ConfigureDevice(s.interfaceName, wgtypes.Config{Peers: undo}); err != nil {
+				slog.Error("Failed to restore device after bulk change", "error", err)
+			}
+		}
+		return fmt.Errorf("failed to save bulk changes: %w", err)
+	}
+
+	events, _ := stage.GetEvents()
+	for _, e := range events {
+		s.emit(e.Type, e.Message, e.Data)
+	}
+	return nil
+}
+
+// peerState returns the config that recreates a device peer.
+func peerState(p wgtypes.Peer) wgtypes.PeerConfig {
+	return wgtypes.PeerConfig{
+		PublicKey:                   p.PublicKey,
+		PresharedKey:                &p.PresharedKey,
+		Endpoint:                    p.Endpoint,
+		PersistentKeepaliveInterval: &p.PersistentKeepaliveInterval,
+		ReplaceAllowedIPs:           true,
+		AllowedIPs:                  p.AllowedIPs,
+	}
+}
+
+// samePeerState reports whether two device peers are configured alike.
+func samePeerState(a, b wgtypes.Peer) bool {
+	return a.PresharedKey == b.PresharedKey &&
+		a.PersistentKeepaliveInterval == b.PersistentKeepaliveInterval &&
+		a.Endpoint.String() == b.Endpoint.String() &&
+		samePrefixes(ipNetStrings(a.AllowedIPs), ipNetStrings(b.AllowedIPs))
+}
+
+// devicePeerChanges returns the peer configs that turn the peers from into
+// the peers to: removals first, then every new or changed peer in full.
+func devicePeerChanges(from, to []wgtypes.Peer) []wgtypes.PeerConfig {
+	before := make(map[wgtypes.Key]wgtypes.Peer, len(from))
+	for _, p := range from {
+		before[p.PublicKey] = p
+	}
+	after := make(map[wgtypes.Key]bool, len(to))
+	for _, p := range to {
+		after[p.PublicKey] = true
+	}
+
+	var changes []wgtypes.PeerConfig
+	for _, p := range from {
+		if !after[p.PublicKey] {
+			changes = append(changes, wgtypes.PeerConfig{PublicKey: p.PublicKey, Remove: true})
+		}
+	}
+	for _, p := range to {
+		if old, ok := before[p.PublicKey]; ok && samePeerState(old, p) {
+			continue
+		}
+		changes = append(changes, peerState(p))
+	}
+	return changes
+}
+
+// metadataChanges returns the stored peers that are new or changed in after,
+// relying on every change bumping the revision, and the IDs gone from it.
+func metadataChanges(before, after map[string]PeerMetadata) (map[string]PeerMetadata, []string) {
+	set := map[string]PeerMetadata{}
+	for id, meta := range after {
+		if old, ok := before[id]; !ok || old.Revision != meta.Revision {
+			set[id] = meta
+		}
+	}
+	var deleted []string
+	for id := range before {
+		if _, ok := after[id]; !ok {
+			deleted = append(deleted, id)
+		}
+	}
+	slices.Sort(deleted)
+	return set, deleted
+}
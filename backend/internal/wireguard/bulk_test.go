@@ -0,0 +1,263 @@
+package wireguard
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"wg-manager/backend/internal/wireguard/fakedevice"
+)
+
+func TestBulk(t *testing.T) {
+	setup := func(t *testing.T) (*realService, []PeerResponse) {
+		t.Helper()
+		client := newFakeDevice(t)
+		srv := newFakeService(t, client, filepath.Join(t.TempDir(), "peers.json"))
+		if _, err := srv.CreateGroup(Group{Name: "contractors"}); err != nil {
+			t.Fatalf("CreateGroup failed: %v", err)
+		}
+		var peers []PeerResponse
+		for i, name := range []string{"alice", "bob", "carol"} {
+			group := ""
+			if name != "alice" {
+				group = "contractors"
+			}
+			peer, err := srv.AddPeer(AddPeerOptions{Name: name, AllowedIPs: []string{fmt.Sprintf("10.0.0.%d/32", i+2)}, Group: group})
+			if err != nil {
+				t.Fatalf("AddPeer failed: %v", err)
+			}
+			peers = append(peers, peer)
+		}
+		return srv.(*realService), peers
+	}
+	configureCalls := func(rs *realService) int {
+		return rs.client.(*fakedevice.Client).ConfigureCalls()
+	}
+	names := func(t *testing.T, rs *realService) []string {
+		t.Helper()
+		listed, err := rs.ListPeers()
+		if err != nil {
+			t.Fatalf("ListPeers failed: %v", err)
+		}
+		var got []string
+		for _, p := range listed {
+			got = append(got, p.Name)
+		}
+		slices.Sort(got)
+		return got
+	}
+
+	t.Run("OneDeviceCall", func(t *testing.T) {
+		rs, peers := setup(t)
+		calls := configureCalls(rs)
+		newName := "alice laptop"
+		result, err := rs.Bulk([]BulkOperation{
+			{Op: BulkCreate, Create: AddPeerOptions{Name: "dave", AllowedIPs: []string{"10.0.0.9/32"}}},
+			{Op: BulkUpdate, ID: peers[0].ID, Update: PeerUpdate{Name: &newName}},
+			{Op: BulkDisable, ID: peers[1].ID},
+			{Op: BulkRegenerate, ID: peers[2].ID},
+		}, BulkOptions{})
+		if err != nil {
+			t.Fatalf("Bulk failed: %v", err)
+		}
+		if !result.Applied || result.Succeeded != 4 || result.Failed != 0 {
+			t.Fatalf("expected 4 applied operations, got %+v", result)
+		}
+		if got := configureCalls(rs) - calls; got != 1 {
+			t.Errorf("expected a single ConfigureDevice call, got %d", got)
+		}
+
+		created := result.Results[0].Peer
+		if created == nil || created.PrivateKey == "" || created.Config == "" {
+			t.Fatalf("expected the created peer with its keys, got %+v", created)
+		}
+		if got := names(t, rs); !slices.Equal(got, []string{"alice laptop", "bob", "carol", "dave"}) {
+			t.Errorf("unexpected peers after the batch: %v", got)
+		}
+		if bob, _ := rs.GetPeer(peers[1].ID); bob.Status != PeerStatusDisabled {
+			t.Errorf("expected bob to be disabled, got %s", bob.Status)
+		}
+		carol, _ := rs.GetPeer(peers[2].ID)
+		if carol.PublicKey == peers[2].PublicKey || carol.PublicKey != result.Results[3].Peer.PublicKey {
+			t.Errorf("expected carol to have the regenerated key, got %s", carol.PublicKey)
+		}
+		if drift, _ := rs.CheckDrift(); !drift.InSync {
+			t.Errorf("expected storage and device to agree, got %+v", drift)
+		}
+		events, _ := rs.GetEvents()
+		if !slices.ContainsFunc(events, func(e Event) bool { return e.Type == EventKeyRotated }) {
+			t.Error("expected the staged key rotation event to be recorded")
+		}
+	})
+
+	t.Run("Selector", func(t *testing.T) {
+		rs, _ := setup(t)
+		result, err := rs.Bulk([]BulkOperation{{Op: BulkDelete, Selector: &PeerSelector{Group: "contractors"}}}, BulkOptions{})
+		if err != nil {
+			t.Fatalf("Bulk failed: %v", err)
+		}
+		if len(result.Results) != 2 || result.Results[0].Index != 0 || result.Results[1].Index != 0 {
+			t.Errorf("expected a result per selected peer, got %+v", result.Results)
+		}
+		if got := names(t, rs); !slices.Equal(got, []string{"alice"}) {
+			t.Errorf("expected only alice to remain, got %v", got)
+		}
+		if drift, _ := rs.CheckDrift(); !drift.InSync {
+			t.Errorf("expected storage and device to agree, got %+v", drift)
+		}
+	})
+
+	t.Run("PartialFailure", func(t *testing.T) {
+		rs, peers := setup(t)
+		result, err := rs.Bulk([]BulkOperation{
+			{Op: BulkDelete, ID: peers[0].ID},
+			{Op: BulkDelete, ID: "missing"},
+		}, BulkOptions{})
+		if err != nil {
+			t.Fatalf("Bulk failed: %v", err)
+		}
+		if !result.Applied || result.Succeeded != 1 || result.Failed != 1 {
+			t.Fatalf("expected one success and one failure, got %+v", result)
+		}
+		if item := result.Results[1]; item.Status != BulkStatusFailed || !errors.Is(item.Err, ErrPeerNotFound) {
+			t.Errorf("expected ErrPeerNotFound for the missing peer, got %+v", item)
+		}
+		if got := names(t, rs); !slices.Equal(got, []string{"bob", "carol"}) {
+			t.Errorf("expected alice to be deleted, got %v", got)
+		}
+	})
+
+	t.Run("AtomicFailure", func(t *testing.T) {
+		rs, peers := setup(t)
+		calls := configureCalls(rs)
+		result, err := rs.Bulk([]BulkOperation{
+			{Op: BulkDelete, ID: peers[0].ID},
+			{Op: BulkCreate, Create: AddPeerOptions{Name: "clash", AllowedIPs: []string{"10.0.0.3/32"}}},
+		}, BulkOptions{Atomic: true})
+		if err != nil {
+			t.Fatalf("Bulk failed: %v", err)
+		}
+		if result.Applied || result.Failed != 1 {
+			t.Fatalf("expected the batch not to be applied, got %+v", result)
+		}
+		var conflict *ConflictError
+		if !errors.As(result.Results[1].Err, &conflict) {
+			t.Errorf("expected a *ConflictError, got %v", result.Results[1].Err)
+		}
+		if configureCalls(rs) != calls {
+			t.Error("expected the device to be left alone")
+		}
+		if got := names(t, rs); !slices.Equal(got, []string{"alice", "bob", "carol"}) {
+			t.Errorf("expected no changes, got %v", got)
+		}
+	})
+
+	t.Run("CreateAllocatesAddresses", func(t *testing.T) {
+		rs, peers := setup(t)
+		key := mustKey(t).PublicKey().String()
+		result, err := rs.Bulk([]BulkOperation{
+			{Op: BulkCreate, Create: AddPeerOptions{Name: "dave", PublicKey: key}},
+			{Op: BulkCreate, Create: AddPeerOptions{Name: "erin"}},
+			{Op: BulkCreate, Create: AddPeerOptions{Name: "dave again", PublicKey: key}},
+			{Op: BulkCreate, Create: AddPeerOptions{Name: "bob again", PublicKey: peers[1].PublicKey}},
+		}, BulkOptions{DryRun: true})
+		if err != nil {
+			t.Fatalf("Bulk failed: %v", err)
+		}
+		for i, want := range []string{"10.0.0.5/32", "10.0.0.6/32"} {
+			if peer := result.Results[i].Peer; peer == nil || !slices.Equal(peer.AllowedIPs, []string{want}) {
+				t.Errorf("expected peer %d to get %s, got %+v", i, want, peer)
+			}
+		}
+		for _, item := range result.Results[2:] {
+			if !errors.Is(item.Err, ErrConflict) {
+				t.Errorf("expected ErrConflict for a key in use, got %v", item.Err)
+			}
+		}
+	})
+
+	t.Run("DryRun", func(t *testing.T) {
+		rs, peers := setup(t)
+		calls := configureCalls(rs)
+		result, err := rs.Bulk([]BulkOperation{
+			{Op: BulkCreate, Create: AddPeerOptions{Name: "dave", AllowedIPs: []string{"10.0.0.9/32"}}},
+			{Op: BulkRegenerate, ID: peers[0].ID},
+		}, BulkOptions{DryRun: true})
+		if err != nil {
+			t.Fatalf("Bulk failed: %v", err)
+		}
+		if result.Applied || !result.DryRun || result.Succeeded != 2 {
+			t.Fatalf("expected a dry run with 2 successes, got %+v", result)
+		}
+		for _, item := range result.Results {
+			if item.Peer == nil || item.Peer.PrivateKey != "" || item.Peer.Config != "" {
+				t.Errorf("expected the peer without keys or config, got %+v", item.Peer)
+			}
+		}
+		if configureCalls(rs) != calls {
+			t.Error("expected the device to be left alone")
+		}
+		if got := names(t, rs); !slices.Equal(got, []string{"alice", "bob", "carol"}) {
+			t.Errorf("expected no changes, got %v", got)
+		}
+		if alice, _ := rs.GetPeer(peers[0].ID); alice.PublicKey != peers[0].PublicKey {
+			t.Error("expected the key to be kept")
+		}
+	})
+}
+
+func TestSnapshotDevice(t *testing.T) {
+	a, b, unknown := mustKey(t), mustKey(t), mustKey(t)
+	handshake := time.Now().Add(-time.Minute)
+	live := &wgtypes.Device{Name: "wg0", ListenPort: 51820, Peers: []wgtypes.Peer{
+		{PublicKey: a, AllowedIPs: []net.IPNet{mustIPNet(t, "10.0.0.2/32")}, LastHandshakeTime: handshake, ReceiveBytes: 42},
+		{PublicKey: b, AllowedIPs: []net.IPNet{mustIPNet(t, "10.0.0.3/32")}},
+	}}
+	snapshot := newSnapshotDevice("wg0", live)
+
+	keepalive := 25 * time.Second
+	err := snapshot.ConfigureDevice("wg0", wgtypes.Config{Peers: []wgtypes.PeerConfig{
+		// Taking a's address moves it, as on a live device.
+		{PublicKey: b, AllowedIPs: []net.IPNet{mustIPNet(t, "10.0.0.2/32")}, PersistentKeepaliveInterval: &keepalive},
+		{PublicKey: unknown, UpdateOnly: true, AllowedIPs: []net.IPNet{mustIPNet(t, "10.0.0.9/32")}},
+	}})
+	if err != nil {
+		t.Fatalf("ConfigureDevice failed: %v", err)
+	}
+	device, err := snapshot.Device("wg0")
+	if err != nil {
+		t.Fatalf("Device failed: %v", err)
+	}
+	if len(device.Peers) != 2 {
+		t.Fatalf("expected an update-only config not to add a peer, got %+v", device.Peers)
+	}
+	if got := ipNetStrings(device.Peers[0].AllowedIPs); len(got) != 0 {
+		t.Errorf("expected a to lose its address, got %v", got)
+	}
+	if !device.Peers[0].LastHandshakeTime.Equal(handshake) || device.Peers[0].ReceiveBytes != 42 {
+		t.Errorf("expected a's statistics to be kept, got %+v", device.Peers[0])
+	}
+	if got := ipNetStrings(device.Peers[1].AllowedIPs); !slices.Equal(got, []string{"10.0.0.3/32", "10.0.0.2/32"}) || device.Peers[1].PersistentKeepaliveInterval != keepalive {
+		t.Errorf("expected b to gain the address and keepalive, got %+v", device.Peers[1])
+	}
+	if got := ipNetStrings(live.Peers[0].AllowedIPs); !slices.Equal(got, []string{"10.0.0.2/32"}) {
+		t.Errorf("expected the live device to be left alone, got %v", got)
+	}
+
+	if err := snapshot.ConfigureDevice("wg0", wgtypes.Config{Peers: []wgtypes.PeerConfig{{PublicKey: a, Remove: true}}}); err != nil {
+		t.Fatalf("ConfigureDevice failed: %v", err)
+	}
+	if device, _ := snapshot.Device("wg0"); len(device.Peers) != 1 || device.Peers[0].PublicKey != b {
+		t.Errorf("expected a to be removed, got %+v", device.Peers)
+	}
+	if _, err := snapshot.Device("wg1"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected os.ErrNotExist for another device, got %v", err)
+	}
+}
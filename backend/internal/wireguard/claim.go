@@ -0,0 +1,88 @@
+package wireguard
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// ClaimPeer assigns a client-generated public key to a peer created with
+// AddPeerOptions.Claimable and adds the peer to the device. The returned
+// config carries PrivateKeyPlaceholder, since the private key never leaves
+// the client. A non-zero ifRevision must match the peer's current revision.
+func (s *realService) ClaimPeer(id string, publicKey string, ifRevision int64) (PeerResponse, error) {
+	pubKey, err := wgtypes.ParseKey(publicKey)
+	if err != nil {
+		return PeerResponse{}, invalidKey("public key", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, ok := s.storedPeer(id)
+	if !ok {
+		return PeerResponse{}, peerNotFound(id)
+	}
+	if err := s.checkPeerRevision(id, ifRevision); err != nil {
+		return PeerResponse{}, err
+	}
+	if !meta.Unclaimed() {
+		return PeerResponse{}, fmt.Errorf("%w: peer %s has already been claimed", ErrConflict, id)
+	}
+	if reason := meta.inactive(time.Now()); reason != "" {
+		return PeerResponse{}, fmt.Errorf("%w: peer %s %s", ErrConflict, id, reason)
+	}
+	if err := s.checkKeyUnused(pubKey); err != nil {
+		return PeerResponse{}, err
+	}
+
+	meta.PublicKey = publicKey
+	if err := s.checkConflicts(meta.DeviceAllowedIPs(), meta); err != nil {
+		return PeerResponse{}, err
+	}
+	if meta.IssuedConfig, err = s.renderPeerConfig(meta); err != nil {
+		return PeerResponse{}, fmt.Errorf("failed to render config: %w", err)
+	}
+
+	peerConfig, err := devicePeerConfig(publicKey, meta)
+	if err != nil {
+		return PeerResponse{}, err
+	}
+	if err := s.client.ConfigureDevice(s.interfaceName, wgtypes.Config{Peers: []wgtypes.PeerConfig{peerConfig}}); err != nil {
+		return PeerResponse{}, deviceError("failed to configure device", err)
+	}
+	if err := s.storage.SetMetadata(meta.ID, meta); err != nil {
+		// Take the key off the device again so the slot stays claimable.
+		remove := wgtypes.Config{Peers: []wgtypes.PeerConfig{{PublicKey: pubKey, Remove: true}}}
+		if rerr := s.client.ConfigureDevice(s.interfaceName, remove); rerr != nil {
+			slog.Error("Failed to roll back peer claim", "peer", meta.ID, "error", rerr)
+		}
+		return PeerResponse{}, fmt.Errorf("failed to save metadata: %w", err)
+	}
+
+	stored, _ := s.storage.GetMetadata(meta.ID)
+	return PeerResponse{
+		Peer:         metadataPeer(stored),
+		PresharedKey: stored.PresharedKey,
+		Config:       stored.IssuedConfig,
+	}, nil
+}
+
+// checkKeyUnused fails with ErrConflict if key belongs to a stored peer or is
+// already on the device.
+func (s *realService) checkKeyUnused(key wgtypes.Key) error {
+	if other, ok := s.storage.LookupPublicKey(key.String()); ok {
+		return fmt.Errorf("%w: public key is already used by peer %s", ErrConflict, other.ID)
+	}
+	device, err := s.client.Device(s.interfaceName)
+	if err != nil {
+		return deviceError("failed to get device "+s.interfaceName, err)
+	}
+	if slices.ContainsFunc(device.Peers, func(p wgtypes.Peer) bool { return p.PublicKey == key }) {
+		return fmt.Errorf("%w: public key is already on the device", ErrConflict)
+	}
+	return nil
+}
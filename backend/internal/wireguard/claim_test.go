@@ -0,0 +1,140 @@
+package wireguard
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClaimPeer(t *testing.T) {
+	t.Run("SlotWaitsForKey", func(t *testing.T) {
+		client := newFakeDevice(t)
+		srv := newFakeService(t, client, filepath.Join(t.TempDir(), "peers.json"))
+		resp, err := srv.AddPeer(AddPeerOptions{Name: "phone", AllowedIPs: []string{"10.0.0.2/32"}, Claimable: true})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		if !resp.Unclaimed || resp.PublicKey != "" || resp.PrivateKey != "" {
+			t.Fatalf("expected an unclaimed peer without keys, got %+v", resp.Peer)
+		}
+		if calls := client.ConfigureCalls(); calls != 0 {
+			t.Errorf("expected no device changes, got %d ConfigureDevice calls", calls)
+		}
+		if !strings.Contains(resp.Config, "PrivateKey = "+PrivateKeyPlaceholder) {
+			t.Errorf("expected a placeholder private key, got:\n%s", resp.Config)
+		}
+		peers, err := srv.ListPeers()
+		if err != nil || len(peers) != 1 || peers[0].ID != resp.ID || !peers[0].Unclaimed {
+			t.Errorf("expected the slot listed as unclaimed, got %+v (%v)", peers, err)
+		}
+		if report, _ := srv.CheckDrift(); !report.InSync {
+			t.Errorf("expected the slot not to count as drift, got %+v", report)
+		}
+	})
+
+	t.Run("Claim", func(t *testing.T) {
+		client := newFakeDevice(t)
+		srv := newFakeService(t, client, filepath.Join(t.TempDir(), "peers.json"))
+		resp, err := srv.AddPeer(AddPeerOptions{Name: "phone", AllowedIPs: []string{"10.0.0.2/32"}, Claimable: true})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+
+		key := mustKey(t).PublicKey().String()
+		claimed, err := srv.ClaimPeer(resp.ID, key, resp.Revision)
+		if err != nil {
+			t.Fatalf("ClaimPeer failed: %v", err)
+		}
+		if claimed.ID != resp.ID || claimed.PublicKey != key || claimed.Unclaimed {
+			t.Errorf("expected the slot claimed with %s, got %+v", key, claimed.Peer)
+		}
+		if claimed.PrivateKey != "" || !strings.Contains(claimed.Config, PrivateKeyPlaceholder) {
+			t.Errorf("expected no private key and a placeholder config, got %+v", claimed)
+		}
+		if p := devicePeer(t, client, key); p == nil || len(p.AllowedIPs) != 1 {
+			t.Errorf("expected the key on the device with its AllowedIPs, got %+v", p)
+		}
+
+		if _, err := srv.ClaimPeer(resp.ID, mustKey(t).PublicKey().String(), 0); !errors.Is(err, ErrConflict) {
+			t.Errorf("expected ErrConflict claiming twice, got %v", err)
+		}
+	})
+
+	t.Run("KeyInUse", func(t *testing.T) {
+		srv := newFakeService(t, newFakeDevice(t), filepath.Join(t.TempDir(), "peers.json"))
+		existing, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		slot, err := srv.AddPeer(AddPeerOptions{Name: "phone", AllowedIPs: []string{"10.0.0.3/32"}, Claimable: true})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+
+		if _, err := srv.ClaimPeer(slot.ID, existing.PublicKey, 0); !errors.Is(err, ErrConflict) {
+			t.Errorf("expected ErrConflict, got %v", err)
+		}
+		if _, err := srv.ClaimPeer(slot.ID, "not-a-key", 0); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("expected ErrInvalidKey, got %v", err)
+		}
+		if meta, _ := srv.GetPeerMetadata(slot.ID); !meta.Unclaimed() {
+			t.Errorf("expected the slot still unclaimed, got %+v", meta)
+		}
+	})
+
+	t.Run("StaleRevision", func(t *testing.T) {
+		srv := newFakeService(t, newFakeDevice(t), filepath.Join(t.TempDir(), "peers.json"))
+		slot, err := srv.AddPeer(AddPeerOptions{Name: "phone", AllowedIPs: []string{"10.0.0.2/32"}, Claimable: true})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		if _, err := srv.ClaimPeer(slot.ID, mustKey(t).PublicKey().String(), slot.Revision+1); !errors.Is(err, ErrPreconditionFailed) {
+			t.Errorf("expected ErrPreconditionFailed, got %v", err)
+		}
+	})
+
+	t.Run("UnclaimedSlotRules", func(t *testing.T) {
+		client := newFakeDevice(t)
+		srv := newFakeService(t, client, filepath.Join(t.TempDir(), "peers.json"))
+		slot, err := srv.AddPeer(AddPeerOptions{Name: "phone", AllowedIPs: []string{"10.0.0.2/32"}, Claimable: true})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+
+		if _, err := srv.RegeneratePeer(slot.ID, RegenerateOptions{}, 0); !errors.Is(err, ErrConflict) {
+			t.Errorf("expected ErrConflict regenerating an unclaimed peer, got %v", err)
+		}
+		if _, err := srv.AddPeer(AddPeerOptions{Name: "tablet", AllowedIPs: []string{"10.0.0.2/32"}}); !errors.Is(err, ErrConflict) {
+			t.Errorf("expected the slot to reserve its AllowedIPs, got %v", err)
+		}
+		if err := srv.RemovePeer(slot.ID, 0); err != nil {
+			t.Fatalf("RemovePeer failed: %v", err)
+		}
+		if _, ok := srv.GetPeerMetadata(slot.ID); ok {
+			t.Error("expected the slot removed")
+		}
+		if calls := client.ConfigureCalls(); calls != 0 {
+			t.Errorf("expected no device changes, got %d ConfigureDevice calls", calls)
+		}
+	})
+
+	t.Run("OwnPublicKey", func(t *testing.T) {
+		client := newFakeDevice(t)
+		srv := newFakeService(t, client, filepath.Join(t.TempDir(), "peers.json"))
+		key := mustKey(t).PublicKey().String()
+		resp, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}, PublicKey: key})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		if resp.PrivateKey != "" || !strings.Contains(resp.Config, "PrivateKey = "+PrivateKeyPlaceholder) {
+			t.Errorf("expected a placeholder config, got:\n%s", resp.Config)
+		}
+		if config, err := srv.GetPeerConfig(resp.ID); err != nil || !strings.Contains(config, PrivateKeyPlaceholder) {
+			t.Errorf("expected the config downloadable with a placeholder, got %v", err)
+		}
+		if devicePeer(t, client, key) == nil {
+			t.Error("expected the key on the device")
+		}
+	})
+}
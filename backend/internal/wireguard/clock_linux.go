@@ -0,0 +1,30 @@
+package wireguard
+
+import "golang.org/x/sys/unix"
+
+// adjtimex status bits and clock state, from <sys/timex.h>.
+const (
+	staUnsync = 0x0040
+	staNano   = 0x2000
+	timeError = 5
+)
+
+// readClockStatus queries the kernel NTP state without adjusting anything.
+func readClockStatus() ClockStatus {
+	var tx unix.Timex
+	state, err := unix.Adjtimex(&tx)
+	if err != nil {
+		return ClockStatus{}
+	}
+	offset := int64(tx.Offset)
+	if tx.Status&staNano != 0 {
+		offset /= 1000
+	}
+	return ClockStatus{
+		Supported:      true,
+		Synchronized:   state != timeError && tx.Status&staUnsync == 0,
+		OffsetMicros:   offset,
+		MaxErrorMicros: int64(tx.Maxerror),
+		EstErrorMicros: int64(tx.Esterror),
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package wireguard
+
+// readClockStatus is only implemented on Linux.
+func readClockStatus() ClockStatus {
+	return ClockStatus{}
+}
@@ -5,6 +5,10 @@ import (
 	"strings"
 )
 
+// PrivateKeyPlaceholder stands in for the private key in configs of peers
+// whose key the server does not hold. The client fills in its own key.
+const PrivateKeyPlaceholder = "<insert>"
+
 // PeerConfigInfo contains all data needed to generate a client .conf file.
 type PeerConfigInfo struct {
 	PrivateKey          string
@@ -15,7 +19,9 @@ type PeerConfigInfo struct {
 	PublicKey           string // Server's public key
 	PresharedKey        string
 	Endpoint            string   // Server's endpoint
-	AllowedIPs          []string // Typically "0.0.0.0/0, ::/0" for full tunnel
+	AllowedIPs          []string // Client routes, resolved from the peer's route profile
+	PostUp              []string // Hook commands, used for site router forwarding hints
+	PostDown            []string
 }
 
 // GenerateConfigString creates a WireGuard .conf content.
@@ -33,6 +39,12 @@ func GenerateConfigString(info PeerConfigInfo) string {
 	if info.MTU > 0 {
 		sb.WriteString(fmt.Sprintf("MTU = %d\n", info.MTU))
 	}
+	for _, cmd := range info.PostUp {
+		sb.WriteString(fmt.Sprintf("PostUp = %s\n", cmd))
+	}
+	for _, cmd := range info.PostDown {
+		sb.WriteString(fmt.Sprintf("PostDown = %s\n", cmd))
+	}
 	sb.WriteString("\n")
 
 	sb.WriteString("[Peer]\n")
@@ -50,3 +62,104 @@ func GenerateConfigString(info PeerConfigInfo) string {
 
 	return sb.String()
 }
+
+// Forwarding hints added to site router configs that enable SiteForwarding.
+var (
+	siteForwardingPostUp = []string{
+		"sysctl -w net.ipv4.ip_forward=1",
+		"iptables -A FORWARD -i %i -j ACCEPT; iptables -A FORWARD -o %i -j ACCEPT",
+	}
+	siteForwardingPostDown = []string{
+		"iptables -D FORWARD -i %i -j ACCEPT; iptables -D FORWARD -o %i -j ACCEPT",
+	}
+)
+
+// ConfigChange describes a single setting that differs between two client configs.
+type ConfigChange struct {
+	Section string `json:"section"`
+	Key     string `json:"key"`
+	Old     string `json:"old,omitempty"`
+	New     string `json:"new,omitempty"`
+}
+
+// ConfigDiff describes how a peer's live config differs from the one it last downloaded.
+type ConfigDiff struct {
+	Downloaded bool           `json:"downloaded"`
+	UpToDate   bool           `json:"upToDate"`
+	Changes    []ConfigChange `json:"changes"`
+}
+
+// secretConfigKeys are keys whose values must never be echoed in a diff.
+var secretConfigKeys = map[string]bool{
+	"PrivateKey":   true,
+	"PresharedKey": true,
+}
+
+// DiffConfigs compares two rendered .conf files key by key. Values of secret
+// keys are redacted; only the fact that they changed is reported.
+func DiffConfigs(oldConfig, newConfig string) []ConfigChange {
+	oldValues, oldOrder := parseConfigValues(oldConfig)
+	newValues, newOrder := parseConfigValues(newConfig)
+
+	changes := []ConfigChange{}
+	seen := make(map[string]bool)
+	for _, k := range append(oldOrder, newOrder...) {
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+
+		oldVal, inOld := oldValues[k]
+		newVal, inNew := newValues[k]
+		if inOld && inNew && oldVal == newVal {
+			continue
+		}
+
+		section, key, _ := strings.Cut(k, ".")
+		change := ConfigChange{Section: section, Key: key, Old: oldVal, New: newVal}
+		if secretConfigKeys[key] {
+			change.Old, change.New = redact(inOld), redact(inNew)
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// parseConfigValues flattens a .conf file into "Section.Key" => value pairs,
+// preserving the order in which keys first appear. Repeated keys such as
+// PostUp are joined with "; ".
+func parseConfigValues(config string) (map[string]string, []string) {
+	values := make(map[string]string)
+	var order []string
+	section := ""
+	for _, line := range strings.Split(config, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.Trim(line, "[]")
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		k := section + "." + strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if prev, exists := values[k]; exists {
+			values[k] = prev + "; " + value
+			continue
+		}
+		order = append(order, k)
+		values[k] = value
+	}
+	return values, order
+}
+
+func redact(present bool) string {
+	if !present {
+		return ""
+	}
+	return "(redacted)"
+}
@@ -0,0 +1,64 @@
+package wireguard
+
+import "testing"
+
+func TestDiffConfigs(t *testing.T) {
+	base := PeerConfigInfo{
+		PrivateKey: "OLD_PRIVATE",
+		Address:    []string{"10.0.0.2/32"},
+		DNS:        []string{"1.1.1.1"},
+		MTU:        1420,
+		PublicKey:  "SERVER_PUB",
+		Endpoint:   "vpn.example.com:51820",
+		AllowedIPs: []string{"0.0.0.0/0", "::/0"},
+	}
+
+	t.Run("Identical", func(t *testing.T) {
+		cfg := GenerateConfigString(base)
+		if changes := DiffConfigs(cfg, cfg); len(changes) != 0 {
+			t.Errorf("expected no changes, got %v", changes)
+		}
+	})
+
+	t.Run("ChangedAddedRemoved", func(t *testing.T) {
+		updated := base
+		updated.MTU = 1380
+		updated.DNS = nil
+		updated.PersistentKeepalive = 25
+
+		changes := DiffConfigs(GenerateConfigString(base), GenerateConfigString(updated))
+		want := map[string]ConfigChange{
+			"Interface.MTU":            {Section: "Interface", Key: "MTU", Old: "1420", New: "1380"},
+			"Interface.DNS":            {Section: "Interface", Key: "DNS", Old: "1.1.1.1"},
+			"Peer.PersistentKeepalive": {Section: "Peer", Key: "PersistentKeepalive", New: "25"},
+		}
+		if len(changes) != len(want) {
+			t.Fatalf("expected %d changes, got %d: %v", len(want), len(changes), changes)
+		}
+		for _, c := range changes {
+			if w, ok := want[c.Section+"."+c.Key]; !ok || w != c {
+				t.Errorf("unexpected change %+v", c)
+			}
+		}
+	})
+
+	t.Run("SecretsRedacted", func(t *testing.T) {
+		updated := base
+		updated.PrivateKey = "NEW_PRIVATE"
+
+		changes := DiffConfigs(GenerateConfigString(base), GenerateConfigString(updated))
+		if len(changes) != 1 {
+			t.Fatalf("expected 1 change, got %v", changes)
+		}
+		if changes[0].Old != "(redacted)" || changes[0].New != "(redacted)" {
+			t.Errorf("expected redacted values, got %+v", changes[0])
+		}
+	})
+
+	t.Run("NeverDownloaded", func(t *testing.T) {
+		changes := DiffConfigs("", GenerateConfigString(base))
+		if len(changes) == 0 {
+			t.Error("expected every key to be reported as added")
+		}
+	})
+}
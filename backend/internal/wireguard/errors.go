@@ -0,0 +1,106 @@
+package wireguard
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// Sentinel errors returned (wrapped) by Service implementations. Callers
+// should test for them with errors.Is.
+var (
+	// ErrPeerNotFound means no peer with the given ID exists.
+	ErrPeerNotFound = errors.New("peer not found")
+	// ErrInvalidKey means a public, private or preshared key failed to parse.
+	ErrInvalidKey = errors.New("invalid key")
+	// ErrConflict means the request clashes with existing state, such as an
+	// AllowedIP owned by another peer. See *ConflictError for details.
+	ErrConflict = errors.New("conflict")
+	// ErrDeviceUnavailable means the WireGuard device could not be reached,
+	// e.g. because it does not exist. Other device failures are untyped.
+	ErrDeviceUnavailable = errors.New("device unavailable")
+	// ErrPreconditionFailed means the resource changed since the revision
+	// the caller based its change on.
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// ErrShareNotFound means a share token is unknown, forged, expired or
+	// already used. The reason is not revealed to the caller.
+	ErrShareNotFound = errors.New("share link not found")
+	// ErrInviteNotFound means an invite is unknown, or its token can no
+	// longer be redeemed because it was used up, expired or revoked.
+	ErrInviteNotFound = errors.New("invite not found")
+	// ErrInvalidQuery means a peer query has an unknown status, sort key or
+	// order, or a cursor that is malformed or belongs to another sort.
+	ErrInvalidQuery = errors.New("invalid query")
+	// ErrGroupNotFound means no group with the given name exists.
+	ErrGroupNotFound = errors.New("group not found")
+	// ErrInvalidArgument means the caller asked for something the service
+	// refuses outright, such as an unknown route profile, a malformed CIDR
+	// or a duration out of range.
+	ErrInvalidArgument = errors.New("invalid argument")
+	// ErrConfigUnavailable means the peer exists but no config can be
+	// rendered for it, e.g. because its route profile no longer exists.
+	ErrConfigUnavailable = errors.New("config unavailable")
+)
+
+// peerNotFound wraps ErrPeerNotFound with the requested ID.
+func peerNotFound(id string) error {
+	return fmt.Errorf("%w: %s", ErrPeerNotFound, id)
+}
+
+// groupNotFound wraps ErrGroupNotFound with the requested name.
+func groupNotFound(name string) error {
+	return fmt.Errorf("%w: %s", ErrGroupNotFound, name)
+}
+
+// invalidArgument wraps ErrInvalidArgument with a message for the caller.
+func invalidArgument(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidArgument, fmt.Sprintf(format, args...))
+}
+
+// configUnavailable wraps ErrConfigUnavailable with why the peer's config
+// could not be rendered.
+func configUnavailable(id string, err error) error {
+	return fmt.Errorf("%w: peer %s: %v", ErrConfigUnavailable, id, err)
+}
+
+// invalidKey wraps ErrInvalidKey with the parse failure.
+func invalidKey(kind string, err error) error {
+	return fmt.Errorf("%w: %s: %w", ErrInvalidKey, kind, err)
+}
+
+// deviceError wraps a failed device call. Only failures to reach the device
+// are marked ErrDeviceUnavailable, as retrying may help with those; a
+// rejected configuration is returned untyped, keeping the underlying cause
+// either way.
+func deviceError(action string, err error) error {
+	if deviceUnreachable(err) {
+		return fmt.Errorf("%w: %s: %w", ErrDeviceUnavailable, action, err)
+	}
+	return fmt.Errorf("%s: %w", action, err)
+}
+
+// deviceUnreachable reports whether err means the device or its control
+// socket could not be reached: it does not exist, the client was closed, or
+// the connection failed.
+func deviceUnreachable(err error) bool {
+	for _, target := range []error{
+		os.ErrNotExist,
+		net.ErrClosed,
+		os.ErrDeadlineExceeded,
+		syscall.ENODEV,
+		syscall.ECONNREFUSED,
+		syscall.ECONNRESET,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// revisionMismatch wraps ErrPreconditionFailed for a stale revision.
+func revisionMismatch(resource string, expected, current int64) error {
+	return fmt.Errorf("%w: %s is at revision %d, not %d", ErrPreconditionFailed, resource, current, expected)
+}
@@ -0,0 +1,69 @@
+package wireguard
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Event types emitted by the service.
+const (
+	EventDriftDetected = "drift.detected"
+	EventReconciled    = "drift.reconciled"
+	EventSettings      = "settings.updated"
+	EventKeyRotated    = "peer.key_rotated"
+	EventShareCreated  = "share.created"
+	EventShareOpened   = "share.opened"
+	EventShareRejected = "share.rejected"
+
+	EventInviteCreated  = "invite.created"
+	EventInviteRedeemed = "invite.redeemed"
+	EventInviteRevoked  = "invite.revoked"
+
+	EventGroupCreated = "group.created"
+	EventGroupUpdated = "group.updated"
+	EventGroupDeleted = "group.deleted"
+	EventPeerExpired  = "peer.expired"
+	EventBulkApplied  = "peer.bulk_applied"
+
+	EventSecretsExported = "peer.secrets_exported"
+)
+
+// maxEvents bounds the in-memory event log. It is a live feed only; share
+// events are also kept in the stored share audit log.
+const maxEvents = 100
+
+// Event is a notable occurrence recorded by the service, such as drift
+// between storage and the device.
+type Event struct {
+	Type      string `json:"type"`
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+	Data      any    `json:"data,omitempty"`
+}
+
+// emit records an event in the bounded event log and logs it.
+func (s *realService) emit(eventType string, message string, data any) {
+	slog.Info("Event emitted", "type", eventType, "message", message)
+
+	s.eventsMu.Lock()
+	defer s.eventsMu.Unlock()
+	if len(s.events) >= maxEvents {
+		s.events = s.events[1:]
+	}
+	s.events = append(s.events, Event{
+		Type:      eventType,
+		Timestamp: time.Now().Unix(),
+		Message:   message,
+		Data:      data,
+	})
+}
+
+// GetEvents returns the recorded events, oldest first.
+func (s *realService) GetEvents() ([]Event, error) {
+	s.eventsMu.RLock()
+	defer s.eventsMu.RUnlock()
+
+	cp := make([]Event, len(s.events))
+	copy(cp, s.events)
+	return cp, nil
+}
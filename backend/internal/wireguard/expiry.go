@@ -0,0 +1,105 @@
+package wireguard
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// expiryCheckInterval is how often expired peers are taken off the device.
+const expiryCheckInterval = 10 * time.Second
+
+// peerDeviceConfigs returns the configs that put a stored peer on the device,
+// including its pending key during an overlap window.
+func peerDeviceConfigs(meta PeerMetadata) ([]wgtypes.PeerConfig, error) {
+	pc, err := devicePeerConfig(meta.PublicKey, meta)
+	if err != nil {
+		return nil, err
+	}
+	configs := []wgtypes.PeerConfig{pc}
+	if meta.PendingKey != nil {
+		pending, err := pendingPeerConfig(meta)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, pending)
+	}
+	return configs, nil
+}
+
+// peerRemovalConfigs returns the configs that take a stored peer and its
+// pending key off the device. Keys that fail to parse are skipped, as they
+// cannot be on the device.
+func peerRemovalConfigs(meta PeerMetadata) []wgtypes.PeerConfig {
+	keys := []string{meta.PublicKey}
+	if meta.PendingKey != nil {
+		keys = append(keys, meta.PendingKey.PublicKey)
+	}
+	var configs []wgtypes.PeerConfig
+	for _, k := range keys {
+		if key, err := wgtypes.ParseKey(k); err == nil {
+			configs = append(configs, wgtypes.PeerConfig{PublicKey: key, Remove: true})
+		}
+	}
+	return configs
+}
+
+// watchExpiry takes peers off the device as they expire.
+func (s *realService) watchExpiry() {
+	ticker := time.NewTicker(expiryCheckInterval)
+	defer ticker.Stop()
+
+	s.beat(collectorExpiry)
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case now := <-ticker.C:
+			s.beat(collectorExpiry)
+			s.expirePeers(now)
+		}
+	}
+}
+
+// expirePeers removes every peer that has expired by now from the device in a
+// single call, and records an event for each.
+func (s *realService) expirePeers(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, err := s.client.Device(s.interfaceName)
+	if err != nil {
+		slog.Error("Failed to check for expired peers", "error", err)
+		return
+	}
+	onDevice := make(map[string]bool, len(device.Peers))
+	for _, p := range device.Peers {
+		onDevice[p.PublicKey.String()] = true
+	}
+
+	var expired []PeerMetadata
+	var configs []wgtypes.PeerConfig
+	for _, meta := range s.storage.ListMetadata() {
+		if meta.Unclaimed() || !meta.Expired(now) {
+			continue
+		}
+		if !onDevice[meta.PublicKey] && (meta.PendingKey == nil || !onDevice[meta.PendingKey.PublicKey]) {
+			continue
+		}
+		expired = append(expired, meta)
+		configs = append(configs, peerRemovalConfigs(meta)...)
+	}
+	if len(expired) == 0 {
+		return
+	}
+
+	if err := s.client.ConfigureDevice(s.interfaceName, wgtypes.Config{Peers: configs}); err != nil {
+		slog.Error("Failed to remove expired peers", "error", deviceError("failed to configure device", err))
+		return
+	}
+	for _, meta := range expired {
+		s.emit(EventPeerExpired, fmt.Sprintf("Peer %s expired and was removed from the device", meta.Name), AffectedPeer{ID: meta.ID, Name: meta.Name})
+	}
+}
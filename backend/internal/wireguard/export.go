@@ -0,0 +1,43 @@
+package wireguard
+
+import "fmt"
+
+// PeerExport is a peer as listed in an export. The keys are only filled in
+// when secrets are requested, and only for peers whose keys are stored.
+// Private keys are never exported in zero-knowledge mode.
+type PeerExport struct {
+	Peer
+	PrivateKey   string `json:"privateKey,omitempty"`
+	PresharedKey string `json:"presharedKey,omitempty"`
+}
+
+// ExportPeers returns every peer that q selects, ignoring its cursor and
+// limit. With secrets, stored private and preshared keys are included and
+// the export is recorded as an event.
+func (s *realService) ExportPeers(q PeerQuery, secrets bool) ([]PeerExport, error) {
+	q.Cursor, q.Limit = "", 0
+	page, err := s.QueryPeers(q)
+	if err != nil {
+		return nil, err
+	}
+
+	zeroKnowledge := s.storage.GetSettings().ZeroKnowledge
+	exported := make([]PeerExport, len(page.Peers))
+	for i, p := range page.Peers {
+		exported[i] = PeerExport{Peer: p}
+		if !secrets {
+			continue
+		}
+		if meta, ok := s.storage.GetMetadata(p.ID); ok {
+			exported[i].PrivateKey, exported[i].PresharedKey = meta.PrivateKey, meta.PresharedKey
+		}
+		if zeroKnowledge {
+			exported[i].PrivateKey = ""
+		}
+	}
+
+	if secrets {
+		s.emit(EventSecretsExported, fmt.Sprintf("Exported the keys of %d peers", len(exported)), nil)
+	}
+	return exported, nil
+}
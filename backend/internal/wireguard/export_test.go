@@ -0,0 +1,54 @@
+package wireguard
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestExportPeers(t *testing.T) {
+	client := newFakeDevice(t)
+	srv := newFakeService(t, client, filepath.Join(t.TempDir(), "peers.json"))
+	if _, err := srv.CreateGroup(Group{Name: "staff"}); err != nil {
+		t.Fatalf("CreateGroup failed: %v", err)
+	}
+	added, err := srv.AddPeer(AddPeerOptions{Name: "alice", AllowedIPs: []string{"10.0.0.2/32"}, Group: "staff", PreSharedKey: true})
+	if err != nil {
+		t.Fatalf("AddPeer failed: %v", err)
+	}
+	if _, err := srv.AddPeer(AddPeerOptions{Name: "bob", AllowedIPs: []string{"10.0.0.3/32"}}); err != nil {
+		t.Fatalf("AddPeer failed: %v", err)
+	}
+
+	t.Run("WithoutSecrets", func(t *testing.T) {
+		exported, err := srv.ExportPeers(PeerQuery{Limit: 1}, false)
+		if err != nil {
+			t.Fatalf("ExportPeers failed: %v", err)
+		}
+		if len(exported) != 2 {
+			t.Fatalf("expected the limit to be ignored, got %d peers", len(exported))
+		}
+		for _, p := range exported {
+			if p.PrivateKey != "" || p.PresharedKey != "" {
+				t.Errorf("expected no keys for %s, got %+v", p.Name, p)
+			}
+		}
+	})
+
+	t.Run("WithSecrets", func(t *testing.T) {
+		exported, err := srv.ExportPeers(PeerQuery{Group: "staff"}, true)
+		if err != nil {
+			t.Fatalf("ExportPeers failed: %v", err)
+		}
+		if len(exported) != 1 || exported[0].Name != "alice" {
+			t.Fatalf("expected only alice, got %+v", exported)
+		}
+		if exported[0].PrivateKey != added.PrivateKey || exported[0].PresharedKey != added.PresharedKey {
+			t.Errorf("expected alice's stored keys, got %+v", exported[0])
+		}
+		events, _ := srv.GetEvents()
+		if !slices.ContainsFunc(events, func(e Event) bool { return e.Type == EventSecretsExported }) {
+			t.Error("expected the key export to be recorded")
+		}
+	})
+}
@@ -0,0 +1,207 @@
+// Package fakedevice provides an in-memory stand-in for a wgctrl client.
+//
+// It models the parts of WireGuard's configuration semantics that the
+// service relies on: ReplacePeers, Remove, UpdateOnly, ReplaceAllowedIPs and
+// AllowedIP "stealing", where assigning a prefix to one peer silently removes
+// the identical prefix from whichever peer held it before.
+package fakedevice
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// ErrClosed is returned by every call made after Close. It matches
+// net.ErrClosed, like the userspace client.
+var ErrClosed = fmt.Errorf("fakedevice: client closed: %w", net.ErrClosed)
+
+// Client is an in-memory WireGuard control client holding any number of devices.
+type Client struct {
+	mu          sync.Mutex
+	devices     map[string]*wgtypes.Device
+	failNext    error
+	closed      bool
+	configCalls int
+}
+
+// New returns a client with no devices.
+func New() *Client {
+	return &Client{devices: make(map[string]*wgtypes.Device)}
+}
+
+// AddDevice creates a device with the given private key and listen port.
+func (c *Client) AddDevice(name string, privateKey wgtypes.Key, listenPort int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.devices[name] = &wgtypes.Device{
+		Name:       name,
+		Type:       wgtypes.Unknown,
+		PrivateKey: privateKey,
+		PublicKey:  privateKey.PublicKey(),
+		ListenPort: listenPort,
+	}
+}
+
+// FailNextConfigure makes the next ConfigureDevice call return err without
+// changing anything.
+func (c *Client) FailNextConfigure(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failNext = err
+}
+
+// ConfigureCalls returns how many times ConfigureDevice has succeeded.
+func (c *Client) ConfigureCalls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.configCalls
+}
+
+// SetPeerStats sets the runtime statistics of a peer, as if traffic had flowed.
+func (c *Client) SetPeerStats(name string, publicKey wgtypes.Key, lastHandshake time.Time, rx, tx int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d, ok := c.devices[name]
+	if !ok {
+		return os.ErrNotExist
+	}
+	i := peerIndex(d, publicKey)
+	if i < 0 {
+		return fmt.Errorf("fakedevice: peer %s not found", publicKey)
+	}
+	d.Peers[i].LastHandshakeTime = lastHandshake
+	d.Peers[i].ReceiveBytes = rx
+	d.Peers[i].TransmitBytes = tx
+	return nil
+}
+
+// Device returns a deep copy of the named device, or an error matching
+// os.ErrNotExist like wgctrl does.
+func (c *Client) Device(name string) (*wgtypes.Device, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, ErrClosed
+	}
+	d, ok := c.devices[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	cp := *d
+	cp.Peers = make([]wgtypes.Peer, len(d.Peers))
+	for i, p := range d.Peers {
+		p.AllowedIPs = slices.Clone(p.AllowedIPs)
+		cp.Peers[i] = p
+	}
+	return &cp, nil
+}
+
+// ConfigureDevice applies cfg to the named device with WireGuard semantics.
+func (c *Client) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	d, ok := c.devices[name]
+	if !ok {
+		return os.ErrNotExist
+	}
+	if err := c.failNext; err != nil {
+		c.failNext = nil
+		return err
+	}
+	c.configCalls++
+
+	if cfg.PrivateKey != nil {
+		d.PrivateKey = *cfg.PrivateKey
+		d.PublicKey = cfg.PrivateKey.PublicKey()
+	}
+	if cfg.ListenPort != nil {
+		d.ListenPort = *cfg.ListenPort
+	}
+	if cfg.FirewallMark != nil {
+		d.FirewallMark = *cfg.FirewallMark
+	}
+	if cfg.ReplacePeers {
+		d.Peers = nil
+	}
+
+	for _, pc := range cfg.Peers {
+		i := peerIndex(d, pc.PublicKey)
+		if pc.Remove {
+			if i >= 0 {
+				d.Peers = slices.Delete(d.Peers, i, i+1)
+			}
+			continue
+		}
+		if i < 0 {
+			if pc.UpdateOnly {
+				continue
+			}
+			d.Peers = append(d.Peers, wgtypes.Peer{PublicKey: pc.PublicKey, ProtocolVersion: 1})
+			i = len(d.Peers) - 1
+		}
+
+		p := &d.Peers[i]
+		if pc.PresharedKey != nil {
+			p.PresharedKey = *pc.PresharedKey
+		}
+		if pc.Endpoint != nil {
+			ep := *pc.Endpoint
+			p.Endpoint = &ep
+		}
+		if pc.PersistentKeepaliveInterval != nil {
+			p.PersistentKeepaliveInterval = *pc.PersistentKeepaliveInterval
+		}
+		if pc.ReplaceAllowedIPs {
+			p.AllowedIPs = nil
+		}
+		for _, ip := range pc.AllowedIPs {
+			ip = masked(ip)
+			stealAllowedIP(d, ip)
+			d.Peers[i].AllowedIPs = append(d.Peers[i].AllowedIPs, ip)
+		}
+	}
+	return nil
+}
+
+// Close marks the client as closed.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func peerIndex(d *wgtypes.Device, key wgtypes.Key) int {
+	return slices.IndexFunc(d.Peers, func(p wgtypes.Peer) bool { return p.PublicKey == key })
+}
+
+// stealAllowedIP removes ip from every peer that currently holds it, as
+// WireGuard does when the same prefix is assigned to another peer.
+func stealAllowedIP(d *wgtypes.Device, ip net.IPNet) {
+	for i := range d.Peers {
+		d.Peers[i].AllowedIPs = slices.DeleteFunc(d.Peers[i].AllowedIPs, func(n net.IPNet) bool {
+			return n.String() == ip.String()
+		})
+	}
+}
+
+// masked clears the host bits of ip, as the kernel does when storing AllowedIPs.
+func masked(ip net.IPNet) net.IPNet {
+	if v4 := ip.IP.To4(); v4 != nil && len(ip.Mask) == net.IPv4len {
+		return net.IPNet{IP: v4.Mask(ip.Mask), Mask: ip.Mask}
+	}
+	return net.IPNet{IP: ip.IP.Mask(ip.Mask), Mask: ip.Mask}
+}
@@ -0,0 +1,169 @@
+package fakedevice
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func mustKey(t *testing.T) wgtypes.Key {
+	t.Helper()
+	k, err := wgtypes.GenerateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return k
+}
+
+func ipNet(t *testing.T, cidr string) net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("invalid CIDR %s: %v", cidr, err)
+	}
+	return *n
+}
+
+func allowedIPs(t *testing.T, c *Client, key wgtypes.Key) []string {
+	t.Helper()
+	d, err := c.Device("wg0")
+	if err != nil {
+		t.Fatalf("Device failed: %v", err)
+	}
+	for _, p := range d.Peers {
+		if p.PublicKey == key {
+			out := []string{}
+			for _, ip := range p.AllowedIPs {
+				out = append(out, ip.String())
+			}
+			return out
+		}
+	}
+	return nil
+}
+
+func TestConfigureDevice(t *testing.T) {
+	newClient := func(t *testing.T) *Client {
+		c := New()
+		c.AddDevice("wg0", mustKey(t), 51820)
+		return c
+	}
+
+	t.Run("MissingDevice", func(t *testing.T) {
+		c := New()
+		if _, err := c.Device("wg0"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected os.ErrNotExist, got %v", err)
+		}
+	})
+
+	t.Run("AllowedIPStealing", func(t *testing.T) {
+		c := newClient(t)
+		a, b := mustKey(t), mustKey(t)
+		_ = c.ConfigureDevice("wg0", wgtypes.Config{Peers: []wgtypes.PeerConfig{
+			{PublicKey: a, AllowedIPs: []net.IPNet{ipNet(t, "10.0.0.2/32"), ipNet(t, "10.0.0.0/24")}},
+		}})
+		_ = c.ConfigureDevice("wg0", wgtypes.Config{Peers: []wgtypes.PeerConfig{
+			{PublicKey: b, AllowedIPs: []net.IPNet{ipNet(t, "10.0.0.2/32"), ipNet(t, "10.0.0.0/25")}},
+		}})
+
+		if got := allowedIPs(t, c, a); len(got) != 1 || got[0] != "10.0.0.0/24" {
+			t.Errorf("expected only the identical prefix to be stolen from a, got %v", got)
+		}
+		if got := allowedIPs(t, c, b); len(got) != 2 {
+			t.Errorf("expected b to hold both prefixes, got %v", got)
+		}
+	})
+
+	t.Run("ReplaceAllowedIPs", func(t *testing.T) {
+		c := newClient(t)
+		a := mustKey(t)
+		_ = c.ConfigureDevice("wg0", wgtypes.Config{Peers: []wgtypes.PeerConfig{
+			{PublicKey: a, AllowedIPs: []net.IPNet{ipNet(t, "10.0.0.2/32")}},
+		}})
+		_ = c.ConfigureDevice("wg0", wgtypes.Config{Peers: []wgtypes.PeerConfig{
+			{PublicKey: a, AllowedIPs: []net.IPNet{ipNet(t, "10.0.0.3/32")}},
+		}})
+		if got := allowedIPs(t, c, a); len(got) != 2 {
+			t.Errorf("expected AllowedIPs to be appended, got %v", got)
+		}
+
+		_ = c.ConfigureDevice("wg0", wgtypes.Config{Peers: []wgtypes.PeerConfig{
+			{PublicKey: a, ReplaceAllowedIPs: true, AllowedIPs: []net.IPNet{ipNet(t, "10.0.0.4/32")}},
+		}})
+		if got := allowedIPs(t, c, a); len(got) != 1 || got[0] != "10.0.0.4/32" {
+			t.Errorf("expected AllowedIPs to be replaced, got %v", got)
+		}
+	})
+
+	t.Run("UpdateOnly", func(t *testing.T) {
+		c := newClient(t)
+		a := mustKey(t)
+		_ = c.ConfigureDevice("wg0", wgtypes.Config{Peers: []wgtypes.PeerConfig{
+			{PublicKey: a, UpdateOnly: true, AllowedIPs: []net.IPNet{ipNet(t, "10.0.0.2/32")}},
+		}})
+		d, _ := c.Device("wg0")
+		if len(d.Peers) != 0 {
+			t.Errorf("expected UpdateOnly not to create a peer, got %+v", d.Peers)
+		}
+	})
+
+	t.Run("RemoveAndReplacePeers", func(t *testing.T) {
+		c := newClient(t)
+		a, b, x := mustKey(t), mustKey(t), mustKey(t)
+		_ = c.ConfigureDevice("wg0", wgtypes.Config{Peers: []wgtypes.PeerConfig{{PublicKey: a}, {PublicKey: b}}})
+		_ = c.ConfigureDevice("wg0", wgtypes.Config{Peers: []wgtypes.PeerConfig{{PublicKey: a, Remove: true}}})
+		d, _ := c.Device("wg0")
+		if len(d.Peers) != 1 || d.Peers[0].PublicKey != b {
+			t.Fatalf("expected only b to remain, got %+v", d.Peers)
+		}
+
+		_ = c.ConfigureDevice("wg0", wgtypes.Config{ReplacePeers: true, Peers: []wgtypes.PeerConfig{{PublicKey: x}}})
+		d, _ = c.Device("wg0")
+		if len(d.Peers) != 1 || d.Peers[0].PublicKey != x {
+			t.Errorf("expected ReplacePeers to leave only x, got %+v", d.Peers)
+		}
+	})
+
+	t.Run("PeerSettings", func(t *testing.T) {
+		c := newClient(t)
+		a, psk := mustKey(t), mustKey(t)
+		keepalive := 25 * time.Second
+		endpoint := &net.UDPAddr{IP: net.ParseIP("198.51.100.7"), Port: 51820}
+		_ = c.ConfigureDevice("wg0", wgtypes.Config{Peers: []wgtypes.PeerConfig{
+			{PublicKey: a, PresharedKey: &psk, PersistentKeepaliveInterval: &keepalive, Endpoint: endpoint},
+		}})
+		d, _ := c.Device("wg0")
+		p := d.Peers[0]
+		if p.PresharedKey != psk || p.PersistentKeepaliveInterval != keepalive || p.Endpoint.String() != endpoint.String() {
+			t.Errorf("expected peer settings to be applied, got %+v", p)
+		}
+	})
+
+	t.Run("FailNextConfigure", func(t *testing.T) {
+		c := newClient(t)
+		boom := errors.New("boom")
+		c.FailNextConfigure(boom)
+		if err := c.ConfigureDevice("wg0", wgtypes.Config{Peers: []wgtypes.PeerConfig{{PublicKey: mustKey(t)}}}); !errors.Is(err, boom) {
+			t.Fatalf("expected injected error, got %v", err)
+		}
+		d, _ := c.Device("wg0")
+		if len(d.Peers) != 0 {
+			t.Errorf("expected failed configure to change nothing, got %+v", d.Peers)
+		}
+	})
+
+	t.Run("DeviceIsACopy", func(t *testing.T) {
+		c := newClient(t)
+		a := mustKey(t)
+		_ = c.ConfigureDevice("wg0", wgtypes.Config{Peers: []wgtypes.PeerConfig{{PublicKey: a, AllowedIPs: []net.IPNet{ipNet(t, "10.0.0.2/32")}}}})
+		d, _ := c.Device("wg0")
+		d.Peers[0].AllowedIPs[0] = ipNet(t, "10.9.9.9/32")
+		if got := allowedIPs(t, c, a); got[0] != "10.0.0.2/32" {
+			t.Errorf("expected device state to be isolated from callers, got %v", got)
+		}
+	})
+}
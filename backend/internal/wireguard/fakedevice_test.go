@@ -0,0 +1,413 @@
+package wireguard
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"wg-manager/backend/internal/wireguard/fakedevice"
+)
+
+// newFakeService starts a service against an in-memory device with the given
+// storage file, so restarts can be simulated by reusing the path.
+func newFakeService(t *testing.T, client *fakedevice.Client, storagePath string) Service {
+	t.Helper()
+	srv, err := NewServiceWithClient(client, "wg0", storagePath, "vpn.example.com:51820", "SERVER_PUB", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("NewServiceWithClient failed: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+func newFakeDevice(t *testing.T) *fakedevice.Client {
+	t.Helper()
+	client := fakedevice.New()
+	client.AddDevice("wg0", mustKey(t), 51820)
+	return client
+}
+
+func devicePeer(t *testing.T, client *fakedevice.Client, publicKey string) *wgtypes.Peer {
+	t.Helper()
+	d, err := client.Device("wg0")
+	if err != nil {
+		t.Fatalf("Device failed: %v", err)
+	}
+	for _, p := range d.Peers {
+		if p.PublicKey.String() == publicKey {
+			return &p
+		}
+	}
+	return nil
+}
+
+func TestFakeDeviceService(t *testing.T) {
+	t.Run("MissingDevice", func(t *testing.T) {
+		_, err := NewServiceWithClient(fakedevice.New(), "wg0", filepath.Join(t.TempDir(), "peers.json"), "", "", "10.0.0.0/24")
+		if err == nil {
+			t.Error("expected an error for a missing device")
+		}
+	})
+
+	t.Run("AddAndListPeer", func(t *testing.T) {
+		client := newFakeDevice(t)
+		srv := newFakeService(t, client, filepath.Join(t.TempDir(), "peers.json"))
+
+		resp, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}, PreSharedKey: true})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		if resp.PrivateKey == "" || resp.Config == "" {
+			t.Errorf("expected generated keys and config, got %+v", resp)
+		}
+
+		peers, err := srv.ListPeers()
+		if err != nil {
+			t.Fatalf("ListPeers failed: %v", err)
+		}
+		if len(peers) != 1 || peers[0].Name != "laptop" || peers[0].PublicKey != resp.PublicKey {
+			t.Errorf("expected the added peer to be listed, got %+v", peers)
+		}
+		if p := devicePeer(t, client, resp.PublicKey); p == nil || p.PresharedKey.String() != resp.PresharedKey {
+			t.Errorf("expected peer with preshared key on device, got %+v", p)
+		}
+	})
+
+	t.Run("DeviceFailureLeavesStorageUntouched", func(t *testing.T) {
+		client := newFakeDevice(t)
+		srv := newFakeService(t, client, filepath.Join(t.TempDir(), "peers.json"))
+
+		client.FailNextConfigure(errors.New("netlink: operation not permitted"))
+		if _, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}}); err == nil {
+			t.Fatal("expected AddPeer to fail")
+		}
+		peers, _ := srv.ListPeers()
+		if len(peers) != 0 {
+			t.Errorf("expected no peers after a failed add, got %+v", peers)
+		}
+		report, _ := srv.CheckDrift()
+		if !report.InSync {
+			t.Errorf("expected storage and device to stay in sync, got %+v", report)
+		}
+	})
+
+	t.Run("FailedUpdateKeepsDeviceAndStorageInStep", func(t *testing.T) {
+		client := newFakeDevice(t)
+		dir := filepath.Join(t.TempDir(), "data")
+		if err := os.Mkdir(dir, 0o700); err != nil {
+			t.Fatal(err)
+		}
+		srv := newFakeService(t, client, filepath.Join(dir, "peers.json"))
+		peer, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		ips := []string{"10.0.0.3/32"}
+		assertUnchanged := func(t *testing.T) {
+			t.Helper()
+			got, _ := srv.GetPeer(peer.ID)
+			if len(got.AllowedIPs) != 1 || got.AllowedIPs[0] != "10.0.0.2/32" {
+				t.Errorf("expected the stored AllowedIPs to be kept, got %v", got.AllowedIPs)
+			}
+			if p := devicePeer(t, client, peer.PublicKey); p == nil || len(p.AllowedIPs) != 1 || p.AllowedIPs[0].String() != "10.0.0.2/32" {
+				t.Errorf("expected the device AllowedIPs to be kept, got %+v", p)
+			}
+		}
+
+		t.Run("Device", func(t *testing.T) {
+			client.FailNextConfigure(fmt.Errorf("netlink: %w", syscall.ENODEV))
+			if _, err := srv.UpdatePeer(peer.ID, PeerUpdate{AllowedIPs: &ips}, 0); !errors.Is(err, ErrDeviceUnavailable) {
+				t.Fatalf("expected ErrDeviceUnavailable, got %v", err)
+			}
+			assertUnchanged(t)
+		})
+
+		t.Run("Storage", func(t *testing.T) {
+			if err := os.RemoveAll(dir); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := srv.UpdatePeer(peer.ID, PeerUpdate{AllowedIPs: &ips}, 0); err == nil {
+				t.Fatal("expected UpdatePeer to fail when storage cannot be written")
+			}
+			assertUnchanged(t)
+		})
+	})
+
+	t.Run("DuplicatePublicKey", func(t *testing.T) {
+		client := newFakeDevice(t)
+		srv := newFakeService(t, client, filepath.Join(t.TempDir(), "peers.json"))
+
+		key := mustKey(t).PublicKey().String()
+		first, err := srv.AddPeer(AddPeerOptions{Name: "laptop", PublicKey: key, AllowedIPs: []string{"10.0.0.2/32"}})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		if _, err := srv.AddPeer(AddPeerOptions{Name: "phone", PublicKey: key, AllowedIPs: []string{"10.0.0.3/32"}}); !errors.Is(err, ErrConflict) {
+			t.Fatalf("expected ErrConflict for a key in use, got %v", err)
+		}
+		peers, _ := srv.ListPeers()
+		if len(peers) != 1 || peers[0].ID != first.ID || peers[0].Name != "laptop" {
+			t.Errorf("expected only the first peer, got %+v", peers)
+		}
+		if p := devicePeer(t, client, key); p == nil || len(p.AllowedIPs) != 1 || p.AllowedIPs[0].String() != "10.0.0.2/32" {
+			t.Errorf("expected the first peer's address on the device, got %+v", p)
+		}
+	})
+
+	t.Run("TypedErrors", func(t *testing.T) {
+		client := newFakeDevice(t)
+		srv := newFakeService(t, client, filepath.Join(t.TempDir(), "peers.json"))
+
+		unknown := mustKey(t).PublicKey().String()
+		if err := srv.RemovePeer(unknown, 0); !errors.Is(err, ErrPeerNotFound) {
+			t.Errorf("expected ErrPeerNotFound from RemovePeer, got %v", err)
+		}
+		if _, err := srv.RegeneratePeer(unknown, RegenerateOptions{}, 0); !errors.Is(err, ErrPeerNotFound) {
+			t.Errorf("expected ErrPeerNotFound from RegeneratePeer, got %v", err)
+		}
+		if _, err := srv.UpdatePeer("not-an-id", PeerUpdate{}, 0); !errors.Is(err, ErrPeerNotFound) {
+			t.Errorf("expected ErrPeerNotFound from UpdatePeer, got %v", err)
+		}
+		if _, err := srv.AddPeer(AddPeerOptions{Name: "byo", PublicKey: "not-a-key", AllowedIPs: []string{"10.0.0.2/32"}}); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("expected ErrInvalidKey from AddPeer, got %v", err)
+		}
+
+		client.FailNextConfigure(fmt.Errorf("netlink: %w", syscall.ENODEV))
+		if _, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}}); !errors.Is(err, ErrDeviceUnavailable) {
+			t.Errorf("expected ErrDeviceUnavailable from AddPeer, got %v", err)
+		}
+		// A rejected configuration will not succeed on retry, so it is untyped.
+		client.FailNextConfigure(fmt.Errorf("netlink: %w", syscall.EINVAL))
+		if _, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}}); err == nil || errors.Is(err, ErrDeviceUnavailable) {
+			t.Errorf("expected an untyped error from AddPeer, got %v", err)
+		}
+		client.Close()
+		if _, err := srv.ListPeers(); !errors.Is(err, ErrDeviceUnavailable) {
+			t.Errorf("expected ErrDeviceUnavailable from ListPeers, got %v", err)
+		}
+	})
+
+	t.Run("ConflictWithDeviceOnlyPeer", func(t *testing.T) {
+		client := newFakeDevice(t)
+		srv := newFakeService(t, client, filepath.Join(t.TempDir(), "peers.json"))
+
+		stray := mustKey(t)
+		_ = client.ConfigureDevice("wg0", wgtypes.Config{Peers: []wgtypes.PeerConfig{
+			{PublicKey: stray, AllowedIPs: []net.IPNet{mustIPNet(t, "10.0.0.5/32")}},
+		}})
+
+		_, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.5/32"}})
+		var conflict *ConflictError
+		if !errors.As(err, &conflict) || !errors.Is(err, ErrConflict) {
+			t.Fatalf("expected *ConflictError, got %v", err)
+		}
+		if conflict.PeerID != stray.String() {
+			t.Errorf("expected conflict with %s, got %s", stray, conflict.PeerID)
+		}
+	})
+
+	t.Run("ForceStealKeepsStorageInSync", func(t *testing.T) {
+		client := newFakeDevice(t)
+		srv := newFakeService(t, client, filepath.Join(t.TempDir(), "peers.json"))
+
+		a, err := srv.AddPeer(AddPeerOptions{Name: "a", AllowedIPs: []string{"10.0.0.2/32", "10.0.0.3/32"}})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		if _, err := srv.AddPeer(AddPeerOptions{Name: "b", AllowedIPs: []string{"10.0.0.3/32"}, Force: true}); err != nil {
+			t.Fatalf("forced AddPeer failed: %v", err)
+		}
+
+		meta, _ := srv.GetPeerMetadata(a.PublicKey)
+		if len(meta.AllowedIPs) != 1 || meta.AllowedIPs[0] != "10.0.0.2/32" {
+			t.Errorf("expected stolen prefix to be released from a, got %v", meta.AllowedIPs)
+		}
+		report, _ := srv.CheckDrift()
+		if !report.InSync {
+			t.Errorf("expected no drift after a forced add, got %+v", report)
+		}
+	})
+
+	t.Run("UpdateAndRegenerate", func(t *testing.T) {
+		client := newFakeDevice(t)
+		srv := newFakeService(t, client, filepath.Join(t.TempDir(), "peers.json"))
+
+		resp, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		ips := []string{"10.0.0.9/32"}
+		if _, err := srv.UpdatePeer(resp.PublicKey, PeerUpdate{AllowedIPs: &ips}, 0); err != nil {
+			t.Fatalf("UpdatePeer failed: %v", err)
+		}
+		if p := devicePeer(t, client, resp.PublicKey); p == nil || len(p.AllowedIPs) != 1 || p.AllowedIPs[0].String() != "10.0.0.9/32" {
+			t.Errorf("expected device AllowedIPs to be replaced, got %+v", p)
+		}
+
+		regen, err := srv.RegeneratePeer(resp.PublicKey, RegenerateOptions{}, 0)
+		if err != nil {
+			t.Fatalf("RegeneratePeer failed: %v", err)
+		}
+		if devicePeer(t, client, resp.PublicKey) != nil {
+			t.Error("expected old key to be removed from the device")
+		}
+		if p := devicePeer(t, client, regen.PublicKey); p == nil || p.AllowedIPs[0].String() != "10.0.0.9/32" {
+			t.Errorf("expected new key to carry the AllowedIPs, got %+v", p)
+		}
+		if _, ok := srv.GetPeerMetadata(resp.PublicKey); ok {
+			t.Error("expected old metadata to be removed")
+		}
+	})
+
+	t.Run("SyncRestoresDeviceAfterRestart", func(t *testing.T) {
+		storagePath := filepath.Join(t.TempDir(), "peers.json")
+		srv, err := NewServiceWithClient(newFakeDevice(t), "wg0", storagePath, "vpn.example.com:51820", "SERVER_PUB", "10.0.0.0/24")
+		if err != nil {
+			t.Fatalf("NewServiceWithClient failed: %v", err)
+		}
+		resp, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}, PreSharedKey: true})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		srv.Close()
+
+		// A fresh device stands in for the interface after a reboot.
+		client := newFakeDevice(t)
+		newFakeService(t, client, storagePath)
+		p := devicePeer(t, client, resp.PublicKey)
+		if p == nil || p.PresharedKey.String() != resp.PresharedKey || p.AllowedIPs[0].String() != "10.0.0.2/32" {
+			t.Errorf("expected peer to be restored on startup, got %+v", p)
+		}
+	})
+
+	t.Run("Reconcile", func(t *testing.T) {
+		setup := func(t *testing.T) (Service, *fakedevice.Client, wgtypes.Key, string) {
+			client := newFakeDevice(t)
+			srv := newFakeService(t, client, filepath.Join(t.TempDir(), "peers.json"))
+			resp, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+			if err != nil {
+				t.Fatalf("AddPeer failed: %v", err)
+			}
+			stray := mustKey(t)
+			_ = client.ConfigureDevice("wg0", wgtypes.Config{Peers: []wgtypes.PeerConfig{
+				{PublicKey: stray, AllowedIPs: []net.IPNet{mustIPNet(t, "10.0.0.7/32")}},
+			}})
+			return srv, client, stray, resp.PublicKey
+		}
+
+		t.Run("Adopt", func(t *testing.T) {
+			srv, _, stray, _ := setup(t)
+			if _, err := srv.Reconcile(ReconcileAdopt); err != nil {
+				t.Fatalf("Reconcile failed: %v", err)
+			}
+			if _, ok := srv.GetPeerMetadata(stray.String()); !ok {
+				t.Error("expected device-only peer to be adopted into storage")
+			}
+			report, _ := srv.CheckDrift()
+			if !report.InSync {
+				t.Errorf("expected no drift after adopt, got %+v", report)
+			}
+		})
+
+		t.Run("Prune", func(t *testing.T) {
+			srv, client, stray, _ := setup(t)
+			if _, err := srv.Reconcile(ReconcilePrune); err != nil {
+				t.Fatalf("Reconcile failed: %v", err)
+			}
+			if devicePeer(t, client, stray.String()) != nil {
+				t.Error("expected device-only peer to be removed from the device")
+			}
+		})
+
+		t.Run("StorageWins", func(t *testing.T) {
+			srv, client, stray, stored := setup(t)
+			_ = client.ConfigureDevice("wg0", wgtypes.Config{Peers: []wgtypes.PeerConfig{
+				{PublicKey: mustParseKey(t, stored), ReplaceAllowedIPs: true, AllowedIPs: []net.IPNet{mustIPNet(t, "10.0.0.99/32")}},
+			}})
+			if _, err := srv.Reconcile(ReconcileStorageWins); err != nil {
+				t.Fatalf("Reconcile failed: %v", err)
+			}
+			if devicePeer(t, client, stray.String()) != nil {
+				t.Error("expected device-only peer to be removed")
+			}
+			if p := devicePeer(t, client, stored); p == nil || p.AllowedIPs[0].String() != "10.0.0.2/32" {
+				t.Errorf("expected stored AllowedIPs to be restored, got %+v", p)
+			}
+		})
+
+		t.Run("StorageWinsKeepsPendingKey", func(t *testing.T) {
+			srv, client, stray, stored := setup(t)
+			peer, _ := srv.GetPeerMetadata(stored)
+			regen, err := srv.RegeneratePeer(peer.ID, RegenerateOptions{Overlap: time.Hour}, 0)
+			if err != nil {
+				t.Fatalf("RegeneratePeer failed: %v", err)
+			}
+			// The device lost the new key, e.g. through "wg set ... remove".
+			_ = client.ConfigureDevice("wg0", wgtypes.Config{Peers: []wgtypes.PeerConfig{
+				{PublicKey: mustParseKey(t, regen.PendingPublicKey), Remove: true},
+			}})
+
+			result, err := srv.Reconcile(ReconcileStorageWins)
+			if err != nil {
+				t.Fatalf("Reconcile failed: %v", err)
+			}
+			if devicePeer(t, client, stray.String()) != nil {
+				t.Error("expected device-only peer to be removed")
+			}
+			if p := devicePeer(t, client, regen.PendingPublicKey); p == nil || len(p.AllowedIPs) != 0 {
+				t.Errorf("expected the pending key restored without AllowedIPs, got %+v (actions %v)", p, result.Actions)
+			}
+			if p := devicePeer(t, client, stored); p == nil || p.AllowedIPs[0].String() != "10.0.0.2/32" {
+				t.Errorf("expected the old key to keep its AllowedIPs, got %+v", p)
+			}
+		})
+
+		t.Run("DriftReportedOnce", func(t *testing.T) {
+			srv, client, _, _ := setup(t)
+			live := srv.(*realService)
+			driftEvents := func() int {
+				events, _ := srv.GetEvents()
+				n := 0
+				for _, e := range events {
+					if e.Type == EventDriftDetected {
+						n++
+					}
+				}
+				return n
+			}
+
+			var last DriftReport
+			for range 3 {
+				last = live.reportDrift(last)
+			}
+			if got := driftEvents(); got != 1 {
+				t.Fatalf("expected unchanged drift reported once, got %d events", got)
+			}
+
+			_ = client.ConfigureDevice("wg0", wgtypes.Config{Peers: []wgtypes.PeerConfig{
+				{PublicKey: mustKey(t), AllowedIPs: []net.IPNet{mustIPNet(t, "10.0.0.8/32")}},
+			}})
+			last = live.reportDrift(last)
+			last = live.reportDrift(last)
+			if got := driftEvents(); got != 2 {
+				t.Errorf("expected changed drift reported again, got %d events", got)
+			}
+		})
+	})
+}
+
+func mustParseKey(t *testing.T, s string) wgtypes.Key {
+	t.Helper()
+	k, err := wgtypes.ParseKey(s)
+	if err != nil {
+		t.Fatalf("invalid key %s: %v", s, err)
+	}
+	return k
+}
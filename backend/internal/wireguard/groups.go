@@ -0,0 +1,196 @@
+package wireguard
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Group is a named set of peers sharing defaults. A peer's own settings take
+// precedence over its group's, which take precedence over the global settings.
+type Group struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	DNS         string `json:"dns,omitempty"`
+	MTU         int    `json:"mtu,omitempty"`
+	// PersistentKeepalive is the client-side keepalive, in seconds.
+	PersistentKeepalive int `json:"persistentKeepalive,omitempty"`
+	// RouteProfile is the client route profile for client peers that do not
+	// pick one.
+	RouteProfile string `json:"routeProfile,omitempty"`
+	// PeerLifetime, in seconds, sets when peers added to the group expire
+	// unless they are given an expiry of their own. Changing it does not
+	// affect existing peers.
+	PeerLifetime int64 `json:"peerLifetime,omitempty"`
+}
+
+// GroupChange is the result of a group update: the stored group and the
+// member peers whose configs now differ and must be re-downloaded.
+type GroupChange struct {
+	Group         Group          `json:"group"`
+	AffectedPeers []AffectedPeer `json:"affectedPeers"`
+}
+
+// withGroupDefaults fills in the settings a peer leaves unset from its group.
+// The group's route profile only applies to client peers; site routers keep
+// their own routes.
+func withGroupDefaults(meta PeerMetadata, group Group) PeerMetadata {
+	if meta.DNS == "" {
+		meta.DNS = group.DNS
+	}
+	if meta.MTU == 0 {
+		meta.MTU = group.MTU
+	}
+	if meta.PersistentKeepalive == 0 {
+		meta.PersistentKeepalive = group.PersistentKeepalive
+	}
+	if meta.RouteProfile == "" && meta.Type != PeerTypeSite {
+		meta.RouteProfile = group.RouteProfile
+	}
+	return meta
+}
+
+// inherit applies the defaults of the peer's group, if it has one.
+func (s *realService) inherit(meta PeerMetadata) PeerMetadata {
+	group, _ := s.storage.GetGroup(meta.Group)
+	return withGroupDefaults(meta, group)
+}
+
+// lookupGroup returns the named group, or the zero Group for no name.
+func (s *realService) lookupGroup(name string) (Group, error) {
+	if name == "" {
+		return Group{}, nil
+	}
+	group, ok := s.storage.GetGroup(name)
+	if !ok {
+		return Group{}, groupNotFound(name)
+	}
+	return group, nil
+}
+
+// peerExpiry picks the expiry of a new peer: its own, or else now plus its
+// group's peer lifetime.
+func peerExpiry(expires int64, group Group, now time.Time) int64 {
+	if expires == 0 && group.PeerLifetime > 0 {
+		return now.Unix() + group.PeerLifetime
+	}
+	return expires
+}
+
+// normalizeTags sorts tags and drops duplicates.
+func normalizeTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	return slices.Compact(slices.Sorted(slices.Values(tags)))
+}
+
+// ListGroups returns all groups sorted by name.
+func (s *realService) ListGroups() ([]Group, error) {
+	return slices.SortedFunc(maps.Values(s.storage.ListGroups()), func(a, b Group) int {
+		return strings.Compare(a.Name, b.Name)
+	}), nil
+}
+
+// GetGroup returns a group by name.
+func (s *realService) GetGroup(name string) (Group, error) {
+	group, ok := s.storage.GetGroup(name)
+	if !ok {
+		return Group{}, groupNotFound(name)
+	}
+	return group, nil
+}
+
+// CreateGroup stores a new group. It fails with ErrConflict if the name is
+// taken.
+func (s *realService) CreateGroup(group Group) (Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.storage.GetGroup(group.Name); ok {
+		return Group{}, fmt.Errorf("%w: group %s already exists", ErrConflict, group.Name)
+	}
+	if err := s.checkGroupRouteProfile(group); err != nil {
+		return Group{}, err
+	}
+	if err := s.storage.SetGroup(group); err != nil {
+		return Group{}, fmt.Errorf("failed to save group: %w", err)
+	}
+
+	s.emit(EventGroupCreated, fmt.Sprintf("Group %s created", group.Name), group)
+	return group, nil
+}
+
+// UpdateGroup replaces the defaults of an existing group and reports the
+// member peers whose configs changed.
+func (s *realService) UpdateGroup(name string, group Group) (GroupChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, ok := s.storage.GetGroup(name)
+	if !ok {
+		return GroupChange{}, groupNotFound(name)
+	}
+	group.Name = name
+	if err := s.checkGroupRouteProfile(group); err != nil {
+		return GroupChange{}, err
+	}
+
+	settings := s.storage.GetSettings()
+	renderIn := func(g Group) func(PeerMetadata) (string, error) {
+		return func(meta PeerMetadata) (string, error) {
+			if meta.Group != name {
+				return "", nil
+			}
+			return s.renderConfig(withGroupDefaults(meta, g), settings)
+		}
+	}
+	affected := s.affectedPeers(renderIn(previous), renderIn(group))
+	if err := s.storage.SetGroup(group); err != nil {
+		return GroupChange{}, fmt.Errorf("failed to save group: %w", err)
+	}
+
+	change := GroupChange{Group: group, AffectedPeers: affected}
+	s.emit(EventGroupUpdated, fmt.Sprintf("Group %s updated; %d peer configs changed", name, len(affected)), change)
+	return change, nil
+}
+
+// DeleteGroup removes a group. It fails with ErrConflict while peers are
+// still in it.
+func (s *realService) DeleteGroup(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.storage.GetGroup(name); !ok {
+		return groupNotFound(name)
+	}
+	members := 0
+	for _, meta := range s.storage.ListMetadata() {
+		if meta.Group == name {
+			members++
+		}
+	}
+	if members > 0 {
+		return fmt.Errorf("%w: group %s still has %d peers", ErrConflict, name, members)
+	}
+	if err := s.storage.DeleteGroup(name); err != nil {
+		return fmt.Errorf("failed to delete group: %w", err)
+	}
+
+	s.emit(EventGroupDeleted, fmt.Sprintf("Group %s deleted", name), Group{Name: name})
+	return nil
+}
+
+// checkGroupRouteProfile rejects a group whose route profile is not defined
+// in the global settings.
+func (s *realService) checkGroupRouteProfile(group Group) error {
+	if group.RouteProfile == "" {
+		return nil
+	}
+	if _, ok := findRouteProfile(s.storage.GetSettings(), group.RouteProfile); !ok {
+		return invalidArgument("unknown route profile: %s", group.RouteProfile)
+	}
+	return nil
+}
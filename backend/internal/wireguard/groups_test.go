@@ -0,0 +1,216 @@
+package wireguard
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestGroups(t *testing.T) {
+	t.Run("DefaultsFallBackPeerGroupGlobal", func(t *testing.T) {
+		srv := newFakeService(t, newFakeDevice(t), filepath.Join(t.TempDir(), "peers.json"))
+		profiles := []RouteProfile{{Name: "office", AllowedIPs: []string{"192.168.10.0/24"}}}
+		if _, err := srv.PatchSettings(SettingsUpdate{RouteProfiles: &profiles}, 0); err != nil {
+			t.Fatalf("PatchSettings failed: %v", err)
+		}
+		if _, err := srv.CreateGroup(Group{Name: "staff", DNS: "10.0.0.53", MTU: 1380, RouteProfile: "office"}); err != nil {
+			t.Fatalf("CreateGroup failed: %v", err)
+		}
+
+		member, err := srv.AddPeer(AddPeerOptions{Name: "member", AllowedIPs: []string{"10.0.0.2/32"}, Group: "staff"})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		override, err := srv.AddPeer(AddPeerOptions{Name: "override", AllowedIPs: []string{"10.0.0.3/32"}, Group: "staff", DNS: "9.9.9.9"})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		outsider, err := srv.AddPeer(AddPeerOptions{Name: "outsider", AllowedIPs: []string{"10.0.0.4/32"}})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+
+		config, _ := srv.GetPeerConfig(member.ID)
+		for _, want := range []string{"DNS = 10.0.0.53", "MTU = 1380", "AllowedIPs = 192.168.10.0/24"} {
+			if !strings.Contains(config, want) {
+				t.Errorf("expected the member's config to contain %q, got:\n%s", want, config)
+			}
+		}
+		if config, _ := srv.GetPeerConfig(override.ID); !strings.Contains(config, "DNS = 9.9.9.9") || !strings.Contains(config, "MTU = 1380") {
+			t.Errorf("expected the peer's DNS over the group's and the group's MTU, got:\n%s", config)
+		}
+		if config, _ := srv.GetPeerConfig(outsider.ID); !strings.Contains(config, "MTU = 1420") {
+			t.Errorf("expected the global MTU outside the group, got:\n%s", config)
+		}
+
+		change, err := srv.UpdateGroup("staff", Group{DNS: "10.0.0.54"})
+		if err != nil {
+			t.Fatalf("UpdateGroup failed: %v", err)
+		}
+		if change.Group.Name != "staff" {
+			t.Errorf("expected the name to be kept, got %q", change.Group.Name)
+		}
+		affected := []string{}
+		for _, p := range change.AffectedPeers {
+			affected = append(affected, p.Name)
+		}
+		if !slices.Equal(affected, []string{"member", "override"}) {
+			t.Errorf("expected both members to be affected, got %v", affected)
+		}
+	})
+
+	t.Run("FilterByGroupAndTag", func(t *testing.T) {
+		srv := newFakeService(t, newFakeDevice(t), filepath.Join(t.TempDir(), "peers.json"))
+		if _, err := srv.CreateGroup(Group{Name: "staff"}); err != nil {
+			t.Fatalf("CreateGroup failed: %v", err)
+		}
+		tagged, err := srv.AddPeer(AddPeerOptions{Name: "a", AllowedIPs: []string{"10.0.0.2/32"}, Group: "staff", Tags: []string{"oncall", "laptop", "oncall"}})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		if !slices.Equal(tagged.Tags, []string{"laptop", "oncall"}) {
+			t.Errorf("expected sorted, deduplicated tags, got %v", tagged.Tags)
+		}
+		if _, err := srv.AddPeer(AddPeerOptions{Name: "b", AllowedIPs: []string{"10.0.0.3/32"}, Group: "staff"}); err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		if _, err := srv.AddPeer(AddPeerOptions{Name: "c", AllowedIPs: []string{"10.0.0.4/32"}, Tags: []string{"oncall"}}); err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+
+		tests := []struct {
+			query PeerQuery
+			want  int
+		}{
+			{PeerQuery{}, 3},
+			{PeerQuery{Group: "staff"}, 2},
+			{PeerQuery{Tag: "oncall"}, 2},
+			{PeerQuery{Group: "staff", Tag: "oncall"}, 1},
+			{PeerQuery{Group: "nope"}, 0},
+		}
+		for _, tt := range tests {
+			page, err := srv.QueryPeers(tt.query)
+			if err != nil {
+				t.Fatalf("QueryPeers failed: %v", err)
+			}
+			if page.Total != tt.want {
+				t.Errorf("%+v: expected %d peers, got %d", tt.query, tt.want, page.Total)
+			}
+		}
+	})
+
+	t.Run("Lifecycle", func(t *testing.T) {
+		srv := newFakeService(t, newFakeDevice(t), filepath.Join(t.TempDir(), "peers.json"))
+		if _, err := srv.AddPeer(AddPeerOptions{Name: "a", AllowedIPs: []string{"10.0.0.2/32"}, Group: "staff"}); !errors.Is(err, ErrGroupNotFound) {
+			t.Errorf("expected ErrGroupNotFound for an unknown group, got %v", err)
+		}
+		if _, err := srv.CreateGroup(Group{Name: "staff"}); err != nil {
+			t.Fatalf("CreateGroup failed: %v", err)
+		}
+		if _, err := srv.CreateGroup(Group{Name: "staff"}); !errors.Is(err, ErrConflict) {
+			t.Errorf("expected ErrConflict for a duplicate name, got %v", err)
+		}
+		if _, err := srv.CreateGroup(Group{Name: "routed", RouteProfile: "nope"}); err == nil {
+			t.Error("expected an unknown route profile to be rejected")
+		}
+
+		peer, err := srv.AddPeer(AddPeerOptions{Name: "a", AllowedIPs: []string{"10.0.0.2/32"}, Group: "staff"})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		if err := srv.DeleteGroup("staff"); !errors.Is(err, ErrConflict) {
+			t.Errorf("expected ErrConflict while the group has peers, got %v", err)
+		}
+		none := ""
+		if _, err := srv.UpdatePeer(peer.ID, PeerUpdate{Group: &none}, 0); err != nil {
+			t.Fatalf("UpdatePeer failed: %v", err)
+		}
+		if err := srv.DeleteGroup("staff"); err != nil {
+			t.Fatalf("DeleteGroup failed: %v", err)
+		}
+		if _, err := srv.GetGroup("staff"); !errors.Is(err, ErrGroupNotFound) {
+			t.Errorf("expected ErrGroupNotFound after deletion, got %v", err)
+		}
+	})
+}
+
+func TestPeerExpiry(t *testing.T) {
+	client := newFakeDevice(t)
+	srv := newFakeService(t, client, filepath.Join(t.TempDir(), "peers.json"))
+	if _, err := srv.CreateGroup(Group{Name: "contractors", PeerLifetime: 3600}); err != nil {
+		t.Fatalf("CreateGroup failed: %v", err)
+	}
+
+	before := time.Now().Unix()
+	peer, err := srv.AddPeer(AddPeerOptions{Name: "temp", AllowedIPs: []string{"10.0.0.2/32"}, Group: "contractors"})
+	if err != nil {
+		t.Fatalf("AddPeer failed: %v", err)
+	}
+	if peer.Expires < before+3600 || peer.Expires > time.Now().Unix()+3600 {
+		t.Fatalf("expected the group's lifetime to set the expiry, got %d", peer.Expires)
+	}
+	own, err := srv.AddPeer(AddPeerOptions{Name: "own", AllowedIPs: []string{"10.0.0.3/32"}, Group: "contractors", Expires: before + 60})
+	if err != nil {
+		t.Fatalf("AddPeer failed: %v", err)
+	}
+	if own.Expires != before+60 {
+		t.Errorf("expected the peer's own expiry to win, got %d", own.Expires)
+	}
+
+	// Let the first peer's access lapse without the device being told.
+	rs := srv.(*realService)
+	meta, _ := rs.storage.GetMetadata(peer.ID)
+	meta.Expires = before - 1
+	if err := rs.storage.SetMetadata(meta.ID, meta); err != nil {
+		t.Fatalf("SetMetadata failed: %v", err)
+	}
+	rs.expirePeers(time.Now())
+	if devicePeer(t, client, peer.PublicKey) != nil {
+		t.Error("expected the expired peer to be removed from the device")
+	}
+	if devicePeer(t, client, own.PublicKey) == nil {
+		t.Error("expected the unexpired peer to stay on the device")
+	}
+	events, _ := srv.GetEvents()
+	if !slices.ContainsFunc(events, func(e Event) bool { return e.Type == EventPeerExpired }) {
+		t.Error("expected a peer.expired event")
+	}
+	listed, err := srv.GetPeer(peer.ID)
+	if err != nil {
+		t.Fatalf("expected the expired peer to stay listed, got %v", err)
+	}
+	if listed.Expires != before-1 {
+		t.Errorf("expected the expiry to be listed, got %d", listed.Expires)
+	}
+	if _, err := srv.RegeneratePeer(peer.ID, RegenerateOptions{}, 0); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict regenerating an expired peer, got %v", err)
+	}
+
+	never := int64(0)
+	if _, err := srv.UpdatePeer(peer.ID, PeerUpdate{Expires: &never}, 0); err != nil {
+		t.Fatalf("UpdatePeer failed: %v", err)
+	}
+	if devicePeer(t, client, peer.PublicKey) == nil {
+		t.Error("expected clearing the expiry to put the peer back on the device")
+	}
+
+	past := before - 1
+	client.FailNextConfigure(fmt.Errorf("netlink: %w", syscall.ENODEV))
+	if _, err := srv.UpdatePeer(own.ID, PeerUpdate{Expires: &past}, 0); !errors.Is(err, ErrDeviceUnavailable) {
+		t.Fatalf("expected ErrDeviceUnavailable, got %v", err)
+	}
+	if got, _ := srv.GetPeer(own.ID); got.Expires != before+60 || devicePeer(t, client, own.PublicKey) == nil {
+		t.Errorf("expected a refused expiry to leave the peer as it was, got %+v", got)
+	}
+	if _, err := srv.UpdatePeer(own.ID, PeerUpdate{Expires: &past}, 0); err != nil {
+		t.Fatalf("UpdatePeer failed: %v", err)
+	}
+	if devicePeer(t, client, own.PublicKey) != nil {
+		t.Error("expected an expiry in the past to take the peer off the device")
+	}
+}
@@ -0,0 +1,306 @@
+package wireguard
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statsInterval is how often the stats collector records a history point.
+const statsInterval = time.Minute
+
+// Background collectors that report heartbeats for readiness.
+const (
+	collectorStats     = "stats"
+	collectorDrift     = "drift"
+	collectorRotations = "rotations"
+	collectorExpiry    = "expiry"
+)
+
+// collectors lists the background collectors in the order readiness
+// reports them.
+var collectors = []string{collectorStats, collectorDrift, collectorRotations, collectorExpiry}
+
+// collectorIntervals is how often each collector is expected to beat.
+var collectorIntervals = map[string]time.Duration{
+	collectorStats:     statsInterval,
+	collectorDrift:     driftCheckInterval,
+	collectorRotations: rotationCheckInterval,
+	collectorExpiry:    expiryCheckInterval,
+}
+
+// Roots of the kernel pseudo-filesystems read by Diagnostics; tests point
+// them at fixture trees.
+var (
+	sysfsRoot  = "/sys"
+	procfsRoot = "/proc"
+)
+
+// HealthCheck is the outcome of a single readiness probe.
+type HealthCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// Readiness reports whether the service can handle requests.
+type Readiness struct {
+	Ready  bool          `json:"ready"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// Diagnostics describes the host environment the interface depends on.
+type Diagnostics struct {
+	Timestamp    int64            `json:"timestamp"`
+	Interface    string           `json:"interface"`
+	DeviceType   string           `json:"deviceType"`
+	KernelModule ModuleStatus     `json:"kernelModule"`
+	Link         LinkStatus       `json:"link"`
+	ListenPort   ListenPortStatus `json:"listenPort"`
+	Forwarding   ForwardingStatus `json:"forwarding"`
+	NAT          NATStatus        `json:"nat"`
+	Clock        ClockStatus      `json:"clock"`
+	Warnings     []string         `json:"warnings"`
+}
+
+// ModuleStatus reports whether the wireguard kernel module is loaded.
+type ModuleStatus struct {
+	Loaded bool `json:"loaded"`
+	// Required is false for userspace devices, which don't use the module.
+	Required bool `json:"required"`
+}
+
+// LinkStatus is the state of the network interface as seen by the kernel.
+type LinkStatus struct {
+	Exists    bool   `json:"exists"`
+	Up        bool   `json:"up"`
+	OperState string `json:"operState,omitempty"`
+	MTU       int    `json:"mtu,omitempty"`
+}
+
+// ListenPortStatus reports whether the device's UDP port has a bound socket.
+type ListenPortStatus struct {
+	Port  int  `json:"port"`
+	Bound bool `json:"bound"`
+}
+
+// ForwardingStatus holds the IP forwarding sysctls.
+type ForwardingStatus struct {
+	IPv4 bool `json:"ipv4"`
+	IPv6 bool `json:"ipv6"`
+}
+
+// NATStatus reports the kernel pieces needed to masquerade peer traffic.
+type NATStatus struct {
+	Conntrack    bool `json:"conntrack"`
+	NATModule    bool `json:"natModule"`
+	SrcValidMark bool `json:"srcValidMark"`
+}
+
+// ClockStatus reports the kernel's view of clock synchronisation. WireGuard
+// rejects handshakes whose timestamps go backwards, so a badly skewed clock
+// after a restart breaks existing peers.
+type ClockStatus struct {
+	Supported      bool  `json:"supported"`
+	Synchronized   bool  `json:"synchronized"`
+	OffsetMicros   int64 `json:"offsetMicros"`
+	MaxErrorMicros int64 `json:"maxErrorMicros"`
+	EstErrorMicros int64 `json:"estErrorMicros"`
+}
+
+// beat records that a background collector is still running.
+func (s *realService) beat(collector string) {
+	s.beatsMu.Lock()
+	defer s.beatsMu.Unlock()
+	if s.beats == nil {
+		s.beats = make(map[string]time.Time)
+	}
+	s.beats[collector] = time.Now()
+}
+
+// Readiness checks the device, storage and background collectors.
+func (s *realService) Readiness() Readiness {
+	checks := []HealthCheck{}
+	add := func(name string, err error) {
+		c := HealthCheck{Name: name, OK: err == nil}
+		if err != nil {
+			c.Message = err.Error()
+		}
+		checks = append(checks, c)
+	}
+
+	_, err := s.client.Device(s.interfaceName)
+	add("device", err)
+	add("storage", s.storage.CheckWritable())
+
+	s.beatsMu.Lock()
+	for _, name := range collectors {
+		last, ok := s.beats[name]
+		switch {
+		case !ok:
+			add("collector."+name, fmt.Errorf("collector has not started"))
+		case time.Since(last) > 2*collectorIntervals[name]:
+			add("collector."+name, fmt.Errorf("no heartbeat since %s", last.UTC().Format(time.RFC3339)))
+		default:
+			add("collector."+name, nil)
+		}
+	}
+	s.beatsMu.Unlock()
+
+	ready := true
+	for _, c := range checks {
+		ready = ready && c.OK
+	}
+	return Readiness{Ready: ready, Checks: checks}
+}
+
+// Diagnostics inspects the host for common causes of a non-working tunnel.
+func (s *realService) Diagnostics() Diagnostics {
+	d := Diagnostics{
+		Timestamp: time.Now().Unix(),
+		Interface: s.interfaceName,
+		Warnings:  []string{},
+	}
+	warn := func(format string, args ...any) {
+		d.Warnings = append(d.Warnings, fmt.Sprintf(format, args...))
+	}
+
+	device, err := s.client.Device(s.interfaceName)
+	if err != nil {
+		warn("device %s is unreachable: %v", s.interfaceName, err)
+	} else {
+		d.DeviceType = device.Type.String()
+		d.ListenPort.Port = device.ListenPort
+	}
+	kernel := d.DeviceType == "Linux kernel"
+
+	d.KernelModule = ModuleStatus{Loaded: pathExists(sysfsRoot, "module", "wireguard"), Required: kernel}
+	if kernel && !d.KernelModule.Loaded {
+		warn("wireguard kernel module is not loaded")
+	}
+
+	d.Link = readLinkStatus(s.interfaceName)
+	if kernel && !d.Link.Up {
+		warn("interface %s is not up", s.interfaceName)
+	}
+
+	if d.ListenPort.Port != 0 {
+		d.ListenPort.Bound = udpPortBound(d.ListenPort.Port)
+		if !d.ListenPort.Bound {
+			warn("no UDP socket is bound to listen port %d", d.ListenPort.Port)
+		}
+	}
+
+	d.Forwarding = ForwardingStatus{
+		IPv4: readSysctl("net/ipv4/ip_forward") == "1",
+		IPv6: readSysctl("net/ipv6/conf/all/forwarding") == "1",
+	}
+	if !d.Forwarding.IPv4 {
+		warn("IPv4 forwarding is disabled; peers can only reach this host")
+	}
+
+	d.NAT = NATStatus{
+		Conntrack:    pathExists(procfsRoot, "sys", "net", "netfilter", "nf_conntrack_max"),
+		NATModule:    pathExists(sysfsRoot, "module", "nf_nat"),
+		SrcValidMark: readSysctl("net/ipv4/conf/all/src_valid_mark") == "1",
+	}
+	if d.Forwarding.IPv4 && !d.NAT.NATModule {
+		warn("nf_nat is not loaded; masquerading peer traffic will not work")
+	}
+
+	d.Clock = readClockStatus()
+	if d.Clock.Supported && !d.Clock.Synchronized {
+		warn("system clock is not synchronised")
+	}
+
+	return d
+}
+
+// CheckWritable verifies the storage directory accepts new files and the
+// store itself, once saved, can be opened for reading and writing.
+func (s *Storage) CheckWritable() error {
+	store, err := os.OpenFile(s.path, os.O_RDWR, 0)
+	switch {
+	case err == nil:
+		store.Close()
+	case !errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("storage is not writable: %w", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(s.path), ".writecheck-*")
+	if err != nil {
+		return fmt.Errorf("storage is not writable: %w", err)
+	}
+	name := f.Name()
+	_, err = f.WriteString("ok")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	os.Remove(name)
+	if err != nil {
+		return fmt.Errorf("storage is not writable: %w", err)
+	}
+	return nil
+}
+
+func pathExists(elem ...string) bool {
+	_, err := os.Stat(filepath.Join(elem...))
+	return err == nil
+}
+
+func readSysctl(name string) string {
+	data, err := os.ReadFile(filepath.Join(procfsRoot, "sys", name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// readLinkStatus reads the interface from sysfs. Link state comes from the
+// IFF_UP flag, since WireGuard links report an "unknown" operstate.
+func readLinkStatus(name string) LinkStatus {
+	dir := filepath.Join(sysfsRoot, "class", "net", name)
+	if !pathExists(dir) {
+		return LinkStatus{}
+	}
+	status := LinkStatus{Exists: true}
+	if data, err := os.ReadFile(filepath.Join(dir, "operstate")); err == nil {
+		status.OperState = strings.TrimSpace(string(data))
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "flags")); err == nil {
+		flags, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(string(data)), "0x"), 16, 32)
+		status.Up = err == nil && flags&0x1 != 0
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "mtu")); err == nil {
+		status.MTU, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+	}
+	return status
+}
+
+// udpPortBound reports whether any IPv4 or IPv6 UDP socket is bound to port.
+func udpPortBound(port int) bool {
+	want := fmt.Sprintf(":%04X", port)
+	for _, table := range []string{"udp", "udp6"} {
+		f, err := os.Open(filepath.Join(procfsRoot, "net", table))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Scan() // header
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) > 1 && strings.HasSuffix(fields[1], want) {
+				f.Close()
+				return true
+			}
+		}
+		f.Close()
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package wireguard
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// writeFixture creates a file under root, creating parent directories.
+func writeFixture(t *testing.T, root, name, content string) {
+	t.Helper()
+	path := filepath.Join(root, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("mkdir failed: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+}
+
+func useFixtureRoots(t *testing.T) (sys, proc string) {
+	t.Helper()
+	oldSys, oldProc := sysfsRoot, procfsRoot
+	sysfsRoot, procfsRoot = t.TempDir(), t.TempDir()
+	t.Cleanup(func() { sysfsRoot, procfsRoot = oldSys, oldProc })
+	return sysfsRoot, procfsRoot
+}
+
+func checkByName(r Readiness, name string) HealthCheck {
+	for _, c := range r.Checks {
+		if c.Name == name {
+			return c
+		}
+	}
+	return HealthCheck{Name: name}
+}
+
+func TestReadiness(t *testing.T) {
+	newReady := func(t *testing.T) *realService {
+		client := newFakeDevice(t)
+		srv := newFakeService(t, client, filepath.Join(t.TempDir(), "peers.json")).(*realService)
+		deadline := time.Now().Add(time.Second)
+		for !srv.Readiness().Ready && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		return srv
+	}
+
+	t.Run("Ready", func(t *testing.T) {
+		srv := newReady(t)
+		r := srv.Readiness()
+		if !r.Ready {
+			t.Fatalf("expected service to be ready, got %+v", r.Checks)
+		}
+		if len(r.Checks) != 6 {
+			t.Errorf("expected 6 checks, got %+v", r.Checks)
+		}
+	})
+
+	t.Run("StorageNotWritable", func(t *testing.T) {
+		srv := newReady(t)
+		srv.storage.path = filepath.Join(t.TempDir(), "missing", "peers.json")
+		r := srv.Readiness()
+		if r.Ready || checkByName(r, "storage").OK {
+			t.Errorf("expected storage check to fail, got %+v", r.Checks)
+		}
+	})
+
+	t.Run("StorageFileNotWritable", func(t *testing.T) {
+		srv := newReady(t)
+		// A directory in place of the store cannot be opened for writing,
+		// even by root, while its parent still accepts new files.
+		if err := os.Remove(srv.storage.path); err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		if err := os.Mkdir(srv.storage.path, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		r := srv.Readiness()
+		if r.Ready || checkByName(r, "storage").OK {
+			t.Errorf("expected storage check to fail, got %+v", r.Checks)
+		}
+	})
+
+	t.Run("StaleCollector", func(t *testing.T) {
+		srv := newReady(t)
+		srv.beatsMu.Lock()
+		srv.beats[collectorDrift] = time.Now().Add(-3 * driftCheckInterval)
+		srv.beatsMu.Unlock()
+		r := srv.Readiness()
+		if r.Ready || checkByName(r, "collector.drift").OK || !checkByName(r, "collector.stats").OK {
+			t.Errorf("expected only the drift collector to fail, got %+v", r.Checks)
+		}
+	})
+
+	t.Run("WatchersReport", func(t *testing.T) {
+		srv := newReady(t)
+		for _, name := range []string{collectorRotations, collectorExpiry} {
+			if !checkByName(srv.Readiness(), "collector."+name).OK {
+				t.Errorf("expected the %s watcher to report a heartbeat", name)
+			}
+			srv.beatsMu.Lock()
+			srv.beats[name] = time.Now().Add(-3 * collectorIntervals[name])
+			srv.beatsMu.Unlock()
+			if r := srv.Readiness(); r.Ready || checkByName(r, "collector."+name).OK {
+				t.Errorf("expected a stale %s watcher to fail readiness, got %+v", name, r.Checks)
+			}
+		}
+	})
+
+	t.Run("DeviceUnreachable", func(t *testing.T) {
+		srv := newReady(t)
+		srv.client.Close()
+		r := srv.Readiness()
+		if r.Ready || checkByName(r, "device").OK {
+			t.Errorf("expected device check to fail, got %+v", r.Checks)
+		}
+	})
+}
+
+func TestDiagnostics(t *testing.T) {
+	sys, proc := useFixtureRoots(t)
+	writeFixture(t, sys, "module/wireguard/refcnt", "0\n")
+	writeFixture(t, sys, "class/net/wg0/operstate", "unknown\n")
+	writeFixture(t, sys, "class/net/wg0/flags", "0x91\n")
+	writeFixture(t, sys, "class/net/wg0/mtu", "1420\n")
+	writeFixture(t, proc, "sys/net/ipv4/ip_forward", "1\n")
+	writeFixture(t, proc, "sys/net/ipv6/conf/all/forwarding", "0\n")
+	writeFixture(t, proc, "sys/net/ipv4/conf/all/src_valid_mark", "1\n")
+	writeFixture(t, proc, "net/udp",
+		"  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"+
+			"   0: 00000000:CA6C 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 1 2 0000000000000000 0\n")
+
+	t.Run("Link", func(t *testing.T) {
+		link := readLinkStatus("wg0")
+		if !link.Exists || !link.Up || link.OperState != "unknown" || link.MTU != 1420 {
+			t.Errorf("expected wg0 up with MTU 1420, got %+v", link)
+		}
+		if missing := readLinkStatus("wg9"); missing.Exists {
+			t.Errorf("expected wg9 to be missing, got %+v", missing)
+		}
+	})
+
+	t.Run("ListenPort", func(t *testing.T) {
+		if !udpPortBound(51820) {
+			t.Error("expected port 51820 to be bound")
+		}
+		if udpPortBound(51821) {
+			t.Error("expected port 51821 to be free")
+		}
+	})
+
+	t.Run("Report", func(t *testing.T) {
+		client := newFakeDevice(t)
+		srv := newFakeService(t, client, filepath.Join(t.TempDir(), "peers.json"))
+		d := srv.Diagnostics()
+
+		if d.Interface != "wg0" || d.ListenPort.Port != 51820 || !d.ListenPort.Bound {
+			t.Errorf("expected bound listen port 51820, got %+v", d.ListenPort)
+		}
+		if !d.KernelModule.Loaded || d.KernelModule.Required {
+			t.Errorf("expected module loaded but not required for a non-kernel device, got %+v", d.KernelModule)
+		}
+		if !d.Forwarding.IPv4 || d.Forwarding.IPv6 || !d.NAT.SrcValidMark || d.NAT.NATModule {
+			t.Errorf("unexpected forwarding/NAT status: %+v %+v", d.Forwarding, d.NAT)
+		}
+		if !slices.Contains(d.Warnings, "nf_nat is not loaded; masquerading peer traffic will not work") {
+			t.Errorf("expected nf_nat warning, got %v", d.Warnings)
+		}
+	})
+}
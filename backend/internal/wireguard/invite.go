@@ -0,0 +1,210 @@
+package wireguard
+
+import (
+	"cmp"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultInviteTTL is how long an invite stays valid unless the caller
+	// picks another duration.
+	DefaultInviteTTL = 7 * 24 * time.Hour
+	// MaxInviteTTL bounds how long an invite may stay valid.
+	MaxInviteTTL = 90 * 24 * time.Hour
+)
+
+// InviteOptions describes a new invite.
+type InviteOptions struct {
+	// NameTemplate names the peers created through the invite. "{n}" is
+	// replaced by the use number, starting at 1, and "{name}" by the name
+	// the recipient gives, or "peer" if they give none.
+	NameTemplate string
+	// RouteProfile is the route profile of the created peers. Empty uses
+	// the global default.
+	RouteProfile string
+	// MaxUses is how many peers the invite can create. Zero means one.
+	MaxUses int
+	// TTL is how long the invite stays valid. Zero uses DefaultInviteTTL.
+	TTL time.Duration
+}
+
+// Invite lets a recipient create their own peer. Storage keys it by the
+// SHA-256 hash of its token, so the token itself is never persisted.
+type Invite struct {
+	ID           string   `json:"id"`
+	NameTemplate string   `json:"nameTemplate"`
+	RouteProfile string   `json:"routeProfile,omitempty"`
+	MaxUses      int      `json:"maxUses"`
+	Uses         int      `json:"uses"`
+	Created      int64    `json:"created"` // Unix seconds
+	Expires      int64    `json:"expires"` // Unix seconds
+	Revoked      bool     `json:"revoked,omitempty"`
+	PeerIDs      []string `json:"peerIds,omitempty"` // peers created through the invite
+}
+
+// Usable reports whether the invite can still be redeemed at now.
+func (i Invite) Usable(now time.Time) bool {
+	return !i.Revoked && i.Uses < i.MaxUses && now.Unix() < i.Expires
+}
+
+// InviteResponse is a new invite with its token, which is only returned here.
+type InviteResponse struct {
+	Invite
+	Token string `json:"token"`
+}
+
+// RedeemOptions is what the recipient of an invite supplies.
+type RedeemOptions struct {
+	// Name fills "{name}" in the invite's name template.
+	Name string
+	// PublicKey is the recipient's own public key. Empty generates a key
+	// pair, as with AddPeer.
+	PublicKey string
+}
+
+// CreateInvite creates an invite and returns it with its token.
+func (s *realService) CreateInvite(opts InviteOptions) (InviteResponse, error) {
+	if opts.TTL == 0 {
+		opts.TTL = DefaultInviteTTL
+	}
+	if opts.TTL < 0 || opts.TTL > MaxInviteTTL {
+		return InviteResponse{}, invalidArgument("invite duration must be between 0 and %s", MaxInviteTTL)
+	}
+	if opts.MaxUses == 0 {
+		opts.MaxUses = 1
+	}
+	if opts.MaxUses < 0 {
+		return InviteResponse{}, invalidArgument("max uses must not be negative")
+	}
+	if strings.TrimSpace(opts.NameTemplate) == "" {
+		return InviteResponse{}, invalidArgument("a name template is required")
+	}
+	if opts.RouteProfile != "" {
+		if _, err := resolveClientRoutes(PeerMetadata{RouteProfile: opts.RouteProfile}, s.storage.GetSettings()); err != nil {
+			return InviteResponse{}, err
+		}
+	}
+
+	token := newInviteToken()
+	now := time.Now()
+	invite := Invite{
+		ID:           NewPeerID(),
+		NameTemplate: opts.NameTemplate,
+		RouteProfile: opts.RouteProfile,
+		MaxUses:      opts.MaxUses,
+		Created:      now.Unix(),
+		Expires:      now.Add(opts.TTL).Unix(),
+	}
+	if err := s.storage.SetInvite(tokenHash(token), invite); err != nil {
+		return InviteResponse{}, fmt.Errorf("failed to save invite: %w", err)
+	}
+
+	s.emit(EventInviteCreated, fmt.Sprintf("Created invite %s for up to %d peers", invite.ID, invite.MaxUses), invite)
+	return InviteResponse{Invite: invite, Token: token}, nil
+}
+
+// ListInvites returns every invite, oldest first, including used up,
+// expired and revoked ones.
+func (s *realService) ListInvites() ([]Invite, error) {
+	invites := slices.Collect(maps.Values(s.storage.ListInvites()))
+	slices.SortFunc(invites, func(a, b Invite) int {
+		return cmp.Or(cmp.Compare(a.Created, b.Created), strings.Compare(a.ID, b.ID))
+	})
+	return invites, nil
+}
+
+// RevokeInvite stops an invite from being redeemed. Peers already created
+// through it are kept.
+func (s *realService) RevokeInvite(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, invite, ok := s.findInvite(id)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrInviteNotFound, id)
+	}
+	if invite.Revoked {
+		return nil
+	}
+	invite.Revoked = true
+	if err := s.storage.SetInvite(hash, invite); err != nil {
+		return fmt.Errorf("failed to save invite: %w", err)
+	}
+	s.emit(EventInviteRevoked, fmt.Sprintf("Revoked invite %s", invite.ID), invite)
+	return nil
+}
+
+// RedeemInvite creates a peer through an invite, with the next free address
+// of the VPN subnet. Unknown, used up, expired and revoked invites all fail
+// with ErrInviteNotFound.
+func (s *realService) RedeemInvite(token string, opts RedeemOptions) (PeerResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := tokenHash(token)
+	invite, ok := s.storage.GetInvite(hash)
+	if !ok || !invite.Usable(time.Now()) {
+		return PeerResponse{}, ErrInviteNotFound
+	}
+
+	address, err := s.allocateAddress()
+	if err != nil {
+		return PeerResponse{}, err
+	}
+	use := invite.Uses + 1
+	peer, err := s.addPeer(AddPeerOptions{
+		Name:         inviteName(invite.NameTemplate, use, opts.Name),
+		PublicKey:    opts.PublicKey,
+		AllowedIPs:   []string{address},
+		RouteProfile: invite.RouteProfile,
+	})
+	if err != nil {
+		return PeerResponse{}, err
+	}
+
+	invite.Uses = use
+	invite.PeerIDs = append(slices.Clone(invite.PeerIDs), peer.ID)
+	if err := s.storage.SetInvite(hash, invite); err != nil {
+		// The use could not be counted, so take the peer back out.
+		if rerr := s.removePeer(peer.ID); rerr != nil {
+			slog.Error("Failed to roll back invite redemption", "invite", invite.ID, "peer", peer.ID, "error", rerr)
+		}
+		return PeerResponse{}, fmt.Errorf("failed to save invite: %w", err)
+	}
+
+	s.emit(EventInviteRedeemed, fmt.Sprintf("Invite %s created peer %q", invite.ID, peer.Name), AffectedPeer{ID: peer.ID, Name: peer.Name})
+	return peer, nil
+}
+
+// findInvite returns an invite and its token hash by invite ID.
+func (s *realService) findInvite(id string) (string, Invite, bool) {
+	for hash, invite := range s.storage.ListInvites() {
+		if invite.ID == id {
+			return hash, invite, true
+		}
+	}
+	return "", Invite{}, false
+}
+
+// inviteName fills in an invite's name template for its nth use.
+func inviteName(template string, n int, name string) string {
+	if name == "" {
+		name = "peer"
+	}
+	return strings.NewReplacer("{n}", strconv.Itoa(n), "{name}", name).Replace(template)
+}
+
+// newInviteToken returns 32 random bytes, base64url-encoded.
+func newInviteToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
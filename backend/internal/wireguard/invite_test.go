@@ -0,0 +1,140 @@
+package wireguard
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInvites(t *testing.T) {
+	t.Run("Redeem", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "peers.json")
+		client := newFakeDevice(t)
+		srv := newFakeService(t, client, path)
+		if _, err := srv.AddPeer(AddPeerOptions{Name: "existing", AllowedIPs: []string{"10.0.0.2/32"}}); err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+
+		invite, err := srv.CreateInvite(InviteOptions{NameTemplate: "{name}-laptop-{n}", RouteProfile: RouteProfileFull, MaxUses: 2})
+		if err != nil {
+			t.Fatalf("CreateInvite failed: %v", err)
+		}
+		if invite.Token == "" || invite.MaxUses != 2 {
+			t.Fatalf("expected a token for 2 uses, got %+v", invite)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile failed: %v", err)
+		}
+		if strings.Contains(string(data), invite.Token) {
+			t.Error("expected only the token hash to be stored")
+		}
+
+		first, err := srv.RedeemInvite(invite.Token, RedeemOptions{Name: "dana"})
+		if err != nil {
+			t.Fatalf("RedeemInvite failed: %v", err)
+		}
+		if first.Name != "dana-laptop-1" || first.PrivateKey == "" {
+			t.Errorf("expected a generated peer named dana-laptop-1, got %+v", first)
+		}
+		if len(first.AllowedIPs) != 1 || first.AllowedIPs[0] != "10.0.0.3/32" {
+			t.Errorf("expected the next free address 10.0.0.3/32, got %v", first.AllowedIPs)
+		}
+		if devicePeer(t, client, first.PublicKey) == nil {
+			t.Error("expected the peer on the device")
+		}
+
+		key := mustKey(t).PublicKey().String()
+		second, err := srv.RedeemInvite(invite.Token, RedeemOptions{PublicKey: key})
+		if err != nil {
+			t.Fatalf("RedeemInvite failed: %v", err)
+		}
+		if second.Name != "peer-laptop-2" || second.PublicKey != key || second.PrivateKey != "" {
+			t.Errorf("expected peer-laptop-2 with the recipient's key, got %+v", second)
+		}
+		if second.AllowedIPs[0] != "10.0.0.4/32" {
+			t.Errorf("expected 10.0.0.4/32, got %v", second.AllowedIPs)
+		}
+
+		if _, err := srv.RedeemInvite(invite.Token, RedeemOptions{}); !errors.Is(err, ErrInviteNotFound) {
+			t.Errorf("expected ErrInviteNotFound once used up, got %v", err)
+		}
+		invites, _ := srv.ListInvites()
+		if len(invites) != 1 || invites[0].Uses != 2 || len(invites[0].PeerIDs) != 2 {
+			t.Errorf("expected the invite to record both peers, got %+v", invites)
+		}
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		srv := newFakeService(t, newFakeDevice(t), filepath.Join(t.TempDir(), "peers.json"))
+		invite, err := srv.CreateInvite(InviteOptions{NameTemplate: "guest-{n}"})
+		if err != nil {
+			t.Fatalf("CreateInvite failed: %v", err)
+		}
+		if err := srv.RevokeInvite(invite.ID); err != nil {
+			t.Fatalf("RevokeInvite failed: %v", err)
+		}
+		if _, err := srv.RedeemInvite(invite.Token, RedeemOptions{}); !errors.Is(err, ErrInviteNotFound) {
+			t.Errorf("expected ErrInviteNotFound after revocation, got %v", err)
+		}
+		if err := srv.RevokeInvite("missing"); !errors.Is(err, ErrInviteNotFound) {
+			t.Errorf("expected ErrInviteNotFound, got %v", err)
+		}
+		if _, err := srv.RedeemInvite("unknown", RedeemOptions{}); !errors.Is(err, ErrInviteNotFound) {
+			t.Errorf("expected ErrInviteNotFound for an unknown token, got %v", err)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		srv := newFakeService(t, newFakeDevice(t), filepath.Join(t.TempDir(), "peers.json"))
+		invite, err := srv.CreateInvite(InviteOptions{NameTemplate: "guest-{n}", TTL: time.Nanosecond})
+		if err != nil {
+			t.Fatalf("CreateInvite failed: %v", err)
+		}
+		if _, err := srv.RedeemInvite(invite.Token, RedeemOptions{}); !errors.Is(err, ErrInviteNotFound) {
+			t.Errorf("expected ErrInviteNotFound once expired, got %v", err)
+		}
+	})
+
+	t.Run("InvalidOptions", func(t *testing.T) {
+		srv := newFakeService(t, newFakeDevice(t), filepath.Join(t.TempDir(), "peers.json"))
+		if _, err := srv.CreateInvite(InviteOptions{NameTemplate: "guest", RouteProfile: "nope"}); err == nil {
+			t.Error("expected an error for an unknown route profile")
+		}
+		if _, err := srv.CreateInvite(InviteOptions{NameTemplate: "guest", TTL: MaxInviteTTL + time.Second}); err == nil {
+			t.Error("expected an error for a lifetime above the maximum")
+		}
+	})
+}
+
+func TestAllocateAddress(t *testing.T) {
+	srv := newFakeService(t, newFakeDevice(t), filepath.Join(t.TempDir(), "peers.json")).(*realService)
+	srv.vpnSubnet = "10.0.0.0/29"
+
+	// .1 is the server; .2 and .4 are taken.
+	for _, ip := range []string{"10.0.0.2/32", "10.0.0.4/32"} {
+		if _, err := srv.AddPeer(AddPeerOptions{Name: "peer", AllowedIPs: []string{ip}}); err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+	}
+	want := []string{"10.0.0.3/32", "10.0.0.5/32", "10.0.0.6/32"}
+	for _, w := range want {
+		got, err := srv.allocateAddress()
+		if err != nil {
+			t.Fatalf("allocateAddress failed: %v", err)
+		}
+		if got != w {
+			t.Fatalf("expected %s, got %s", w, got)
+		}
+		if _, err := srv.AddPeer(AddPeerOptions{Name: "peer", AllowedIPs: []string{got}}); err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+	}
+	// .7 is the broadcast address.
+	if _, err := srv.allocateAddress(); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict for a full subnet, got %v", err)
+	}
+}
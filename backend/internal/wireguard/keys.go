@@ -1,6 +1,9 @@
 package wireguard
 
 import (
+	"crypto/rand"
+	"fmt"
+
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
@@ -31,3 +34,12 @@ func GeneratePresharedKey() (string, error) {
 	}
 	return key.String(), nil
 }
+
+// NewPeerID returns a random version 4 UUID to identify a peer.
+func NewPeerID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,153 @@
+package wireguard
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// ConflictError reports that a requested AllowedIP overlaps a range already
+// owned by another peer. WireGuard would otherwise silently move the range.
+type ConflictError struct {
+	Prefix            string `json:"prefix"`
+	ConflictingPrefix string `json:"conflictingPrefix"`
+	PeerID            string `json:"peerId"`
+	PeerName          string `json:"peerName,omitempty"`
+}
+
+func (e *ConflictError) Error() string {
+	owner := e.PeerID
+	if e.PeerName != "" {
+		owner = fmt.Sprintf("%q (%s)", e.PeerName, e.PeerID)
+	}
+	return fmt.Sprintf("allowed IP %s overlaps %s owned by peer %s", e.Prefix, e.ConflictingPrefix, owner)
+}
+
+// Is makes errors.Is(err, ErrConflict) match conflict errors.
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// findOverlap returns the first pair of prefixes, one from candidates and one
+// from owned, where either contains the other. Unparseable entries are skipped;
+// callers validate CIDRs separately.
+func findOverlap(candidates, owned []string) (candidate, existing string, ok bool) {
+	for _, c := range candidates {
+		cp, err := netip.ParsePrefix(c)
+		if err != nil {
+			continue
+		}
+		for _, o := range owned {
+			op, err := netip.ParsePrefix(o)
+			if err != nil {
+				continue
+			}
+			if cp.Masked().Overlaps(op.Masked()) {
+				return c, o, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// checkSiteNetworks verifies that networks advertised by a site peer are valid
+// and do not collide with the VPN subnet. Overlaps with other peers are
+// checked by checkConflicts.
+func (s *realService) checkSiteNetworks(networks []string) error {
+	if _, err := parsePrefixes(networks); err != nil {
+		return err
+	}
+	if s.vpnSubnet != "" {
+		if n, _, ok := findOverlap(networks, []string{s.vpnSubnet}); ok {
+			return invalidArgument("network %s overlaps the VPN subnet %s", n, s.vpnSubnet)
+		}
+	}
+	return nil
+}
+
+// checkConflicts returns a *ConflictError if any of prefixes overlaps the
+// AllowedIPs of a peer other than self, either as recorded in storage or as
+// currently configured on the device.
+func (s *realService) checkConflicts(prefixes []string, self PeerMetadata) error {
+	for id, meta := range s.storage.ListMetadata() {
+		if id == self.ID {
+			continue
+		}
+		if p, existing, ok := findOverlap(prefixes, meta.DeviceAllowedIPs()); ok {
+			return &ConflictError{Prefix: p, ConflictingPrefix: existing, PeerID: id, PeerName: meta.Name}
+		}
+	}
+
+	device, err := s.client.Device(s.interfaceName)
+	if err != nil {
+		return deviceError("failed to get device "+s.interfaceName, err)
+	}
+	for _, peer := range device.Peers {
+		key := peer.PublicKey.String()
+		if key == self.PublicKey {
+			continue
+		}
+		owned := make([]string, len(peer.AllowedIPs))
+		for i, ip := range peer.AllowedIPs {
+			owned[i] = ip.String()
+		}
+		if p, existing, ok := findOverlap(prefixes, owned); ok {
+			conflict := &ConflictError{Prefix: p, ConflictingPrefix: existing, PeerID: key}
+			if meta, ok := s.storage.LookupPublicKey(key); ok {
+				conflict.PeerID, conflict.PeerName = meta.ID, meta.Name
+			}
+			return conflict
+		}
+	}
+	return nil
+}
+
+// releaseStolenPrefixes drops prefixes that were forcibly assigned to the peer
+// with ID newOwner from every other peer's metadata. WireGuard moves an identical AllowedIP to
+// the peer that claimed it last, so storage has to follow; ranges that merely
+// overlap stay put because the device keeps both and routes by longest prefix.
+func (s *realService) releaseStolenPrefixes(prefixes []string, newOwner string) error {
+	stolen, err := parsePrefixes(prefixes)
+	if err != nil {
+		return err
+	}
+
+	for id, meta := range s.storage.ListMetadata() {
+		if id == newOwner {
+			continue
+		}
+		allowed, droppedAllowed := withoutPrefixes(meta.AllowedIPs, stolen)
+		networks, droppedNetworks := withoutPrefixes(meta.Networks, stolen)
+		if !droppedAllowed && !droppedNetworks {
+			continue
+		}
+		meta.AllowedIPs, meta.Networks = allowed, networks
+		if err := s.storage.SetMetadata(id, meta); err != nil {
+			return fmt.Errorf("failed to update metadata of peer %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// withoutPrefixes filters out every CIDR in list equal to one of remove.
+func withoutPrefixes(list []string, remove []netip.Prefix) ([]string, bool) {
+	kept := make([]string, 0, len(list))
+	dropped := false
+	for _, c := range list {
+		p, err := netip.ParsePrefix(c)
+		if err == nil && containsPrefix(remove, p.Masked()) {
+			dropped = true
+			continue
+		}
+		kept = append(kept, c)
+	}
+	return kept, dropped
+}
+
+func containsPrefix(prefixes []netip.Prefix, p netip.Prefix) bool {
+	for _, q := range prefixes {
+		if q.Masked() == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package wireguard
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindOverlap(t *testing.T) {
+	owned := []string{"10.0.0.2/32", "192.168.50.0/24"}
+
+	tests := []struct {
+		name       string
+		candidates []string
+		want       string
+		wantOK     bool
+	}{
+		{"Disjoint", []string{"10.0.0.3/32", "192.168.51.0/24"}, "", false},
+		{"Equal", []string{"10.0.0.2/32"}, "10.0.0.2/32", true},
+		{"Contained", []string{"192.168.50.128/25"}, "192.168.50.0/24", true},
+		{"Contains", []string{"10.0.0.0/24"}, "10.0.0.2/32", true},
+		{"Unmasked", []string{"192.168.50.7/24"}, "192.168.50.0/24", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, existing, ok := findOverlap(tt.candidates, owned)
+			if ok != tt.wantOK || existing != tt.want {
+				t.Errorf("expected (%q, %v), got (%q, %v)", tt.want, tt.wantOK, existing, ok)
+			}
+		})
+	}
+}
+
+func TestReleaseStolenPrefixes(t *testing.T) {
+	storage := newTestStorage(t)
+	seed := map[string]PeerMetadata{
+		"old-owner": {Name: "old", AllowedIPs: []string{"10.0.0.2/32", "10.0.0.3/32"}},
+		"site":      {Name: "site", AllowedIPs: []string{"10.0.0.4/32"}, Networks: []string{"192.168.50.0/24"}},
+	}
+	for k, m := range seed {
+		if err := storage.SetMetadata(k, m); err != nil {
+			t.Fatalf("failed to seed storage: %v", err)
+		}
+	}
+	s := &realService{storage: storage}
+
+	if err := s.releaseStolenPrefixes([]string{"10.0.0.2/32", "192.168.50.0/25"}, "new-owner"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	old, _ := storage.GetMetadata("old-owner")
+	if !reflect.DeepEqual(old.AllowedIPs, []string{"10.0.0.3/32"}) {
+		t.Errorf("expected stolen /32 to be released, got %v", old.AllowedIPs)
+	}
+	site, _ := storage.GetMetadata("site")
+	if !reflect.DeepEqual(site.Networks, []string{"192.168.50.0/24"}) {
+		t.Errorf("expected overlapping but distinct network to be kept, got %v", site.Networks)
+	}
+}
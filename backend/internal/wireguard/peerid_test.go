@@ -0,0 +1,99 @@
+package wireguard
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestPeerIDs(t *testing.T) {
+	t.Run("NewPeerID", func(t *testing.T) {
+		uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+		id := NewPeerID()
+		if !uuid.MatchString(id) {
+			t.Errorf("expected a version 4 UUID, got %q", id)
+		}
+		if NewPeerID() == id {
+			t.Error("expected distinct IDs")
+		}
+	})
+
+	t.Run("SurvivesRegeneration", func(t *testing.T) {
+		srv := newFakeService(t, newFakeDevice(t), filepath.Join(t.TempDir(), "peers.json"))
+		resp, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		if resp.ID == resp.PublicKey {
+			t.Fatalf("expected an ID independent of the public key, got %q", resp.ID)
+		}
+
+		regen, err := srv.RegeneratePeer(resp.ID, RegenerateOptions{}, 0)
+		if err != nil {
+			t.Fatalf("RegeneratePeer failed: %v", err)
+		}
+		if regen.ID != resp.ID || regen.PublicKey == resp.PublicKey {
+			t.Errorf("expected ID %q with a new key, got %+v", resp.ID, regen.Peer)
+		}
+		if peer, err := srv.GetPeer(resp.ID); err != nil || peer.PublicKey != regen.PublicKey {
+			t.Errorf("expected the peer under its ID with the new key, got %+v (%v)", peer, err)
+		}
+	})
+
+	t.Run("LookupByPublicKey", func(t *testing.T) {
+		srv := newFakeService(t, newFakeDevice(t), filepath.Join(t.TempDir(), "peers.json"))
+		resp, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+
+		if meta, ok := srv.GetPeerMetadata(resp.PublicKey); !ok || meta.ID != resp.ID {
+			t.Errorf("expected metadata of %q by public key, got %+v", resp.ID, meta)
+		}
+		if _, err := srv.GetPeerConfig(resp.PublicKey); err != nil {
+			t.Errorf("expected config by public key, got %v", err)
+		}
+		if err := srv.RemovePeer(resp.PublicKey, 0); err != nil {
+			t.Fatalf("RemovePeer by public key failed: %v", err)
+		}
+		if _, ok := srv.GetPeerMetadata(resp.ID); ok {
+			t.Error("expected the peer removed")
+		}
+	})
+
+	t.Run("MigratesLegacyStore", func(t *testing.T) {
+		key := mustKey(t).PublicKey().String()
+		path := filepath.Join(t.TempDir(), "peers.json")
+		legacy := `{"peers":{"` + key + `":{"publicKey":"` + key + `","name":"laptop","allowedIPs":["10.0.0.2/32"]}},"settings":{"mtu":1420}}`
+		if err := os.WriteFile(path, []byte(legacy), 0o600); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+
+		storage, err := NewStorage(path)
+		if err != nil {
+			t.Fatalf("NewStorage failed: %v", err)
+		}
+		meta, ok := storage.LookupPublicKey(key)
+		if !ok || meta.ID == "" || meta.ID == key {
+			t.Fatalf("expected the peer to get an ID, got %+v", meta)
+		}
+		if _, ok := storage.GetMetadata(meta.ID); !ok {
+			t.Error("expected the peer keyed by its ID")
+		}
+
+		// The migration is saved, so the ID is stable across restarts.
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile failed: %v", err)
+		}
+		var saved storageContainer
+		if err := json.Unmarshal(data, &saved); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		if saved.Peers[meta.ID].PublicKey != key {
+			t.Errorf("expected the saved store keyed by ID, got %+v", saved.Peers)
+		}
+	})
+}
@@ -0,0 +1,270 @@
+package wireguard
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Peer statuses, as listed in Peer.Status and accepted by PeerQuery.Status.
+const (
+	PeerStatusOnline   = "online"
+	PeerStatusOffline  = "offline"
+	PeerStatusDisabled = "disabled"
+	PeerStatusExpired  = "expired"
+)
+
+// PeerStatuses lists every peer status.
+var PeerStatuses = []string{PeerStatusOnline, PeerStatusOffline, PeerStatusDisabled, PeerStatusExpired}
+
+// OnlineWindow is how recent a peer's last handshake must be for it to count
+// as online.
+const OnlineWindow = 2 * time.Minute
+
+// Sort keys accepted by PeerQuery.Sort.
+const (
+	PeerSortName      = "name"
+	PeerSortHandshake = "lastHandshake"
+	PeerSortTraffic   = "traffic"
+)
+
+// Sort orders accepted by PeerQuery.Order.
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// MaxPeerPageSize bounds PeerQuery.Limit.
+const MaxPeerPageSize = 500
+
+// PeerQuery selects, sorts and pages peers. Zero values match every peer and
+// return them all, sorted by name.
+type PeerQuery struct {
+	// Search matches peers whose name, public key or tunnel addresses contain
+	// it, ignoring case. An IP address also matches the peers whose
+	// AllowedIPs cover it.
+	Search string
+	Status string
+	Group  string
+	Tag    string
+	// Sort is PeerSortName (the default), PeerSortHandshake or
+	// PeerSortTraffic. Order defaults to ascending by name and descending
+	// otherwise, so the most recent and busiest peers come first.
+	Sort  string
+	Order string
+	// Cursor continues from the NextCursor of an earlier page with the same
+	// sort and order. Limit is the page size; 0 returns every peer.
+	Cursor string
+	Limit  int
+}
+
+// PeerPage is one page of peers matching a PeerQuery.
+type PeerPage struct {
+	Peers []Peer `json:"peers"`
+	// Total counts the peers matching the query across all pages.
+	Total int `json:"total"`
+	// Counts holds the matching peers per status, ignoring the status
+	// filter, so a client can label status tabs.
+	Counts map[string]int `json:"counts"`
+	// NextCursor fetches the next page; empty on the last page.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// peerCursor is the position after the last peer of a page. It carries the
+// sort key so the next page starts in the right place even if peers were
+// added or removed in between.
+type peerCursor struct {
+	Sort      string `json:"s"`
+	Order     string `json:"o"`
+	Name      string `json:"n,omitempty"`
+	Handshake int64  `json:"h,omitempty"`
+	Traffic   int64  `json:"t,omitempty"`
+	ID        string `json:"i"`
+}
+
+// peerStatus derives a peer's status. Disabled wins over expired, which wins
+// over the handshake.
+func peerStatus(meta PeerMetadata, handshake time.Time, now time.Time) string {
+	switch {
+	case meta.Disabled:
+		return PeerStatusDisabled
+	case meta.Expired(now):
+		return PeerStatusExpired
+	case !handshake.IsZero() && now.Sub(handshake) <= OnlineWindow:
+		return PeerStatusOnline
+	}
+	return PeerStatusOffline
+}
+
+// QueryPeers returns the page of peers that q selects. It fails with
+// ErrInvalidQuery for an unknown status, sort key or order, or a bad cursor.
+func QueryPeers(peers []Peer, q PeerQuery) (PeerPage, error) {
+	if q.Sort == "" {
+		q.Sort = PeerSortName
+	}
+	if q.Order == "" {
+		q.Order = OrderAsc
+		if q.Sort != PeerSortName {
+			q.Order = OrderDesc
+		}
+	}
+	compare, err := peerOrder(q.Sort, q.Order)
+	if err != nil {
+		return PeerPage{}, err
+	}
+	if q.Status != "" && !slices.Contains(PeerStatuses, q.Status) {
+		return PeerPage{}, fmt.Errorf("%w: unknown peer status %s", ErrInvalidQuery, q.Status)
+	}
+
+	page := PeerPage{Peers: []Peer{}, Counts: map[string]int{}}
+	for _, status := range PeerStatuses {
+		page.Counts[status] = 0
+	}
+	var matched []Peer
+	for _, p := range peers {
+		if !q.matches(p) {
+			continue
+		}
+		page.Counts[p.Status]++
+		if q.Status == "" || p.Status == q.Status {
+			matched = append(matched, p)
+		}
+	}
+	slices.SortFunc(matched, compare)
+	page.Total = len(matched)
+
+	if q.Cursor != "" {
+		after, err := decodePeerCursor(q.Cursor, q.Sort, q.Order)
+		if err != nil {
+			return PeerPage{}, err
+		}
+		start, _ := slices.BinarySearchFunc(matched, after, func(p, after Peer) int {
+			// Place the cursor after every peer that sorts before or at it.
+			if compare(p, after) <= 0 {
+				return -1
+			}
+			return 1
+		})
+		matched = matched[start:]
+	}
+	if q.Limit > 0 && len(matched) > q.Limit {
+		matched = matched[:q.Limit]
+		page.NextCursor = encodePeerCursor(matched[len(matched)-1], q.Sort, q.Order)
+	}
+	page.Peers = append(page.Peers, matched...)
+	return page, nil
+}
+
+// matches applies the search, group and tag filters of q.
+func (q PeerQuery) matches(p Peer) bool {
+	if q.Group != "" && p.Group != q.Group {
+		return false
+	}
+	if q.Tag != "" && !slices.Contains(p.Tags, q.Tag) {
+		return false
+	}
+	return q.Search == "" || matchesSearch(p, q.Search)
+}
+
+func matchesSearch(p Peer, search string) bool {
+	search = strings.ToLower(strings.TrimSpace(search))
+	fields := append([]string{p.Name, p.PublicKey, p.InterfaceAddress}, p.AllowedIPs...)
+	for _, f := range fields {
+		if strings.Contains(strings.ToLower(f), search) {
+			return true
+		}
+	}
+	addr, err := netip.ParseAddr(search)
+	if err != nil {
+		return false
+	}
+	for _, cidr := range p.AllowedIPs {
+		if prefix, err := netip.ParsePrefix(cidr); err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerOrder returns the comparison for a sort key and order. Ties are broken
+// by name and then ID, so the order is total and cursors are unambiguous.
+func peerOrder(sort, order string) (func(a, b Peer) int, error) {
+	var key func(a, b Peer) int
+	switch sort {
+	case PeerSortName:
+		key = func(a, b Peer) int { return 0 }
+	case PeerSortHandshake:
+		key = func(a, b Peer) int { return cmp.Compare(a.LastHandshakeUnix, b.LastHandshakeUnix) }
+	case PeerSortTraffic:
+		key = func(a, b Peer) int { return cmp.Compare(peerTraffic(a), peerTraffic(b)) }
+	default:
+		return nil, fmt.Errorf("%w: unknown sort key %s", ErrInvalidQuery, sort)
+	}
+	var sign int
+	switch order {
+	case OrderAsc:
+		sign = 1
+	case OrderDesc:
+		sign = -1
+	default:
+		return nil, fmt.Errorf("%w: unknown sort order %s", ErrInvalidQuery, order)
+	}
+	return func(a, b Peer) int {
+		if c := key(a, b); c != 0 {
+			return sign * c
+		}
+		if c := strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)); c != 0 {
+			return sign * c
+		}
+		return sign * strings.Compare(a.ID, b.ID)
+	}, nil
+}
+
+func peerTraffic(p Peer) int64 {
+	return p.ReceiveBytes + p.TransmitBytes
+}
+
+func encodePeerCursor(last Peer, sort, order string) string {
+	data, _ := json.Marshal(peerCursor{
+		Sort:      sort,
+		Order:     order,
+		Name:      last.Name,
+		Handshake: last.LastHandshakeUnix,
+		Traffic:   peerTraffic(last),
+		ID:        last.ID,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodePeerCursor returns a stand-in for the last peer of the previous page.
+// It fails with ErrInvalidQuery if the cursor is malformed or was made for
+// another sort.
+func decodePeerCursor(cursor, sort, order string) (Peer, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return Peer{}, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	var c peerCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == "" {
+		return Peer{}, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	if c.Sort != sort || c.Order != order {
+		return Peer{}, fmt.Errorf("%w: cursor was made for sort %s %s", ErrInvalidQuery, c.Sort, c.Order)
+	}
+	// Split the traffic between the counters; only the sum is compared.
+	return Peer{Name: c.Name, LastHandshakeUnix: c.Handshake, ReceiveBytes: c.Traffic, ID: c.ID}, nil
+}
+
+// QueryPeers lists the peers that q selects.
+func (s *realService) QueryPeers(q PeerQuery) (PeerPage, error) {
+	peers, err := s.ListPeers()
+	if err != nil {
+		return PeerPage{}, err
+	}
+	return QueryPeers(peers, q)
+}
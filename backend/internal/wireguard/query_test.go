@@ -0,0 +1,238 @@
+package wireguard
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestQueryPeers(t *testing.T) {
+	peers := []Peer{
+		{ID: "1", Name: "Laptop", PublicKey: "aaaKEY=", AllowedIPs: []string{"10.0.0.2/32"}, LastHandshakeUnix: 300, ReceiveBytes: 10, Status: PeerStatusOnline, Group: "staff"},
+		{ID: "2", Name: "phone", PublicKey: "bbbKEY=", AllowedIPs: []string{"10.0.0.3/32"}, InterfaceAddress: "fd00::3/64", LastHandshakeUnix: 100, ReceiveBytes: 500, Status: PeerStatusOffline, Group: "staff"},
+		{ID: "3", Name: "office", PublicKey: "cccKEY=", AllowedIPs: []string{"10.0.0.4/32", "192.168.10.0/24"}, LastHandshakeUnix: 200, TransmitBytes: 50, Status: PeerStatusOnline, Tags: []string{"site"}},
+		{ID: "4", Name: "contractor", PublicKey: "dddKEY=", AllowedIPs: []string{"10.0.0.5/32"}, Status: PeerStatusExpired},
+		{ID: "5", Name: "spare", PublicKey: "eeeKEY=", AllowedIPs: []string{"10.0.0.6/32"}, Status: PeerStatusDisabled},
+	}
+	names := func(page PeerPage) []string {
+		var got []string
+		for _, p := range page.Peers {
+			got = append(got, p.Name)
+		}
+		return got
+	}
+
+	t.Run("SearchAndFilter", func(t *testing.T) {
+		tests := []struct {
+			name  string
+			query PeerQuery
+			want  []string
+		}{
+			{"All", PeerQuery{}, []string{"contractor", "Laptop", "office", "phone", "spare"}},
+			{"NameIgnoresCase", PeerQuery{Search: "LAP"}, []string{"Laptop"}},
+			{"PublicKey", PeerQuery{Search: "bbbkey"}, []string{"phone"}},
+			{"Address", PeerQuery{Search: "10.0.0.4"}, []string{"office"}},
+			{"IPInRoutedPrefix", PeerQuery{Search: "192.168.10.77"}, []string{"office"}},
+			{"InterfaceAddress", PeerQuery{Search: "FD00::3"}, []string{"phone"}},
+			{"Status", PeerQuery{Status: PeerStatusOnline}, []string{"Laptop", "office"}},
+			{"GroupAndStatus", PeerQuery{Group: "staff", Status: PeerStatusOffline}, []string{"phone"}},
+			{"Tag", PeerQuery{Tag: "site"}, []string{"office"}},
+			{"NoMatch", PeerQuery{Search: "nope"}, nil},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				page, err := QueryPeers(peers, tt.query)
+				if err != nil {
+					t.Fatalf("QueryPeers failed: %v", err)
+				}
+				if got := names(page); !slices.Equal(got, tt.want) {
+					t.Errorf("expected %v, got %v", tt.want, got)
+				}
+				if page.Total != len(tt.want) {
+					t.Errorf("expected a total of %d, got %d", len(tt.want), page.Total)
+				}
+			})
+		}
+	})
+
+	t.Run("CountsIgnoreStatusFilter", func(t *testing.T) {
+		page, err := QueryPeers(peers, PeerQuery{Group: "staff", Status: PeerStatusOnline})
+		if err != nil {
+			t.Fatalf("QueryPeers failed: %v", err)
+		}
+		want := map[string]int{PeerStatusOnline: 1, PeerStatusOffline: 1, PeerStatusDisabled: 0, PeerStatusExpired: 0}
+		for status, n := range want {
+			if page.Counts[status] != n {
+				t.Errorf("expected %d %s, got %d", n, status, page.Counts[status])
+			}
+		}
+	})
+
+	t.Run("Sort", func(t *testing.T) {
+		tests := []struct {
+			query PeerQuery
+			want  []string
+		}{
+			{PeerQuery{Sort: PeerSortHandshake}, []string{"Laptop", "office", "phone", "spare", "contractor"}},
+			{PeerQuery{Sort: PeerSortHandshake, Order: OrderAsc}, []string{"contractor", "spare", "phone", "office", "Laptop"}},
+			{PeerQuery{Sort: PeerSortTraffic}, []string{"phone", "office", "Laptop", "spare", "contractor"}},
+			{PeerQuery{Order: OrderDesc}, []string{"spare", "phone", "office", "Laptop", "contractor"}},
+		}
+		for _, tt := range tests {
+			page, err := QueryPeers(peers, tt.query)
+			if err != nil {
+				t.Fatalf("QueryPeers failed: %v", err)
+			}
+			if got := names(page); !slices.Equal(got, tt.want) {
+				t.Errorf("%+v: expected %v, got %v", tt.query, tt.want, got)
+			}
+		}
+	})
+
+	t.Run("CursorPages", func(t *testing.T) {
+		query := PeerQuery{Sort: PeerSortTraffic, Limit: 2}
+		var got []string
+		for range 5 {
+			page, err := QueryPeers(peers, query)
+			if err != nil {
+				t.Fatalf("QueryPeers failed: %v", err)
+			}
+			if page.Total != len(peers) {
+				t.Errorf("expected every page to report a total of %d, got %d", len(peers), page.Total)
+			}
+			got = append(got, names(page)...)
+			if page.NextCursor == "" {
+				break
+			}
+			query.Cursor = page.NextCursor
+		}
+		if want := []string{"phone", "office", "Laptop", "spare", "contractor"}; !slices.Equal(got, want) {
+			t.Errorf("expected the pages to cover %v, got %v", want, got)
+		}
+	})
+
+	t.Run("CursorSurvivesRemoval", func(t *testing.T) {
+		first, err := QueryPeers(peers, PeerQuery{Limit: 2})
+		if err != nil {
+			t.Fatalf("QueryPeers failed: %v", err)
+		}
+		// Drop the last peer of the first page before fetching the next.
+		remaining := slices.DeleteFunc(slices.Clone(peers), func(p Peer) bool { return p.Name == "Laptop" })
+		next, err := QueryPeers(remaining, PeerQuery{Limit: 2, Cursor: first.NextCursor})
+		if err != nil {
+			t.Fatalf("QueryPeers failed: %v", err)
+		}
+		if want := []string{"office", "phone"}; !slices.Equal(names(next), want) {
+			t.Errorf("expected %v, got %v", want, names(next))
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		page, _ := QueryPeers(peers, PeerQuery{Limit: 1})
+		tests := []struct {
+			name  string
+			query PeerQuery
+		}{
+			{"Status", PeerQuery{Status: "sleeping"}},
+			{"Sort", PeerQuery{Sort: "age"}},
+			{"Order", PeerQuery{Order: "up"}},
+			{"MalformedCursor", PeerQuery{Cursor: "!!"}},
+			{"CursorForOtherSort", PeerQuery{Sort: PeerSortTraffic, Cursor: page.NextCursor}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if _, err := QueryPeers(peers, tt.query); !errors.Is(err, ErrInvalidQuery) {
+					t.Errorf("expected ErrInvalidQuery, got %v", err)
+				}
+			})
+		}
+	})
+	t.Run("StoredInterfaceAddress", func(t *testing.T) {
+		srv := newFakeService(t, newFakeDevice(t), filepath.Join(t.TempDir(), "peers.json"))
+		on, err := srv.AddPeer(AddPeerOptions{Name: "on", AllowedIPs: []string{"10.0.0.2/32"}, InterfaceAddress: "10.0.0.2/24"})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		if on.InterfaceAddress != "10.0.0.2/24" {
+			t.Errorf("expected the interface address in the response, got %q", on.InterfaceAddress)
+		}
+		// Off-device peers are listed from storage alone.
+		off, err := srv.AddPeer(AddPeerOptions{Name: "off", Claimable: true, AllowedIPs: []string{"10.0.0.3/32"}, InterfaceAddress: "10.0.0.3/24"})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		for search, want := range map[string]string{"10.0.0.2/24": on.ID, "10.0.0.3/24": off.ID} {
+			page, err := srv.QueryPeers(PeerQuery{Search: search})
+			if err != nil {
+				t.Fatalf("QueryPeers failed: %v", err)
+			}
+			if page.Total != 1 || page.Peers[0].ID != want {
+				t.Errorf("expected %s to find %s, got %+v", search, want, page.Peers)
+			}
+		}
+	})
+}
+
+func TestDisablePeer(t *testing.T) {
+	client := newFakeDevice(t)
+	srv := newFakeService(t, client, filepath.Join(t.TempDir(), "peers.json"))
+	peer, err := srv.AddPeer(AddPeerOptions{Name: "a", AllowedIPs: []string{"10.0.0.2/32"}})
+	if err != nil {
+		t.Fatalf("AddPeer failed: %v", err)
+	}
+
+	disabled := true
+	client.FailNextConfigure(fmt.Errorf("netlink: %w", syscall.ENODEV))
+	if _, err := srv.UpdatePeer(peer.ID, PeerUpdate{Disabled: &disabled}, 0); !errors.Is(err, ErrDeviceUnavailable) {
+		t.Fatalf("expected ErrDeviceUnavailable, got %v", err)
+	}
+	if got, _ := srv.GetPeer(peer.ID); got.Status == PeerStatusDisabled || devicePeer(t, client, peer.PublicKey) == nil {
+		t.Fatalf("expected a refused disable to leave the peer enabled, got %+v", got)
+	}
+
+	updated, err := srv.UpdatePeer(peer.ID, PeerUpdate{Disabled: &disabled}, 0)
+	if err != nil {
+		t.Fatalf("UpdatePeer failed: %v", err)
+	}
+	if updated.Status != PeerStatusDisabled {
+		t.Errorf("expected status %s, got %s", PeerStatusDisabled, updated.Status)
+	}
+	if devicePeer(t, client, peer.PublicKey) != nil {
+		t.Error("expected the disabled peer to be removed from the device")
+	}
+	if _, err := srv.RegeneratePeer(peer.ID, RegenerateOptions{}, 0); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict regenerating a disabled peer, got %v", err)
+	}
+	page, err := srv.QueryPeers(PeerQuery{Status: PeerStatusDisabled})
+	if err != nil {
+		t.Fatalf("QueryPeers failed: %v", err)
+	}
+	if page.Total != 1 || page.Peers[0].ID != peer.ID {
+		t.Errorf("expected the disabled peer to be listed, got %+v", page.Peers)
+	}
+
+	// Disabling wins over expiry, and re-enabling restores the peer.
+	past := time.Now().Add(-time.Hour).Unix()
+	if _, err := srv.UpdatePeer(peer.ID, PeerUpdate{Expires: &past}, 0); err != nil {
+		t.Fatalf("UpdatePeer failed: %v", err)
+	}
+	if got, _ := srv.GetPeer(peer.ID); got.Status != PeerStatusDisabled {
+		t.Errorf("expected status %s, got %s", PeerStatusDisabled, got.Status)
+	}
+	never := int64(0)
+	disabled = false
+	updated, err = srv.UpdatePeer(peer.ID, PeerUpdate{Disabled: &disabled, Expires: &never}, 0)
+	if err != nil {
+		t.Fatalf("UpdatePeer failed: %v", err)
+	}
+	if updated.Status != PeerStatusOffline {
+		t.Errorf("expected status %s, got %s", PeerStatusOffline, updated.Status)
+	}
+	if devicePeer(t, client, peer.PublicKey) == nil {
+		t.Error("expected re-enabling to put the peer back on the device")
+	}
+}
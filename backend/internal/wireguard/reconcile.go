@@ -0,0 +1,373 @@
+package wireguard
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Reconcile modes.
+const (
+	// ReconcileAdopt imports device-only peers into storage and updates stored
+	// AllowedIPs/PSK from the device. Storage-only peers are left alone.
+	ReconcileAdopt = "adopt"
+	// ReconcilePrune removes device-only peers from the device and deletes
+	// storage-only metadata. Mismatched peers are left alone.
+	ReconcilePrune = "prune"
+	// ReconcileStorageWins makes the device match storage exactly.
+	ReconcileStorageWins = "storage-wins"
+)
+
+// driftCheckInterval is how often the background reconciler looks for drift.
+const driftCheckInterval = 5 * time.Minute
+
+// DriftPeer is a peer present on only one side.
+type DriftPeer struct {
+	PublicKey  string   `json:"publicKey"`
+	Name       string   `json:"name,omitempty"`
+	AllowedIPs []string `json:"allowedIPs"`
+}
+
+// PeerMismatch is a peer present on both sides with differing configuration.
+type PeerMismatch struct {
+	PublicKey            string   `json:"publicKey"`
+	Name                 string   `json:"name,omitempty"`
+	StorageAllowedIPs    []string `json:"storageAllowedIPs"`
+	DeviceAllowedIPs     []string `json:"deviceAllowedIPs"`
+	AllowedIPsMismatch   bool     `json:"allowedIPsMismatch"`
+	PresharedKeyMismatch bool     `json:"presharedKeyMismatch"`
+}
+
+// DriftReport describes the differences between storage and the live device.
+type DriftReport struct {
+	InSync      bool           `json:"inSync"`
+	DeviceOnly  []DriftPeer    `json:"deviceOnly"`
+	StorageOnly []DriftPeer    `json:"storageOnly"`
+	Mismatched  []PeerMismatch `json:"mismatched"`
+}
+
+// ReconcileResult describes the drift found and what a reconcile run changed.
+type ReconcileResult struct {
+	Mode    string      `json:"mode"`
+	Drift   DriftReport `json:"drift"`
+	Actions []string    `json:"actions"`
+}
+
+// CheckDrift compares storage with the live device without changing either.
+func (s *realService) CheckDrift() (DriftReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	report, _, err := s.checkDrift()
+	return report, err
+}
+
+// Reconcile resolves drift between storage and the device using mode.
+func (s *realService) Reconcile(mode string) (ReconcileResult, error) {
+	switch mode {
+	case ReconcileAdopt, ReconcilePrune, ReconcileStorageWins:
+	default:
+		return ReconcileResult{}, invalidArgument("unknown reconcile mode: %s", mode)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report, device, err := s.checkDrift()
+	if err != nil {
+		return ReconcileResult{}, err
+	}
+	result := ReconcileResult{Mode: mode, Drift: report, Actions: []string{}}
+	if report.InSync {
+		return result, nil
+	}
+
+	switch mode {
+	case ReconcileAdopt:
+		result.Actions, err = s.adoptDrift(report, device)
+	case ReconcilePrune:
+		result.Actions, err = s.pruneDrift(report)
+	case ReconcileStorageWins:
+		result.Actions, err = s.enforceStorage(report, device)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	s.emit(EventReconciled, fmt.Sprintf("Reconciled drift using %q", mode), result)
+	return result, nil
+}
+
+// checkDrift builds a drift report. The caller must hold s.mu.
+func (s *realService) checkDrift() (DriftReport, *wgtypes.Device, error) {
+	device, err := s.client.Device(s.interfaceName)
+	if err != nil {
+		return DriftReport{}, nil, deviceError("failed to get device "+s.interfaceName, err)
+	}
+	now := time.Now()
+	stored := make(map[string]PeerMetadata)
+	for _, meta := range s.storage.ListMetadata() {
+		if meta.OnDevice(now) {
+			stored[meta.PublicKey] = meta
+		}
+	}
+
+	report := DriftReport{
+		DeviceOnly:  []DriftPeer{},
+		StorageOnly: []DriftPeer{},
+		Mismatched:  []PeerMismatch{},
+	}
+
+	pending := pendingKeys(stored)
+	onDevice := make(map[string]bool, len(device.Peers))
+	for _, p := range device.Peers {
+		key := p.PublicKey.String()
+		onDevice[key] = true
+		if pending[key] {
+			continue
+		}
+		deviceIPs := ipNetStrings(p.AllowedIPs)
+
+		meta, ok := stored[key]
+		if !ok {
+			report.DeviceOnly = append(report.DeviceOnly, DriftPeer{PublicKey: key, AllowedIPs: deviceIPs})
+			continue
+		}
+
+		storageIPs := meta.DeviceAllowedIPs()
+		ipsDiffer := !samePrefixes(storageIPs, deviceIPs)
+		pskDiffer := meta.PresharedKey != presharedKeyString(p.PresharedKey)
+		if ipsDiffer || pskDiffer {
+			report.Mismatched = append(report.Mismatched, PeerMismatch{
+				PublicKey:            key,
+				Name:                 meta.Name,
+				StorageAllowedIPs:    storageIPs,
+				DeviceAllowedIPs:     deviceIPs,
+				AllowedIPsMismatch:   ipsDiffer,
+				PresharedKeyMismatch: pskDiffer,
+			})
+		}
+	}
+
+	for key, meta := range stored {
+		if !onDevice[key] {
+			report.StorageOnly = append(report.StorageOnly, DriftPeer{PublicKey: key, Name: meta.Name, AllowedIPs: meta.DeviceAllowedIPs()})
+		}
+	}
+	// Sorted so that unchanged drift gives an identical report.
+	slices.SortFunc(report.DeviceOnly, func(a, b DriftPeer) int { return strings.Compare(a.PublicKey, b.PublicKey) })
+	slices.SortFunc(report.StorageOnly, func(a, b DriftPeer) int { return strings.Compare(a.PublicKey, b.PublicKey) })
+	slices.SortFunc(report.Mismatched, func(a, b PeerMismatch) int { return strings.Compare(a.PublicKey, b.PublicKey) })
+
+	report.InSync = len(report.DeviceOnly) == 0 && len(report.StorageOnly) == 0 && len(report.Mismatched) == 0
+	return report, device, nil
+}
+
+// adoptDrift records the device's view of unmanaged and mismatched peers in storage.
+func (s *realService) adoptDrift(report DriftReport, device *wgtypes.Device) ([]string, error) {
+	devicePeers := make(map[string]wgtypes.Peer, len(device.Peers))
+	for _, p := range device.Peers {
+		devicePeers[p.PublicKey.String()] = p
+	}
+
+	actions := []string{}
+	for _, d := range report.DeviceOnly {
+		meta := PeerMetadata{
+			ID:           NewPeerID(),
+			PublicKey:    d.PublicKey,
+			Name:         "adopted " + d.PublicKey[:8],
+			AllowedIPs:   d.AllowedIPs,
+			PresharedKey: presharedKeyString(devicePeers[d.PublicKey].PresharedKey),
+		}
+		if err := s.storage.SetMetadata(meta.ID, meta); err != nil {
+			return actions, fmt.Errorf("failed to adopt peer %s: %w", d.PublicKey, err)
+		}
+		actions = append(actions, "adopted "+d.PublicKey)
+	}
+
+	for _, m := range report.Mismatched {
+		meta, _ := s.storage.LookupPublicKey(m.PublicKey)
+		// Advertised site networks stay separate from the tunnel addresses.
+		meta.AllowedIPs = slices.DeleteFunc(slices.Clone(m.DeviceAllowedIPs), func(ip string) bool {
+			return slices.Contains(meta.Networks, ip)
+		})
+		meta.PresharedKey = presharedKeyString(devicePeers[m.PublicKey].PresharedKey)
+		if err := s.storage.SetMetadata(meta.ID, meta); err != nil {
+			return actions, fmt.Errorf("failed to update peer %s: %w", m.PublicKey, err)
+		}
+		actions = append(actions, "updated storage for "+m.PublicKey)
+	}
+	return actions, nil
+}
+
+// pruneDrift removes peers that exist on only one side.
+func (s *realService) pruneDrift(report DriftReport) ([]string, error) {
+	actions := []string{}
+
+	var removals []wgtypes.PeerConfig
+	for _, d := range report.DeviceOnly {
+		key, err := wgtypes.ParseKey(d.PublicKey)
+		if err != nil {
+			return actions, fmt.Errorf("invalid public key on device: %w", err)
+		}
+		removals = append(removals, wgtypes.PeerConfig{PublicKey: key, Remove: true})
+	}
+	if len(removals) > 0 {
+		if err := s.client.ConfigureDevice(s.interfaceName, wgtypes.Config{Peers: removals}); err != nil {
+			return actions, deviceError("failed to prune device peers", err)
+		}
+		for _, d := range report.DeviceOnly {
+			actions = append(actions, "removed from device "+d.PublicKey)
+		}
+	}
+
+	for _, d := range report.StorageOnly {
+		meta, _ := s.storage.LookupPublicKey(d.PublicKey)
+		if err := s.storage.DeleteMetadata(meta.ID); err != nil {
+			return actions, fmt.Errorf("failed to delete metadata for %s: %w", d.PublicKey, err)
+		}
+		actions = append(actions, "deleted from storage "+d.PublicKey)
+	}
+	return actions, nil
+}
+
+// enforceStorage pushes storage onto the device, removing anything else.
+// Pending keys of an overlap window are kept, and put back if the device
+// lost them.
+func (s *realService) enforceStorage(report DriftReport, device *wgtypes.Device) ([]string, error) {
+	actions := []string{}
+	var peers []wgtypes.PeerConfig
+
+	for _, d := range report.DeviceOnly {
+		key, err := wgtypes.ParseKey(d.PublicKey)
+		if err != nil {
+			return actions, fmt.Errorf("invalid public key on device: %w", err)
+		}
+		peers = append(peers, wgtypes.PeerConfig{PublicKey: key, Remove: true})
+		actions = append(actions, "removed from device "+d.PublicKey)
+	}
+
+	restore := make([]string, 0, len(report.StorageOnly)+len(report.Mismatched))
+	for _, d := range report.StorageOnly {
+		restore = append(restore, d.PublicKey)
+	}
+	for _, m := range report.Mismatched {
+		restore = append(restore, m.PublicKey)
+	}
+	for _, key := range restore {
+		meta, _ := s.storage.LookupPublicKey(key)
+		pc, err := devicePeerConfig(key, meta)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, pc)
+		actions = append(actions, "restored on device "+key)
+	}
+
+	// checkDrift skips pending keys, so compare them here: the device must
+	// hold each one, without AllowedIPs and with its preshared key.
+	devicePeers := make(map[string]wgtypes.Peer, len(device.Peers))
+	for _, p := range device.Peers {
+		devicePeers[p.PublicKey.String()] = p
+	}
+	now := time.Now()
+	for _, meta := range s.storage.ListMetadata() {
+		if meta.PendingKey == nil || !meta.OnDevice(now) {
+			continue
+		}
+		key := meta.PendingKey.PublicKey
+		if p, ok := devicePeers[key]; ok && len(p.AllowedIPs) == 0 && presharedKeyString(p.PresharedKey) == meta.PendingKey.PresharedKey {
+			continue
+		}
+		pc, err := pendingPeerConfig(meta)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, pc)
+		actions = append(actions, "restored pending key on device "+key)
+	}
+
+	if err := s.client.ConfigureDevice(s.interfaceName, wgtypes.Config{Peers: peers}); err != nil {
+		return nil, deviceError("failed to apply storage to device", err)
+	}
+	return actions, nil
+}
+
+// watchDrift periodically checks for drift and emits an event when found.
+func (s *realService) watchDrift() {
+	ticker := time.NewTicker(driftCheckInterval)
+	defer ticker.Stop()
+
+	s.beat(collectorDrift)
+	var last DriftReport
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+			s.beat(collectorDrift)
+			last = s.reportDrift(last)
+		}
+	}
+}
+
+// reportDrift checks for drift and emits EventDriftDetected, unless the
+// drift is the same as in last, the previous report. It returns the report
+// to pass next time.
+func (s *realService) reportDrift(last DriftReport) DriftReport {
+	report, err := s.CheckDrift()
+	if err != nil {
+		slog.Error("Failed to check for drift", "error", err)
+		return last
+	}
+	if !report.InSync && !reflect.DeepEqual(report, last) {
+		slog.Warn("Drift detected between storage and device",
+			"deviceOnly", len(report.DeviceOnly),
+			"storageOnly", len(report.StorageOnly),
+			"mismatched", len(report.Mismatched),
+		)
+		s.emit(EventDriftDetected, "Storage and device are out of sync", report)
+	}
+	return report
+}
+
+// ipNetStrings formats device AllowedIPs as CIDR strings.
+func ipNetStrings(ips []net.IPNet) []string {
+	out := make([]string, len(ips))
+	for i, ip := range ips {
+		out[i] = ip.String()
+	}
+	return out
+}
+
+// samePrefixes reports whether two CIDR lists describe the same set of prefixes.
+func samePrefixes(a, b []string) bool {
+	norm := func(list []string) []string {
+		out := make([]string, 0, len(list))
+		for _, c := range list {
+			if p, err := netip.ParsePrefix(c); err == nil {
+				c = p.Masked().String()
+			}
+			if !slices.Contains(out, c) {
+				out = append(out, c)
+			}
+		}
+		slices.Sort(out)
+		return out
+	}
+	return slices.Equal(norm(a), norm(b))
+}
+
+// presharedKeyString returns the base64 form of a device PSK, or "" if unset.
+func presharedKeyString(k wgtypes.Key) string {
+	if k == (wgtypes.Key{}) {
+		return ""
+	}
+	return k.String()
+}
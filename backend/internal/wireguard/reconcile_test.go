@@ -0,0 +1,55 @@
+package wireguard
+
+import (
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestSamePrefixes(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want bool
+	}{
+		{"Equal", []string{"10.0.0.2/32"}, []string{"10.0.0.2/32"}, true},
+		{"Order", []string{"10.0.0.2/32", "10.0.0.3/32"}, []string{"10.0.0.3/32", "10.0.0.2/32"}, true},
+		{"Unmasked", []string{"192.168.50.1/24"}, []string{"192.168.50.0/24"}, true},
+		{"Different", []string{"10.0.0.2/32"}, []string{"10.0.0.4/32"}, false},
+		{"Missing", []string{"10.0.0.2/32", "192.168.50.0/24"}, []string{"10.0.0.2/32"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := samePrefixes(tt.a, tt.b); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDevicePeerConfig(t *testing.T) {
+	key, _ := wgtypes.GeneratePrivateKey()
+	psk, _ := wgtypes.GenerateKey()
+	meta := PeerMetadata{
+		AllowedIPs:   []string{"10.0.0.50/32"},
+		Networks:     []string{"192.168.50.0/24"},
+		PresharedKey: psk.String(),
+	}
+
+	pc, err := devicePeerConfig(key.PublicKey().String(), meta)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(pc.AllowedIPs) != 2 || !pc.ReplaceAllowedIPs {
+		t.Errorf("expected tunnel address and network to replace AllowedIPs, got %+v", pc)
+	}
+	if pc.PresharedKey == nil || *pc.PresharedKey != psk {
+		t.Errorf("expected preshared key to be restored, got %v", pc.PresharedKey)
+	}
+
+	meta.PresharedKey = ""
+	pc, _ = devicePeerConfig(key.PublicKey().String(), meta)
+	if pc.PresharedKey == nil || *pc.PresharedKey != (wgtypes.Key{}) {
+		t.Errorf("expected zero preshared key to clear a stale one, got %v", pc.PresharedKey)
+	}
+}
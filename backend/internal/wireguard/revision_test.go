@@ -0,0 +1,166 @@
+package wireguard
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPeerRevisions(t *testing.T) {
+	storagePath := filepath.Join(t.TempDir(), "peers.json")
+	srv := newFakeService(t, newFakeDevice(t), storagePath)
+
+	resp, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+	if err != nil {
+		t.Fatalf("AddPeer failed: %v", err)
+	}
+	if resp.Revision != 1 {
+		t.Fatalf("expected a new peer at revision 1, got %d", resp.Revision)
+	}
+
+	t.Run("UpdateBumpsRevision", func(t *testing.T) {
+		name := "work laptop"
+		peer, err := srv.UpdatePeer(resp.ID, PeerUpdate{Name: &name}, 1)
+		if err != nil {
+			t.Fatalf("UpdatePeer failed: %v", err)
+		}
+		if peer.Revision != 2 {
+			t.Errorf("expected revision 2, got %d", peer.Revision)
+		}
+	})
+
+	t.Run("StaleRevisionRejected", func(t *testing.T) {
+		name := "clobbered"
+		if _, err := srv.UpdatePeer(resp.ID, PeerUpdate{Name: &name}, 1); !errors.Is(err, ErrPreconditionFailed) {
+			t.Errorf("expected ErrPreconditionFailed from UpdatePeer, got %v", err)
+		}
+		if err := srv.RemovePeer(resp.ID, 1); !errors.Is(err, ErrPreconditionFailed) {
+			t.Errorf("expected ErrPreconditionFailed from RemovePeer, got %v", err)
+		}
+		if _, err := srv.RegeneratePeer(resp.ID, RegenerateOptions{}, 1); !errors.Is(err, ErrPreconditionFailed) {
+			t.Errorf("expected ErrPreconditionFailed from RegeneratePeer, got %v", err)
+		}
+		if peer, _ := srv.GetPeer(resp.ID); peer.Name != "work laptop" {
+			t.Errorf("expected peer untouched, got %+v", peer)
+		}
+	})
+
+	t.Run("ConfigDownloadKeepsRevision", func(t *testing.T) {
+		if _, err := srv.GetPeerConfig(resp.ID); err != nil {
+			t.Fatalf("GetPeerConfig failed: %v", err)
+		}
+		if peer, _ := srv.GetPeer(resp.ID); peer.Revision != 2 {
+			t.Errorf("expected revision 2 after download, got %d", peer.Revision)
+		}
+	})
+
+	t.Run("LegacyStoreStartsAtOne", func(t *testing.T) {
+		storage, err := NewStorage(filepath.Join(t.TempDir(), "peers.json"))
+		if err != nil {
+			t.Fatalf("NewStorage failed: %v", err)
+		}
+		storage.data.Peers["legacy"] = PeerMetadata{Name: "legacy"}
+		storage.data.Settings.Revision = 0
+		if err := storage.save(); err != nil {
+			t.Fatalf("save failed: %v", err)
+		}
+
+		reloaded, err := NewStorage(storage.path)
+		if err != nil {
+			t.Fatalf("NewStorage failed: %v", err)
+		}
+		if meta, _ := reloaded.LookupPublicKey("legacy"); meta.Revision != 1 {
+			t.Errorf("expected legacy peer at revision 1, got %d", meta.Revision)
+		}
+		if reloaded.GetSettings().Revision != 1 {
+			t.Errorf("expected legacy settings at revision 1, got %d", reloaded.GetSettings().Revision)
+		}
+	})
+
+	t.Run("RemoveWithCurrentRevision", func(t *testing.T) {
+		if err := srv.RemovePeer(resp.ID, 2); err != nil {
+			t.Fatalf("RemovePeer failed: %v", err)
+		}
+	})
+}
+
+// TestConcurrentConfigDownload checks that a download, which records the
+// config it hands out, is serialised with peer updates. Run with -race to
+// also catch unsynchronised access.
+func TestConcurrentConfigDownload(t *testing.T) {
+	srv := newFakeService(t, newFakeDevice(t), filepath.Join(t.TempDir(), "peers.json"))
+	resp, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+	if err != nil {
+		t.Fatalf("AddPeer failed: %v", err)
+	}
+
+	t.Run("WaitsForUpdates", func(t *testing.T) {
+		live := srv.(*realService)
+		live.mu.Lock()
+		done := make(chan string)
+		go func() {
+			config, err := srv.GetPeerConfig(resp.ID)
+			if err != nil {
+				t.Errorf("GetPeerConfig failed: %v", err)
+			}
+			done <- config
+		}()
+		select {
+		case <-done:
+			live.mu.Unlock()
+			t.Fatal("expected the download to wait for the update in progress")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		// Finish the "update" while the download waits.
+		meta, _ := live.storage.GetMetadata(resp.ID)
+		meta.DNS = "10.9.9.9"
+		if err := live.storage.SetMetadata(resp.ID, meta); err != nil {
+			t.Fatalf("SetMetadata failed: %v", err)
+		}
+		live.mu.Unlock()
+
+		config := <-done
+		if !strings.Contains(config, "DNS = 10.9.9.9") {
+			t.Errorf("expected the config after the update, got:\n%s", config)
+		}
+		if meta, _ := srv.GetPeerMetadata(resp.ID); meta.IssuedConfig != config {
+			t.Errorf("expected the downloaded config recorded, got:\n%s", meta.IssuedConfig)
+		}
+	})
+
+	t.Run("Race", func(t *testing.T) {
+		for i := range 50 {
+			var wg sync.WaitGroup
+			var config string
+			var downloadErr, updateErr error
+			start := make(chan struct{})
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				<-start
+				config, downloadErr = srv.GetPeerConfig(resp.ID)
+			}()
+			go func() {
+				defer wg.Done()
+				<-start
+				dns := fmt.Sprintf("10.0.0.%d", i+1)
+				_, updateErr = srv.UpdatePeer(resp.ID, PeerUpdate{DNS: &dns}, 0)
+			}()
+			close(start)
+			wg.Wait()
+
+			if downloadErr != nil || updateErr != nil {
+				t.Fatalf("expected both calls to succeed, got %v and %v", downloadErr, updateErr)
+			}
+			// Whichever ran first, the recorded config is the one handed out.
+			if meta, _ := srv.GetPeerMetadata(resp.ID); meta.IssuedConfig != config {
+				t.Fatalf("round %d: expected the downloaded config recorded, got:\n%s", i, meta.IssuedConfig)
+			}
+		}
+	})
+}
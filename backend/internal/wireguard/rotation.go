@@ -0,0 +1,294 @@
+package wireguard
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// MaxKeyOverlap bounds how long an old key may stay valid after regeneration.
+const MaxKeyOverlap = 7 * 24 * time.Hour
+
+// rotationCheckInterval is how often pending keys are checked for a first
+// handshake or expiry.
+const rotationCheckInterval = 10 * time.Second
+
+// RegenerateOptions controls key regeneration.
+type RegenerateOptions struct {
+	// Overlap keeps the old key working after regeneration until the new key
+	// completes a handshake or Overlap elapses, whichever comes first, so a
+	// client can switch over without downtime. Zero switches immediately.
+	Overlap time.Duration
+}
+
+// PendingKey is a regenerated key waiting for cutover during an overlap
+// window. It is on the device without AllowedIPs, so it can handshake while
+// the old key keeps routing traffic.
+type PendingKey struct {
+	PublicKey    string `json:"publicKey"`
+	PrivateKey   string `json:"privateKey,omitempty"`
+	PresharedKey string `json:"presharedKey,omitempty"`
+	Expires      int64  `json:"expires"` // Unix seconds
+}
+
+// RegeneratePeer issues new keys for a peer. Without an overlap the new key
+// replaces the old one in a single device call, and storage is only updated
+// once the device accepted it. A non-zero ifRevision must match the peer's
+// current revision.
+func (s *realService) RegeneratePeer(id string, opts RegenerateOptions, ifRevision int64) (PeerResponse, error) {
+	if opts.Overlap < 0 || opts.Overlap > MaxKeyOverlap {
+		return PeerResponse{}, invalidArgument("overlap must be between 0 and %s", MaxKeyOverlap)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkPeerRevision(id, ifRevision); err != nil {
+		return PeerResponse{}, err
+	}
+	meta, err := s.rotationMetadata(id)
+	if err != nil {
+		return PeerResponse{}, err
+	}
+	if meta.Unclaimed() {
+		return PeerResponse{}, fmt.Errorf("%w: peer %s has not been claimed", ErrConflict, id)
+	}
+	if reason := meta.inactive(time.Now()); reason != "" {
+		return PeerResponse{}, fmt.Errorf("%w: peer %s %s", ErrConflict, id, reason)
+	}
+
+	keys, err := GenerateKeyPair()
+	if err != nil {
+		return PeerResponse{}, fmt.Errorf("failed to generate key pair: %w", err)
+	}
+	key := PendingKey{PublicKey: keys.PublicKey, PrivateKey: s.keptPrivateKey(keys.PrivateKey)}
+	if meta.PresharedKey != "" {
+		if key.PresharedKey, err = GeneratePresharedKey(); err != nil {
+			return PeerResponse{}, fmt.Errorf("failed to generate preshared key: %w", err)
+		}
+	}
+
+	if opts.Overlap > 0 {
+		key.Expires = time.Now().Add(opts.Overlap).Unix()
+		stored, err := s.startOverlap(meta, key)
+		if err != nil {
+			return PeerResponse{}, err
+		}
+		return s.issuedResponse(stored, keys.PrivateKey)
+	}
+
+	next, err := s.commitRotation(meta, key)
+	if err != nil {
+		return PeerResponse{}, err
+	}
+	return s.issuedResponse(next, keys.PrivateKey)
+}
+
+// rotationMetadata returns the stored metadata of a peer, or metadata with a
+// new ID built from the device for a peer that only exists there.
+func (s *realService) rotationMetadata(id string) (PeerMetadata, error) {
+	if meta, ok := s.storedPeer(id); ok {
+		return meta, nil
+	}
+	pubKey, err := wgtypes.ParseKey(id)
+	if err != nil {
+		return PeerMetadata{}, peerNotFound(id)
+	}
+
+	device, err := s.client.Device(s.interfaceName)
+	if err != nil {
+		return PeerMetadata{}, deviceError("failed to get device "+s.interfaceName, err)
+	}
+	for _, p := range device.Peers {
+		if p.PublicKey == pubKey {
+			return PeerMetadata{
+				ID:           NewPeerID(),
+				PublicKey:    id,
+				AllowedIPs:   ipNetStrings(p.AllowedIPs),
+				PresharedKey: presharedKeyString(p.PresharedKey),
+			}, nil
+		}
+	}
+	return PeerMetadata{}, peerNotFound(id)
+}
+
+// commitRotation moves a peer from its current key to key. The new key takes
+// over the AllowedIPs and the old key (and any other pending key) is removed
+// in one device call; storage is rewritten only after the device accepted it,
+// and the device is restored if storage fails. The caller must hold s.mu.
+func (s *realService) commitRotation(meta PeerMetadata, key PendingKey) (PeerMetadata, error) {
+	next := meta
+	next.PublicKey, next.PrivateKey, next.PresharedKey = key.PublicKey, key.PrivateKey, key.PresharedKey
+	next.PendingKey = nil
+	config, err := s.renderPeerConfig(next)
+	if err != nil {
+		return PeerMetadata{}, fmt.Errorf("failed to render config: %w", err)
+	}
+	next.IssuedConfig = config
+
+	newConfig, err := devicePeerConfig(next.PublicKey, next)
+	if err != nil {
+		return PeerMetadata{}, err
+	}
+	oldPub, err := wgtypes.ParseKey(meta.PublicKey)
+	if err != nil {
+		return PeerMetadata{}, invalidKey("public key", err)
+	}
+	peers := []wgtypes.PeerConfig{newConfig, {PublicKey: oldPub, Remove: true}}
+	if meta.PendingKey != nil && meta.PendingKey.PublicKey != key.PublicKey {
+		if stale, err := wgtypes.ParseKey(meta.PendingKey.PublicKey); err == nil {
+			peers = append(peers, wgtypes.PeerConfig{PublicKey: stale, Remove: true})
+		}
+	}
+
+	if err := s.client.ConfigureDevice(s.interfaceName, wgtypes.Config{Peers: peers}); err != nil {
+		s.rollbackRotation(meta, key.PublicKey)
+		return PeerMetadata{}, deviceError("failed to rotate peer key", err)
+	}
+	if err := s.storage.SetMetadata(next.ID, next); err != nil {
+		s.rollbackRotation(meta, key.PublicKey)
+		return PeerMetadata{}, fmt.Errorf("failed to store regenerated peer: %w", err)
+	}
+
+	stored, _ := s.storage.GetMetadata(next.ID)
+	s.emit(EventKeyRotated, fmt.Sprintf("Peer %q switched to a new key", next.Name), AffectedPeer{ID: next.ID, Name: next.Name})
+	return stored, nil
+}
+
+// startOverlap adds key to the device without AllowedIPs and records it as
+// the peer's pending key. The caller must hold s.mu.
+func (s *realService) startOverlap(meta PeerMetadata, key PendingKey) (PeerMetadata, error) {
+	previous := meta.PendingKey
+	meta.PendingKey = &key
+
+	pending, err := pendingPeerConfig(meta)
+	if err != nil {
+		return PeerMetadata{}, err
+	}
+	peers := []wgtypes.PeerConfig{pending}
+	if previous != nil {
+		if stale, err := wgtypes.ParseKey(previous.PublicKey); err == nil {
+			peers = append(peers, wgtypes.PeerConfig{PublicKey: stale, Remove: true})
+		}
+	}
+
+	if err := s.client.ConfigureDevice(s.interfaceName, wgtypes.Config{Peers: peers}); err != nil {
+		meta.PendingKey = previous
+		s.rollbackRotation(meta, key.PublicKey)
+		return PeerMetadata{}, deviceError("failed to add pending key", err)
+	}
+	if err := s.storage.SetMetadata(meta.ID, meta); err != nil {
+		meta.PendingKey = previous
+		s.rollbackRotation(meta, key.PublicKey)
+		return PeerMetadata{}, fmt.Errorf("failed to store pending key: %w", err)
+	}
+
+	stored, _ := s.storage.GetMetadata(meta.ID)
+	return stored, nil
+}
+
+// rollbackRotation restores the device to meta after a failed rotation to
+// newKey. It is best effort: a failure is logged and left for drift
+// detection to report.
+func (s *realService) rollbackRotation(meta PeerMetadata, newKey string) {
+	restore, err := devicePeerConfig(meta.PublicKey, meta)
+	if err != nil {
+		slog.Error("Failed to build rollback for key rotation", "peer", meta.ID, "error", err)
+		return
+	}
+	peers := []wgtypes.PeerConfig{restore}
+	if meta.PendingKey != nil && meta.PendingKey.PublicKey == newKey {
+		// The key was already pending; put it back without AllowedIPs.
+		if pending, err := pendingPeerConfig(meta); err == nil {
+			peers = append(peers, pending)
+		}
+	} else if pub, err := wgtypes.ParseKey(newKey); err == nil {
+		peers = append(peers, wgtypes.PeerConfig{PublicKey: pub, Remove: true})
+	}
+
+	if err := s.client.ConfigureDevice(s.interfaceName, wgtypes.Config{Peers: peers}); err != nil {
+		slog.Error("Failed to roll back key rotation", "peer", meta.ID, "newKey", newKey, "error", err)
+	}
+}
+
+// pendingPeerConfig is the device configuration of a peer's pending key:
+// the peer's settings without any AllowedIPs.
+func pendingPeerConfig(meta PeerMetadata) (wgtypes.PeerConfig, error) {
+	pending := meta
+	pending.AllowedIPs, pending.Networks = nil, nil
+	pending.PresharedKey = meta.PendingKey.PresharedKey
+	return devicePeerConfig(meta.PendingKey.PublicKey, pending)
+}
+
+// configMetadata returns the metadata to render a client config from. During
+// an overlap window that is the pending key, so new downloads use it.
+func configMetadata(meta PeerMetadata) PeerMetadata {
+	if meta.PendingKey != nil {
+		meta.PrivateKey = meta.PendingKey.PrivateKey
+		meta.PresharedKey = meta.PendingKey.PresharedKey
+	}
+	return meta
+}
+
+// pendingKeys returns the pending public keys of stored peers.
+func pendingKeys(stored map[string]PeerMetadata) map[string]bool {
+	keys := map[string]bool{}
+	for _, meta := range stored {
+		if meta.PendingKey != nil {
+			keys[meta.PendingKey.PublicKey] = true
+		}
+	}
+	return keys
+}
+
+// watchRotations completes overlap windows in the background.
+func (s *realService) watchRotations() {
+	ticker := time.NewTicker(rotationCheckInterval)
+	defer ticker.Stop()
+
+	s.beat(collectorRotations)
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case now := <-ticker.C:
+			s.beat(collectorRotations)
+			s.completeRotations(now)
+		}
+	}
+}
+
+// completeRotations switches every peer whose pending key has completed a
+// handshake, or whose overlap window has ended, to that key.
+func (s *realService) completeRotations(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := s.storage.ListMetadata()
+	if len(pendingKeys(stored)) == 0 {
+		return
+	}
+	device, err := s.client.Device(s.interfaceName)
+	if err != nil {
+		slog.Error("Failed to check pending keys", "error", err)
+		return
+	}
+	handshaken := map[string]bool{}
+	for _, p := range device.Peers {
+		handshaken[p.PublicKey.String()] = !p.LastHandshakeTime.IsZero()
+	}
+
+	for id, meta := range stored {
+		if meta.PendingKey == nil || !meta.OnDevice(now) {
+			continue
+		}
+		if !handshaken[meta.PendingKey.PublicKey] && now.Unix() < meta.PendingKey.Expires {
+			continue
+		}
+		if _, err := s.commitRotation(meta, *meta.PendingKey); err != nil {
+			slog.Error("Failed to complete key rotation", "peer", id, "error", err)
+		}
+	}
+}
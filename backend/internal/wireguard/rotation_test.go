@@ -0,0 +1,172 @@
+package wireguard
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRegeneratePeer(t *testing.T) {
+	t.Run("SingleDeviceCall", func(t *testing.T) {
+		client := newFakeDevice(t)
+		srv := newFakeService(t, client, filepath.Join(t.TempDir(), "peers.json"))
+		resp, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}, PreSharedKey: true})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+
+		calls := client.ConfigureCalls()
+		regen, err := srv.RegeneratePeer(resp.ID, RegenerateOptions{}, resp.Revision)
+		if err != nil {
+			t.Fatalf("RegeneratePeer failed: %v", err)
+		}
+		if got := client.ConfigureCalls() - calls; got != 1 {
+			t.Errorf("expected 1 ConfigureDevice call, got %d", got)
+		}
+		if regen.Revision != resp.Revision+1 {
+			t.Errorf("expected revision %d, got %d", resp.Revision+1, regen.Revision)
+		}
+		if regen.PresharedKey == "" || regen.PresharedKey == resp.PresharedKey {
+			t.Errorf("expected a new preshared key, got %q", regen.PresharedKey)
+		}
+		if !strings.Contains(regen.Config, regen.PrivateKey) {
+			t.Error("expected the config to carry the new private key")
+		}
+		if p := devicePeer(t, client, regen.PublicKey); p == nil || p.PresharedKey.String() != regen.PresharedKey {
+			t.Errorf("expected the new key on the device with its preshared key, got %+v", p)
+		}
+	})
+
+	t.Run("DeviceFailureKeepsPeer", func(t *testing.T) {
+		client := newFakeDevice(t)
+		srv := newFakeService(t, client, filepath.Join(t.TempDir(), "peers.json"))
+		resp, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+
+		client.FailNextConfigure(fmt.Errorf("netlink: %w", syscall.ECONNREFUSED))
+		if _, err := srv.RegeneratePeer(resp.ID, RegenerateOptions{}, 0); !errors.Is(err, ErrDeviceUnavailable) {
+			t.Fatalf("expected ErrDeviceUnavailable, got %v", err)
+		}
+		meta, ok := srv.GetPeerMetadata(resp.ID)
+		if !ok || meta.Revision != resp.Revision || meta.PrivateKey != resp.PrivateKey {
+			t.Errorf("expected stored peer untouched, got %+v", meta)
+		}
+		if p := devicePeer(t, client, resp.PublicKey); p == nil || len(p.AllowedIPs) != 1 {
+			t.Errorf("expected old key still routing on the device, got %+v", p)
+		}
+		if peers, _ := srv.ListPeers(); len(peers) != 1 {
+			t.Errorf("expected only the old peer on the device, got %+v", peers)
+		}
+	})
+
+	t.Run("OverlapCompletesOnHandshake", func(t *testing.T) {
+		client := newFakeDevice(t)
+		srv := newFakeService(t, client, filepath.Join(t.TempDir(), "peers.json"))
+		resp, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+
+		regen, err := srv.RegeneratePeer(resp.ID, RegenerateOptions{Overlap: time.Hour}, 0)
+		if err != nil {
+			t.Fatalf("RegeneratePeer failed: %v", err)
+		}
+		if regen.ID != resp.ID || regen.PendingPublicKey == "" {
+			t.Fatalf("expected the old key with a pending key, got %+v", regen.Peer)
+		}
+		if !strings.Contains(regen.Config, regen.PrivateKey) {
+			t.Error("expected the config to carry the pending private key")
+		}
+		if p := devicePeer(t, client, resp.PublicKey); p == nil || len(p.AllowedIPs) != 1 {
+			t.Errorf("expected old key still routing, got %+v", p)
+		}
+		if p := devicePeer(t, client, regen.PendingPublicKey); p == nil || len(p.AllowedIPs) != 0 {
+			t.Errorf("expected pending key on the device without AllowedIPs, got %+v", p)
+		}
+		if peers, _ := srv.ListPeers(); len(peers) != 1 || peers[0].PendingPublicKey != regen.PendingPublicKey {
+			t.Errorf("expected one listed peer with the pending key, got %+v", peers)
+		}
+		if report, _ := srv.CheckDrift(); !report.InSync {
+			t.Errorf("expected the pending key not to count as drift, got %+v", report)
+		}
+
+		real := srv.(*realService)
+		real.completeRotations(time.Now())
+		if devicePeer(t, client, resp.PublicKey) == nil {
+			t.Fatal("expected no cutover before a handshake")
+		}
+
+		if err := client.SetPeerStats("wg0", mustParseKey(t, regen.PendingPublicKey), time.Now(), 0, 0); err != nil {
+			t.Fatalf("SetPeerStats failed: %v", err)
+		}
+		real.completeRotations(time.Now())
+		if devicePeer(t, client, resp.PublicKey) != nil {
+			t.Error("expected old key removed after the handshake")
+		}
+		if p := devicePeer(t, client, regen.PendingPublicKey); p == nil || len(p.AllowedIPs) != 1 {
+			t.Errorf("expected new key to carry the AllowedIPs, got %+v", p)
+		}
+		meta, ok := srv.GetPeerMetadata(regen.PendingPublicKey)
+		if !ok || meta.PendingKey != nil || meta.PrivateKey != regen.PrivateKey {
+			t.Errorf("expected stored peer moved to the new key, got %+v", meta)
+		}
+	})
+
+	t.Run("OverlapExpires", func(t *testing.T) {
+		client := newFakeDevice(t)
+		srv := newFakeService(t, client, filepath.Join(t.TempDir(), "peers.json"))
+		resp, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		regen, err := srv.RegeneratePeer(resp.ID, RegenerateOptions{Overlap: time.Minute}, 0)
+		if err != nil {
+			t.Fatalf("RegeneratePeer failed: %v", err)
+		}
+
+		srv.(*realService).completeRotations(time.Now().Add(2 * time.Minute))
+		if devicePeer(t, client, resp.PublicKey) != nil {
+			t.Error("expected old key removed after the overlap expired")
+		}
+		if _, ok := srv.GetPeerMetadata(regen.PendingPublicKey); !ok {
+			t.Error("expected the peer stored under the new key")
+		}
+	})
+
+	t.Run("RemoveDuringOverlap", func(t *testing.T) {
+		client := newFakeDevice(t)
+		srv := newFakeService(t, client, filepath.Join(t.TempDir(), "peers.json"))
+		resp, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		regen, err := srv.RegeneratePeer(resp.ID, RegenerateOptions{Overlap: time.Hour}, 0)
+		if err != nil {
+			t.Fatalf("RegeneratePeer failed: %v", err)
+		}
+
+		if err := srv.RemovePeer(resp.ID, 0); err != nil {
+			t.Fatalf("RemovePeer failed: %v", err)
+		}
+		if devicePeer(t, client, regen.PendingPublicKey) != nil {
+			t.Error("expected the pending key removed with the peer")
+		}
+	})
+
+	t.Run("OverlapOutOfRange", func(t *testing.T) {
+		srv := newFakeService(t, newFakeDevice(t), filepath.Join(t.TempDir(), "peers.json"))
+		resp, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		if _, err := srv.RegeneratePeer(resp.ID, RegenerateOptions{Overlap: MaxKeyOverlap + time.Second}, 0); err == nil {
+			t.Error("expected an error for an overlap above the maximum")
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package wireguard
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// RouteProfileFull is the built-in profile that routes all traffic through the tunnel.
+const RouteProfileFull = "full"
+
+// fullTunnelRoutes are the client-side AllowedIPs of the built-in "full" profile.
+var fullTunnelRoutes = []string{"0.0.0.0/0", "::/0"}
+
+// RouteProfile is a named set of client-side routes written into the
+// AllowedIPs of a peer's generated config.
+type RouteProfile struct {
+	Name string `json:"name"`
+	// AllowedIPs are the ranges routed through the tunnel. Empty means full tunnel.
+	AllowedIPs []string `json:"allowedIPs,omitempty"`
+	// Exclude lists ranges carved out of AllowedIPs, e.g. RFC1918 space for
+	// "everything except the local LAN".
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// Routes returns the client-side AllowedIPs described by the profile.
+func (p RouteProfile) Routes() ([]string, error) {
+	include := p.AllowedIPs
+	if len(include) == 0 {
+		include = fullTunnelRoutes
+	}
+	if len(p.Exclude) == 0 {
+		return include, nil
+	}
+
+	includePrefixes, err := parsePrefixes(include)
+	if err != nil {
+		return nil, fmt.Errorf("route profile %q: %w", p.Name, err)
+	}
+	excludePrefixes, err := parsePrefixes(p.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("route profile %q: %w", p.Name, err)
+	}
+
+	routes := []string{}
+	for _, prefix := range ExcludePrefixes(includePrefixes, excludePrefixes) {
+		routes = append(routes, prefix.String())
+	}
+	return routes, nil
+}
+
+// findRouteProfile looks up a profile by name, falling back to the built-in
+// "full" profile when it has not been redefined.
+func findRouteProfile(settings GlobalSettings, name string) (RouteProfile, bool) {
+	for _, p := range settings.RouteProfiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	if name == RouteProfileFull {
+		return RouteProfile{Name: RouteProfileFull}, true
+	}
+	return RouteProfile{}, false
+}
+
+// resolveClientRoutes picks the client-side AllowedIPs for a peer: explicit
+// per-peer routes first, then the peer's profile, then the global default
+// profile, and finally full tunnel.
+func resolveClientRoutes(meta PeerMetadata, settings GlobalSettings) ([]string, error) {
+	if len(meta.ClientAllowedIPs) > 0 {
+		return meta.ClientAllowedIPs, nil
+	}
+
+	name := meta.RouteProfile
+	if name == "" {
+		name = settings.ClientRouteProfile
+	}
+	if name == "" {
+		name = RouteProfileFull
+	}
+
+	profile, ok := findRouteProfile(settings, name)
+	if !ok {
+		return nil, invalidArgument("unknown route profile: %s", name)
+	}
+	return profile.Routes()
+}
+
+// ExcludePrefixes returns the smallest set of prefixes covering every address
+// in include that is not covered by exclude.
+func ExcludePrefixes(include, exclude []netip.Prefix) []netip.Prefix {
+	result := []netip.Prefix{}
+	for _, p := range include {
+		remaining := []netip.Prefix{p.Masked()}
+		for _, e := range exclude {
+			var next []netip.Prefix
+			for _, r := range remaining {
+				next = append(next, subtractPrefix(r, e.Masked())...)
+			}
+			remaining = next
+		}
+		result = append(result, remaining...)
+	}
+	return result
+}
+
+// subtractPrefix removes e from p by repeatedly halving p around e.
+func subtractPrefix(p, e netip.Prefix) []netip.Prefix {
+	if !p.Overlaps(e) {
+		return []netip.Prefix{p}
+	}
+	if e.Bits() <= p.Bits() {
+		// e covers all of p.
+		return nil
+	}
+	lo, hi := splitPrefix(p)
+	return append(subtractPrefix(lo, e), subtractPrefix(hi, e)...)
+}
+
+// splitPrefix divides p into its two halves one bit longer.
+func splitPrefix(p netip.Prefix) (netip.Prefix, netip.Prefix) {
+	bits := p.Bits() + 1
+	lo := netip.PrefixFrom(p.Addr(), bits)
+
+	b := p.Addr().AsSlice()
+	b[p.Bits()/8] |= 0x80 >> (p.Bits() % 8)
+	hiAddr, _ := netip.AddrFromSlice(b)
+	hi := netip.PrefixFrom(hiAddr, bits)
+	return lo, hi
+}
+
+// parsePrefixes parses a list of CIDR strings.
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, c := range cidrs {
+		p, err := netip.ParsePrefix(c)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid CIDR '%s': %w", ErrInvalidArgument, c, err)
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, nil
+}
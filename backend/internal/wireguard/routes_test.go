@@ -0,0 +1,112 @@
+package wireguard
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestExcludePrefixes(t *testing.T) {
+	t.Run("FullTunnelMinusRFC1918", func(t *testing.T) {
+		profile := RouteProfile{
+			Name:       "public-only",
+			AllowedIPs: []string{"0.0.0.0/0"},
+			Exclude:    []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"},
+		}
+		routes, err := profile.Routes()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		prefixes, err := parsePrefixes(routes)
+		if err != nil {
+			t.Fatalf("routes are not valid CIDRs: %v", err)
+		}
+
+		contains := func(ip string) bool {
+			addr := netip.MustParseAddr(ip)
+			for _, p := range prefixes {
+				if p.Contains(addr) {
+					return true
+				}
+			}
+			return false
+		}
+		for _, ip := range []string{"10.1.2.3", "172.20.0.1", "192.168.1.1"} {
+			if contains(ip) {
+				t.Errorf("expected %s to be excluded", ip)
+			}
+		}
+		for _, ip := range []string{"8.8.8.8", "172.32.0.1", "11.0.0.1", "192.169.0.1"} {
+			if !contains(ip) {
+				t.Errorf("expected %s to be routed", ip)
+			}
+		}
+	})
+
+	t.Run("ExcludeEverything", func(t *testing.T) {
+		got := ExcludePrefixes(
+			[]netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")},
+			[]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		)
+		if len(got) != 0 {
+			t.Errorf("expected nothing left, got %v", got)
+		}
+	})
+
+	t.Run("SplitOnce", func(t *testing.T) {
+		got := ExcludePrefixes(
+			[]netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")},
+			[]netip.Prefix{netip.MustParsePrefix("10.0.0.0/25")},
+		)
+		want := []netip.Prefix{netip.MustParsePrefix("10.0.0.128/25")}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("OtherFamilyUntouched", func(t *testing.T) {
+		got := ExcludePrefixes(
+			[]netip.Prefix{netip.MustParsePrefix("::/0")},
+			[]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		)
+		if len(got) != 1 || got[0].String() != "::/0" {
+			t.Errorf("expected ::/0 untouched, got %v", got)
+		}
+	})
+}
+
+func TestResolveClientRoutes(t *testing.T) {
+	settings := GlobalSettings{
+		ClientRouteProfile: "corp-only",
+		RouteProfiles: []RouteProfile{
+			{Name: "corp-only", AllowedIPs: []string{"10.10.0.0/16"}},
+		},
+	}
+
+	tests := []struct {
+		name string
+		meta PeerMetadata
+		want []string
+	}{
+		{"GlobalDefault", PeerMetadata{}, []string{"10.10.0.0/16"}},
+		{"PeerProfile", PeerMetadata{RouteProfile: "full"}, []string{"0.0.0.0/0", "::/0"}},
+		{"PeerOverride", PeerMetadata{RouteProfile: "full", ClientAllowedIPs: []string{"10.20.0.0/16"}}, []string{"10.20.0.0/16"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveClientRoutes(tt.meta, settings)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("UnknownProfile", func(t *testing.T) {
+		if _, err := resolveClientRoutes(PeerMetadata{RouteProfile: "nope"}, settings); err == nil {
+			t.Error("expected error for unknown profile")
+		}
+	})
+}
@@ -3,6 +3,8 @@ package wireguard
 import (
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -13,15 +15,32 @@ import (
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
+// defaultSiteKeepalive is the keepalive written into site router configs when
+// neither the peer nor the global settings define one.
+const defaultSiteKeepalive = 25
+
+// DeviceClient is the narrow device-control interface realService needs.
+// *wgctrl.Client satisfies it; fakedevice.Client is an in-memory stand-in.
+type DeviceClient interface {
+	Device(name string) (*wgtypes.Device, error)
+	ConfigureDevice(name string, cfg wgtypes.Config) error
+	Close() error
+}
+
 type realService struct {
-	client         *wgctrl.Client
+	client         DeviceClient
 	interfaceName  string
 	storage        *Storage
 	serverPubKey   string
 	serverEndpoint string
 	vpnSubnet      string
+	mu             sync.Mutex // serialises peer mutations
 	history        []StatsHistoryItem
 	historyMu      sync.RWMutex
+	events         []Event
+	eventsMu       sync.RWMutex
+	beats          map[string]time.Time // last heartbeat per background collector
+	beatsMu        sync.Mutex
 	stopChan       chan struct{}
 }
 
@@ -32,6 +51,13 @@ func NewRealService(interfaceName string, storagePath string, serverEndpoint str
 		return nil, fmt.Errorf("failed to initialize wgctrl: %w", err)
 	}
 
+	return NewServiceWithClient(client, interfaceName, storagePath, serverEndpoint, serverPubKey, vpnSubnet)
+}
+
+// NewServiceWithClient creates a WireGuard service that controls the device
+// through client. The service takes ownership of client and closes it on
+// failure or when the service is closed.
+func NewServiceWithClient(client DeviceClient, interfaceName string, storagePath string, serverEndpoint string, serverPubKey string, vpnSubnet string) (Service, error) {
 	// Initialize storage
 	storage, err := NewStorage(storagePath)
 	if err != nil {
@@ -61,8 +87,12 @@ func NewRealService(interfaceName string, storagePath string, serverEndpoint str
 		slog.Error("Failed to sync peers on startup", "error", err)
 	}
 
-	// Start background stats collector
+	// Start background stats collector, drift watcher, key rotations and
+	// peer expiry
 	go srv.collectStats()
+	go srv.watchDrift()
+	go srv.watchRotations()
+	go srv.watchExpiry()
 
 	return srv, nil
 }
@@ -80,19 +110,37 @@ func (s *realService) Close() error {
 func (s *realService) ListPeers() ([]Peer, error) {
 	device, err := s.client.Device(s.interfaceName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get device %s: %w", s.interfaceName, err)
+		return nil, deviceError("failed to get device "+s.interfaceName, err)
 	}
 
+	now := time.Now()
+	pending := pendingKeys(s.storage.ListMetadata())
 	peers := make([]Peer, 0, len(device.Peers))
+	listed := make(map[string]bool, len(device.Peers))
 	for _, p := range device.Peers {
+		if pending[p.PublicKey.String()] {
+			// Listed as PendingPublicKey of the peer it replaces.
+			continue
+		}
 		allowedIPs := make([]string, len(p.AllowedIPs))
 		for i, ip := range p.AllowedIPs {
 			allowedIPs[i] = ip.String()
 		}
 
-		name := ""
-		if meta, ok := s.storage.GetMetadata(p.PublicKey.String()); ok {
-			name = meta.Name
+		var pendingKey string
+		// Peers that exist only on the device are identified by public key.
+		meta, ok := s.storage.LookupPublicKey(p.PublicKey.String())
+		if !ok {
+			meta = PeerMetadata{ID: p.PublicKey.String()}
+		}
+		if meta.PendingKey != nil {
+			pendingKey = meta.PendingKey.PublicKey
+		}
+		listed[meta.ID] = true
+
+		var handshake int64
+		if !p.LastHandshakeTime.IsZero() {
+			handshake = p.LastHandshakeTime.Unix()
 		}
 
 		endpoint := ""
@@ -101,27 +149,77 @@ func (s *realService) ListPeers() ([]Peer, error) {
 		}
 
 		peers = append(peers, Peer{
-			ID:            p.PublicKey.String(),
-			PublicKey:     p.PublicKey.String(),
-			Name:          name,
-			Endpoint:      endpoint,
-			AllowedIPs:    allowedIPs,
-			LastHandshake: p.LastHandshakeTime.String(),
-			ReceiveBytes:  p.ReceiveBytes,
-			TransmitBytes: p.TransmitBytes,
+			ID:                meta.ID,
+			PublicKey:         p.PublicKey.String(),
+			Name:              meta.Name,
+			Endpoint:          endpoint,
+			AllowedIPs:        allowedIPs,
+			LastHandshake:     p.LastHandshakeTime.String(),
+			LastHandshakeUnix: handshake,
+			ReceiveBytes:      p.ReceiveBytes,
+			TransmitBytes:     p.TransmitBytes,
+			InterfaceAddress:  meta.InterfaceAddress,
+			Type:              meta.Type,
+			Revision:          meta.Revision,
+			PendingPublicKey:  pendingKey,
+			Group:             meta.Group,
+			Tags:              meta.Tags,
+			Expires:           meta.Expires,
+			Status:            peerStatus(meta, p.LastHandshakeTime, now),
 		})
 	}
-	return peers, nil
+	return append(peers, s.offDevicePeers(listed)...), nil
+}
+
+// offDevicePeers lists the stored peers that are kept off the device, such as
+// unclaimed slots and expired peers, sorted by name. Peers already listed from
+// the device are skipped.
+func (s *realService) offDevicePeers(listed map[string]bool) []Peer {
+	now := time.Now()
+	var peers []Peer
+	for _, meta := range s.storage.ListMetadata() {
+		if !meta.OnDevice(now) && !listed[meta.ID] {
+			peers = append(peers, metadataPeer(meta))
+		}
+	}
+	slices.SortFunc(peers, func(a, b Peer) int {
+		return strings.Compare(a.Name+a.ID, b.Name+b.ID)
+	})
+	return peers
+}
+
+// GetPeer returns a single peer as listed by ListPeers, by ID or public key.
+func (s *realService) GetPeer(id string) (Peer, error) {
+	peers, err := s.ListPeers()
+	if err != nil {
+		return Peer{}, err
+	}
+	for _, p := range peers {
+		if p.ID == id || p.PublicKey == id {
+			return p, nil
+		}
+	}
+	return Peer{}, peerNotFound(id)
 }
 
 // AddPeer adds a new peer to the WireGuard interface.
 func (s *realService) AddPeer(opts AddPeerOptions) (PeerResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addPeer(opts)
+}
+
+func (s *realService) addPeer(opts AddPeerOptions) (PeerResponse, error) {
 	var privateKey string
 	var psk string
 	var err error
 
+	if opts.Claimable && opts.PublicKey != "" {
+		return PeerResponse{}, invalidArgument("a claimable peer cannot have a public key")
+	}
+
 	// If publicKey is empty, generate a new key pair
-	if opts.PublicKey == "" {
+	if opts.PublicKey == "" && !opts.Claimable {
 		keys, err := GenerateKeyPair()
 		if err != nil {
 			return PeerResponse{}, fmt.Errorf("failed to generate key pair: %w", err)
@@ -137,48 +235,44 @@ func (s *realService) AddPeer(opts AddPeerOptions) (PeerResponse, error) {
 		}
 	}
 
-	pubKey, err := wgtypes.ParseKey(opts.PublicKey)
-	if err != nil {
-		return PeerResponse{}, fmt.Errorf("invalid public key: %w", err)
-	}
-
-	// Parse allowed IPs
-	var allowedIPConfigs []net.IPNet
-	for _, ipStr := range opts.AllowedIPs {
-		_, ipNet, err := net.ParseCIDR(ipStr)
+	if !opts.Claimable {
+		key, err := wgtypes.ParseKey(opts.PublicKey)
 		if err != nil {
-			return PeerResponse{}, fmt.Errorf("invalid allowed IP '%s': %w", ipStr, err)
+			return PeerResponse{}, invalidKey("public key", err)
+		}
+		if err := s.checkKeyUnused(key); err != nil {
+			return PeerResponse{}, err
 		}
-		allowedIPConfigs = append(allowedIPConfigs, *ipNet)
-	}
-
-	peerConfig := wgtypes.PeerConfig{
-		PublicKey:         pubKey,
-		ReplaceAllowedIPs: true,
-		AllowedIPs:        allowedIPConfigs,
 	}
 
-	if psk != "" {
-		pskKey, err := wgtypes.ParseKey(psk)
-		if err != nil {
-			return PeerResponse{}, fmt.Errorf("invalid preshared key: %w", err)
+	switch opts.Type {
+	case "", PeerTypeClient:
+		if len(opts.Networks) > 0 {
+			return PeerResponse{}, invalidArgument("only site peers can advertise networks")
+		}
+	case PeerTypeSite:
+		if len(opts.Networks) == 0 {
+			return PeerResponse{}, invalidArgument("site peers must advertise at least one network")
+		}
+		if err := s.checkSiteNetworks(opts.Networks); err != nil {
+			return PeerResponse{}, err
 		}
-		peerConfig.PresharedKey = &pskKey
+	default:
+		return PeerResponse{}, invalidArgument("unknown peer type: %s", opts.Type)
 	}
-
-	config := wgtypes.Config{
-		ReplacePeers: false,
-		Peers:        []wgtypes.PeerConfig{peerConfig},
+	if opts.Endpoint != "" && opts.Type != PeerTypeSite {
+		return PeerResponse{}, invalidArgument("only site peers can have an endpoint")
 	}
-
-	if err := s.client.ConfigureDevice(s.interfaceName, config); err != nil {
-		return PeerResponse{}, fmt.Errorf("failed to configure device: %w", err)
+	group, err := s.lookupGroup(opts.Group)
+	if err != nil {
+		return PeerResponse{}, err
 	}
+	now := time.Now()
 
-	// Save metadata
 	meta := PeerMetadata{
+		ID:                  NewPeerID(),
 		PublicKey:           opts.PublicKey,
-		PrivateKey:          privateKey,
+		PrivateKey:          s.keptPrivateKey(privateKey),
 		PresharedKey:        psk,
 		Name:                opts.Name,
 		AllowedIPs:          opts.AllowedIPs,
@@ -186,77 +280,154 @@ func (s *realService) AddPeer(opts AddPeerOptions) (PeerResponse, error) {
 		MTU:                 opts.MTU,
 		PersistentKeepalive: opts.PersistentKeepalive,
 		InterfaceAddress:    opts.InterfaceAddress,
+		RouteProfile:        opts.RouteProfile,
+		ClientAllowedIPs:    opts.ClientAllowedIPs,
+		Type:                opts.Type,
+		Networks:            opts.Networks,
+		SiteForwarding:      opts.SiteForwarding,
+		Endpoint:            opts.Endpoint,
+		ServerKeepalive:     opts.ServerKeepalive,
+		Group:               opts.Group,
+		Tags:                normalizeTags(opts.Tags),
+		Expires:             peerExpiry(opts.Expires, group, now),
+	}
+
+	claimed := meta.DeviceAllowedIPs()
+	if !opts.Force {
+		if err := s.checkConflicts(claimed, meta); err != nil {
+			return PeerResponse{}, err
+		}
 	}
 
-	// Generate config if we have a private key
-	if privateKey != "" {
-		settings := s.storage.GetSettings()
-		dns := opts.DNS
-		if dns == "" {
-			dns = settings.DNS
-		}
-		mtu := opts.MTU
-		if mtu == 0 {
-			mtu = settings.MTU
-		}
-		keepalive := opts.PersistentKeepalive
-		if keepalive == 0 {
-			keepalive = settings.Keepalive
-		}
-		endpoint := settings.Endpoint
-		if endpoint == "" {
-			endpoint = s.serverEndpoint
-		}
+	// Resolve client routes up front so an unknown profile is rejected before
+	// the device is touched.
+	if _, err := resolveClientRoutes(s.inherit(meta), s.storage.GetSettings()); err != nil {
+		return PeerResponse{}, err
+	}
 
-		dnsSplit := []string{}
-		if dns != "" {
-			for _, d := range strings.Split(dns, ",") {
-				dnsSplit = append(dnsSplit, strings.TrimSpace(d))
-			}
+	// Render the initial config as stored. Without a private key it carries
+	// a placeholder for the client's own key.
+	meta.IssuedConfig, err = s.renderPeerConfig(meta)
+	if err != nil {
+		return PeerResponse{}, fmt.Errorf("failed to render config: %w", err)
+	}
+
+	// Unclaimed slots go on the device once a client claims them.
+	if meta.OnDevice(now) {
+		peerConfig, err := devicePeerConfig(opts.PublicKey, meta)
+		if err != nil {
+			return PeerResponse{}, err
 		}
 
-		address := opts.AllowedIPs
-		if opts.InterfaceAddress != "" {
-			address = []string{opts.InterfaceAddress}
+		config := wgtypes.Config{
+			ReplacePeers: false,
+			Peers:        []wgtypes.PeerConfig{peerConfig},
 		}
 
-		meta.Config = GenerateConfigString(PeerConfigInfo{
-			PrivateKey:          privateKey,
-			Address:             address,
-			DNS:                 dnsSplit,
-			MTU:                 mtu,
-			PersistentKeepalive: keepalive,
-			PublicKey:           s.serverPubKey,
-			PresharedKey:        psk,
-			Endpoint:            endpoint,
-			AllowedIPs:          []string{"0.0.0.0/0", "::/0"},
-		})
+		if err := s.client.ConfigureDevice(s.interfaceName, config); err != nil {
+			return PeerResponse{}, deviceError("failed to configure device", err)
+		}
 	}
 
-	if err := s.storage.SetMetadata(opts.PublicKey, meta); err != nil {
+	// Save metadata
+	if opts.Force {
+		if err := s.releaseStolenPrefixes(claimed, meta.ID); err != nil {
+			return PeerResponse{}, err
+		}
+	}
+	if err := s.storage.SetMetadata(meta.ID, meta); err != nil {
 		return PeerResponse{}, fmt.Errorf("failed to save metadata: %w", err)
 	}
+	stored, _ := s.storage.GetMetadata(meta.ID)
+
+	return s.issuedResponse(stored, privateKey)
+}
 
-	response := PeerResponse{
-		Peer: Peer{
-			ID:         opts.PublicKey,
-			PublicKey:  opts.PublicKey,
-			Name:       opts.Name,
-			AllowedIPs: opts.AllowedIPs,
-		},
-		PrivateKey:   meta.PrivateKey,
-		PresharedKey: meta.PresharedKey,
-		Config:       meta.Config,
+// metadataPeer describes a stored peer as the device will list it, for
+// responses to writes.
+func metadataPeer(meta PeerMetadata) Peer {
+	peer := Peer{
+		ID:               meta.ID,
+		PublicKey:        meta.PublicKey,
+		Name:             meta.Name,
+		AllowedIPs:       meta.DeviceAllowedIPs(),
+		InterfaceAddress: meta.InterfaceAddress,
+		Type:             meta.Type,
+		Revision:         meta.Revision,
+		Unclaimed:        meta.Unclaimed(),
+		Group:            meta.Group,
+		Tags:             meta.Tags,
+		Expires:          meta.Expires,
+		Status:           peerStatus(meta, time.Time{}, time.Now()),
+	}
+	if meta.PendingKey != nil {
+		peer.PendingPublicKey = meta.PendingKey.PublicKey
+	}
+	return peer
+}
+
+// RemovePeer removes a peer from the WireGuard interface. A non-zero
+// ifRevision must match the peer's current revision.
+func (s *realService) RemovePeer(id string, ifRevision int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkPeerRevision(id, ifRevision); err != nil {
+		return err
 	}
+	return s.removePeer(id)
+}
 
-	return response, nil
+// checkPeerRevision compares a non-zero ifRevision with the stored revision
+// of a peer. A peer without metadata has no revision and never matches.
+func (s *realService) checkPeerRevision(id string, ifRevision int64) error {
+	if ifRevision == 0 {
+		return nil
+	}
+	meta, _ := s.storedPeer(id)
+	if meta.Revision != ifRevision {
+		return revisionMismatch("peer "+id, ifRevision, meta.Revision)
+	}
+	return nil
 }
 
-// RemovePeer removes a peer from the WireGuard interface.
-func (s *realService) RemovePeer(id string) error {
+// storedPeer returns the metadata of a peer by ID or, for links made before
+// peers had IDs, by public key.
+func (s *realService) storedPeer(id string) (PeerMetadata, bool) {
+	if meta, ok := s.storage.GetMetadata(id); ok {
+		return meta, true
+	}
+	return s.storage.LookupPublicKey(id)
+}
+
+func (s *realService) removePeer(id string) error {
+	meta, ok := s.storedPeer(id)
+	if ok && meta.Unclaimed() {
+		// Not on the device yet.
+		if err := s.storage.DeleteMetadata(meta.ID); err != nil {
+			return fmt.Errorf("failed to delete metadata: %w", err)
+		}
+		return nil
+	}
+	if ok {
+		id = meta.PublicKey
+	}
 	pubKey, err := wgtypes.ParseKey(id)
 	if err != nil {
-		return fmt.Errorf("invalid public key: %w", err)
+		if !ok {
+			return peerNotFound(id)
+		}
+		return invalidKey("public key", err)
+	}
+	if !ok {
+		// Removing an unknown peer is a silent no-op on the device, so
+		// check it is at least present there.
+		device, err := s.client.Device(s.interfaceName)
+		if err != nil {
+			return deviceError("failed to get device "+s.interfaceName, err)
+		}
+		if !slices.ContainsFunc(device.Peers, func(p wgtypes.Peer) bool { return p.PublicKey == pubKey }) {
+			return peerNotFound(id)
+		}
 	}
 
 	peerConfig := wgtypes.PeerConfig{
@@ -267,13 +438,21 @@ func (s *realService) RemovePeer(id string) error {
 	config := wgtypes.Config{
 		Peers: []wgtypes.PeerConfig{peerConfig},
 	}
+	if meta.PendingKey != nil {
+		if pending, err := wgtypes.ParseKey(meta.PendingKey.PublicKey); err == nil {
+			config.Peers = append(config.Peers, wgtypes.PeerConfig{PublicKey: pending, Remove: true})
+		}
+	}
 
 	if err := s.client.ConfigureDevice(s.interfaceName, config); err != nil {
-		return fmt.Errorf("failed to remove peer: %w", err)
+		return deviceError("failed to remove peer", err)
 	}
 
 	// Delete metadata from storage
-	if err := s.storage.DeleteMetadata(id); err != nil {
+	if !ok {
+		return nil
+	}
+	if err := s.storage.DeleteMetadata(meta.ID); err != nil {
 		return fmt.Errorf("failed to delete metadata: %w", err)
 	}
 	return nil
@@ -283,7 +462,7 @@ func (s *realService) RemovePeer(id string) error {
 func (s *realService) GetStats() (Stats, error) {
 	device, err := s.client.Device(s.interfaceName)
 	if err != nil {
-		return Stats{}, fmt.Errorf("failed to get device %s: %w", s.interfaceName, err)
+		return Stats{}, deviceError("failed to get device "+s.interfaceName, err)
 	}
 
 	var totalRX, totalTX int64
@@ -303,64 +482,22 @@ func (s *realService) GetStats() (Stats, error) {
 	}, nil
 }
 
-// RegeneratePeer regenerates keys for a peer.
-func (s *realService) RegeneratePeer(id string) (PeerResponse, error) {
-	// 1. Fetch existing peer to get metadata and allowed IPs
-	peers, err := s.ListPeers()
-	if err != nil {
-		return PeerResponse{}, fmt.Errorf("failed to list peers: %w", err)
-	}
-
-	var targetPeer *Peer
-	for _, p := range peers {
-		if p.ID == id {
-			targetPeer = &p
-			break
-		}
-	}
-
-	if targetPeer == nil {
-		return PeerResponse{}, fmt.Errorf("peer not found: %s", id)
-	}
-
-	// 2. Remove old peer
-	if err := s.RemovePeer(id); err != nil {
-		return PeerResponse{}, fmt.Errorf("failed to remove old peer: %w", err)
-	}
-
-	// 3. Add back with new keys
-	// AddPeer generates new keys if publicKey is empty
-	meta, _ := s.storage.GetMetadata(id)
-	opts := AddPeerOptions{
-		Name:                targetPeer.Name,
-		AllowedIPs:          targetPeer.AllowedIPs,
-		DNS:                 meta.DNS,
-		MTU:                 meta.MTU,
-		PersistentKeepalive: meta.PersistentKeepalive,
-		PreSharedKey:        meta.PresharedKey != "",
-		InterfaceAddress:    meta.InterfaceAddress,
-	}
-
-	response, err := s.AddPeer(opts)
-	if err != nil {
-		return PeerResponse{}, fmt.Errorf("failed to add peer with new keys: %w", err)
-	}
-
-	return response, nil
-}
-
-// UpdatePeer updates peer metadata or configuration.
-func (s *realService) UpdatePeer(id string, updates PeerUpdate) (Peer, error) {
-	pubKey, err := wgtypes.ParseKey(id)
-	if err != nil {
-		return Peer{}, fmt.Errorf("invalid public key: %w", err)
-	}
+// UpdatePeer updates peer metadata or configuration. A non-zero ifRevision
+// must match the peer's current revision.
+func (s *realService) UpdatePeer(id string, updates PeerUpdate, ifRevision int64) (Peer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	// Fetch existing metadata
-	meta, ok := s.storage.GetMetadata(id)
+	meta, ok := s.storedPeer(id)
 	if !ok {
-		return Peer{}, fmt.Errorf("peer metadata not found: %s", id)
+		return Peer{}, peerNotFound(id)
+	}
+	if err := s.checkPeerRevision(id, ifRevision); err != nil {
+		return Peer{}, err
 	}
+	now := time.Now()
+	old := meta
 
 	// Update metadata
 	metaChanged := false
@@ -384,37 +521,104 @@ func (s *realService) UpdatePeer(id string, updates PeerUpdate) (Peer, error) {
 		meta.InterfaceAddress = *updates.InterfaceAddress
 		metaChanged = true
 	}
+	if updates.RouteProfile != nil {
+		meta.RouteProfile = *updates.RouteProfile
+		metaChanged = true
+	}
+	if updates.ClientAllowedIPs != nil {
+		meta.ClientAllowedIPs = *updates.ClientAllowedIPs
+		metaChanged = true
+	}
+	if updates.AllowedIPs != nil {
+		// Keep storage in step with the device so Sync and config rendering
+		// see the new addresses.
+		meta.AllowedIPs = *updates.AllowedIPs
+		metaChanged = true
+	}
+	if updates.Networks != nil {
+		if meta.Type != PeerTypeSite {
+			return Peer{}, invalidArgument("only site peers can advertise networks")
+		}
+		if err := s.checkSiteNetworks(*updates.Networks); err != nil {
+			return Peer{}, err
+		}
+		meta.Networks = *updates.Networks
+		metaChanged = true
+	}
+	if updates.SiteForwarding != nil {
+		meta.SiteForwarding = *updates.SiteForwarding
+		metaChanged = true
+	}
+	if updates.Endpoint != nil {
+		if *updates.Endpoint != "" && meta.Type != PeerTypeSite {
+			return Peer{}, invalidArgument("only site peers can have an endpoint")
+		}
+		meta.Endpoint = *updates.Endpoint
+		metaChanged = true
+	}
+	if updates.ServerKeepalive != nil {
+		meta.ServerKeepalive = *updates.ServerKeepalive
+		metaChanged = true
+	}
+	if updates.Group != nil {
+		if _, err := s.lookupGroup(*updates.Group); err != nil {
+			return Peer{}, err
+		}
+		meta.Group = *updates.Group
+		metaChanged = true
+	}
+	if updates.Tags != nil {
+		meta.Tags = normalizeTags(*updates.Tags)
+		metaChanged = true
+	}
+	if updates.Expires != nil {
+		meta.Expires = *updates.Expires
+		metaChanged = true
+	}
+	if updates.Disabled != nil {
+		meta.Disabled = *updates.Disabled
+		metaChanged = true
+	}
 
-	if metaChanged {
-		if err := s.storage.SetMetadata(id, meta); err != nil {
-			return Peer{}, fmt.Errorf("failed to update metadata: %w", err)
+	routesChanged := updates.AllowedIPs != nil || updates.Networks != nil
+	if routesChanged && !updates.Force {
+		if err := s.checkConflicts(meta.DeviceAllowedIPs(), meta); err != nil {
+			return Peer{}, err
 		}
 	}
 
-	// Update WireGuard config if AllowedIPs changed
-	if updates.AllowedIPs != nil {
-		var allowedIPConfigs []net.IPNet
-		for _, ipStr := range *updates.AllowedIPs {
-			_, ipNet, err := net.ParseCIDR(ipStr)
-			if err != nil {
-				return Peer{}, fmt.Errorf("invalid allowed IP '%s': %w", ipStr, err)
-			}
-			allowedIPConfigs = append(allowedIPConfigs, *ipNet)
+	if metaChanged {
+		if _, err := resolveClientRoutes(s.inherit(meta), s.storage.GetSettings()); err != nil {
+			return Peer{}, err
 		}
+	}
 
-		peerConfig := wgtypes.PeerConfig{
-			PublicKey:         pubKey,
-			UpdateOnly:        true,
-			ReplaceAllowedIPs: true,
-			AllowedIPs:        allowedIPConfigs,
+	// Update WireGuard config if anything the device knows about changed, or
+	// disabling or the expiry moved the peer on or off the device. The device
+	// goes first, so a refused change leaves storage as it was.
+	deviceChanged := routesChanged || updates.Endpoint != nil || updates.ServerKeepalive != nil
+	peerConfigs, err := updateDeviceConfigs(old, meta, now, deviceChanged)
+	if err != nil {
+		return Peer{}, err
+	}
+	if len(peerConfigs) > 0 {
+		if err := s.client.ConfigureDevice(s.interfaceName, wgtypes.Config{Peers: peerConfigs}); err != nil {
+			return Peer{}, deviceError("failed to update WireGuard peer config", err)
 		}
+	}
 
-		config := wgtypes.Config{
-			Peers: []wgtypes.PeerConfig{peerConfig},
+	if metaChanged {
+		if err := s.storage.SetMetadata(meta.ID, meta); err != nil {
+			if len(peerConfigs) > 0 {
+				s.rollbackUpdate(meta, old, now, deviceChanged)
+			}
+			return Peer{}, fmt.Errorf("failed to update metadata: %w", err)
 		}
+	}
 
-		if err := s.client.ConfigureDevice(s.interfaceName, config); err != nil {
-			return Peer{}, fmt.Errorf("failed to update WireGuard peer config: %w", err)
+	if len(peerConfigs) > 0 && updates.Force && routesChanged {
+		if err := s.releaseStolenPrefixes(meta.DeviceAllowedIPs(), meta.ID); err != nil {
+			return Peer{}, err
 		}
 	}
 
@@ -425,43 +629,76 @@ func (s *realService) UpdatePeer(id string, updates PeerUpdate) (Peer, error) {
 	}
 
 	for _, p := range peers {
-		if p.ID == id {
+		if p.ID == meta.ID {
 			return p, nil
 		}
 	}
 
-	return Peer{}, fmt.Errorf("peer not found after update: %s", id)
+	return Peer{}, fmt.Errorf("peer disappeared from device after update: %w", peerNotFound(id))
+}
+
+// updateDeviceConfigs returns the device configs that move a peer from the
+// stored peer from to to: removing it if it went off the device, adding it in
+// full if it came on, and otherwise updating it if deviceChanged.
+func updateDeviceConfigs(from, to PeerMetadata, now time.Time, deviceChanged bool) ([]wgtypes.PeerConfig, error) {
+	wasOnDevice, onDevice := from.OnDevice(now), to.OnDevice(now)
+	switch {
+	case wasOnDevice && !onDevice:
+		return peerRemovalConfigs(from), nil
+	case !wasOnDevice && onDevice:
+		return peerDeviceConfigs(to)
+	case onDevice && deviceChanged:
+		pc, err := devicePeerConfig(to.PublicKey, to)
+		if err != nil {
+			return nil, err
+		}
+		pc.UpdateOnly = true
+		return []wgtypes.PeerConfig{pc}, nil
+	}
+	return nil, nil
 }
 
-// Sync restores all peers from storage to the WireGuard interface.
+// rollbackUpdate restores the device to the stored peer old after an update
+// to meta could not be stored. It is best effort: a failure is logged and
+// left for drift detection to report.
+func (s *realService) rollbackUpdate(meta, old PeerMetadata, now time.Time, deviceChanged bool) {
+	peers, err := updateDeviceConfigs(meta, old, now, deviceChanged)
+	if err == nil && len(peers) > 0 {
+		err = s.client.ConfigureDevice(s.interfaceName, wgtypes.Config{Peers: peers})
+	}
+	if err != nil {
+		slog.Error("Failed to roll back peer update", "peer", meta.ID, "error", err)
+	}
+}
+
+// Sync restores all peers from storage to the WireGuard interface, including
+// preshared keys, server-side keepalive and site endpoints.
 func (s *realService) Sync() error {
 	slog.Info("Syncing peers from storage to interface", "interface", s.interfaceName)
-	s.storage.mu.RLock()
-	defer s.storage.mu.RUnlock()
+	stored := slices.SortedFunc(maps.Values(s.storage.ListMetadata()), func(a, b PeerMetadata) int {
+		return strings.Compare(a.PublicKey, b.PublicKey)
+	})
 
+	now := time.Now()
 	var peerConfigs []wgtypes.PeerConfig
-	for pubKeyStr, meta := range s.storage.data.Peers {
-		pubKey, err := wgtypes.ParseKey(pubKeyStr)
+	for _, meta := range stored {
+		if !meta.OnDevice(now) {
+			continue
+		}
+		pc, err := devicePeerConfig(meta.PublicKey, meta)
 		if err != nil {
-			slog.Error("Invalid public key in storage", "key", pubKeyStr, "error", err)
+			slog.Error("Invalid peer in storage", "id", meta.ID, "key", meta.PublicKey, "error", err)
 			continue
 		}
-
-		var allowedIPConfigs []net.IPNet
-		for _, ipStr := range meta.AllowedIPs {
-			_, ipNet, err := net.ParseCIDR(ipStr)
+		peerConfigs = append(peerConfigs, pc)
+		if meta.PendingKey != nil {
+			pending, err := pendingPeerConfig(meta)
 			if err != nil {
-				slog.Error("Invalid allowed IP in storage", "ip", ipStr, "error", err)
+				slog.Error("Invalid pending key in storage", "id", meta.ID, "error", err)
 				continue
 			}
-			allowedIPConfigs = append(allowedIPConfigs, *ipNet)
+			peerConfigs = append(peerConfigs, pending)
 		}
-
-		peerConfigs = append(peerConfigs, wgtypes.PeerConfig{
-			PublicKey:         pubKey,
-			ReplaceAllowedIPs: true,
-			AllowedIPs:        allowedIPConfigs,
-		})
 	}
 
 	if len(peerConfigs) == 0 {
@@ -474,27 +711,212 @@ func (s *realService) Sync() error {
 	}
 
 	if err := s.client.ConfigureDevice(s.interfaceName, config); err != nil {
-		return fmt.Errorf("failed to sync peers to device: %w", err)
+		return deviceError("failed to sync peers to device", err)
 	}
 
 	return nil
 }
 
-// GetPeerConfig returns the configuration string for a peer.
+// devicePeerConfig builds the complete device configuration for a stored
+// peer: AllowedIPs (including site networks), preshared key, server-side
+// persistent keepalive and, for site peers, the router's endpoint.
+func devicePeerConfig(publicKey string, meta PeerMetadata) (wgtypes.PeerConfig, error) {
+	pubKey, err := wgtypes.ParseKey(publicKey)
+	if err != nil {
+		return wgtypes.PeerConfig{}, invalidKey("public key", err)
+	}
+
+	var allowedIPConfigs []net.IPNet
+	for _, ipStr := range meta.DeviceAllowedIPs() {
+		_, ipNet, err := net.ParseCIDR(ipStr)
+		if err != nil {
+			return wgtypes.PeerConfig{}, fmt.Errorf("invalid allowed IP '%s': %w", ipStr, err)
+		}
+		allowedIPConfigs = append(allowedIPConfigs, *ipNet)
+	}
+
+	pc := wgtypes.PeerConfig{
+		PublicKey:         pubKey,
+		ReplaceAllowedIPs: true,
+		AllowedIPs:        allowedIPConfigs,
+	}
+
+	// Always set the PSK and keepalive so stale values on the device are cleared.
+	psk := wgtypes.Key{}
+	if meta.PresharedKey != "" {
+		psk, err = wgtypes.ParseKey(meta.PresharedKey)
+		if err != nil {
+			return wgtypes.PeerConfig{}, invalidKey("preshared key", err)
+		}
+	}
+	pc.PresharedKey = &psk
+
+	keepalive := time.Duration(meta.ServerKeepalive) * time.Second
+	pc.PersistentKeepaliveInterval = &keepalive
+
+	if meta.Type == PeerTypeSite && meta.Endpoint != "" {
+		endpoint, err := net.ResolveUDPAddr("udp", meta.Endpoint)
+		if err != nil {
+			// The router will still connect to us; don't drop the peer because
+			// its endpoint can't be resolved right now.
+			slog.Warn("Failed to resolve site endpoint", "endpoint", meta.Endpoint, "error", err)
+		} else {
+			pc.Endpoint = endpoint
+		}
+	}
+	return pc, nil
+}
+
+// GetPeerConfig renders the configuration string for a peer from its current
+// metadata and the current global settings, and records it as the config
+// last issued to that peer. If the server does not hold the peer's private
+// key, the config carries PrivateKeyPlaceholder instead.
 func (s *realService) GetPeerConfig(id string) (string, error) {
-	meta, ok := s.storage.GetMetadata(id)
+	// Recording the download writes the peer's metadata, so it must not
+	// interleave with a concurrent update of the same peer.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, ok := s.storedPeer(id)
 	if !ok {
-		return "", fmt.Errorf("peer not found: %s", id)
+		return "", peerNotFound(id)
+	}
+	// During an overlap window, downloads carry the new key.
+	meta = configMetadata(meta)
+
+	config, err := s.renderPeerConfig(meta)
+	if err != nil {
+		return "", configUnavailable(id, err)
 	}
-	if meta.Config == "" {
-		return "", fmt.Errorf("config not available for peer (might need key regeneration): %s", id)
+	if config != meta.IssuedConfig {
+		if err := s.storage.RecordIssuedConfig(meta.ID, config); err != nil {
+			return "", fmt.Errorf("failed to record issued config: %w", err)
+		}
 	}
-	return meta.Config, nil
+	return config, nil
 }
 
-// GetPeerMetadata returns metadata for a peer.
+// GetPeerConfigDiff reports how the config a peer would download now differs
+// from the one it last downloaded.
+func (s *realService) GetPeerConfigDiff(id string) (ConfigDiff, error) {
+	meta, ok := s.storedPeer(id)
+	if !ok {
+		return ConfigDiff{}, peerNotFound(id)
+	}
+	// During an overlap window, downloads carry the new key.
+	meta = configMetadata(meta)
+
+	config, err := s.renderPeerConfig(meta)
+	if err != nil {
+		return ConfigDiff{}, configUnavailable(id, err)
+	}
+
+	changes := DiffConfigs(meta.IssuedConfig, config)
+	return ConfigDiff{
+		Downloaded: meta.IssuedConfig != "",
+		UpToDate:   len(changes) == 0,
+		Changes:    changes,
+	}, nil
+}
+
+// renderPeerConfig builds a client .conf for a peer, falling back to its
+// group and then the global settings for any value the peer does not override.
+// In zero-knowledge mode a private key still stored from before is not used.
+func (s *realService) renderPeerConfig(meta PeerMetadata) (string, error) {
+	settings := s.storage.GetSettings()
+	if settings.ZeroKnowledge {
+		meta.PrivateKey = ""
+	}
+	return s.renderConfig(s.inherit(meta), settings)
+}
+
+// renderConfig renders a peer's config against the given settings.
+func (s *realService) renderConfig(meta PeerMetadata, settings GlobalSettings) (string, error) {
+	isSite := meta.Type == PeerTypeSite
+
+	var routes []string
+	if isSite && meta.RouteProfile == "" && len(meta.ClientAllowedIPs) == 0 {
+		// Site routers only route the VPN and HQ networks, never a full tunnel.
+		routes = s.siteClientRoutes(settings)
+	} else {
+		var err error
+		routes, err = resolveClientRoutes(meta, settings)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	dns := meta.DNS
+	if dns == "" && !isSite {
+		// Routers keep their own resolvers unless the peer sets DNS explicitly.
+		dns = settings.DNS
+	}
+	mtu := meta.MTU
+	if mtu == 0 {
+		mtu = settings.MTU
+	}
+	keepalive := meta.PersistentKeepalive
+	if keepalive == 0 {
+		keepalive = settings.Keepalive
+	}
+	if keepalive == 0 && isSite {
+		// Sites usually sit behind NAT and must keep the tunnel open for HQ.
+		keepalive = defaultSiteKeepalive
+	}
+	endpoint := settings.Endpoint
+	if endpoint == "" {
+		endpoint = s.serverEndpoint
+	}
+
+	dnsSplit := []string{}
+	if dns != "" {
+		for _, d := range strings.Split(dns, ",") {
+			dnsSplit = append(dnsSplit, strings.TrimSpace(d))
+		}
+	}
+
+	address := meta.AllowedIPs
+	if meta.InterfaceAddress != "" {
+		address = []string{meta.InterfaceAddress}
+	}
+
+	privateKey := meta.PrivateKey
+	if privateKey == "" {
+		privateKey = PrivateKeyPlaceholder
+	}
+
+	info := PeerConfigInfo{
+		PrivateKey:          privateKey,
+		Address:             address,
+		DNS:                 dnsSplit,
+		MTU:                 mtu,
+		PersistentKeepalive: keepalive,
+		PublicKey:           s.serverPubKey,
+		PresharedKey:        meta.PresharedKey,
+		Endpoint:            endpoint,
+		AllowedIPs:          routes,
+	}
+	if isSite && meta.SiteForwarding {
+		info.PostUp = siteForwardingPostUp
+		info.PostDown = siteForwardingPostDown
+	}
+
+	return GenerateConfigString(info), nil
+}
+
+// siteClientRoutes returns the client-side routes of a site router: the VPN
+// subnet plus the HQ networks behind this server.
+func (s *realService) siteClientRoutes(settings GlobalSettings) []string {
+	routes := []string{}
+	if s.vpnSubnet != "" {
+		routes = append(routes, s.vpnSubnet)
+	}
+	return append(routes, settings.LocalNetworks...)
+}
+
+// GetPeerMetadata returns metadata for a peer by ID or public key.
 func (s *realService) GetPeerMetadata(id string) (PeerMetadata, bool) {
-	return s.storage.GetMetadata(id)
+	return s.storedPeer(id)
 }
 
 // GetStatsHistory returns historical statistics.
@@ -513,20 +935,17 @@ func (s *realService) GetSettings() (GlobalSettings, error) {
 	return s.storage.GetSettings(), nil
 }
 
-// UpdateSettings updates application-wide settings.
-func (s *realService) UpdateSettings(settings GlobalSettings) error {
-	return s.storage.UpdateSettings(settings)
-}
-
 func (s *realService) collectStats() {
-	ticker := time.NewTicker(time.Minute)
+	ticker := time.NewTicker(statsInterval)
 	defer ticker.Stop()
 
+	s.beat(collectorStats)
 	for {
 		select {
 		case <-s.stopChan:
 			return
 		case <-ticker.C:
+			s.beat(collectorStats)
 			stats, err := s.GetStats()
 			if err != nil {
 				slog.Error("Failed to collect stats for history", "error", err)
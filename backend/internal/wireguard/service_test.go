@@ -0,0 +1,77 @@
+package wireguard
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestStorage returns a Storage backed by a file in a temporary directory.
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	storage, err := NewStorage(filepath.Join(t.TempDir(), "peers.json"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	return storage
+}
+
+func TestRenderSitePeerConfig(t *testing.T) {
+	storage := newTestStorage(t)
+	settings := storage.GetSettings()
+	settings.LocalNetworks = []string{"192.168.0.0/24"}
+	if err := storage.UpdateSettings(settings); err != nil {
+		t.Fatalf("failed to update settings: %v", err)
+	}
+
+	s := &realService{storage: storage, serverPubKey: "SERVER_PUB", serverEndpoint: "vpn.example.com:51820", vpnSubnet: "10.0.0.0/24"}
+	meta := PeerMetadata{
+		PrivateKey:     "SITE_PRIVATE",
+		Name:           "branch",
+		AllowedIPs:     []string{"10.0.0.50/32"},
+		Type:           PeerTypeSite,
+		Networks:       []string{"192.168.50.0/24"},
+		SiteForwarding: true,
+	}
+
+	config, err := s.renderPeerConfig(meta)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, want := range []string{
+		"AllowedIPs = 10.0.0.0/24, 192.168.0.0/24\n",
+		"PersistentKeepalive = 25\n",
+		"PostUp = sysctl -w net.ipv4.ip_forward=1\n",
+		"PostDown = iptables -D FORWARD",
+	} {
+		if !strings.Contains(config, want) {
+			t.Errorf("expected config to contain %q, got:\n%s", want, config)
+		}
+	}
+	if strings.Contains(config, "DNS =") {
+		t.Errorf("expected site config without DNS, got:\n%s", config)
+	}
+}
+
+func TestCheckSiteNetworks(t *testing.T) {
+	s := &realService{storage: newTestStorage(t), vpnSubnet: "10.0.0.0/24"}
+
+	tests := []struct {
+		name     string
+		networks []string
+		wantErr  bool
+	}{
+		{"Free", []string{"192.168.60.0/24"}, false},
+		{"VPNSubnet", []string{"10.0.0.0/16"}, true},
+		{"Invalid", []string{"not-a-cidr"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.checkSiteNetworks(tt.networks)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
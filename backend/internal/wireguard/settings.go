@@ -0,0 +1,172 @@
+package wireguard
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// SettingsUpdate represents optional updates to the global settings. Nil
+// fields are left unchanged.
+type SettingsUpdate struct {
+	ServerAddress      *string         `json:"serverAddress,omitempty"`
+	DNS                *string         `json:"dns,omitempty"`
+	MTU                *int            `json:"mtu,omitempty"`
+	Keepalive          *int            `json:"keepalive,omitempty"`
+	Endpoint           *string         `json:"endpoint,omitempty"`
+	ClientRouteProfile *string         `json:"clientRouteProfile,omitempty"`
+	RouteProfiles      *[]RouteProfile `json:"routeProfiles,omitempty"`
+	LocalNetworks      *[]string       `json:"localNetworks,omitempty"`
+	ZeroKnowledge      *bool           `json:"zeroKnowledge,omitempty"`
+}
+
+// Apply returns settings with the non-nil fields of u applied.
+func (u SettingsUpdate) Apply(settings GlobalSettings) GlobalSettings {
+	if u.ServerAddress != nil {
+		settings.ServerAddress = *u.ServerAddress
+	}
+	if u.DNS != nil {
+		settings.DNS = *u.DNS
+	}
+	if u.MTU != nil {
+		settings.MTU = *u.MTU
+	}
+	if u.Keepalive != nil {
+		settings.Keepalive = *u.Keepalive
+	}
+	if u.Endpoint != nil {
+		settings.Endpoint = *u.Endpoint
+	}
+	if u.ClientRouteProfile != nil {
+		settings.ClientRouteProfile = *u.ClientRouteProfile
+	}
+	if u.RouteProfiles != nil {
+		settings.RouteProfiles = *u.RouteProfiles
+	}
+	if u.LocalNetworks != nil {
+		settings.LocalNetworks = *u.LocalNetworks
+	}
+	if u.ZeroKnowledge != nil {
+		settings.ZeroKnowledge = *u.ZeroKnowledge
+	}
+	return settings
+}
+
+// AffectedPeer is a peer whose rendered config changed.
+type AffectedPeer struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// SettingsChange is the result of a settings update: the stored settings and
+// the peers whose configs now differ and must be re-downloaded.
+type SettingsChange struct {
+	Settings      GlobalSettings `json:"settings"`
+	AffectedPeers []AffectedPeer `json:"affectedPeers"`
+}
+
+// UpdateSettings replaces the global settings. A non-zero ifRevision must
+// match the current revision, otherwise ErrPreconditionFailed is returned.
+func (s *realService) UpdateSettings(settings GlobalSettings, ifRevision int64) (SettingsChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.replaceSettings(func(GlobalSettings) GlobalSettings { return settings }, ifRevision)
+}
+
+// PatchSettings applies a partial update to the global settings, with the
+// same revision check as UpdateSettings.
+func (s *realService) PatchSettings(update SettingsUpdate, ifRevision int64) (SettingsChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.replaceSettings(update.Apply, ifRevision)
+}
+
+// replaceSettings stores next(current) after checking the revision and the
+// route profiles. The caller must hold s.mu.
+func (s *realService) replaceSettings(next func(GlobalSettings) GlobalSettings, ifRevision int64) (SettingsChange, error) {
+	current := s.storage.GetSettings()
+	if ifRevision != 0 && ifRevision != current.Revision {
+		return SettingsChange{}, revisionMismatch("settings", ifRevision, current.Revision)
+	}
+
+	settings := next(current)
+	for _, p := range settings.RouteProfiles {
+		if _, err := p.Routes(); err != nil {
+			return SettingsChange{}, err
+		}
+	}
+	if settings.ClientRouteProfile != "" {
+		if _, ok := findRouteProfile(settings, settings.ClientRouteProfile); !ok {
+			return SettingsChange{}, invalidArgument("unknown route profile: %s", settings.ClientRouteProfile)
+		}
+	}
+	if err := s.checkRemovedRouteProfiles(current, settings); err != nil {
+		return SettingsChange{}, err
+	}
+
+	affected := s.affectedPeers(
+		func(meta PeerMetadata) (string, error) { return s.renderConfig(s.inherit(meta), current) },
+		func(meta PeerMetadata) (string, error) { return s.renderConfig(s.inherit(meta), settings) },
+	)
+	if err := s.storage.UpdateSettings(settings); err != nil {
+		return SettingsChange{}, err
+	}
+
+	change := SettingsChange{Settings: s.storage.GetSettings(), AffectedPeers: affected}
+	s.emit(EventSettings, fmt.Sprintf("Settings updated to revision %d; %d peer configs changed", change.Settings.Revision, len(affected)), change)
+	return change, nil
+}
+
+// checkRemovedRouteProfiles refuses settings that drop a route profile of
+// current that peers or groups still use, listing them as DeleteGroup does
+// for members. The caller must hold s.mu.
+func (s *realService) checkRemovedRouteProfiles(current, settings GlobalSettings) error {
+	removed := map[string]bool{}
+	for _, p := range current.RouteProfiles {
+		if _, ok := findRouteProfile(settings, p.Name); !ok {
+			removed[p.Name] = true
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+
+	users := map[string][]string{}
+	for _, meta := range s.storage.ListMetadata() {
+		if removed[meta.RouteProfile] {
+			users[meta.RouteProfile] = append(users[meta.RouteProfile], fmt.Sprintf("peer %q", meta.Name))
+		}
+	}
+	for _, group := range s.storage.ListGroups() {
+		if removed[group.RouteProfile] {
+			users[group.RouteProfile] = append(users[group.RouteProfile], fmt.Sprintf("group %q", group.Name))
+		}
+	}
+	var inUse []string
+	for _, name := range slices.Sorted(maps.Keys(users)) {
+		slices.Sort(users[name])
+		inUse = append(inUse, fmt.Sprintf("route profile %s is still used by %s", name, strings.Join(users[name], ", ")))
+	}
+	if len(inUse) > 0 {
+		return fmt.Errorf("%w: %s", ErrConflict, strings.Join(inUse, "; "))
+	}
+	return nil
+}
+
+// affectedPeers lists the peers whose config renders differently with after
+// than with before, sorted by name.
+func (s *realService) affectedPeers(before, after func(PeerMetadata) (string, error)) []AffectedPeer {
+	affected := []AffectedPeer{}
+	for id, meta := range s.storage.ListMetadata() {
+		oldConfig, errBefore := before(meta)
+		newConfig, errAfter := after(meta)
+		if oldConfig != newConfig || (errBefore == nil) != (errAfter == nil) {
+			affected = append(affected, AffectedPeer{ID: id, Name: meta.Name})
+		}
+	}
+	slices.SortFunc(affected, func(a, b AffectedPeer) int {
+		return strings.Compare(a.Name+a.ID, b.Name+b.ID)
+	})
+	return affected
+}
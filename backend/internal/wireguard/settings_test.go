@@ -0,0 +1,123 @@
+package wireguard
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSettingsUpdates(t *testing.T) {
+	srv := newFakeService(t, newFakeDevice(t), filepath.Join(t.TempDir(), "peers.json"))
+
+	inherits, err := srv.AddPeer(AddPeerOptions{Name: "inherits", AllowedIPs: []string{"10.0.0.2/32"}})
+	if err != nil {
+		t.Fatalf("AddPeer failed: %v", err)
+	}
+	if _, err := srv.AddPeer(AddPeerOptions{Name: "overrides", AllowedIPs: []string{"10.0.0.3/32"}, DNS: "9.9.9.9", MTU: 1380}); err != nil {
+		t.Fatalf("AddPeer failed: %v", err)
+	}
+
+	initial, _ := srv.GetSettings()
+	if initial.Revision != 1 {
+		t.Fatalf("expected a new store to start at revision 1, got %d", initial.Revision)
+	}
+
+	t.Run("PatchMergesAndReportsAffectedPeers", func(t *testing.T) {
+		dns := "8.8.4.4"
+		change, err := srv.PatchSettings(SettingsUpdate{DNS: &dns}, initial.Revision)
+		if err != nil {
+			t.Fatalf("PatchSettings failed: %v", err)
+		}
+		if change.Settings.DNS != dns || change.Settings.MTU != initial.MTU {
+			t.Errorf("expected only DNS to change, got %+v", change.Settings)
+		}
+		if change.Settings.Revision != initial.Revision+1 {
+			t.Errorf("expected revision %d, got %d", initial.Revision+1, change.Settings.Revision)
+		}
+		if len(change.AffectedPeers) != 1 || change.AffectedPeers[0].ID != inherits.ID {
+			t.Errorf("expected only the inheriting peer to be affected, got %+v", change.AffectedPeers)
+		}
+	})
+
+	t.Run("StaleRevision", func(t *testing.T) {
+		mtu := 1280
+		_, err := srv.PatchSettings(SettingsUpdate{MTU: &mtu}, initial.Revision)
+		if !errors.Is(err, ErrPreconditionFailed) {
+			t.Fatalf("expected ErrPreconditionFailed, got %v", err)
+		}
+		if current, _ := srv.GetSettings(); current.MTU != initial.MTU {
+			t.Errorf("expected settings untouched, got MTU %d", current.MTU)
+		}
+	})
+
+	t.Run("ReplaceResetsOmittedFields", func(t *testing.T) {
+		change, err := srv.UpdateSettings(GlobalSettings{MTU: 1400, Revision: 99}, 0)
+		if err != nil {
+			t.Fatalf("UpdateSettings failed: %v", err)
+		}
+		if change.Settings.DNS != "" || change.Settings.MTU != 1400 {
+			t.Errorf("expected a full replacement, got %+v", change.Settings)
+		}
+		if change.Settings.Revision != initial.Revision+2 {
+			t.Errorf("expected client revision to be ignored, got %d", change.Settings.Revision)
+		}
+		if len(change.AffectedPeers) != 1 || change.AffectedPeers[0].ID != inherits.ID {
+			t.Errorf("expected only the inheriting peer to be affected, got %+v", change.AffectedPeers)
+		}
+	})
+
+	t.Run("NoOpAffectsNothing", func(t *testing.T) {
+		change, err := srv.PatchSettings(SettingsUpdate{}, 0)
+		if err != nil {
+			t.Fatalf("PatchSettings failed: %v", err)
+		}
+		if len(change.AffectedPeers) != 0 {
+			t.Errorf("expected no affected peers, got %+v", change.AffectedPeers)
+		}
+	})
+	t.Run("RouteProfileInUse", func(t *testing.T) {
+		profiles := []RouteProfile{{Name: "lan", AllowedIPs: []string{"192.168.0.0/16"}}, {Name: "office", AllowedIPs: []string{"172.16.0.0/12"}}}
+		if _, err := srv.PatchSettings(SettingsUpdate{RouteProfiles: &profiles}, 0); err != nil {
+			t.Fatalf("PatchSettings failed: %v", err)
+		}
+		lan := "lan"
+		if _, err := srv.UpdatePeer(inherits.ID, PeerUpdate{RouteProfile: &lan}, 0); err != nil {
+			t.Fatalf("UpdatePeer failed: %v", err)
+		}
+		if _, err := srv.CreateGroup(Group{Name: "staff", RouteProfile: "lan"}); err != nil {
+			t.Fatalf("CreateGroup failed: %v", err)
+		}
+
+		for name, remaining := range map[string][]RouteProfile{
+			"Patch":   profiles[1:],
+			"Replace": nil,
+		} {
+			t.Run(name, func(t *testing.T) {
+				var err error
+				if remaining == nil {
+					_, err = srv.UpdateSettings(GlobalSettings{}, 0)
+				} else {
+					_, err = srv.PatchSettings(SettingsUpdate{RouteProfiles: &remaining}, 0)
+				}
+				if !errors.Is(err, ErrConflict) {
+					t.Fatalf("expected ErrConflict, got %v", err)
+				}
+				for _, user := range []string{`group "staff"`, `peer "inherits"`} {
+					if !strings.Contains(err.Error(), user) {
+						t.Errorf("expected %s to be listed, got %v", user, err)
+					}
+				}
+				if current, _ := srv.GetSettings(); len(current.RouteProfiles) != 2 {
+					t.Errorf("expected the profiles to be kept, got %+v", current.RouteProfiles)
+				}
+			})
+		}
+
+		// A profile nothing uses can go.
+		kept := profiles[:1]
+		if _, err := srv.PatchSettings(SettingsUpdate{RouteProfiles: &kept}, 0); err != nil {
+			t.Errorf("expected removing an unused profile to succeed, got %v", err)
+		}
+	})
+}
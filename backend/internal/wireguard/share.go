@@ -0,0 +1,208 @@
+package wireguard
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultShareTTL is how long a share link stays valid unless the
+	// caller picks another duration.
+	DefaultShareTTL = 24 * time.Hour
+	// MaxShareTTL bounds how long a share link may stay valid.
+	MaxShareTTL = 7 * 24 * time.Hour
+)
+
+// Share is a stored share link. Storage keys it by the SHA-256 hash of its
+// token, so the token itself is never persisted.
+type Share struct {
+	PeerID  string `json:"peerId"`
+	Created int64  `json:"created"` // Unix seconds
+	Expires int64  `json:"expires"` // Unix seconds
+}
+
+// ShareLink is a new single-use link to a peer's config. Token is only
+// returned here.
+type ShareLink struct {
+	Token   string `json:"token"`
+	Path    string `json:"path"`
+	PeerID  string `json:"peerId"`
+	Expires int64  `json:"expires"` // Unix seconds
+}
+
+// SharedConfig is the config handed out through a share link.
+type SharedConfig struct {
+	PeerID string `json:"peerId"`
+	Name   string `json:"name"`
+	Config string `json:"config"`
+}
+
+// shareEvent is the data of share events. It never carries the token.
+type shareEvent struct {
+	PeerID  string `json:"peerId,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Expires int64  `json:"expires,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// ShareAuditEntry is a share event as kept in the share audit log. Unlike
+// the events feed, the log is stored and never trimmed.
+type ShareAuditEntry struct {
+	Type      string `json:"type"` // EventShareCreated, EventShareOpened or EventShareRejected
+	Timestamp int64  `json:"timestamp"`
+	PeerID    string `json:"peerId,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Expires   int64  `json:"expires,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// audit returns the audit log entry for an event of eventType at now.
+func (e shareEvent) audit(eventType string, now time.Time) ShareAuditEntry {
+	return ShareAuditEntry{
+		Type:      eventType,
+		Timestamp: now.Unix(),
+		PeerID:    e.PeerID,
+		Name:      e.Name,
+		Expires:   e.Expires,
+		Reason:    e.Reason,
+	}
+}
+
+// SharePeer creates a single-use link to a peer's config that expires after
+// ttl, or DefaultShareTTL if ttl is zero.
+func (s *realService) SharePeer(id string, ttl time.Duration) (ShareLink, error) {
+	if ttl == 0 {
+		ttl = DefaultShareTTL
+	}
+	if ttl < 0 || ttl > MaxShareTTL {
+		return ShareLink{}, invalidArgument("share duration must be between 0 and %s", MaxShareTTL)
+	}
+
+	meta, ok := s.storedPeer(id)
+	if !ok {
+		return ShareLink{}, peerNotFound(id)
+	}
+	key, err := s.storage.ShareKey()
+	if err != nil {
+		return ShareLink{}, fmt.Errorf("failed to load share key: %w", err)
+	}
+
+	now := time.Now()
+	expires := now.Add(ttl)
+	token := newShareToken(key, expires)
+	share := Share{PeerID: meta.ID, Created: now.Unix(), Expires: expires.Unix()}
+	event := shareEvent{PeerID: meta.ID, Name: meta.Name, Expires: share.Expires}
+	if err := s.storage.AddShare(tokenHash(token), share, event.audit(EventShareCreated, now)); err != nil {
+		return ShareLink{}, fmt.Errorf("failed to save share link: %w", err)
+	}
+
+	s.emit(EventShareCreated, fmt.Sprintf("Created a share link for peer %q", meta.Name), event)
+	return ShareLink{Token: token, Path: "/share/" + token, PeerID: meta.ID, Expires: share.Expires}, nil
+}
+
+// OpenShare redeems a share token and returns its peer's config. A token
+// works once: forged, expired and used tokens fail with ErrShareNotFound.
+func (s *realService) OpenShare(token string) (SharedConfig, error) {
+	key, err := s.storage.ShareKey()
+	if err != nil {
+		return SharedConfig{}, fmt.Errorf("failed to load share key: %w", err)
+	}
+	expires, ok := verifyShareToken(key, token)
+	if !ok {
+		return SharedConfig{}, s.rejectShare(shareEvent{Reason: "invalid signature"})
+	}
+	if !time.Now().Before(expires) {
+		return SharedConfig{}, s.rejectShare(shareEvent{Expires: expires.Unix(), Reason: "expired"})
+	}
+
+	hash := tokenHash(token)
+	share, ok := s.storage.GetShare(hash)
+	if !ok {
+		return SharedConfig{}, s.rejectShare(shareEvent{Expires: expires.Unix(), Reason: "already used"})
+	}
+	meta, ok := s.storage.GetMetadata(share.PeerID)
+	if !ok {
+		return SharedConfig{}, s.rejectShare(shareEvent{PeerID: share.PeerID, Reason: "peer removed"})
+	}
+
+	// Render before spending the token, so a failure leaves the link usable.
+	config, err := s.GetPeerConfig(meta.ID)
+	if err != nil {
+		return SharedConfig{}, err
+	}
+	event := shareEvent{PeerID: meta.ID, Name: meta.Name, Expires: share.Expires}
+	_, ok, err = s.storage.TakeShare(hash, event.audit(EventShareOpened, time.Now()))
+	if err != nil {
+		return SharedConfig{}, fmt.Errorf("failed to redeem share link: %w", err)
+	}
+	if !ok {
+		return SharedConfig{}, s.rejectShare(shareEvent{Expires: expires.Unix(), Reason: "already used"})
+	}
+	s.emit(EventShareOpened, fmt.Sprintf("Share link for peer %q was used", meta.Name), event)
+	return SharedConfig{PeerID: meta.ID, Name: meta.Name, Config: config}, nil
+}
+
+// rejectShare records a refused share token and returns ErrShareNotFound.
+// The reason is only audited, not returned, so callers cannot probe tokens.
+func (s *realService) rejectShare(event shareEvent) error {
+	if err := s.storage.AppendShareAudit(event.audit(EventShareRejected, time.Now())); err != nil {
+		slog.Error("Failed to record rejected share link", "reason", event.Reason, "error", err)
+	}
+	s.emit(EventShareRejected, "Rejected a share link: "+event.Reason, event)
+	return ErrShareNotFound
+}
+
+// GetShareAudit returns the share audit log, oldest first.
+func (s *realService) GetShareAudit() ([]ShareAuditEntry, error) {
+	return s.storage.ShareAudit(), nil
+}
+
+// newShareToken returns a token "<payload>.<signature>". The payload holds
+// 16 random bytes and the expiry; the signature is its HMAC-SHA256 under key.
+func newShareToken(key []byte, expires time.Time) string {
+	payload := make([]byte, 24)
+	rand.Read(payload[:16])
+	binary.BigEndian.PutUint64(payload[16:], uint64(expires.Unix()))
+
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(shareSignature(key, payload))
+}
+
+// verifyShareToken checks a token's signature and returns the expiry it
+// carries.
+func verifyShareToken(key []byte, token string) (time.Time, bool) {
+	enc := base64.RawURLEncoding
+	p, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return time.Time{}, false
+	}
+	payload, err := enc.DecodeString(p)
+	if err != nil || len(payload) != 24 {
+		return time.Time{}, false
+	}
+	signature, err := enc.DecodeString(sig)
+	if err != nil || !hmac.Equal(signature, shareSignature(key, payload)) {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(binary.BigEndian.Uint64(payload[16:])), 0), true
+}
+
+func shareSignature(key, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// tokenHash is the storage key of a share or invite token.
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,181 @@
+package wireguard
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSharePeer(t *testing.T) {
+	t.Run("SingleUse", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "peers.json")
+		srv := newFakeService(t, newFakeDevice(t), path)
+		resp, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+
+		link, err := srv.SharePeer(resp.ID, time.Hour)
+		if err != nil {
+			t.Fatalf("SharePeer failed: %v", err)
+		}
+		if link.PeerID != resp.ID || link.Path != "/share/"+link.Token {
+			t.Errorf("expected a link to %s, got %+v", resp.ID, link)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile failed: %v", err)
+		}
+		if strings.Contains(string(data), link.Token) || !strings.Contains(string(data), tokenHash(link.Token)) {
+			t.Error("expected only the token hash to be stored")
+		}
+
+		shared, err := srv.OpenShare(link.Token)
+		if err != nil {
+			t.Fatalf("OpenShare failed: %v", err)
+		}
+		if shared.Name != "laptop" || !strings.Contains(shared.Config, resp.PrivateKey) {
+			t.Errorf("expected the peer's config, got %+v", shared)
+		}
+		if _, err := srv.OpenShare(link.Token); !errors.Is(err, ErrShareNotFound) {
+			t.Errorf("expected ErrShareNotFound on second use, got %v", err)
+		}
+
+		events, _ := srv.GetEvents()
+		var types []string
+		for _, e := range events {
+			types = append(types, e.Type)
+		}
+		for _, want := range []string{EventShareCreated, EventShareOpened, EventShareRejected} {
+			if !slices.Contains(types, want) {
+				t.Errorf("expected a %s event, got %v", want, types)
+			}
+		}
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		srv := newFakeService(t, newFakeDevice(t), filepath.Join(t.TempDir(), "peers.json"))
+		resp, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+
+		expired, err := srv.SharePeer(resp.ID, time.Nanosecond)
+		if err != nil {
+			t.Fatalf("SharePeer failed: %v", err)
+		}
+		if _, err := srv.OpenShare(expired.Token); !errors.Is(err, ErrShareNotFound) {
+			t.Errorf("expected ErrShareNotFound for an expired link, got %v", err)
+		}
+
+		link, err := srv.SharePeer(resp.ID, 0)
+		if err != nil {
+			t.Fatalf("SharePeer failed: %v", err)
+		}
+		if remaining := time.Until(time.Unix(link.Expires, 0)); remaining < DefaultShareTTL-time.Minute {
+			t.Errorf("expected the default lifetime, got %s", remaining)
+		}
+		payload, _, _ := strings.Cut(link.Token, ".")
+		forged := payload + "." + strings.Repeat("A", 43)
+		if _, err := srv.OpenShare(forged); !errors.Is(err, ErrShareNotFound) {
+			t.Errorf("expected ErrShareNotFound for a forged link, got %v", err)
+		}
+		if _, err := srv.OpenShare("garbage"); !errors.Is(err, ErrShareNotFound) {
+			t.Errorf("expected ErrShareNotFound for garbage, got %v", err)
+		}
+
+		if err := srv.RemovePeer(resp.ID, 0); err != nil {
+			t.Fatalf("RemovePeer failed: %v", err)
+		}
+		if _, err := srv.OpenShare(link.Token); !errors.Is(err, ErrShareNotFound) {
+			t.Errorf("expected ErrShareNotFound for a removed peer, got %v", err)
+		}
+	})
+
+	t.Run("AuditStored", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "peers.json")
+		srv := newFakeService(t, newFakeDevice(t), path)
+		resp, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		link, err := srv.SharePeer(resp.ID, time.Hour)
+		if err != nil {
+			t.Fatalf("SharePeer failed: %v", err)
+		}
+		if _, err := srv.OpenShare(link.Token); err != nil {
+			t.Fatalf("OpenShare failed: %v", err)
+		}
+		srv.OpenShare(link.Token)
+		srv.OpenShare("garbage")
+
+		// The log outlives the process, unlike the bounded events feed.
+		storage, err := NewStorage(path)
+		if err != nil {
+			t.Fatalf("NewStorage failed: %v", err)
+		}
+		audit := storage.ShareAudit()
+		var got []string
+		for _, e := range audit {
+			got = append(got, e.Type+" "+e.Reason)
+		}
+		want := []string{EventShareCreated + " ", EventShareOpened + " ", EventShareRejected + " already used", EventShareRejected + " invalid signature"}
+		if !slices.Equal(got, want) {
+			t.Fatalf("expected %q, got %q", want, got)
+		}
+		if audit[0].PeerID != resp.ID || audit[0].Name != "laptop" || audit[0].Expires != link.Expires {
+			t.Errorf("expected the created entry to name the peer, got %+v", audit[0])
+		}
+	})
+
+	t.Run("RenderFailureKeepsLink", func(t *testing.T) {
+		srv := newFakeService(t, newFakeDevice(t), filepath.Join(t.TempDir(), "peers.json"))
+		resp, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		link, err := srv.SharePeer(resp.ID, time.Hour)
+		if err != nil {
+			t.Fatalf("SharePeer failed: %v", err)
+		}
+
+		storage := srv.(*realService).storage
+		meta, _ := storage.GetMetadata(resp.ID)
+		broken := meta
+		broken.RouteProfile = "missing"
+		if err := storage.SetMetadata(resp.ID, broken); err != nil {
+			t.Fatalf("SetMetadata failed: %v", err)
+		}
+		if _, err := srv.OpenShare(link.Token); err == nil {
+			t.Fatal("expected OpenShare to fail while the config cannot be rendered")
+		}
+
+		if err := storage.SetMetadata(resp.ID, meta); err != nil {
+			t.Fatalf("SetMetadata failed: %v", err)
+		}
+		if _, err := srv.OpenShare(link.Token); err != nil {
+			t.Fatalf("expected the link to survive the failed render, got %v", err)
+		}
+		if _, err := srv.OpenShare(link.Token); !errors.Is(err, ErrShareNotFound) {
+			t.Errorf("expected ErrShareNotFound on second use, got %v", err)
+		}
+	})
+
+	t.Run("InvalidRequest", func(t *testing.T) {
+		srv := newFakeService(t, newFakeDevice(t), filepath.Join(t.TempDir(), "peers.json"))
+		if _, err := srv.SharePeer("missing", time.Hour); !errors.Is(err, ErrPeerNotFound) {
+			t.Errorf("expected ErrPeerNotFound, got %v", err)
+		}
+		resp, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		if _, err := srv.SharePeer(resp.ID, MaxShareTTL+time.Second); err == nil {
+			t.Error("expected an error for a lifetime above the maximum")
+		}
+	})
+}
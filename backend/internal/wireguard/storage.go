@@ -1,13 +1,23 @@
 package wireguard
 
 import (
+	"crypto/rand"
 	"encoding/json"
+	"fmt"
+	"maps"
 	"os"
+	"slices"
 	"sync"
+	"time"
 )
 
 // PeerMetadata stores persistent information about a peer.
 type PeerMetadata struct {
+	// Revision increases with every change to the peer, starting at 1. It is
+	// managed by storage and unaffected by config downloads.
+	Revision int64 `json:"revision"`
+	// ID identifies the peer for its whole life, across key regenerations.
+	ID                  string   `json:"id"`
 	PublicKey           string   `json:"publicKey"`
 	PrivateKey          string   `json:"privateKey,omitempty"`
 	PresharedKey        string   `json:"presharedKey,omitempty"`
@@ -17,29 +27,111 @@ type PeerMetadata struct {
 	MTU                 int      `json:"mtu,omitempty"`
 	PersistentKeepalive int      `json:"persistentKeepalive,omitempty"`
 	InterfaceAddress    string   `json:"interfaceAddress,omitempty"`
-	Config              string   `json:"config,omitempty"`
+	RouteProfile        string   `json:"routeProfile,omitempty"`
+	ClientAllowedIPs    []string `json:"clientAllowedIPs,omitempty"`
+	Type                string   `json:"type,omitempty"`
+	Networks            []string `json:"networks,omitempty"`
+	SiteForwarding      bool     `json:"siteForwarding,omitempty"`
+	Endpoint            string   `json:"endpoint,omitempty"`        // site router's public host:port
+	ServerKeepalive     int      `json:"serverKeepalive,omitempty"` // keepalive sent by the server, in seconds
+	// Group names the group the peer inherits defaults from. Tags are free
+	// labels for filtering.
+	Group string   `json:"group,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+	// Expires is when the peer loses access, in Unix seconds; 0 never.
+	// Expired peers stay in storage but are kept off the device.
+	Expires int64 `json:"expires,omitempty"`
+	// Disabled peers stay in storage but are kept off the device.
+	Disabled bool `json:"disabled,omitempty"`
+	// PendingKey is a regenerated key during an overlap window, until the
+	// peer switches to it.
+	PendingKey *PendingKey `json:"pendingKey,omitempty"`
+	// IssuedConfig is the client config as last handed out to the peer. It is
+	// only kept to diff against the live rendering; GetPeerConfig always
+	// renders the config from current metadata and settings.
+	IssuedConfig string `json:"config,omitempty"`
+}
+
+// DeviceAllowedIPs returns the server-side AllowedIPs for the peer: its tunnel
+// addresses plus any networks it advertises as a site.
+func (m PeerMetadata) DeviceAllowedIPs() []string {
+	ips := make([]string, 0, len(m.AllowedIPs)+len(m.Networks))
+	ips = append(ips, m.AllowedIPs...)
+	return append(ips, m.Networks...)
+}
+
+// Unclaimed reports whether the peer is a slot created without keys. It has
+// no public key and is not on the device until a client claims it.
+func (m PeerMetadata) Unclaimed() bool {
+	return m.PublicKey == ""
+}
+
+// Expired reports whether the peer's access has ended by now.
+func (m PeerMetadata) Expired(now time.Time) bool {
+	return m.Expires != 0 && now.Unix() >= m.Expires
+}
+
+// OnDevice reports whether the peer belongs on the device at now: it has been
+// claimed, is enabled and has not expired.
+func (m PeerMetadata) OnDevice(now time.Time) bool {
+	return !m.Unclaimed() && m.inactive(now) == ""
+}
+
+// inactive says why a peer is kept off the device regardless of its keys, or
+// returns "" if it is not.
+func (m PeerMetadata) inactive(now time.Time) string {
+	switch {
+	case m.Disabled:
+		return "is disabled"
+	case m.Expired(now):
+		return "has expired"
+	}
+	return ""
 }
 
 // GlobalSettings stores application-wide WireGuard settings.
 type GlobalSettings struct {
+	// Revision increases with every write, starting at 1. It is managed by
+	// storage; values supplied by clients are ignored.
+	Revision      int64  `json:"revision"`
 	ServerAddress string `json:"serverAddress"`
 	DNS           string `json:"dns"`
 	MTU           int    `json:"mtu"`
 	Keepalive     int    `json:"keepalive"`
 	Endpoint      string `json:"endpoint"`
+	// ClientRouteProfile names the route profile used for peers that do not
+	// pick one. Empty means "full".
+	ClientRouteProfile string         `json:"clientRouteProfile,omitempty"`
+	RouteProfiles      []RouteProfile `json:"routeProfiles,omitempty"`
+	// LocalNetworks are the HQ ranges behind this server that site peers route
+	// through the tunnel.
+	LocalNetworks []string `json:"localNetworks,omitempty"`
+	// ZeroKnowledge stops generated private keys from being stored. They are
+	// returned once when issued, and configs are served with
+	// PrivateKeyPlaceholder afterwards. Turning it on removes the private keys
+	// already stored. Preshared keys are still stored and served in configs,
+	// as the device needs them.
+	ZeroKnowledge bool `json:"zeroKnowledge,omitempty"`
 }
 
 // storageContainer is used for JSON marshaling/unmarshaling of all persistent data.
 type storageContainer struct {
-	Peers    map[string]PeerMetadata `json:"peers"`
+	Peers    map[string]PeerMetadata `json:"peers"` // keyed by peer ID
 	Settings GlobalSettings          `json:"settings"`
+	Shares   map[string]Share        `json:"shares,omitempty"` // keyed by token hash
+	ShareKey []byte                  `json:"shareKey,omitempty"`
+	Invites  map[string]Invite       `json:"invites,omitempty"` // keyed by token hash
+	Groups   map[string]Group        `json:"groups,omitempty"`  // keyed by name
+	// ShareAudit records every share link created, opened or rejected.
+	ShareAudit []ShareAuditEntry `json:"shareAudit,omitempty"`
 }
 
 // Storage handles persistent storage of peer metadata and settings.
 type Storage struct {
-	path string
-	mu   sync.RWMutex
-	data storageContainer
+	path  string // empty for a detached copy, which is never saved
+	mu    sync.RWMutex
+	data  storageContainer
+	byKey map[string]string // public key -> peer ID
 }
 
 // NewStorage creates a new storage manager.
@@ -47,37 +139,86 @@ func NewStorage(path string) (*Storage, error) {
 	s := &Storage{
 		path: path,
 		data: storageContainer{
-			Peers: make(map[string]PeerMetadata),
+			Peers:   make(map[string]PeerMetadata),
+			Shares:  make(map[string]Share),
+			Invites: make(map[string]Invite),
+			Groups:  make(map[string]Group),
 			Settings: GlobalSettings{
-				DNS: "1.1.1.1, 8.8.8.8",
-				MTU: 1420,
+				Revision: 1,
+				DNS:      "1.1.1.1, 8.8.8.8",
+				MTU:      1420,
 			},
 		},
+		byKey: make(map[string]string),
 	}
 
-	if err := s.load(); err != nil && !os.IsNotExist(err) {
+	migrated, err := s.load()
+	if err != nil && !os.IsNotExist(err) {
 		return nil, err
 	}
+	if migrated {
+		if err := s.save(); err != nil {
+			return nil, fmt.Errorf("failed to save migrated storage: %w", err)
+		}
+	}
 
 	return s, nil
 }
 
-func (s *Storage) load() error {
+// load reads the store and reports whether it had to be migrated.
+func (s *Storage) load() (bool, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	file, err := os.Open(s.path)
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer file.Close()
 
-	return json.NewDecoder(file).Decode(&s.data)
+	if err := json.NewDecoder(file).Decode(&s.data); err != nil {
+		return false, err
+	}
+	// Stores written before revisions existed start at revision 1.
+	s.data.Settings.Revision = max(s.data.Settings.Revision, 1)
+
+	// Stores written before peer IDs existed are keyed by public key; give
+	// every peer an ID and re-key the map by it.
+	migrated := false
+	peers := make(map[string]PeerMetadata, len(s.data.Peers))
+	for key, meta := range s.data.Peers {
+		meta.Revision = max(meta.Revision, 1)
+		if meta.ID == "" {
+			meta.ID = NewPeerID()
+			migrated = true
+		}
+		if meta.PublicKey == "" {
+			meta.PublicKey = key
+		}
+		peers[meta.ID] = meta
+		if meta.PublicKey != "" {
+			s.byKey[meta.PublicKey] = meta.ID
+		}
+	}
+	s.data.Peers = peers
+	if s.data.Shares == nil {
+		s.data.Shares = make(map[string]Share)
+	}
+	if s.data.Invites == nil {
+		s.data.Invites = make(map[string]Invite)
+	}
+	if s.data.Groups == nil {
+		s.data.Groups = make(map[string]Group)
+	}
+	return migrated, nil
 }
 
 func (s *Storage) save() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if s.path == "" {
+		return nil
+	}
 
 	file, err := os.Create(s.path)
 	if err != nil {
@@ -90,27 +231,183 @@ func (s *Storage) save() error {
 	return encoder.Encode(s.data)
 }
 
-// GetMetadata returns metadata for a peer.
-func (s *Storage) GetMetadata(publicKey string) (PeerMetadata, bool) {
+// GetMetadata returns metadata for a peer by ID.
+func (s *Storage) GetMetadata(id string) (PeerMetadata, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	m, ok := s.data.Peers[publicKey]
+	m, ok := s.data.Peers[id]
 	return m, ok
 }
 
-// SetMetadata updates metadata for a peer.
-func (s *Storage) SetMetadata(publicKey string, metadata PeerMetadata) error {
+// LookupPublicKey returns metadata for the peer currently using publicKey.
+// Unclaimed peers have no public key and are never found.
+func (s *Storage) LookupPublicKey(publicKey string) (PeerMetadata, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	id, ok := s.byKey[publicKey]
+	if !ok {
+		return PeerMetadata{}, false
+	}
+	m, ok := s.data.Peers[id]
+	return m, ok
+}
+
+// ListMetadata returns a snapshot of all peer metadata keyed by peer ID.
+func (s *Storage) ListMetadata() map[string]PeerMetadata {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	peers := make(map[string]PeerMetadata, len(s.data.Peers))
+	for k, m := range s.data.Peers {
+		peers[k] = m
+	}
+	return peers
+}
+
+// SetMetadata stores metadata for the peer with the given ID and bumps its
+// revision. A changed public key moves the peer in the public key index. If
+// the save fails, the previous state is restored.
+func (s *Storage) SetMetadata(id string, metadata PeerMetadata) error {
 	s.mu.Lock()
-	s.data.Peers[publicKey] = metadata
+	previous, existed := s.data.Peers[id]
+	metadata.ID = id
+	metadata.Revision = previous.Revision + 1
+	s.put(metadata, previous, existed)
 	s.mu.Unlock()
 
-	return s.save()
+	if err := s.save(); err != nil {
+		s.mu.Lock()
+		if existed {
+			s.put(previous, metadata, true)
+		} else {
+			s.remove(metadata)
+		}
+		s.mu.Unlock()
+		return err
+	}
+	return nil
 }
 
-// DeleteMetadata removes metadata for a peer.
-func (s *Storage) DeleteMetadata(publicKey string) error {
+// put replaces previous with metadata. The caller must hold s.mu.
+func (s *Storage) put(metadata, previous PeerMetadata, hadPrevious bool) {
+	if hadPrevious && previous.PublicKey != metadata.PublicKey && s.byKey[previous.PublicKey] == previous.ID {
+		delete(s.byKey, previous.PublicKey)
+	}
+	s.data.Peers[metadata.ID] = metadata
+	if metadata.PublicKey != "" {
+		s.byKey[metadata.PublicKey] = metadata.ID
+	}
+}
+
+// remove drops a peer and its index entry. The caller must hold s.mu.
+func (s *Storage) remove(metadata PeerMetadata) {
+	delete(s.data.Peers, metadata.ID)
+	if s.byKey[metadata.PublicKey] == metadata.ID {
+		delete(s.byKey, metadata.PublicKey)
+	}
+}
+
+// RecordIssuedConfig stores the config last handed out to a peer. Unlike
+// SetMetadata it keeps the revision, since the peer itself did not change.
+func (s *Storage) RecordIssuedConfig(id string, config string) error {
+	s.mu.Lock()
+	meta, ok := s.data.Peers[id]
+	previous := meta.IssuedConfig
+	if ok {
+		meta.IssuedConfig = config
+		s.data.Peers[id] = meta
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	if err := s.save(); err != nil {
+		s.mu.Lock()
+		if meta, ok := s.data.Peers[id]; ok && meta.IssuedConfig == config {
+			meta.IssuedConfig = previous
+			s.data.Peers[id] = meta
+		}
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// PurgePrivateKeys removes every stored private key, including pending ones,
+// and replaces them with PrivateKeyPlaceholder in the recorded issued configs.
+// It returns the number of peers changed. Revisions are left alone, as private
+// keys are not part of a peer's API representation.
+func (s *Storage) PurgePrivateKeys() (int, error) {
+	s.mu.Lock()
+	previous := maps.Clone(s.data.Peers)
+	purged := 0
+	for id, meta := range s.data.Peers {
+		if meta, ok := withoutPrivateKeys(meta); ok {
+			s.data.Peers[id] = meta
+			purged++
+		}
+	}
+	s.mu.Unlock()
+
+	if purged == 0 {
+		return 0, nil
+	}
+	if err := s.save(); err != nil {
+		s.mu.Lock()
+		s.data.Peers = previous
+		s.mu.Unlock()
+		return 0, err
+	}
+	return purged, nil
+}
+
+// detached returns an in-memory copy of the store. Changes to the copy are
+// never saved and do not affect s.
+func (s *Storage) detached() *Storage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data := s.data
+	data.Peers = maps.Clone(s.data.Peers)
+	data.Shares = maps.Clone(s.data.Shares)
+	data.Invites = maps.Clone(s.data.Invites)
+	data.Groups = maps.Clone(s.data.Groups)
+	data.ShareAudit = slices.Clone(s.data.ShareAudit)
+	return &Storage{data: data, byKey: maps.Clone(s.byKey)}
+}
+
+// ApplyMetadata stores a batch of peer changes with a single save: set holds
+// metadata by peer ID, stored as is including its revision, and deleted the
+// IDs to remove. If the save fails, the previous state is restored.
+func (s *Storage) ApplyMetadata(set map[string]PeerMetadata, deleted []string) error {
 	s.mu.Lock()
-	delete(s.data.Peers, publicKey)
+	previousPeers, previousKeys := maps.Clone(s.data.Peers), maps.Clone(s.byKey)
+	for _, id := range deleted {
+		if meta, ok := s.data.Peers[id]; ok {
+			s.remove(meta)
+		}
+	}
+	for id, meta := range set {
+		previous, existed := s.data.Peers[id]
+		meta.ID = id
+		s.put(meta, previous, existed)
+	}
+	s.mu.Unlock()
+
+	if err := s.save(); err != nil {
+		s.mu.Lock()
+		s.data.Peers, s.byKey = previousPeers, previousKeys
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// DeleteMetadata removes metadata for a peer by ID.
+func (s *Storage) DeleteMetadata(id string) error {
+	s.mu.Lock()
+	if meta, ok := s.data.Peers[id]; ok {
+		s.remove(meta)
+	}
 	s.mu.Unlock()
 
 	return s.save()
@@ -123,11 +420,223 @@ func (s *Storage) GetSettings() GlobalSettings {
 	return s.data.Settings
 }
 
-// UpdateSettings updates application-wide settings.
+// UpdateSettings replaces application-wide settings and bumps their revision.
+// Turning ZeroKnowledge on also removes stored private keys, as
+// PurgePrivateKeys does.
 func (s *Storage) UpdateSettings(settings GlobalSettings) error {
 	s.mu.Lock()
+	previous, previousPeers := s.data.Settings, s.data.Peers
+	settings.Revision = previous.Revision + 1
 	s.data.Settings = settings
+	if settings.ZeroKnowledge && !previous.ZeroKnowledge {
+		// Keys stored before the switch must go in the same save.
+		s.data.Peers = maps.Clone(previousPeers)
+		for id, meta := range s.data.Peers {
+			if meta, ok := withoutPrivateKeys(meta); ok {
+				s.data.Peers[id] = meta
+			}
+		}
+	}
 	s.mu.Unlock()
 
-	return s.save()
+	if err := s.save(); err != nil {
+		s.mu.Lock()
+		s.data.Settings, s.data.Peers = previous, previousPeers
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// ShareKey returns the key share tokens are signed with, generating and
+// saving it on first use.
+func (s *Storage) ShareKey() ([]byte, error) {
+	s.mu.Lock()
+	key, created := s.data.ShareKey, false
+	if key == nil {
+		key = make([]byte, 32)
+		rand.Read(key)
+		s.data.ShareKey, created = key, true
+	}
+	s.mu.Unlock()
+
+	if !created {
+		return key, nil
+	}
+	if err := s.save(); err != nil {
+		s.mu.Lock()
+		s.data.ShareKey = nil
+		s.mu.Unlock()
+		return nil, err
+	}
+	return key, nil
+}
+
+// AddShare stores a share link under the hash of its token, with its audit
+// entry, and drops expired links.
+func (s *Storage) AddShare(hash string, share Share, entry ShareAuditEntry) error {
+	s.mu.Lock()
+	for h, existing := range s.data.Shares {
+		if existing.Expires <= entry.Timestamp {
+			delete(s.data.Shares, h)
+		}
+	}
+	s.data.Shares[hash] = share
+	audit := s.data.ShareAudit
+	s.data.ShareAudit = append(audit, entry)
+	s.mu.Unlock()
+
+	if err := s.save(); err != nil {
+		s.mu.Lock()
+		delete(s.data.Shares, hash)
+		s.data.ShareAudit = audit
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// GetShare returns the share stored under hash without taking it.
+func (s *Storage) GetShare(hash string) (Share, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	share, ok := s.data.Shares[hash]
+	return share, ok
+}
+
+// TakeShare removes and returns the share stored under hash, so each share
+// can be taken only once. The audit entry is saved with the removal.
+func (s *Storage) TakeShare(hash string, entry ShareAuditEntry) (Share, bool, error) {
+	s.mu.Lock()
+	share, ok := s.data.Shares[hash]
+	audit := s.data.ShareAudit
+	if ok {
+		delete(s.data.Shares, hash)
+		s.data.ShareAudit = append(audit, entry)
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		return Share{}, false, nil
+	}
+	if err := s.save(); err != nil {
+		s.mu.Lock()
+		s.data.Shares[hash] = share
+		s.data.ShareAudit = audit
+		s.mu.Unlock()
+		return Share{}, false, err
+	}
+	return share, true, nil
+}
+
+// AppendShareAudit adds an entry to the share audit log.
+func (s *Storage) AppendShareAudit(entry ShareAuditEntry) error {
+	s.mu.Lock()
+	audit := s.data.ShareAudit
+	s.data.ShareAudit = append(audit, entry)
+	s.mu.Unlock()
+
+	if err := s.save(); err != nil {
+		s.mu.Lock()
+		s.data.ShareAudit = audit
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// ShareAudit returns a copy of the share audit log, oldest first.
+func (s *Storage) ShareAudit() []ShareAuditEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.data.ShareAudit)
+}
+
+// ListInvites returns a snapshot of all invites keyed by token hash.
+func (s *Storage) ListInvites() map[string]Invite {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.data.Invites)
+}
+
+// GetInvite returns the invite stored under a token hash.
+func (s *Storage) GetInvite(hash string) (Invite, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	invite, ok := s.data.Invites[hash]
+	return invite, ok
+}
+
+// SetInvite stores an invite under the hash of its token.
+func (s *Storage) SetInvite(hash string, invite Invite) error {
+	s.mu.Lock()
+	previous, existed := s.data.Invites[hash]
+	s.data.Invites[hash] = invite
+	s.mu.Unlock()
+
+	if err := s.save(); err != nil {
+		s.mu.Lock()
+		if existed {
+			s.data.Invites[hash] = previous
+		} else {
+			delete(s.data.Invites, hash)
+		}
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// ListGroups returns a snapshot of all groups keyed by name.
+func (s *Storage) ListGroups() map[string]Group {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.data.Groups)
+}
+
+// GetGroup returns a group by name.
+func (s *Storage) GetGroup(name string) (Group, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	group, ok := s.data.Groups[name]
+	return group, ok
+}
+
+// SetGroup stores a group under its name. If the save fails, the previous
+// state is restored.
+func (s *Storage) SetGroup(group Group) error {
+	s.mu.Lock()
+	previous, existed := s.data.Groups[group.Name]
+	s.data.Groups[group.Name] = group
+	s.mu.Unlock()
+
+	if err := s.save(); err != nil {
+		s.mu.Lock()
+		if existed {
+			s.data.Groups[group.Name] = previous
+		} else {
+			delete(s.data.Groups, group.Name)
+		}
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// DeleteGroup removes a group by name.
+func (s *Storage) DeleteGroup(name string) error {
+	s.mu.Lock()
+	previous, existed := s.data.Groups[name]
+	delete(s.data.Groups, name)
+	s.mu.Unlock()
+
+	if err := s.save(); err != nil {
+		if existed {
+			s.mu.Lock()
+			s.data.Groups[name] = previous
+			s.mu.Unlock()
+		}
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,211 @@
+package wireguard
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// recordingClient is a fake wgctrl client that returns a fixed device and
+// records every configuration applied to it.
+type recordingClient struct {
+	device  wgtypes.Device
+	configs []wgtypes.Config
+}
+
+func (c *recordingClient) Device(name string) (*wgtypes.Device, error) {
+	d := c.device
+	d.Name = name
+	return &d, nil
+}
+
+func (c *recordingClient) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	c.configs = append(c.configs, cfg)
+	return nil
+}
+
+func (c *recordingClient) Close() error { return nil }
+
+func newRecordingService(t *testing.T) (*realService, *recordingClient) {
+	t.Helper()
+	client := &recordingClient{}
+	return &realService{
+		client:         client,
+		interfaceName:  "wg-test",
+		storage:        newTestStorage(t),
+		serverPubKey:   "SERVER_PUB",
+		serverEndpoint: "vpn.example.com:51820",
+		vpnSubnet:      "10.0.0.0/24",
+		stopChan:       make(chan struct{}),
+	}, client
+}
+
+func mustKey(t *testing.T) wgtypes.Key {
+	t.Helper()
+	k, err := wgtypes.GenerateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return k
+}
+
+func mustIPNet(t *testing.T, cidr string) net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("invalid CIDR %s: %v", cidr, err)
+	}
+	return *n
+}
+
+func durationPtr(d time.Duration) *time.Duration { return &d }
+
+func TestSyncRestoresFullPeerConfig(t *testing.T) {
+	s, client := newRecordingService(t)
+
+	clientKey, siteKey := mustKey(t), mustKey(t)
+	psk := mustKey(t)
+	seed := map[string]PeerMetadata{
+		clientKey.String(): {
+			PublicKey:           clientKey.String(),
+			Name:                "laptop",
+			AllowedIPs:          []string{"10.0.0.2/32"},
+			PresharedKey:        psk.String(),
+			PersistentKeepalive: 25, // client-side only, must not reach the device
+		},
+		siteKey.String(): {
+			PublicKey:       siteKey.String(),
+			Name:            "branch",
+			AllowedIPs:      []string{"10.0.0.50/32"},
+			Type:            PeerTypeSite,
+			Networks:        []string{"192.168.50.0/24"},
+			Endpoint:        "198.51.100.7:51820",
+			ServerKeepalive: 15,
+		},
+	}
+	for k, m := range seed {
+		if err := s.storage.SetMetadata(k, m); err != nil {
+			t.Fatalf("failed to seed storage: %v", err)
+		}
+	}
+
+	if err := s.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if len(client.configs) != 1 {
+		t.Fatalf("expected one ConfigureDevice call, got %d", len(client.configs))
+	}
+
+	zero := wgtypes.Key{}
+	clientPeer := wgtypes.PeerConfig{
+		PublicKey:                   clientKey,
+		PresharedKey:                &psk,
+		PersistentKeepaliveInterval: durationPtr(0),
+		ReplaceAllowedIPs:           true,
+		AllowedIPs:                  []net.IPNet{mustIPNet(t, "10.0.0.2/32")},
+	}
+	sitePeer := wgtypes.PeerConfig{
+		PublicKey:                   siteKey,
+		PresharedKey:                &zero,
+		Endpoint:                    &net.UDPAddr{IP: net.ParseIP("198.51.100.7"), Port: 51820},
+		PersistentKeepaliveInterval: durationPtr(15 * time.Second),
+		ReplaceAllowedIPs:           true,
+		AllowedIPs:                  []net.IPNet{mustIPNet(t, "10.0.0.50/32"), mustIPNet(t, "192.168.50.0/24")},
+	}
+	want := wgtypes.Config{Peers: []wgtypes.PeerConfig{clientPeer, sitePeer}}
+	if clientKey.String() > siteKey.String() {
+		want.Peers = []wgtypes.PeerConfig{sitePeer, clientPeer}
+	}
+
+	got := client.configs[0]
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected device config:\n got: %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestAddPeerConfiguresDevice(t *testing.T) {
+	s, client := newRecordingService(t)
+
+	resp, err := s.AddPeer(AddPeerOptions{
+		Name:            "laptop",
+		AllowedIPs:      []string{"10.0.0.2/32"},
+		PreSharedKey:    true,
+		ServerKeepalive: 30,
+	})
+	if err != nil {
+		t.Fatalf("AddPeer failed: %v", err)
+	}
+	if len(client.configs) != 1 || len(client.configs[0].Peers) != 1 {
+		t.Fatalf("expected a single peer to be configured, got %+v", client.configs)
+	}
+
+	pc := client.configs[0].Peers[0]
+	if pc.PublicKey.String() != resp.PublicKey {
+		t.Errorf("expected public key %s, got %s", resp.PublicKey, pc.PublicKey)
+	}
+	if pc.PresharedKey == nil || pc.PresharedKey.String() != resp.PresharedKey {
+		t.Errorf("expected preshared key %s on device, got %v", resp.PresharedKey, pc.PresharedKey)
+	}
+	if pc.PersistentKeepaliveInterval == nil || *pc.PersistentKeepaliveInterval != 30*time.Second {
+		t.Errorf("expected 30s keepalive, got %v", pc.PersistentKeepaliveInterval)
+	}
+
+	// What AddPeer configured must be exactly what Sync restores after a reboot.
+	if err := s.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if !reflect.DeepEqual(client.configs[1].Peers[0], pc) {
+		t.Errorf("Sync config differs from AddPeer config:\n sync: %+v\n  add: %+v", client.configs[1].Peers[0], pc)
+	}
+}
+
+func TestUpdatePeerConfiguresDevice(t *testing.T) {
+	s, client := newRecordingService(t)
+
+	resp, err := s.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}, PreSharedKey: true})
+	if err != nil {
+		t.Fatalf("AddPeer failed: %v", err)
+	}
+	key, _ := wgtypes.ParseKey(resp.PublicKey)
+	client.device.Peers = []wgtypes.Peer{{PublicKey: key}}
+
+	ips := []string{"10.0.0.3/32"}
+	if _, err := s.UpdatePeer(resp.ID, PeerUpdate{AllowedIPs: &ips}, 0); err != nil {
+		t.Fatalf("UpdatePeer failed: %v", err)
+	}
+
+	pc := client.configs[len(client.configs)-1].Peers[0]
+	if !pc.UpdateOnly || !pc.ReplaceAllowedIPs {
+		t.Errorf("expected an update-only replacement, got %+v", pc)
+	}
+	if !reflect.DeepEqual(pc.AllowedIPs, []net.IPNet{mustIPNet(t, "10.0.0.3/32")}) {
+		t.Errorf("expected new AllowedIPs, got %v", pc.AllowedIPs)
+	}
+	if pc.PresharedKey == nil || pc.PresharedKey.String() != resp.PresharedKey {
+		t.Errorf("expected the preshared key to be kept, got %v", pc.PresharedKey)
+	}
+}
+
+func TestRemovePeerConfiguresDevice(t *testing.T) {
+	s, client := newRecordingService(t)
+
+	resp, err := s.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+	if err != nil {
+		t.Fatalf("AddPeer failed: %v", err)
+	}
+	if err := s.RemovePeer(resp.ID, 0); err != nil {
+		t.Fatalf("RemovePeer failed: %v", err)
+	}
+
+	key, _ := wgtypes.ParseKey(resp.PublicKey)
+	want := wgtypes.Config{Peers: []wgtypes.PeerConfig{{PublicKey: key, Remove: true}}}
+	if got := client.configs[len(client.configs)-1]; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected removal config: %+v", got)
+	}
+	if _, ok := s.storage.GetMetadata(resp.ID); ok {
+		t.Error("expected metadata to be deleted")
+	}
+}
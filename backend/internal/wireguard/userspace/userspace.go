@@ -0,0 +1,306 @@
+// Package userspace runs a wireguard-go device on an in-process netstack TUN
+// and exposes it through the same Device/ConfigureDevice calls as wgctrl, so
+// the manager can drive real handshakes without kernel privileges.
+package userspace
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// defaultMTU matches the MTU wg-quick uses for most interfaces.
+const defaultMTU = 1420
+
+// Options configures a userspace device.
+type Options struct {
+	// InterfaceName is the name the device answers to in Device/ConfigureDevice.
+	InterfaceName string
+	// PrivateKey is the base64 interface key. A fresh key is generated when empty.
+	PrivateKey string
+	// ListenPort is the UDP port to listen on; 0 picks a random port.
+	ListenPort int
+	// Addresses are the IPs assigned to the netstack interface, e.g. 10.0.0.1.
+	Addresses []netip.Addr
+	MTU       int
+}
+
+// Client is a userspace WireGuard device that satisfies wireguard.DeviceClient.
+type Client struct {
+	mu     sync.Mutex
+	name   string
+	dev    *device.Device
+	net    *netstack.Net
+	closed bool
+}
+
+// New creates the netstack TUN, brings up a wireguard-go device on it and
+// applies the interface key and listen port.
+func New(opts Options) (*Client, error) {
+	if opts.InterfaceName == "" {
+		return nil, fmt.Errorf("interface name is required")
+	}
+	mtu := opts.MTU
+	if mtu == 0 {
+		mtu = defaultMTU
+	}
+
+	privateKey, err := parseOrGenerateKey(opts.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	tunDev, tnet, err := netstack.CreateNetTUN(opts.Addresses, nil, mtu)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create netstack TUN: %w", err)
+	}
+
+	dev := device.NewDevice(tunDev, conn.NewDefaultBind(), newLogger(opts.InterfaceName))
+	c := &Client{name: opts.InterfaceName, dev: dev, net: tnet}
+
+	port := opts.ListenPort
+	if err := c.ConfigureDevice(c.name, wgtypes.Config{PrivateKey: &privateKey, ListenPort: &port}); err != nil {
+		dev.Close()
+		return nil, err
+	}
+	if err := dev.Up(); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("failed to bring up device: %w", err)
+	}
+
+	return c, nil
+}
+
+// Net returns the in-process network stack attached to the device, which
+// can dial and listen on the interface addresses.
+func (c *Client) Net() *netstack.Net {
+	return c.net
+}
+
+// Device returns the current device state parsed from the UAPI "get" operation.
+func (c *Client) Device(name string) (*wgtypes.Device, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.check(name); err != nil {
+		return nil, err
+	}
+
+	out, err := c.dev.IpcGet()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device state: %w", err)
+	}
+	d, err := parseUAPI(out)
+	if err != nil {
+		return nil, err
+	}
+	d.Name = c.name
+	return d, nil
+}
+
+// ConfigureDevice applies cfg through the UAPI "set" operation.
+func (c *Client) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.check(name); err != nil {
+		return err
+	}
+
+	if err := c.dev.IpcSet(formatUAPI(cfg)); err != nil {
+		return fmt.Errorf("failed to configure device: %w", err)
+	}
+	return nil
+}
+
+// Close tears down the device and its network stack.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		c.dev.Close()
+	}
+	return nil
+}
+
+func (c *Client) check(name string) error {
+	if c.closed {
+		return net.ErrClosed
+	}
+	if name != c.name {
+		return fmt.Errorf("device %s: %w", name, os.ErrNotExist)
+	}
+	return nil
+}
+
+func parseOrGenerateKey(s string) (wgtypes.Key, error) {
+	if s == "" {
+		return wgtypes.GeneratePrivateKey()
+	}
+	k, err := wgtypes.ParseKey(s)
+	if err != nil {
+		return wgtypes.Key{}, fmt.Errorf("invalid private key: %w", err)
+	}
+	return k, nil
+}
+
+// newLogger routes wireguard-go logging through slog.
+func newLogger(name string) *device.Logger {
+	logger := slog.With("interface", name, "backend", "userspace")
+	return &device.Logger{
+		Verbosef: func(format string, args ...any) { logger.Debug(fmt.Sprintf(format, args...)) },
+		Errorf:   func(format string, args ...any) { logger.Error(fmt.Sprintf(format, args...)) },
+	}
+}
+
+// formatUAPI serialises a wgtypes.Config into the cross-platform
+// configuration protocol understood by wireguard-go.
+func formatUAPI(cfg wgtypes.Config) string {
+	var b strings.Builder
+	set := func(key, value string) {
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+	}
+
+	if cfg.PrivateKey != nil {
+		set("private_key", hex.EncodeToString(cfg.PrivateKey[:]))
+	}
+	if cfg.ListenPort != nil {
+		set("listen_port", strconv.Itoa(*cfg.ListenPort))
+	}
+	if cfg.FirewallMark != nil {
+		set("fwmark", strconv.Itoa(*cfg.FirewallMark))
+	}
+	if cfg.ReplacePeers {
+		set("replace_peers", "true")
+	}
+
+	for _, p := range cfg.Peers {
+		set("public_key", hex.EncodeToString(p.PublicKey[:]))
+		if p.Remove {
+			set("remove", "true")
+			continue
+		}
+		if p.UpdateOnly {
+			set("update_only", "true")
+		}
+		if p.PresharedKey != nil {
+			set("preshared_key", hex.EncodeToString(p.PresharedKey[:]))
+		}
+		if p.Endpoint != nil {
+			set("endpoint", p.Endpoint.String())
+		}
+		if p.PersistentKeepaliveInterval != nil {
+			set("persistent_keepalive_interval", strconv.Itoa(int(p.PersistentKeepaliveInterval.Seconds())))
+		}
+		if p.ReplaceAllowedIPs {
+			set("replace_allowed_ips", "true")
+		}
+		for _, ip := range p.AllowedIPs {
+			set("allowed_ip", ip.String())
+		}
+	}
+
+	return b.String()
+}
+
+// parseUAPI parses the output of the UAPI "get" operation.
+func parseUAPI(s string) (*wgtypes.Device, error) {
+	d := &wgtypes.Device{Type: wgtypes.Userspace}
+	var peer *wgtypes.Peer
+	var handshakeSec, handshakeNsec int64
+
+	flush := func() {
+		if peer == nil {
+			return
+		}
+		if handshakeSec != 0 || handshakeNsec != 0 {
+			peer.LastHandshakeTime = time.Unix(handshakeSec, handshakeNsec)
+		}
+		d.Peers = append(d.Peers, *peer)
+		peer, handshakeSec, handshakeNsec = nil, 0, 0
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed UAPI line: %q", line)
+		}
+
+		var err error
+		switch key {
+		case "private_key":
+			d.PrivateKey, err = parseHexKey(value)
+			d.PublicKey = d.PrivateKey.PublicKey()
+		case "listen_port":
+			d.ListenPort, err = strconv.Atoi(value)
+		case "fwmark":
+			d.FirewallMark, err = strconv.Atoi(value)
+		case "public_key":
+			flush()
+			peer = &wgtypes.Peer{}
+			peer.PublicKey, err = parseHexKey(value)
+		case "preshared_key":
+			peer.PresharedKey, err = parseHexKey(value)
+		case "protocol_version":
+			peer.ProtocolVersion, err = strconv.Atoi(value)
+		case "endpoint":
+			peer.Endpoint, err = net.ResolveUDPAddr("udp", value)
+		case "last_handshake_time_sec":
+			handshakeSec, err = strconv.ParseInt(value, 10, 64)
+		case "last_handshake_time_nsec":
+			handshakeNsec, err = strconv.ParseInt(value, 10, 64)
+		case "tx_bytes":
+			peer.TransmitBytes, err = strconv.ParseInt(value, 10, 64)
+		case "rx_bytes":
+			peer.ReceiveBytes, err = strconv.ParseInt(value, 10, 64)
+		case "persistent_keepalive_interval":
+			var secs int
+			secs, err = strconv.Atoi(value)
+			peer.PersistentKeepaliveInterval = time.Duration(secs) * time.Second
+		case "allowed_ip":
+			var ipNet *net.IPNet
+			_, ipNet, err = net.ParseCIDR(value)
+			if err == nil {
+				peer.AllowedIPs = append(peer.AllowedIPs, *ipNet)
+			}
+		case "errno":
+			if value != "0" {
+				err = fmt.Errorf("device returned errno %s", value)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", key, err)
+		}
+	}
+	flush()
+
+	return d, scanner.Err()
+}
+
+func parseHexKey(s string) (wgtypes.Key, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return wgtypes.Key{}, err
+	}
+	return wgtypes.NewKey(b)
+}
@@ -0,0 +1,207 @@
+package userspace
+
+import (
+	"bufio"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"wg-manager/backend/internal/wireguard"
+)
+
+func mustKey(t *testing.T) wgtypes.Key {
+	t.Helper()
+	k, err := wgtypes.GenerateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return k
+}
+
+// freeUDPPort reserves a loopback UDP port and releases it for the device.
+func freeUDPPort(t *testing.T) int {
+	t.Helper()
+	c, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to reserve UDP port: %v", err)
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).Port
+}
+
+// parseClientConfig reads the wg-quick config returned by AddPeer.
+func parseClientConfig(t *testing.T, config string) (wgtypes.Key, netip.Addr, wgtypes.PeerConfig) {
+	t.Helper()
+	var privateKey wgtypes.Key
+	var address netip.Addr
+	var peer wgtypes.PeerConfig
+
+	scanner := bufio.NewScanner(strings.NewReader(config))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), " = ")
+		if !ok {
+			continue
+		}
+		var err error
+		switch key {
+		case "PrivateKey":
+			privateKey, err = wgtypes.ParseKey(value)
+		case "Address":
+			var p netip.Prefix
+			p, err = netip.ParsePrefix(strings.Split(value, ",")[0])
+			address = p.Addr()
+		case "PublicKey":
+			peer.PublicKey, err = wgtypes.ParseKey(value)
+		case "PresharedKey":
+			var psk wgtypes.Key
+			psk, err = wgtypes.ParseKey(value)
+			peer.PresharedKey = &psk
+		case "Endpoint":
+			peer.Endpoint, err = net.ResolveUDPAddr("udp", value)
+		case "AllowedIPs":
+			for _, cidr := range strings.Split(value, ", ") {
+				var ipNet *net.IPNet
+				if _, ipNet, err = net.ParseCIDR(cidr); err != nil {
+					break
+				}
+				peer.AllowedIPs = append(peer.AllowedIPs, *ipNet)
+			}
+		}
+		if err != nil {
+			t.Fatalf("invalid %s in config: %v\n%s", key, err, config)
+		}
+	}
+	return privateKey, address, peer
+}
+
+func TestUAPIRoundTrip(t *testing.T) {
+	client, err := New(Options{InterfaceName: "wg-test", Addresses: []netip.Addr{netip.MustParseAddr("10.0.0.1")}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer client.Close()
+
+	peerKey, psk := mustKey(t), mustKey(t)
+	keepalive := 25 * time.Second
+	_, allowed, _ := net.ParseCIDR("10.0.0.2/32")
+	err = client.ConfigureDevice("wg-test", wgtypes.Config{Peers: []wgtypes.PeerConfig{{
+		PublicKey:                   peerKey,
+		PresharedKey:                &psk,
+		Endpoint:                    &net.UDPAddr{IP: net.IPv4(198, 51, 100, 7), Port: 51820},
+		PersistentKeepaliveInterval: &keepalive,
+		AllowedIPs:                  []net.IPNet{*allowed},
+	}}})
+	if err != nil {
+		t.Fatalf("ConfigureDevice failed: %v", err)
+	}
+
+	d, err := client.Device("wg-test")
+	if err != nil {
+		t.Fatalf("Device failed: %v", err)
+	}
+	if d.ListenPort == 0 || d.PublicKey != d.PrivateKey.PublicKey() {
+		t.Errorf("expected listen port and interface keys, got %+v", d)
+	}
+	if len(d.Peers) != 1 {
+		t.Fatalf("expected 1 peer, got %d", len(d.Peers))
+	}
+	p := d.Peers[0]
+	if p.PublicKey != peerKey || p.PresharedKey != psk || p.PersistentKeepaliveInterval != keepalive {
+		t.Errorf("expected peer settings to round-trip, got %+v", p)
+	}
+	if p.Endpoint.String() != "198.51.100.7:51820" || len(p.AllowedIPs) != 1 || p.AllowedIPs[0].String() != "10.0.0.2/32" {
+		t.Errorf("expected endpoint and AllowedIPs to round-trip, got %v %v", p.Endpoint, p.AllowedIPs)
+	}
+
+	if err := client.ConfigureDevice("wg-test", wgtypes.Config{Peers: []wgtypes.PeerConfig{{PublicKey: peerKey, Remove: true}}}); err != nil {
+		t.Fatalf("ConfigureDevice failed: %v", err)
+	}
+	if d, _ := client.Device("wg-test"); len(d.Peers) != 0 {
+		t.Errorf("expected peer to be removed, got %+v", d.Peers)
+	}
+
+	if _, err := client.Device("wg1"); err == nil {
+		t.Error("expected an error for an unknown interface")
+	}
+}
+
+// TestHandshake creates a peer through the service and brings up a second
+// userspace device from the returned config, then sends traffic through the
+// tunnel to prove the config completes a real handshake.
+func TestHandshake(t *testing.T) {
+	port := freeUDPPort(t)
+	serverAddr := netip.MustParseAddr("10.0.0.1")
+	server, err := New(Options{InterfaceName: "wg-test", ListenPort: port, Addresses: []netip.Addr{serverAddr}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	d, err := server.Device("wg-test")
+	if err != nil {
+		t.Fatalf("Device failed: %v", err)
+	}
+
+	endpoint := netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), uint16(port)).String()
+	srv, err := wireguard.NewServiceWithClient(server, "wg-test", filepath.Join(t.TempDir(), "peers.json"), endpoint, d.PublicKey.String(), "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("NewServiceWithClient failed: %v", err)
+	}
+	defer srv.Close()
+
+	resp, err := srv.AddPeer(wireguard.AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}, PreSharedKey: true})
+	if err != nil {
+		t.Fatalf("AddPeer failed: %v", err)
+	}
+
+	privateKey, clientAddr, peer := parseClientConfig(t, resp.Config)
+	peerClient, err := New(Options{InterfaceName: "wg-client", PrivateKey: privateKey.String(), Addresses: []netip.Addr{clientAddr}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer peerClient.Close()
+	if err := peerClient.ConfigureDevice("wg-client", wgtypes.Config{Peers: []wgtypes.PeerConfig{peer}}); err != nil {
+		t.Fatalf("ConfigureDevice failed: %v", err)
+	}
+
+	listener, err := server.Net().ListenUDPAddrPort(netip.AddrPortFrom(serverAddr, 9000))
+	if err != nil {
+		t.Fatalf("ListenUDP failed: %v", err)
+	}
+	defer listener.Close()
+
+	conn, err := peerClient.Net().DialUDPAddrPort(netip.AddrPort{}, netip.AddrPortFrom(serverAddr, 9000))
+	if err != nil {
+		t.Fatalf("DialUDP failed: %v", err)
+	}
+	defer conn.Close()
+
+	// The first packets may be dropped while the handshake completes, so
+	// keep sending until one arrives.
+	buf := make([]byte, 64)
+	deadline := time.Now().Add(10 * time.Second)
+	var n int
+	for time.Now().Before(deadline) {
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		listener.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		if n, _, err = listener.ReadFrom(buf); err == nil {
+			break
+		}
+	}
+	if got := string(buf[:n]); got != "hello" {
+		t.Fatalf("expected payload through the tunnel, got %q (err %v)", got, err)
+	}
+
+	d, err = server.Device("wg-test")
+	if err != nil {
+		t.Fatalf("Device failed: %v", err)
+	}
+	if len(d.Peers) != 1 || d.Peers[0].LastHandshakeTime.IsZero() || d.Peers[0].ReceiveBytes == 0 {
+		t.Errorf("expected handshake and traffic on the server peer, got %+v", d.Peers)
+	}
+}
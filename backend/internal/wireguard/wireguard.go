@@ -3,19 +3,47 @@ package wireguard
 import (
 	"fmt"
 	"log/slog"
+	"slices"
+	"time"
+)
+
+// Peer types.
+const (
+	PeerTypeClient = "client"
+	// PeerTypeSite is a site-to-site router that advertises the networks behind it.
+	PeerTypeSite = "site"
 )
 
 // Peer represents a WireGuard peer.
 type Peer struct {
-	ID               string   `json:"id"`
-	PublicKey        string   `json:"publicKey"`
-	Name             string   `json:"name"`
-	Endpoint         string   `json:"endpoint"`
-	AllowedIPs       []string `json:"allowedIPs"`
-	LastHandshake    string   `json:"lastHandshake"`
-	ReceiveBytes     int64    `json:"receiveBytes"`
-	TransmitBytes    int64    `json:"transmitBytes"`
-	InterfaceAddress string   `json:"interfaceAddress,omitempty"`
+	ID            string   `json:"id"`
+	PublicKey     string   `json:"publicKey"`
+	Name          string   `json:"name"`
+	Endpoint      string   `json:"endpoint"`
+	AllowedIPs    []string `json:"allowedIPs"`
+	LastHandshake string   `json:"lastHandshake"`
+	// LastHandshakeUnix is LastHandshake in Unix seconds; 0 never.
+	LastHandshakeUnix int64  `json:"lastHandshakeUnix,omitempty"`
+	ReceiveBytes      int64  `json:"receiveBytes"`
+	TransmitBytes     int64  `json:"transmitBytes"`
+	InterfaceAddress  string `json:"interfaceAddress,omitempty"`
+	Type              string `json:"type,omitempty"`
+	// Revision is the stored revision, sent as the peer's ETag. Peers that
+	// exist only on the device have none.
+	Revision int64 `json:"revision,omitempty"`
+	// PendingPublicKey is the regenerated key during an overlap window, while
+	// PublicKey still routes traffic.
+	PendingPublicKey string `json:"pendingPublicKey,omitempty"`
+	// Unclaimed peers are slots waiting for a client to claim them with its
+	// own public key. They have no public key and are not on the device.
+	Unclaimed bool     `json:"unclaimed,omitempty"`
+	Group     string   `json:"group,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	// Expires is when the peer loses access, in Unix seconds; 0 never.
+	// Expired peers are not on the device.
+	Expires int64 `json:"expires,omitempty"`
+	// Status is one of the PeerStatus values.
+	Status string `json:"status"`
 }
 
 // Stats represents interface-level statistics.
@@ -37,6 +65,23 @@ type PeerUpdate struct {
 	MTU                 *int      `json:"mtu,omitempty"`
 	PersistentKeepalive *int      `json:"persistentKeepalive,omitempty"`
 	InterfaceAddress    *string   `json:"interfaceAddress,omitempty"`
+	RouteProfile        *string   `json:"routeProfile,omitempty"`
+	ClientAllowedIPs    *[]string `json:"clientAllowedIPs,omitempty"`
+	Networks            *[]string `json:"networks,omitempty"`
+	SiteForwarding      *bool     `json:"siteForwarding,omitempty"`
+	Endpoint            *string   `json:"endpoint,omitempty"`
+	ServerKeepalive     *int      `json:"serverKeepalive,omitempty"`
+	// Group moves the peer to another group; "" removes it from its group.
+	Group *string   `json:"group,omitempty"`
+	Tags  *[]string `json:"tags,omitempty"`
+	// Expires sets when the peer loses access, in Unix seconds; 0 never.
+	// Extending an expired peer puts it back on the device.
+	Expires *int64 `json:"expires,omitempty"`
+	// Disabled takes the peer off the device, keeping it in storage.
+	Disabled *bool `json:"disabled,omitempty"`
+	// Force takes over AllowedIPs already owned by other peers instead of
+	// failing with a *ConflictError.
+	Force bool `json:"force,omitempty"`
 }
 
 // StatsHistoryItem represents a single data point in traffic history.
@@ -52,6 +97,12 @@ type PeerResponse struct {
 	Config       string `json:"config,omitempty"`
 	PrivateKey   string `json:"privateKey,omitempty"`
 	PresharedKey string `json:"presharedKey,omitempty"`
+	// PrivateKeyDiscarded is set when PrivateKey was not stored, so this
+	// response is the only copy of it and of the full config.
+	PrivateKeyDiscarded bool `json:"privateKeyDiscarded,omitempty"`
+	// QRCode is a PNG data URL of Config, included by the API whenever
+	// PrivateKeyDiscarded is set.
+	QRCode string `json:"qrCode,omitempty"`
 }
 
 // AddPeerOptions represents the options for creating a new peer.
@@ -64,28 +115,103 @@ type AddPeerOptions struct {
 	PersistentKeepalive int      `json:"persistentKeepalive,omitempty"`
 	PreSharedKey        bool     `json:"preSharedKey,omitempty"`
 	InterfaceAddress    string   `json:"interfaceAddress,omitempty"`
+	// RouteProfile selects a named client route profile; ClientAllowedIPs
+	// overrides it with explicit client-side routes.
+	RouteProfile     string   `json:"routeProfile,omitempty"`
+	ClientAllowedIPs []string `json:"clientAllowedIPs,omitempty"`
+	// Type is "client" (default) or "site". Site peers advertise Networks,
+	// which are added to their server-side AllowedIPs, and get a config
+	// tailored for routers; SiteForwarding adds PostUp/PostDown forwarding hints.
+	Type           string   `json:"type,omitempty"`
+	Networks       []string `json:"networks,omitempty"`
+	SiteForwarding bool     `json:"siteForwarding,omitempty"`
+	// Endpoint is the public host:port of a site router, letting the server
+	// initiate the tunnel. ServerKeepalive is the keepalive interval, in
+	// seconds, the server sends to the peer.
+	Endpoint        string `json:"endpoint,omitempty"`
+	ServerKeepalive int    `json:"serverKeepalive,omitempty"`
+	// Group names an existing group whose defaults the peer inherits for
+	// DNS, MTU, keepalive and client route profile when they are left unset.
+	Group string   `json:"group,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+	// Expires is when the peer loses access, in Unix seconds. Zero falls
+	// back to the group's peer lifetime, if any.
+	Expires int64 `json:"expires,omitempty"`
+	// Force takes over AllowedIPs already owned by other peers instead of
+	// failing with a *ConflictError.
+	Force bool `json:"force,omitempty"`
+	// Claimable creates a slot without keys instead of a peer. A client
+	// claims it later with its own public key, so the server never sees the
+	// private key. PublicKey must be empty.
+	Claimable bool `json:"claimable,omitempty"`
 }
 
 // Service defines the interface for WireGuard operations.
 type Service interface {
 	ListPeers() ([]Peer, error)
+	// QueryPeers searches, filters, sorts and pages the peers. It fails with
+	// ErrInvalidQuery for an unknown status, sort or order, or a bad cursor.
+	QueryPeers(query PeerQuery) (PeerPage, error)
+	GetPeer(id string) (Peer, error)
 	AddPeer(options AddPeerOptions) (PeerResponse, error)
-	RemovePeer(id string) error
-	RegeneratePeer(id string) (PeerResponse, error)
-	UpdatePeer(id string, updates PeerUpdate) (Peer, error)
+	// RemovePeer, RegeneratePeer and UpdatePeer fail with
+	// ErrPreconditionFailed unless ifRevision is 0 or the peer's revision.
+	RemovePeer(id string, ifRevision int64) error
+	RegeneratePeer(id string, opts RegenerateOptions, ifRevision int64) (PeerResponse, error)
+	UpdatePeer(id string, updates PeerUpdate, ifRevision int64) (Peer, error)
+	// Bulk runs a batch of peer operations, reporting a result for each.
+	Bulk(ops []BulkOperation, opts BulkOptions) (BulkResult, error)
+	// ExportPeers lists every peer that query selects, with their stored
+	// keys only if secrets is set.
+	ExportPeers(query PeerQuery, secrets bool) ([]PeerExport, error)
+	// ClaimPeer assigns a client-generated public key to an unclaimed peer
+	// and adds it to the device. It fails with ErrConflict if the peer was
+	// already claimed or the key is in use.
+	ClaimPeer(id string, publicKey string, ifRevision int64) (PeerResponse, error)
+	// SharePeer creates a single-use, expiring link to a peer's config, and
+	// OpenShare redeems it.
+	SharePeer(id string, ttl time.Duration) (ShareLink, error)
+	OpenShare(token string) (SharedConfig, error)
+	// GetShareAudit returns the stored log of share links created, opened
+	// and rejected, oldest first.
+	GetShareAudit() ([]ShareAuditEntry, error)
+	CreateInvite(opts InviteOptions) (InviteResponse, error)
+	ListInvites() ([]Invite, error)
+	RevokeInvite(id string) error
+	// RedeemInvite creates a peer through an invite. It fails with
+	// ErrInviteNotFound if the invite cannot be redeemed.
+	RedeemInvite(token string, opts RedeemOptions) (PeerResponse, error)
+	ListGroups() ([]Group, error)
+	GetGroup(name string) (Group, error)
+	// CreateGroup fails with ErrConflict if the name is taken, and
+	// DeleteGroup while the group still has peers.
+	CreateGroup(group Group) (Group, error)
+	UpdateGroup(name string, group Group) (GroupChange, error)
+	DeleteGroup(name string) error
 	Sync() error
 	GetPeerConfig(id string) (string, error)
+	GetPeerConfigDiff(id string) (ConfigDiff, error)
 	GetPeerMetadata(id string) (PeerMetadata, bool)
 	GetStats() (Stats, error)
 	GetStatsHistory() ([]StatsHistoryItem, error)
 	GetSettings() (GlobalSettings, error)
-	UpdateSettings(settings GlobalSettings) error
+	// UpdateSettings replaces and PatchSettings partially updates the global
+	// settings. A non-zero ifRevision must match the current revision.
+	UpdateSettings(settings GlobalSettings, ifRevision int64) (SettingsChange, error)
+	PatchSettings(update SettingsUpdate, ifRevision int64) (SettingsChange, error)
+	CheckDrift() (DriftReport, error)
+	Reconcile(mode string) (ReconcileResult, error)
+	GetEvents() ([]Event, error)
+	Readiness() Readiness
+	Diagnostics() Diagnostics
 	Close() error
 }
 
 // mockService is a mock implementation of the WireGuard service for development.
 type mockService struct {
-	peers []Peer
+	peers   []Peer
+	invites []Invite
+	groups  []Group
 }
 
 // NewMockService creates and returns a new mock WireGuard service.
@@ -101,6 +227,8 @@ func NewMockService() Service {
 				LastHandshake: "2026-01-31 02:00:00",
 				ReceiveBytes:  1024,
 				TransmitBytes: 2048,
+				Revision:      1,
+				Status:        PeerStatusOffline,
 			},
 			{
 				ID:            "mock-peer-2",
@@ -111,8 +239,13 @@ func NewMockService() Service {
 				LastHandshake: "2026-01-31 02:05:00",
 				ReceiveBytes:  512,
 				TransmitBytes: 256,
+				Revision:      1,
+				Status:        PeerStatusOffline,
 			},
 		},
+		groups: []Group{
+			{Name: "staff", Description: "Employees", DNS: "10.0.0.1"},
+		},
 	}
 }
 
@@ -127,19 +260,60 @@ func (s *mockService) ListPeers() ([]Peer, error) {
 	return s.peers, nil
 }
 
+// QueryPeers queries the mock peers.
+func (s *mockService) QueryPeers(query PeerQuery) (PeerPage, error) {
+	peers, err := s.ListPeers()
+	if err != nil {
+		return PeerPage{}, err
+	}
+	return QueryPeers(peers, query)
+}
+
+// GetPeer returns a mock WireGuard peer.
+func (s *mockService) GetPeer(id string) (Peer, error) {
+	slog.Warn("Using mock WireGuard service for GetPeer")
+	for _, p := range s.peers {
+		if p.ID == id {
+			return p, nil
+		}
+	}
+	return Peer{}, peerNotFound(id)
+}
+
+// checkRevision compares a non-zero ifRevision with a mock peer's revision.
+func (s *mockService) checkRevision(p Peer, ifRevision int64) error {
+	if ifRevision != 0 && ifRevision != p.Revision {
+		return revisionMismatch("peer "+p.ID, ifRevision, p.Revision)
+	}
+	return nil
+}
+
 // AddPeer adds a mock WireGuard peer.
 func (s *mockService) AddPeer(opts AddPeerOptions) (PeerResponse, error) {
 	slog.Warn("Using mock WireGuard service for AddPeer")
 	if opts.Name == "force-add-error" {
 		return PeerResponse{}, fmt.Errorf("forced error")
 	}
+	if !opts.Force {
+		if err := s.checkConflicts(opts.AllowedIPs, ""); err != nil {
+			return PeerResponse{}, err
+		}
+	}
 	peer := Peer{
-		ID:         fmt.Sprintf("mock-peer-%d", len(s.peers)+1),
-		PublicKey:  opts.PublicKey,
-		Name:       opts.Name,
-		AllowedIPs: opts.AllowedIPs,
+		ID:               fmt.Sprintf("mock-peer-%d", len(s.peers)+1),
+		PublicKey:        opts.PublicKey,
+		Name:             opts.Name,
+		AllowedIPs:       opts.AllowedIPs,
+		InterfaceAddress: opts.InterfaceAddress,
+		Revision:         1,
+		Group:            opts.Group,
+		Tags:             opts.Tags,
+		Expires:          opts.Expires,
+		Status:           PeerStatusOffline,
 	}
-	if peer.PublicKey == "" {
+	if opts.Claimable {
+		peer.Unclaimed = true
+	} else if peer.PublicKey == "" {
 		peer.PublicKey = "MOCK_PUBKEY_" + peer.ID
 	}
 	s.peers = append(s.peers, peer)
@@ -149,33 +323,54 @@ func (s *mockService) AddPeer(opts AddPeerOptions) (PeerResponse, error) {
 	}, nil
 }
 
+// checkConflicts reports the first mock peer other than selfID whose
+// AllowedIPs overlap prefixes.
+func (s *mockService) checkConflicts(prefixes []string, selfID string) error {
+	for _, p := range s.peers {
+		if p.ID == selfID {
+			continue
+		}
+		if prefix, existing, ok := findOverlap(prefixes, p.AllowedIPs); ok {
+			return &ConflictError{Prefix: prefix, ConflictingPrefix: existing, PeerID: p.ID, PeerName: p.Name}
+		}
+	}
+	return nil
+}
+
 // RemovePeer removes a mock WireGuard peer.
-func (s *mockService) RemovePeer(id string) error {
+func (s *mockService) RemovePeer(id string, ifRevision int64) error {
 	slog.Warn("Using mock WireGuard service for RemovePeer")
 	if id == "force-error" {
 		return fmt.Errorf("forced error")
 	}
 	for i, p := range s.peers {
 		if p.ID == id {
+			if err := s.checkRevision(p, ifRevision); err != nil {
+				return err
+			}
 			s.peers = append(s.peers[:i], s.peers[i+1:]...)
 			return nil
 		}
 	}
-	return fmt.Errorf("mock peer with ID %s not found", id)
+	return peerNotFound(id)
 }
 
 // RegeneratePeer regenerates keys for a mock WireGuard peer.
-func (s *mockService) RegeneratePeer(id string) (PeerResponse, error) {
+func (s *mockService) RegeneratePeer(id string, opts RegenerateOptions, ifRevision int64) (PeerResponse, error) {
 	slog.Warn("Using mock WireGuard service for RegeneratePeer")
 	if id == "force-error" {
 		return PeerResponse{}, fmt.Errorf("forced error")
 	}
 	for i, p := range s.peers {
 		if p.ID == id {
+			if err := s.checkRevision(p, ifRevision); err != nil {
+				return PeerResponse{}, err
+			}
 			// In a real implementation we'd generate new keys
-			// For mock, just append "-new" to the public key to simulate change
+			// For mock, just append "-new" to the public key to simulate change.
+			// The ID stays the same.
 			p.PublicKey = p.PublicKey + "-new"
-			p.ID = p.PublicKey
+			p.Revision++
 			s.peers[i] = p
 			return PeerResponse{
 				Peer:   p,
@@ -183,17 +378,172 @@ func (s *mockService) RegeneratePeer(id string) (PeerResponse, error) {
 			}, nil
 		}
 	}
-	return PeerResponse{}, fmt.Errorf("mock peer with ID %s not found", id)
+	return PeerResponse{}, peerNotFound(id)
+}
+
+// ClaimPeer assigns a public key to a mock WireGuard peer. Unlike the real
+// service it also accepts peers that were already claimed.
+func (s *mockService) ClaimPeer(id string, publicKey string, ifRevision int64) (PeerResponse, error) {
+	slog.Warn("Using mock WireGuard service for ClaimPeer")
+	for i, p := range s.peers {
+		if p.ID == id {
+			if err := s.checkRevision(p, ifRevision); err != nil {
+				return PeerResponse{}, err
+			}
+			p.PublicKey = publicKey
+			p.Unclaimed = false
+			p.Revision++
+			s.peers[i] = p
+			return PeerResponse{
+				Peer:   p,
+				Config: "[Interface]\nPrivateKey = " + PrivateKeyPlaceholder + "\n...",
+			}, nil
+		}
+	}
+	return PeerResponse{}, peerNotFound(id)
+}
+
+// SharePeer returns a fixed share link for a mock WireGuard peer.
+func (s *mockService) SharePeer(id string, ttl time.Duration) (ShareLink, error) {
+	slog.Warn("Using mock WireGuard service for SharePeer")
+	for _, p := range s.peers {
+		if p.ID == id {
+			if ttl == 0 {
+				ttl = DefaultShareTTL
+			}
+			return ShareLink{
+				Token:   "mock-share-token",
+				Path:    "/share/mock-share-token",
+				PeerID:  id,
+				Expires: time.Now().Add(ttl).Unix(),
+			}, nil
+		}
+	}
+	return ShareLink{}, peerNotFound(id)
+}
+
+// OpenShare returns the first mock peer's config for any token.
+func (s *mockService) OpenShare(token string) (SharedConfig, error) {
+	slog.Warn("Using mock WireGuard service for OpenShare")
+	if len(s.peers) == 0 {
+		return SharedConfig{}, ErrShareNotFound
+	}
+	p := s.peers[0]
+	return SharedConfig{PeerID: p.ID, Name: p.Name, Config: "[Interface]\nPrivateKey = MOCK_KEY\n..."}, nil
+}
+
+// GetShareAudit returns an empty share audit log.
+func (s *mockService) GetShareAudit() ([]ShareAuditEntry, error) {
+	slog.Warn("Using mock WireGuard service for GetShareAudit")
+	return []ShareAuditEntry{}, nil
+}
+
+// CreateInvite records a mock invite with a fixed token.
+func (s *mockService) CreateInvite(opts InviteOptions) (InviteResponse, error) {
+	slog.Warn("Using mock WireGuard service for CreateInvite")
+	if opts.TTL == 0 {
+		opts.TTL = DefaultInviteTTL
+	}
+	invite := Invite{
+		ID:           fmt.Sprintf("mock-invite-%d", len(s.invites)+1),
+		NameTemplate: opts.NameTemplate,
+		RouteProfile: opts.RouteProfile,
+		MaxUses:      max(opts.MaxUses, 1),
+		Created:      time.Now().Unix(),
+		Expires:      time.Now().Add(opts.TTL).Unix(),
+	}
+	s.invites = append(s.invites, invite)
+	return InviteResponse{Invite: invite, Token: "mock-invite-token"}, nil
+}
+
+// ListInvites returns the mock invites.
+func (s *mockService) ListInvites() ([]Invite, error) {
+	return append([]Invite{}, s.invites...), nil
+}
+
+// RevokeInvite revokes a mock invite. Unlike the real service it accepts
+// unknown IDs.
+func (s *mockService) RevokeInvite(id string) error {
+	slog.Warn("Using mock WireGuard service for RevokeInvite")
+	for i := range s.invites {
+		if s.invites[i].ID == id {
+			s.invites[i].Revoked = true
+		}
+	}
+	return nil
+}
+
+// RedeemInvite adds a mock peer for any token.
+func (s *mockService) RedeemInvite(token string, opts RedeemOptions) (PeerResponse, error) {
+	slog.Warn("Using mock WireGuard service for RedeemInvite")
+	return s.AddPeer(AddPeerOptions{
+		Name:       inviteName("{name}", len(s.peers)+1, opts.Name),
+		PublicKey:  opts.PublicKey,
+		AllowedIPs: []string{fmt.Sprintf("10.0.0.%d/32", len(s.peers)+10)},
+	})
+}
+
+// ListGroups returns the mock groups.
+func (s *mockService) ListGroups() ([]Group, error) {
+	return append([]Group{}, s.groups...), nil
+}
+
+// GetGroup returns a mock group by name.
+func (s *mockService) GetGroup(name string) (Group, error) {
+	for _, g := range s.groups {
+		if g.Name == name {
+			return g, nil
+		}
+	}
+	return Group{}, groupNotFound(name)
+}
+
+// CreateGroup adds a mock group.
+func (s *mockService) CreateGroup(group Group) (Group, error) {
+	slog.Warn("Using mock WireGuard service for CreateGroup")
+	if _, err := s.GetGroup(group.Name); err == nil {
+		return Group{}, fmt.Errorf("%w: group %s already exists", ErrConflict, group.Name)
+	}
+	s.groups = append(s.groups, group)
+	return group, nil
+}
+
+// UpdateGroup replaces a mock group without reporting affected peers.
+func (s *mockService) UpdateGroup(name string, group Group) (GroupChange, error) {
+	slog.Warn("Using mock WireGuard service for UpdateGroup")
+	for i, g := range s.groups {
+		if g.Name == name {
+			group.Name = name
+			s.groups[i] = group
+			return GroupChange{Group: group, AffectedPeers: []AffectedPeer{}}, nil
+		}
+	}
+	return GroupChange{}, groupNotFound(name)
+}
+
+// DeleteGroup removes a mock group.
+func (s *mockService) DeleteGroup(name string) error {
+	slog.Warn("Using mock WireGuard service for DeleteGroup")
+	for i, g := range s.groups {
+		if g.Name == name {
+			s.groups = append(s.groups[:i], s.groups[i+1:]...)
+			return nil
+		}
+	}
+	return groupNotFound(name)
 }
 
 // UpdatePeer updates a mock WireGuard peer.
-func (s *mockService) UpdatePeer(id string, updates PeerUpdate) (Peer, error) {
+func (s *mockService) UpdatePeer(id string, updates PeerUpdate, ifRevision int64) (Peer, error) {
 	slog.Warn("Using mock WireGuard service for UpdatePeer")
 	if id == "force-error" {
 		return Peer{}, fmt.Errorf("forced error")
 	}
 	for i, p := range s.peers {
 		if p.ID == id {
+			if err := s.checkRevision(p, ifRevision); err != nil {
+				return Peer{}, err
+			}
 			if updates.Name != nil {
 				if *updates.Name == "force-error" {
 					return Peer{}, fmt.Errorf("forced error")
@@ -201,13 +551,100 @@ func (s *mockService) UpdatePeer(id string, updates PeerUpdate) (Peer, error) {
 				p.Name = *updates.Name
 			}
 			if updates.AllowedIPs != nil {
+				if !updates.Force {
+					if err := s.checkConflicts(*updates.AllowedIPs, id); err != nil {
+						return Peer{}, err
+					}
+				}
 				p.AllowedIPs = *updates.AllowedIPs
 			}
+			if updates.Group != nil {
+				p.Group = *updates.Group
+			}
+			if updates.Tags != nil {
+				p.Tags = *updates.Tags
+			}
+			if updates.Disabled != nil {
+				p.Status = PeerStatusOffline
+				if *updates.Disabled {
+					p.Status = PeerStatusDisabled
+				}
+			}
+			p.Revision++
 			s.peers[i] = p
 			return p, nil
 		}
 	}
-	return Peer{}, fmt.Errorf("mock peer with ID %s not found", id)
+	return Peer{}, peerNotFound(id)
+}
+
+// Bulk runs a batch against the mock peers, one operation at a time.
+func (s *mockService) Bulk(ops []BulkOperation, opts BulkOptions) (BulkResult, error) {
+	slog.Warn("Using mock WireGuard service for Bulk")
+	before := slices.Clone(s.peers)
+	result := BulkResult{Results: []BulkItemResult{}, DryRun: opts.DryRun}
+	for i, op := range ops {
+		ids := []string{op.ID}
+		if op.Op != BulkCreate && op.Selector != nil {
+			ids = nil
+			for _, p := range s.peers {
+				if (PeerQuery{Group: op.Selector.Group, Tag: op.Selector.Tag}).matches(p) {
+					ids = append(ids, p.ID)
+				}
+			}
+		}
+		for _, id := range ids {
+			item := BulkItemResult{Index: i, Op: op.Op, ID: id, Status: BulkStatusOK}
+			var peer PeerResponse
+			var err error
+			switch op.Op {
+			case BulkCreate:
+				peer, err = s.AddPeer(op.Create)
+			case BulkUpdate:
+				peer.Peer, err = s.UpdatePeer(id, op.Update, 0)
+			case BulkDisable, BulkEnable:
+				disabled := op.Op == BulkDisable
+				peer.Peer, err = s.UpdatePeer(id, PeerUpdate{Disabled: &disabled}, 0)
+			case BulkDelete:
+				err = s.RemovePeer(id, 0)
+			case BulkRegenerate:
+				peer, err = s.RegeneratePeer(id, op.Regenerate, 0)
+			default:
+				err = invalidArgument("unknown bulk operation: %s", op.Op)
+			}
+			if err != nil {
+				item.Status, item.Error, item.Err = BulkStatusFailed, err.Error(), err
+				result.Failed++
+			} else {
+				if op.Op != BulkDelete {
+					item.ID, item.Peer = peer.ID, &peer
+				}
+				result.Succeeded++
+			}
+			result.Results = append(result.Results, item)
+		}
+	}
+	if opts.DryRun || (opts.Atomic && result.Failed > 0) {
+		s.peers = before
+		return result, nil
+	}
+	result.Applied = true
+	return result, nil
+}
+
+// ExportPeers lists the mock peers that q selects. They have no stored keys.
+func (s *mockService) ExportPeers(q PeerQuery, secrets bool) ([]PeerExport, error) {
+	slog.Warn("Using mock WireGuard service for ExportPeers")
+	q.Cursor, q.Limit = "", 0
+	page, err := s.QueryPeers(q)
+	if err != nil {
+		return nil, err
+	}
+	exported := make([]PeerExport, len(page.Peers))
+	for i, p := range page.Peers {
+		exported[i] = PeerExport{Peer: p}
+	}
+	return exported, nil
 }
 
 // Sync is a no-op for mockService.
@@ -222,6 +659,12 @@ func (s *mockService) GetPeerConfig(id string) (string, error) {
 	return "[Interface]\nPrivateKey = MOCK_KEY\n...", nil
 }
 
+// GetPeerConfigDiff returns a mock diff reporting the config as up to date.
+func (s *mockService) GetPeerConfigDiff(id string) (ConfigDiff, error) {
+	slog.Warn("Using mock WireGuard service for GetPeerConfigDiff")
+	return ConfigDiff{Downloaded: true, UpToDate: true, Changes: []ConfigChange{}}, nil
+}
+
 // GetPeerMetadata returns mock metadata.
 func (s *mockService) GetPeerMetadata(id string) (PeerMetadata, bool) {
 	slog.Warn("Using mock WireGuard service for GetPeerMetadata")
@@ -247,6 +690,7 @@ func (s *mockService) GetStatsHistory() ([]StatsHistoryItem, error) {
 func (s *mockService) GetSettings() (GlobalSettings, error) {
 	slog.Warn("Using mock WireGuard service for GetSettings")
 	return GlobalSettings{
+		Revision:      1,
 		ServerAddress: "10.0.0.1/24",
 		DNS:           "1.1.1.1, 8.8.8.8",
 		MTU:           1420,
@@ -255,10 +699,56 @@ func (s *mockService) GetSettings() (GlobalSettings, error) {
 	}, nil
 }
 
-// UpdateSettings updates mock settings.
-func (s *mockService) UpdateSettings(settings GlobalSettings) error {
+// UpdateSettings accepts settings at the mock's fixed revision without
+// storing them.
+func (s *mockService) UpdateSettings(settings GlobalSettings, ifRevision int64) (SettingsChange, error) {
 	slog.Warn("Using mock WireGuard service for UpdateSettings")
-	return nil
+	if ifRevision != 0 && ifRevision != 1 {
+		return SettingsChange{}, revisionMismatch("settings", ifRevision, 1)
+	}
+	settings.Revision = 2
+	return SettingsChange{Settings: settings, AffectedPeers: []AffectedPeer{}}, nil
+}
+
+// PatchSettings applies update to the mock settings without storing them.
+func (s *mockService) PatchSettings(update SettingsUpdate, ifRevision int64) (SettingsChange, error) {
+	slog.Warn("Using mock WireGuard service for PatchSettings")
+	settings, _ := s.GetSettings()
+	return s.UpdateSettings(update.Apply(settings), ifRevision)
+}
+
+// CheckDrift reports the mock device as in sync with storage.
+func (s *mockService) CheckDrift() (DriftReport, error) {
+	slog.Warn("Using mock WireGuard service for CheckDrift")
+	return DriftReport{InSync: true, DeviceOnly: []DriftPeer{}, StorageOnly: []DriftPeer{}, Mismatched: []PeerMismatch{}}, nil
+}
+
+// Reconcile validates the mode and reports nothing to do.
+func (s *mockService) Reconcile(mode string) (ReconcileResult, error) {
+	slog.Warn("Using mock WireGuard service for Reconcile")
+	switch mode {
+	case ReconcileAdopt, ReconcilePrune, ReconcileStorageWins:
+	default:
+		return ReconcileResult{}, invalidArgument("unknown reconcile mode: %s", mode)
+	}
+	drift, _ := s.CheckDrift()
+	return ReconcileResult{Mode: mode, Drift: drift, Actions: []string{}}, nil
+}
+
+// GetEvents returns no events.
+func (s *mockService) GetEvents() ([]Event, error) {
+	slog.Warn("Using mock WireGuard service for GetEvents")
+	return []Event{}, nil
+}
+
+// Readiness reports the mock service as ready.
+func (s *mockService) Readiness() Readiness {
+	return Readiness{Ready: true, Checks: []HealthCheck{{Name: "device", OK: true}}}
+}
+
+// Diagnostics returns an empty report with no warnings.
+func (s *mockService) Diagnostics() Diagnostics {
+	return Diagnostics{Interface: "wg0", DeviceType: "mock", Warnings: []string{}}
 }
 
 // GetStats returns mock interface-level statistics.
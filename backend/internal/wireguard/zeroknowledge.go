@@ -0,0 +1,61 @@
+package wireguard
+
+import (
+	"fmt"
+	"strings"
+)
+
+// keptPrivateKey returns the part of a generated private key that may be
+// stored: the key itself, or nothing in zero-knowledge mode.
+func (s *realService) keptPrivateKey(privateKey string) string {
+	if s.storage.GetSettings().ZeroKnowledge {
+		return ""
+	}
+	return privateKey
+}
+
+// issuedResponse describes a stored peer whose keys were just generated.
+// privateKey is returned, with a config carrying it, even if it was not
+// stored; in zero-knowledge mode this is the only time the server hands it
+// out. An empty privateKey leaves the placeholder in the config.
+func (s *realService) issuedResponse(stored PeerMetadata, privateKey string) (PeerResponse, error) {
+	meta := configMetadata(stored)
+	discarded := privateKey != "" && meta.PrivateKey == ""
+	if privateKey != "" {
+		meta.PrivateKey = privateKey
+	}
+
+	// Render directly: renderPeerConfig would drop the key in zero-knowledge
+	// mode, but it belongs in this one response.
+	config, err := s.renderConfig(s.inherit(meta), s.storage.GetSettings())
+	if err != nil {
+		return PeerResponse{}, fmt.Errorf("failed to render config: %w", err)
+	}
+	return PeerResponse{
+		Peer:                metadataPeer(stored),
+		PrivateKey:          privateKey,
+		PresharedKey:        meta.PresharedKey,
+		Config:              config,
+		PrivateKeyDiscarded: discarded,
+	}, nil
+}
+
+// withoutPrivateKeys returns meta with its private keys, including a pending
+// one, removed and replaced by PrivateKeyPlaceholder in the issued config. It
+// reports whether anything changed.
+func withoutPrivateKeys(meta PeerMetadata) (PeerMetadata, bool) {
+	changed := false
+	if meta.PrivateKey != "" {
+		meta.IssuedConfig = strings.ReplaceAll(meta.IssuedConfig, meta.PrivateKey, PrivateKeyPlaceholder)
+		meta.PrivateKey = ""
+		changed = true
+	}
+	if meta.PendingKey != nil && meta.PendingKey.PrivateKey != "" {
+		pending := *meta.PendingKey
+		meta.IssuedConfig = strings.ReplaceAll(meta.IssuedConfig, pending.PrivateKey, PrivateKeyPlaceholder)
+		pending.PrivateKey = ""
+		meta.PendingKey = &pending
+		changed = true
+	}
+	return meta, changed
+}
@@ -0,0 +1,208 @@
+package wireguard
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"wg-manager/backend/internal/wireguard/fakedevice"
+)
+
+// zeroKnowledgeService returns a service with zero-knowledge mode on.
+func zeroKnowledgeService(t *testing.T, client *fakedevice.Client, path string) Service {
+	t.Helper()
+	srv := newFakeService(t, client, path)
+	on := true
+	if _, err := srv.PatchSettings(SettingsUpdate{ZeroKnowledge: &on}, 0); err != nil {
+		t.Fatalf("PatchSettings failed: %v", err)
+	}
+	return srv
+}
+
+func TestZeroKnowledge(t *testing.T) {
+	t.Run("KeyReturnedOnce", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "peers.json")
+		srv := zeroKnowledgeService(t, newFakeDevice(t), path)
+		resp, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}, PreSharedKey: true})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		if resp.PrivateKey == "" || !resp.PrivateKeyDiscarded || !strings.Contains(resp.Config, resp.PrivateKey) {
+			t.Fatalf("expected the private key and its config once, got %+v", resp)
+		}
+
+		meta, _ := srv.GetPeerMetadata(resp.ID)
+		if meta.PrivateKey != "" || strings.Contains(meta.IssuedConfig, resp.PrivateKey) {
+			t.Errorf("expected no private key stored, got %+v", meta)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile failed: %v", err)
+		}
+		if strings.Contains(string(data), resp.PrivateKey) {
+			t.Error("expected the private key absent from the store")
+		}
+
+		config, err := srv.GetPeerConfig(resp.ID)
+		if err != nil {
+			t.Fatalf("GetPeerConfig failed: %v", err)
+		}
+		if strings.Contains(config, resp.PrivateKey) || !strings.Contains(config, "PrivateKey = "+PrivateKeyPlaceholder) {
+			t.Errorf("expected a config without the private key, got:\n%s", config)
+		}
+		// The device needs the preshared key, so it is kept and served.
+		if meta.PresharedKey != resp.PresharedKey || !strings.Contains(config, "PresharedKey = "+resp.PresharedKey) {
+			t.Errorf("expected the preshared key to be kept in the config, got:\n%s", config)
+		}
+		if diff, _ := srv.GetPeerConfigDiff(resp.ID); !diff.UpToDate {
+			t.Errorf("expected the placeholder config to be up to date, got %+v", diff)
+		}
+	})
+
+	t.Run("Regenerate", func(t *testing.T) {
+		srv := zeroKnowledgeService(t, newFakeDevice(t), filepath.Join(t.TempDir(), "peers.json"))
+		resp, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+
+		regen, err := srv.RegeneratePeer(resp.ID, RegenerateOptions{}, 0)
+		if err != nil {
+			t.Fatalf("RegeneratePeer failed: %v", err)
+		}
+		if !regen.PrivateKeyDiscarded || !strings.Contains(regen.Config, regen.PrivateKey) {
+			t.Errorf("expected the new private key once, got %+v", regen)
+		}
+		if meta, _ := srv.GetPeerMetadata(resp.ID); meta.PrivateKey != "" {
+			t.Error("expected the new private key not stored")
+		}
+
+		overlap, err := srv.RegeneratePeer(resp.ID, RegenerateOptions{Overlap: time.Hour}, 0)
+		if err != nil {
+			t.Fatalf("RegeneratePeer failed: %v", err)
+		}
+		if !overlap.PrivateKeyDiscarded || !strings.Contains(overlap.Config, overlap.PrivateKey) {
+			t.Errorf("expected the pending private key once, got %+v", overlap)
+		}
+		if meta, _ := srv.GetPeerMetadata(resp.ID); meta.PendingKey == nil || meta.PendingKey.PrivateKey != "" {
+			t.Errorf("expected the pending private key not stored, got %+v", meta.PendingKey)
+		}
+	})
+
+	t.Run("Off", func(t *testing.T) {
+		srv := newFakeService(t, newFakeDevice(t), filepath.Join(t.TempDir(), "peers.json"))
+		resp, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		if resp.PrivateKeyDiscarded {
+			t.Error("expected the private key to be kept")
+		}
+		if meta, _ := srv.GetPeerMetadata(resp.ID); meta.PrivateKey != resp.PrivateKey {
+			t.Errorf("expected the private key stored, got %+v", meta)
+		}
+	})
+
+	t.Run("TurnedOnLater", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "peers.json")
+		srv := newFakeService(t, newFakeDevice(t), path)
+		resp, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}, PreSharedKey: true})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		link, err := srv.SharePeer(resp.ID, time.Hour)
+		if err != nil {
+			t.Fatalf("SharePeer failed: %v", err)
+		}
+
+		on := true
+		if _, err := srv.PatchSettings(SettingsUpdate{ZeroKnowledge: &on}, 0); err != nil {
+			t.Fatalf("PatchSettings failed: %v", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile failed: %v", err)
+		}
+		if strings.Contains(string(data), resp.PrivateKey) {
+			t.Error("expected turning the mode on to remove the stored private key")
+		}
+
+		// A key written back behind the service's back is still not served.
+		storage := srv.(*realService).storage
+		meta, _ := storage.GetMetadata(resp.ID)
+		meta.PrivateKey = resp.PrivateKey
+		if err := storage.SetMetadata(resp.ID, meta); err != nil {
+			t.Fatalf("SetMetadata failed: %v", err)
+		}
+
+		config, err := srv.GetPeerConfig(resp.ID)
+		if err != nil {
+			t.Fatalf("GetPeerConfig failed: %v", err)
+		}
+		if strings.Contains(config, resp.PrivateKey) || !strings.Contains(config, "PrivateKey = "+PrivateKeyPlaceholder) {
+			t.Errorf("expected a config without the private key, got:\n%s", config)
+		}
+		shared, err := srv.OpenShare(link.Token)
+		if err != nil {
+			t.Fatalf("OpenShare failed: %v", err)
+		}
+		if strings.Contains(shared.Config, resp.PrivateKey) {
+			t.Errorf("expected a shared config without the private key, got:\n%s", shared.Config)
+		}
+		exported, err := srv.ExportPeers(PeerQuery{}, true)
+		if err != nil {
+			t.Fatalf("ExportPeers failed: %v", err)
+		}
+		if len(exported) != 1 || exported[0].PrivateKey != "" || exported[0].PresharedKey != resp.PresharedKey {
+			t.Errorf("expected only the preshared key exported, got %+v", exported)
+		}
+	})
+
+	t.Run("PurgePrivateKeys", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "peers.json")
+		srv := newFakeService(t, newFakeDevice(t), path)
+		laptop, err := srv.AddPeer(AddPeerOptions{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32"}})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		phone, err := srv.AddPeer(AddPeerOptions{Name: "phone", AllowedIPs: []string{"10.0.0.3/32"}})
+		if err != nil {
+			t.Fatalf("AddPeer failed: %v", err)
+		}
+		pending, err := srv.RegeneratePeer(phone.ID, RegenerateOptions{Overlap: time.Hour}, 0)
+		if err != nil {
+			t.Fatalf("RegeneratePeer failed: %v", err)
+		}
+
+		storage, err := NewStorage(path)
+		if err != nil {
+			t.Fatalf("NewStorage failed: %v", err)
+		}
+		purged, err := storage.PurgePrivateKeys()
+		if err != nil {
+			t.Fatalf("PurgePrivateKeys failed: %v", err)
+		}
+		if purged != 2 {
+			t.Errorf("expected 2 peers purged, got %d", purged)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile failed: %v", err)
+		}
+		for _, key := range []string{laptop.PrivateKey, phone.PrivateKey, pending.PrivateKey} {
+			if strings.Contains(string(data), key) {
+				t.Errorf("expected private key %s purged from the store", key)
+			}
+		}
+		meta, _ := storage.GetMetadata(laptop.ID)
+		if meta.Revision != laptop.Revision || !strings.Contains(meta.IssuedConfig, PrivateKeyPlaceholder) {
+			t.Errorf("expected the revision kept and a placeholder issued config, got %+v", meta)
+		}
+		if purged, _ := storage.PurgePrivateKeys(); purged != 0 {
+			t.Errorf("expected nothing left to purge, got %d", purged)
+		}
+	})
+}
@@ -36,8 +36,10 @@ async function request<T>(endpoint: string, options: RequestInit = {}): Promise<
 		try {
 			const errorData = await response.json();
 			error = {
-				error: errorData.error || `HTTP ${status} error`,
-				details: errorData.details
+				error: errorData.message || errorData.error || `HTTP ${status} error`,
+				code: errorData.code,
+				details: errorData.details,
+				requestId: errorData.requestId
 			};
 		} catch {
 			error = {
@@ -74,6 +76,17 @@ export async function post<T>(endpoint: string, body: unknown): Promise<APIRespo
 	});
 }
 
+/**
+ * POST request with a CSV body
+ */
+export async function postCSV<T>(endpoint: string, csv: string): Promise<APIResponse<T>> {
+	return request<T>(endpoint, {
+		method: 'POST',
+		headers: { 'Content-Type': 'text/csv' },
+		body: csv
+	});
+}
+
 /**
  * DELETE request
  */
@@ -84,9 +97,24 @@ export async function del<T>(endpoint: string): Promise<APIResponse<T>> {
 /**
  * PATCH request
  */
-export async function patch<T>(endpoint: string, body: unknown): Promise<APIResponse<T>> {
+export async function patch<T>(
+	endpoint: string,
+	body: unknown,
+	headers: Record<string, string> = {}
+): Promise<APIResponse<T>> {
 	return request<T>(endpoint, {
 		method: 'PATCH',
+		headers,
+		body: JSON.stringify(body)
+	});
+}
+
+/**
+ * PUT request
+ */
+export async function put<T>(endpoint: string, body: unknown): Promise<APIResponse<T>> {
+	return request<T>(endpoint, {
+		method: 'PUT',
 		body: JSON.stringify(body)
 	});
 }
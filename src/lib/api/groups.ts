@@ -0,0 +1,44 @@
+// Group API client
+import { get, post, put, del } from './client';
+import type { APIResponse } from '../types/api';
+import type { Group, GroupChange } from '../types/group';
+
+/**
+ * List all groups
+ * GET /groups
+ */
+export async function listGroups(): Promise<APIResponse<Group[]>> {
+	return get<Group[]>('/groups');
+}
+
+/**
+ * Get a group by name
+ * GET /groups/{name}
+ */
+export async function getGroup(name: string): Promise<APIResponse<Group>> {
+	return get<Group>(`/groups/${encodeURIComponent(name)}`);
+}
+
+/**
+ * Create a group
+ * POST /groups
+ */
+export async function createGroup(group: Group): Promise<APIResponse<Group>> {
+	return post<Group>('/groups', group);
+}
+
+/**
+ * Replace a group's defaults
+ * PUT /groups/{name}
+ */
+export async function updateGroup(name: string, group: Group): Promise<APIResponse<GroupChange>> {
+	return put<GroupChange>(`/groups/${encodeURIComponent(name)}`, group);
+}
+
+/**
+ * Delete a group; fails with 409 while it has peers
+ * DELETE /groups/{name}
+ */
+export async function deleteGroup(name: string): Promise<APIResponse<void>> {
+	return del<void>(`/groups/${encodeURIComponent(name)}`);
+}
@@ -0,0 +1,46 @@
+// Invite API client
+import { get, post, del } from './client';
+import type { APIResponse } from '../types/api';
+import type { PeerCreateResponse } from '../types/peer';
+import type {
+	Invite,
+	InviteCreateResponse,
+	InviteFormData,
+	InviteRedeemRequest
+} from '../types/invite';
+
+/**
+ * List all invites
+ * GET /invites
+ */
+export async function listInvites(): Promise<APIResponse<Invite[]>> {
+	return get<Invite[]>('/invites');
+}
+
+/**
+ * Create an invite
+ * POST /invites
+ */
+export async function createInvite(
+	data: InviteFormData
+): Promise<APIResponse<InviteCreateResponse>> {
+	return post<InviteCreateResponse>('/invites', data);
+}
+
+/**
+ * Revoke an invite; peers created through it are kept
+ * DELETE /invites/{id}
+ */
+export async function revokeInvite(inviteId: string): Promise<APIResponse<void>> {
+	return del<void>(`/invites/${encodeURIComponent(inviteId)}`);
+}
+
+/**
+ * Redeem an invite to create a peer
+ * POST /invites/redeem
+ */
+export async function redeemInvite(
+	data: InviteRedeemRequest
+): Promise<APIResponse<PeerCreateResponse>> {
+	return post<PeerCreateResponse>('/invites/redeem', data);
+}
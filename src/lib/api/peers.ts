@@ -1,7 +1,19 @@
 // Peer API client
-import { get, post, del, patch, API_BASE_URL } from './client';
+import { get, post, postCSV, del, patch, API_BASE_URL } from './client';
 import type { APIResponse } from '../types/api';
-import type { Peer, PeerFormData, PeerCreateResponse, PeerUpdateRequest } from '../types/peer';
+import type {
+	Peer,
+	PeerFormData,
+	PeerCreateResponse,
+	PeerUpdateRequest,
+	PeerFilter,
+	PeerQuery,
+	PeerPage,
+	ShareLink,
+	BulkRequest,
+	BulkResult,
+	ImportRow
+} from '../types/peer';
 
 /**
  * Get the URL for a peer's configuration file
@@ -17,12 +29,63 @@ export function getQrUrl(peerId: string): string {
 	return `${API_BASE_URL}/peers/qr/${encodeURIComponent(peerId)}`;
 }
 
+function peerSearchParams(query: PeerQuery): string {
+	const params = new URLSearchParams();
+	for (const [key, value] of Object.entries(query)) {
+		if (value !== undefined && value !== '') params.set(key, String(value));
+	}
+	return params.toString();
+}
+
 /**
- * List all peers
- * GET /peers
+ * List every peer a search, filter and sort selects
+ * GET /peers?q=&status=&group=&tag=&sort=&order=
  */
-export async function listPeers(): Promise<APIResponse<Peer[]>> {
-	return get<Peer[]>('/peers');
+export async function listPeers(filter: PeerFilter = {}): Promise<APIResponse<Peer[]>> {
+	const search = peerSearchParams(filter);
+	return get<Peer[]>(search ? `/peers?${search}` : '/peers');
+}
+
+/**
+ * Get one page of peers. A limit or cursor is required; without either the
+ * server answers with a bare array (see listPeers).
+ * GET /peers?...&cursor=&limit=
+ */
+export async function pagePeers(
+	query: PeerFilter & ({ limit: number; cursor?: string } | { cursor: string; limit?: number })
+): Promise<APIResponse<PeerPage>> {
+	return get<PeerPage>(`/peers?${peerSearchParams(query)}`);
+}
+
+/**
+ * Get the URL that downloads the peers a query selects, without keys. Keys
+ * need the server's export token and are not offered in the UI.
+ * GET /peers/export?format=csv|json
+ */
+export function getExportUrl(format: 'csv' | 'json', query: PeerQuery = {}): string {
+	const params = new URLSearchParams({ format });
+	for (const [key, value] of Object.entries(query)) {
+		if (key !== 'cursor' && key !== 'limit' && value !== undefined && value !== '') {
+			params.set(key, String(value));
+		}
+	}
+	return `${API_BASE_URL}/peers/export?${params}`;
+}
+
+/**
+ * Import peers from a CSV file's text or from rows, all or nothing. With
+ * preview, nothing is created.
+ * POST /peers/import?preview=
+ */
+export async function importPeers(
+	data: string | ImportRow[],
+	preview = false
+): Promise<APIResponse<BulkResult>> {
+	const endpoint = `/peers/import?preview=${preview}`;
+	if (typeof data === 'string') {
+		return postCSV<BulkResult>(endpoint, data);
+	}
+	return post<BulkResult>(endpoint, { rows: data });
 }
 
 /**
@@ -33,6 +96,14 @@ export async function addPeer(data: PeerFormData): Promise<APIResponse<PeerCreat
 	return post<PeerCreateResponse>('/peers', data);
 }
 
+/**
+ * Run a batch of peer operations, applied with a single device change
+ * POST /peers/bulk
+ */
+export async function bulkPeers(data: BulkRequest): Promise<APIResponse<BulkResult>> {
+	return post<BulkResult>('/peers/bulk', data);
+}
+
 /**
  * Remove a peer by ID (public key)
  * DELETE /peers/{id}
@@ -42,20 +113,60 @@ export async function removePeer(peerId: string): Promise<APIResponse<void>> {
 }
 
 /**
- * Update a peer's metadata or config
+ * Update a peer's metadata or config. With a revision, the update fails with
+ * 412 if the peer changed since that revision was read.
  * PATCH /peers/{id}
  */
 export async function updatePeer(
 	peerId: string,
-	data: PeerUpdateRequest
+	data: PeerUpdateRequest,
+	revision?: number
 ): Promise<APIResponse<Peer>> {
-	return patch<Peer>(`/peers/${encodeURIComponent(peerId)}`, data);
+	const headers: Record<string, string> = revision ? { 'If-Match': `"${revision}"` } : {};
+	return patch<Peer>(`/peers/${encodeURIComponent(peerId)}`, data, headers);
 }
 
 /**
  * Regenerate keys for a peer
- * POST /peers/regenerate-keys/{id}
+ * POST /peers/{id}/regenerate-keys
+ */
+export async function regenerateKeys(
+	peerId: string,
+	overlapSeconds = 0
+): Promise<APIResponse<PeerCreateResponse>> {
+	return post<PeerCreateResponse>(`/peers/${encodeURIComponent(peerId)}/regenerate-keys`, {
+		overlapSeconds
+	});
+}
+
+/**
+ * Issue a peer a new config by regenerating its keys immediately
+ * POST /peers/{id}/reissue
+ */
+export async function reissuePeer(peerId: string): Promise<APIResponse<PeerCreateResponse>> {
+	return post<PeerCreateResponse>(`/peers/${encodeURIComponent(peerId)}/reissue`, {});
+}
+
+/**
+ * Create a single-use link to a peer's config. The link opens at
+ * `${API_BASE_URL}${link.path}` without authentication.
+ * POST /peers/{id}/share
+ */
+export async function sharePeer(
+	peerId: string,
+	expiresInSeconds = 0
+): Promise<APIResponse<ShareLink>> {
+	return post<ShareLink>(`/peers/${encodeURIComponent(peerId)}/share`, { expiresInSeconds });
+}
+
+/**
+ * Claim a peer created as claimable with a public key generated on the
+ * client. The returned config has a placeholder for the private key.
+ * POST /peers/{id}/claim
  */
-export async function regenerateKeys(peerId: string): Promise<APIResponse<PeerCreateResponse>> {
-	return post<PeerCreateResponse>(`/peers/regenerate-keys/${encodeURIComponent(peerId)}`, {});
+export async function claimPeer(
+	peerId: string,
+	publicKey: string
+): Promise<APIResponse<PeerCreateResponse>> {
+	return post<PeerCreateResponse>(`/peers/${encodeURIComponent(peerId)}/claim`, { publicKey });
 }
@@ -1,7 +1,7 @@
 // Settings API client
-import { get, post } from './client';
+import { get, patch } from './client';
 import type { APIResponse } from '../types/api';
-import type { GlobalSettings } from '../types/settings';
+import type { GlobalSettings, SettingsChange, SettingsUpdate } from '../types/settings';
 
 /**
  * Get global settings
@@ -12,9 +12,14 @@ export async function getSettings(): Promise<APIResponse<GlobalSettings>> {
 }
 
 /**
- * Update global settings
- * POST /settings
+ * Update some global settings, failing with 412 if they changed since
+ * `revision` was read
+ * PATCH /settings
  */
-export async function updateSettings(settings: GlobalSettings): Promise<APIResponse<void>> {
-	return post<void>('/settings', settings);
+export async function updateSettings(
+	update: SettingsUpdate,
+	revision?: number
+): Promise<APIResponse<SettingsChange>> {
+	const headers: Record<string, string> = revision ? { 'If-Match': `"${revision}"` } : {};
+	return patch<SettingsChange>('/settings', update, headers);
 }
@@ -3,25 +3,8 @@ import type { Peer, PeerFormData, PeerCreateResponse, PeerUpdateRequest } from '
 import * as peersAPI from '../api/peers';
 import { addNotification } from './notifications';
 
-// HANDSHAKE_TIMEOUT: peer is online if lastHandshake within 120 seconds
-const HANDSHAKE_TIMEOUT = 120000; // 120 seconds in milliseconds
 const POLLING_INTERVAL = 3000; // 3 seconds
 
-/**
- * Determine peer status based on lastHandshake timestamp
- */
-function deriveStatus(lastHandshake: string): 'online' | 'offline' {
-	if (lastHandshake === '0' || !lastHandshake) {
-		return 'offline';
-	}
-
-	const handshakeTime = new Date(lastHandshake).getTime();
-	const now = Date.now();
-	const diff = now - handshakeTime;
-
-	return diff <= HANDSHAKE_TIMEOUT ? 'online' : 'offline';
-}
-
 /**
  * Peers writable store
  */
@@ -51,12 +34,7 @@ function createPeersStore() {
 			}
 
 			if (response.data) {
-				// Derive status for each peer
-				const peersWithStatus = response.data.map((peer) => ({
-					...peer,
-					status: deriveStatus(peer.lastHandshake)
-				}));
-				set(peersWithStatus);
+				set(response.data);
 			}
 		},
 
@@ -93,9 +71,18 @@ function createPeersStore() {
 		/**
 		 * Update an existing peer
 		 */
-		async update(peerId: string, data: PeerUpdateRequest): Promise<boolean> {
-			const response = await peersAPI.updatePeer(peerId, data);
+		async update(peerId: string, data: PeerUpdateRequest, revision?: number): Promise<boolean> {
+			const response = await peersAPI.updatePeer(peerId, data, revision);
 
+			if (response.status === 412) {
+				addNotification({
+					type: 'error',
+					message: 'This peer was changed by someone else. Reload and try again.',
+					duration: 5000
+				});
+				await this.load();
+				return false;
+			}
 			if (response.error) {
 				addNotification({
 					type: 'error',
@@ -113,10 +100,7 @@ function createPeersStore() {
 				});
 
 				// Update local state
-				const updatedPeer = {
-					...response.data,
-					status: deriveStatus(response.data.lastHandshake)
-				};
+				const updatedPeer = response.data;
 				update((peers) => peers.map((p) => (p.id === peerId ? updatedPeer : p)));
 				return true;
 			}
@@ -156,10 +140,16 @@ function createPeersStore() {
 			try {
 				const response = await fetch(peersAPI.getConfigUrl(peerId));
 				if (!response.ok) {
-					const errorText = await response.text();
+					let errorText = response.statusText;
+					try {
+						const errorData = await response.json();
+						errorText = errorData.message || errorText;
+					} catch {
+						// Non-JSON error body; fall back to the status text.
+					}
 					addNotification({
 						type: 'error',
-						message: `Failed to fetch config: ${errorText || response.statusText}`,
+						message: `Failed to fetch config: ${errorText}`,
 						duration: 5000
 					});
 					return null;
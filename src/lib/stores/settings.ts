@@ -1,11 +1,12 @@
 // Settings store
-import { writable } from 'svelte/store';
-import type { GlobalSettings } from '../types/settings';
+import { get, writable } from 'svelte/store';
+import type { GlobalSettings, SettingsUpdate } from '../types/settings';
 import * as settingsAPI from '../api/settings';
 import { addNotification } from './notifications';
 
 function createSettingsStore() {
-	const { subscribe, set, update } = writable<GlobalSettings | null>(null);
+	const store = writable<GlobalSettings | null>(null);
+	const { subscribe, set, update } = store;
 
 	return {
 		subscribe,
@@ -33,24 +34,36 @@ function createSettingsStore() {
 		},
 
 		/**
-		 * Save settings to API
+		 * Save changed settings to API, based on the loaded revision
 		 */
-		async save(settings: GlobalSettings): Promise<boolean> {
-			const response = await settingsAPI.updateSettings(settings);
+		async save(settings: SettingsUpdate): Promise<boolean> {
+			const response = await settingsAPI.updateSettings(settings, get(store)?.revision);
 
-			if (response.error) {
+			if (response.status === 412) {
+				addNotification({
+					type: 'error',
+					message: 'Settings were changed by someone else. Refresh and try again.',
+					duration: 5000
+				});
+				return false;
+			}
+			if (response.error || !response.data) {
 				addNotification({
 					type: 'error',
-					message: `Failed to save settings: ${response.error.error}`,
+					message: `Failed to save settings: ${response.error?.error}`,
 					duration: 5000
 				});
 				return false;
 			}
 
-			set(settings);
+			set(response.data.settings);
+			const affected = response.data.affectedPeers.length;
 			addNotification({
 				type: 'success',
-				message: 'Settings saved successfully',
+				message:
+					affected > 0
+						? `Settings saved. ${affected} peer config(s) changed and must be re-downloaded.`
+						: 'Settings saved successfully',
 				duration: 3000
 			});
 			return true;
@@ -2,7 +2,9 @@
 
 export interface APIError {
 	error: string; // User-facing error message
-	details?: string; // Optional detailed error
+	code?: string; // Machine-readable error code, e.g. "peer_not_found"
+	details?: unknown; // Optional structured details, e.g. a conflict
+	requestId?: string; // Server request ID, for correlating with logs
 }
 
 export interface APIResponse<T> {
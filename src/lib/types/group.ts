@@ -0,0 +1,20 @@
+// Group types
+import type { AffectedPeer } from './settings';
+
+// Defaults shared by the peers of a group; a peer's own values take precedence,
+// then the group's, then the global settings
+export interface Group {
+	name: string; // Identifies the group; cannot be changed
+	description?: string;
+	dns?: string;
+	mtu?: number;
+	persistentKeepalive?: number;
+	routeProfile?: string; // Client route profile for client peers
+	peerLifetime?: number; // Seconds until new members expire
+}
+
+// Response of PUT /groups/{name}
+export interface GroupChange {
+	group: Group;
+	affectedPeers: AffectedPeer[]; // Members whose configs changed
+}
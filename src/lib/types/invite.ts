@@ -0,0 +1,30 @@
+// Invite types
+export interface Invite {
+	id: string;
+	nameTemplate: string; // "{n}" is the use number, "{name}" the recipient's name
+	routeProfile?: string;
+	maxUses: number;
+	uses: number;
+	created: number; // Unix seconds
+	expires: number; // Unix seconds
+	revoked?: boolean;
+	peerIds?: string[]; // Peers created through the invite
+}
+
+// Response of POST /invites; the token is only returned here
+export interface InviteCreateResponse extends Invite {
+	token: string;
+}
+
+export interface InviteFormData {
+	nameTemplate: string;
+	routeProfile?: string;
+	maxUses?: number; // Defaults to 1
+	expiresInSeconds?: number; // Defaults to one week
+}
+
+export interface InviteRedeemRequest {
+	token: string;
+	name?: string;
+	publicKey?: string; // Optional (backend generates if omitted)
+}
@@ -1,15 +1,26 @@
 // Peer types for WireGuard peer management
 
+// Server-computed; online means a handshake within the last 2 minutes
+export type PeerStatus = 'online' | 'offline' | 'disabled' | 'expired';
+
 export interface Peer {
-	id: string; // PublicKey (unique identifier)
+	id: string; // Stable peer ID; the public key for device-only peers
 	publicKey: string; // WireGuard public key (base64)
 	name: string; // User-friendly name
 	endpoint?: string; // Peer's public IP:port (optional, set by kernel)
 	allowedIPs: string[]; // CIDR notation array (e.g., ["10.0.0.2/32"])
 	lastHandshake: string; // ISO timestamp or "0" (never connected)
+	lastHandshakeUnix?: number; // Unix seconds; absent if never connected
 	receiveBytes: number; // Total bytes received
 	transmitBytes: number; // Total bytes transmitted
-	status: 'online' | 'offline'; // Derived from lastHandshake (client-side)
+	status: PeerStatus;
+	revision?: number; // Stored revision, sent back as If-Match; absent for device-only peers
+	pendingPublicKey?: string; // New key during a regeneration overlap window
+	unclaimed?: boolean; // Created as claimable and still waiting for a client public key
+	group?: string; // Name of the group the peer inherits defaults from
+	tags?: string[];
+	expires?: number; // Unix seconds; expired peers are off the device
+	disabled?: boolean;
 	config?: string;
 	dns?: string;
 	mtu?: number;
@@ -27,6 +38,10 @@ export interface PeerFormData {
 	persistentKeepalive?: number;
 	preSharedKey?: boolean;
 	interfaceAddress?: string;
+	claimable?: boolean; // Create a slot the client claims with its own public key
+	group?: string;
+	tags?: string[];
+	expires?: number; // Unix seconds; defaults to the group's peer lifetime
 }
 
 export interface PeerCreateResponse {
@@ -37,6 +52,8 @@ export interface PeerCreateResponse {
 	config: string; // WireGuard .conf file content
 	privateKey?: string; // Only if backend generated keypair
 	presharedKey?: string;
+	privateKeyDiscarded?: boolean; // Not stored: this response is the only copy of the key
+	qrCode?: string; // PNG data URL of config, set with privateKeyDiscarded
 }
 
 export interface PeerUpdateRequest {
@@ -46,4 +63,91 @@ export interface PeerUpdateRequest {
 	mtu?: number;
 	persistentKeepalive?: number;
 	interfaceAddress?: string;
+	group?: string; // "" leaves the group
+	tags?: string[];
+	expires?: number; // 0 removes the expiry
+	disabled?: boolean; // Takes the peer off the device, keeping it stored
+}
+
+// Query parameters for GET /peers
+export interface PeerQuery {
+	q?: string; // Name, public key or address; an IP also matches routed prefixes
+	status?: PeerStatus;
+	group?: string;
+	tag?: string;
+	sort?: 'name' | 'lastHandshake' | 'traffic';
+	order?: 'asc' | 'desc'; // Defaults to asc by name, desc otherwise
+	cursor?: string; // nextCursor of the previous page
+	limit?: number; // 1-500; with cursor, switches the response to a PeerPage
+}
+
+// Search, filter and sort for GET /peers without paging
+export type PeerFilter = Omit<PeerQuery, 'cursor' | 'limit'>;
+
+// Response of GET /peers when limit or cursor is given; without them the
+// response is a bare Peer[]
+export interface PeerPage {
+	peers: Peer[];
+	total: number; // Matching peers across all pages
+	counts: Record<PeerStatus, number>; // Per status, ignoring the status filter
+	nextCursor?: string; // Absent on the last page
+}
+
+// Single-use config link from POST /peers/{id}/share
+export interface ShareLink {
+	token: string;
+	path: string; // e.g. /share/<token>
+	peerId: string;
+	expires: number; // Unix seconds
+}
+
+// Picks the stored peers in a group, with a tag, or both
+export interface PeerSelector {
+	group?: string;
+	tag?: string;
+}
+
+export type BulkOp = 'create' | 'update' | 'disable' | 'enable' | 'delete' | 'regenerate';
+
+// One operation of POST /peers/bulk; all but create take an id or a selector
+export interface BulkOperation {
+	op: BulkOp;
+	id?: string;
+	selector?: PeerSelector;
+	peer?: PeerFormData; // create
+	changes?: PeerUpdateRequest; // update
+	overlapSeconds?: number; // regenerate
+}
+
+export interface BulkRequest {
+	operations: BulkOperation[];
+	atomic?: boolean; // Apply nothing unless every operation succeeds
+	dryRun?: boolean; // Report the results without applying them
+}
+
+export interface BulkItemResult {
+	index: number; // Position of the operation in the request
+	op: BulkOp;
+	id?: string;
+	status: 'ok' | 'failed';
+	peer?: PeerCreateResponse; // Keys and config only if the batch was applied
+	code?: string; // Error code of the single-peer endpoint
+	error?: string;
+}
+
+// Response of POST /peers/bulk
+export interface BulkResult {
+	results: BulkItemResult[];
+	succeeded: number;
+	failed: number;
+	applied: boolean; // False for dry runs and failed atomic batches
+	dryRun: boolean;
+}
+
+// One row of POST /peers/import; CSV files use the same names as columns
+export interface ImportRow {
+	name: string;
+	group?: string;
+	publicKey?: string; // Keys are generated if omitted
+	ip?: string; // Single tunnel address; the next free one if omitted
 }
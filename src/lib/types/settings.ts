@@ -1,8 +1,24 @@
 // Global settings types
 export interface GlobalSettings {
+	revision: number; // Increases on every change; sent back as If-Match
 	serverAddress: string;
 	dns: string;
 	mtu: number;
 	keepalive: number;
 	endpoint: string;
+	zeroKnowledge?: boolean; // Generated private keys are shown once and never stored
+}
+
+// Fields to change with PATCH /settings; omitted fields are left alone
+export type SettingsUpdate = Partial<Omit<GlobalSettings, 'revision'>>;
+
+export interface AffectedPeer {
+	id: string;
+	name: string;
+}
+
+// Response of PUT and PATCH /settings
+export interface SettingsChange {
+	settings: GlobalSettings;
+	affectedPeers: AffectedPeer[];
 }